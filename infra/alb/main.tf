@@ -279,7 +279,7 @@ resource "aws_lb_target_group" "app" {
     healthy_threshold   = 2
     interval            = 30
     matcher             = "200"
-    path                = "/"
+    path                = "/readyz"
     port                = "traffic-port"
     timeout             = 5
     unhealthy_threshold = 2
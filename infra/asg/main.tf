@@ -276,7 +276,7 @@ resource "aws_lb_target_group" "app" {
   vpc_id   = data.aws_vpc.default.id
 
   health_check {
-    path                = "/"
+    path                = "/readyz"
     protocol            = "HTTP"
     matcher             = "200"
     interval            = 15
@@ -0,0 +1,482 @@
+// Package accesslog writes one line per HTTP request to a dedicated, rotated
+// file, apart from application logs, in Combined Log Format, JSON or the
+// ALB access log layout.
+package accesslog
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"goaws/internal/env"
+	"goaws/internal/httpx"
+	"goaws/internal/logging"
+	"goaws/internal/metrics"
+	"goaws/internal/redact"
+)
+
+var (
+	writtenLines = metrics.NewCounter("accesslog_lines_written_total", "Access log lines written.")
+	droppedLines = metrics.NewCounter("accesslog_lines_dropped_total", "Access log lines dropped because the writer fell behind or failed.", "reason")
+	excluded     = metrics.NewCounter("accesslog_requests_excluded_total", "Requests not access logged because of an exclusion.")
+	rotations    = metrics.NewCounter("accesslog_rotations_total", "Access log file rotations.")
+)
+
+// Config configures access logging. It is normally built by ConfigFromEnv.
+type Config struct {
+	// File is the log's path; access logging is off when it is empty.
+	File string
+	// Format is "combined", "json" or "alb".
+	Format string
+	// MaxSize in bytes and RotateInterval trigger rotation; zero disables
+	// either. MaxBackups rotated files are kept, gzipped if Compress.
+	MaxSize        int64
+	RotateInterval time.Duration
+	MaxBackups     int
+	Compress       bool
+	// Exclude lists requests not to log. Each entry is a route pattern,
+	// or route=user-agent-prefix to skip only matching clients on it; a
+	// route of * matches every route.
+	Exclude []string
+	// Buffer is the number of lines queued for the writer; lines beyond
+	// it are dropped and counted.
+	Buffer int
+
+	// Redactor, if set, masks secrets in the query string, referer and
+	// user agent.
+	Redactor *redact.Redactor
+}
+
+// ConfigFromEnv reads the ACCESS_LOG_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		File:           env.String("ACCESS_LOG_FILE", ""),
+		Format:         env.String("ACCESS_LOG_FORMAT", "combined"),
+		MaxSize:        int64(env.Int("ACCESS_LOG_MAX_SIZE_MB", 100)) << 20,
+		RotateInterval: env.Duration("ACCESS_LOG_ROTATE_INTERVAL", 24*time.Hour),
+		MaxBackups:     env.Int("ACCESS_LOG_MAX_BACKUPS", 14),
+		Compress:       env.Bool("ACCESS_LOG_COMPRESS", true),
+		Exclude:        env.List("ACCESS_LOG_EXCLUDE", []string{"*=ELB-HealthChecker/"}),
+		Buffer:         env.Int("ACCESS_LOG_BUFFER", 10000),
+	}
+}
+
+type exclusion struct {
+	route, agent string
+}
+
+// Logger formats requests and hands the lines to a writer goroutine, so a
+// slow disk never holds up a response.
+type Logger struct {
+	format  func(b []byte, e *entry) []byte
+	exclude []exclusion
+	file    *rotatingFile
+	lines   chan *[]byte
+	rd      *redact.Redactor
+}
+
+// linePool recycles line buffers: the middleware formats into one and the
+// writer returns it once written.
+var linePool = sync.Pool{New: func() any {
+	b := make([]byte, 0, 512)
+	return &b
+}}
+
+func putLine(b *[]byte) {
+	// Keep the odd huge line (a long URI, say) from pinning its buffer.
+	if cap(*b) <= 4<<10 {
+		linePool.Put(b)
+	}
+}
+
+// New opens cfg.File for appending.
+func New(cfg Config) (*Logger, error) {
+	l := &Logger{lines: make(chan *[]byte, max(cfg.Buffer, 1)), rd: cfg.Redactor}
+	switch cfg.Format {
+	case "combined", "":
+		l.format = appendCombined
+	case "json":
+		l.format = appendJSON
+	case "alb":
+		l.format = appendALB
+	default:
+		return nil, fmt.Errorf("accesslog: unknown format %q", cfg.Format)
+	}
+	for _, x := range cfg.Exclude {
+		route, agent, _ := strings.Cut(x, "=")
+		l.exclude = append(l.exclude, exclusion{strings.TrimSpace(route), strings.TrimSpace(agent)})
+	}
+	f, err := openRotating(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("accesslog: %w", err)
+	}
+	l.file = f
+	return l, nil
+}
+
+// Run writes queued lines until ctx is done, then writes what is left and
+// closes the file.
+func (l *Logger) Run(ctx context.Context) {
+	w := bufio.NewWriterSize(l.file, 64<<10)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	write := func(b *[]byte) {
+		defer putLine(b)
+		if _, err := w.Write(*b); err != nil {
+			droppedLines.With("write").Inc()
+			slog.Error("access log write failed", "err", err)
+			return
+		}
+		writtenLines.With().Inc()
+	}
+	for {
+		select {
+		case b := <-l.lines:
+			write(b)
+		case <-tick.C:
+			w.Flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case b := <-l.lines:
+					write(b)
+				default:
+					w.Flush()
+					l.file.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Middleware logs every request that is not excluded. It must run inside
+// logging.Middleware, which supplies the request ID and, with the mux
+// wrapped in httpx.Routes, the route. The body is counted on a copy of the
+// request, leaving the caller's untouched.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &entry{start: time.Now(), rd: l.rd}
+		e.body.ReadCloser = r.Body
+		e.req = &e.copy
+		*e.req = *r
+		e.req.Body = &e.body
+		rec, ok := w.(*httpx.Recorder)
+		if !ok {
+			e.rec.Reset(w)
+			rec = &e.rec
+		}
+		next.ServeHTTP(rec, e.req)
+		e.end = time.Now()
+		if l.excluded(e.req) {
+			excluded.With().Inc()
+			return
+		}
+		// Count a declared body the handler did not read as received too.
+		e.status, e.sent, e.received = rec.Status(), rec.Bytes(), max(e.body.n, r.ContentLength)
+		b := linePool.Get().(*[]byte)
+		*b = l.format((*b)[:0], e)
+		select {
+		case l.lines <- b:
+		default:
+			droppedLines.With("overflow").Inc()
+			putLine(b)
+		}
+	})
+}
+
+func (l *Logger) excluded(r *http.Request) bool {
+	for _, x := range l.exclude {
+		if x.route != "*" && x.route != httpx.Route(r) {
+			continue
+		}
+		if x.agent == "" || strings.HasPrefix(r.UserAgent(), x.agent) {
+			return true
+		}
+	}
+	return false
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// entry holds what a line is formatted from. The request copy, the body
+// counter and the response recorder live in it so that logging a request
+// is one allocation.
+type entry struct {
+	start, end     time.Time
+	req            *http.Request
+	copy           http.Request
+	body           countingBody
+	rec            httpx.Recorder
+	status         int
+	sent, received int64
+	rd             *redact.Redactor
+}
+
+func (e *entry) uri() string {
+	return e.rd.RequestURI(redact.SourceAccessLog, e.req.URL.RequestURI())
+}
+
+func (e *entry) referer() string {
+	return e.rd.RequestURI(redact.SourceAccessLog, e.req.Referer())
+}
+
+func (e *entry) userAgent() string {
+	return e.rd.String(redact.SourceAccessLog, e.req.UserAgent())
+}
+
+// appendCombined writes the Apache/nginx Combined Log Format:
+// host ident user [time] "request" status bytes "referer" "user-agent".
+func appendCombined(b []byte, e *entry) []byte {
+	r := e.req
+	b = append(b, httpx.ClientIP(r)...)
+	b = append(b, " - "...)
+	b = append(b, orDash(user(r))...)
+	b = append(b, " ["...)
+	b = e.start.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, "] "...)
+	b = strconv.AppendQuote(b, r.Method+" "+e.uri()+" "+r.Proto)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(e.status), 10)
+	b = append(b, ' ')
+	if e.sent == 0 {
+		b = append(b, '-')
+	} else {
+		b = strconv.AppendInt(b, e.sent, 10)
+	}
+	b = append(b, ' ')
+	b = strconv.AppendQuote(b, orDash(e.referer()))
+	b = append(b, ' ')
+	b = strconv.AppendQuote(b, orDash(e.userAgent()))
+	return append(b, '\n')
+}
+
+// appendJSON writes one JSON object per line. The fields are appended by
+// hand rather than marshalled, which would cost a struct of strings, the
+// reflection walk and a second buffer per request; the strings are escaped
+// as encoding/json would.
+func appendJSON(b []byte, e *entry) []byte {
+	r := e.req
+	b = append(b, `{"time":"`...)
+	b = e.start.UTC().AppendFormat(b, time.RFC3339Nano)
+	b = append(b, '"')
+	if id := logging.RequestID(r.Context()); id != "" {
+		b = appendField(b, "request_id", id)
+	}
+	b = appendField(b, "client", httpx.ClientIP(r))
+	if u := user(r); u != "" {
+		b = appendField(b, "user", u)
+	}
+	b = appendField(b, "method", r.Method)
+	b = appendField(b, "uri", e.uri())
+	b = appendField(b, "route", httpx.Route(r))
+	b = appendField(b, "proto", r.Proto)
+	b = appendField(b, "host", r.Host)
+	b = append(b, `,"status":`...)
+	b = strconv.AppendInt(b, int64(e.status), 10)
+	b = append(b, `,"bytes_in":`...)
+	b = strconv.AppendInt(b, e.received, 10)
+	b = append(b, `,"bytes_out":`...)
+	b = strconv.AppendInt(b, e.sent, 10)
+	b = append(b, `,"duration_ms":`...)
+	b = strconv.AppendFloat(b, float64(e.end.Sub(e.start).Microseconds())/1000, 'f', -1, 64)
+	if ref := e.referer(); ref != "" {
+		b = appendField(b, "referer", ref)
+	}
+	if ua := e.userAgent(); ua != "" {
+		b = appendField(b, "user_agent", ua)
+	}
+	if tid := r.Header.Get("X-Amzn-Trace-Id"); tid != "" {
+		b = appendField(b, "trace_id", tid)
+	}
+	return append(b, "}\n"...)
+}
+
+// appendField appends ,"key":"value". Keys are plain ASCII.
+func appendField(b []byte, key, value string) []byte {
+	b = append(b, ',', '"')
+	b = append(b, key...)
+	b = append(b, '"', ':')
+	return appendJSONString(b, value)
+}
+
+// appendJSONString appends s quoted and escaped the way json.Marshal does:
+// HTML-sensitive characters, U+2028 and U+2029 escaped, and each invalid
+// UTF-8 byte replaced with U+FFFD.
+func appendJSONString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+		case r == '\u2028' || r == '\u2029':
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xf])
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// appendALB writes the Application Load Balancer access log layout, so the
+// same tooling reads both. This process plays the target: the processing
+// time is the handler's, the ELB-side fields are 0 or -, and the client is
+// the one the ALB forwarded.
+func appendALB(b []byte, e *entry) []byte {
+	r := e.req
+	scheme, cipher, protocol, domain := "http", "-", "-", "-"
+	if r.TLS != nil {
+		scheme = "https"
+		// The ALB logs OpenSSL cipher names; the IANA name is the closest
+		// the standard library offers.
+		cipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+		protocol = tlsVersionName(r.TLS.Version)
+		domain = orDash(r.TLS.ServerName)
+	}
+	clientPort := r.Header.Get("X-Forwarded-Port")
+	if _, p, err := net.SplitHostPort(r.RemoteAddr); err == nil && r.Header.Get("X-Forwarded-For") == "" {
+		clientPort = p
+	}
+	target := "-"
+	if a, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		target = a.String()
+	}
+	port := ""
+	if !strings.Contains(r.Host, ":") {
+		if scheme == "https" {
+			port = ":443"
+		} else {
+			port = ":80"
+		}
+	}
+
+	b = append(b, scheme...)
+	b = append(b, ' ')
+	b = e.end.UTC().AppendFormat(b, "2006-01-02T15:04:05.000000Z")
+	b = append(b, " - "...)
+	b = appendHostPort(b, httpx.ClientIP(r), orDefault(clientPort, "0"))
+	b = append(b, ' ')
+	b = append(b, target...)
+	b = append(b, " 0.000 "...)
+	b = strconv.AppendFloat(b, e.end.Sub(e.start).Seconds(), 'f', 3, 64)
+	b = append(b, " 0.000 "...)
+	b = strconv.AppendInt(b, int64(e.status), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(e.status), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, e.received, 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, e.sent, 10)
+	b = append(b, ' ')
+	b = strconv.AppendQuote(b, r.Method+" "+scheme+"://"+r.Host+port+e.uri()+" "+r.Proto)
+	b = append(b, ' ')
+	b = strconv.AppendQuote(b, orDash(e.userAgent()))
+	b = append(b, ' ')
+	b = append(b, cipher...)
+	b = append(b, ' ')
+	b = append(b, protocol...)
+	b = append(b, " - "...)
+	b = strconv.AppendQuote(b, orDash(r.Header.Get("X-Amzn-Trace-Id")))
+	b = append(b, ' ')
+	b = strconv.AppendQuote(b, domain)
+	b = append(b, ` "-" 0 `...)
+	b = e.start.UTC().AppendFormat(b, "2006-01-02T15:04:05.000000Z")
+	b = append(b, ` "forward" "-" "-" `...)
+	b = strconv.AppendQuote(b, target)
+	b = append(b, ' ')
+	b = append(b, '"')
+	b = strconv.AppendInt(b, int64(e.status), 10)
+	b = append(b, '"')
+	b = append(b, ` "-" "-" -`...)
+	return append(b, '\n')
+}
+
+// appendHostPort appends host:port as net.JoinHostPort would, bracketing
+// IPv6 addresses.
+func appendHostPort(b []byte, host, port string) []byte {
+	if strings.Contains(host, ":") {
+		b = append(b, '[')
+		b = append(b, host...)
+		b = append(b, ']')
+	} else {
+		b = append(b, host...)
+	}
+	b = append(b, ':')
+	return append(b, port...)
+}
+
+func user(r *http.Request) string {
+	if u, _, ok := r.BasicAuth(); ok {
+		return u
+	}
+	return ""
+}
+
+func orDash(s string) string { return orDefault(s, "-") }
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// tlsVersionName returns the protocol as the ALB spells it, e.g. TLSv1.2.
+func tlsVersionName(v uint16) string {
+	return strings.Replace(tls.VersionName(v), "TLS ", "TLSv", 1)
+}
@@ -0,0 +1,208 @@
+package accesslog
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goaws/internal/httpx"
+	"goaws/internal/logging"
+)
+
+func testEntry() *entry {
+	r := httptest.NewRequest("POST", "/items/7?token=abc", nil)
+	r.RemoteAddr = "10.0.0.5:41234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+	r.Header.Set("X-Forwarded-Port", "443")
+	r.Header.Set("User-Agent", `curl/8 "quoted"`)
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("X-Amzn-Trace-Id", "Root=1-abc")
+	r.SetBasicAuth("alice", "pw")
+	r.Pattern = "POST /items/{id}"
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &entry{
+		start: start, end: start.Add(1500 * time.Microsecond), req: r,
+		status: 201, sent: 12, received: 34,
+	}
+}
+
+func TestAppendCombined(t *testing.T) {
+	got := string(appendCombined(nil, testEntry()))
+	want := `203.0.113.9 - alice [01/May/2024:12:00:00 +0000] "POST /items/7?token=abc HTTP/1.1" 201 12 "https://example.com/" "curl/8 \"quoted\""` + "\n"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestAppendJSON(t *testing.T) {
+	line := appendJSON(nil, testEntry())
+	var m map[string]any
+	if err := json.Unmarshal(line, &m); err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	for k, want := range map[string]any{
+		"time": "2024-05-01T12:00:00Z", "client": "203.0.113.9", "user": "alice",
+		"method": "POST", "uri": "/items/7?token=abc", "route": "POST /items/{id}",
+		"status": 201.0, "bytes_in": 34.0, "bytes_out": 12.0, "duration_ms": 1.5,
+		"user_agent": `curl/8 "quoted"`, "trace_id": "Root=1-abc",
+	} {
+		if m[k] != want {
+			t.Errorf("%s = %v, want %v", k, m[k], want)
+		}
+	}
+}
+
+func TestAppendALB(t *testing.T) {
+	e := testEntry()
+	e.req.TLS = &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, ServerName: "api.example.com"}
+	fields := strings.Fields(string(appendALB(nil, e)))
+	for i, want := range map[int]string{
+		0:  "https",
+		1:  "2024-05-01T12:00:00.001500Z",
+		3:  "203.0.113.9:443",
+		6:  "0.002",
+		8:  "201",
+		10: "34",
+		11: "12",
+		12: `"POST`,
+		13: "https://example.com:443/items/7?token=abc",
+	} {
+		if fields[i] != want {
+			t.Errorf("field %d = %q, want %q", i, fields[i], want)
+		}
+	}
+	if !strings.Contains(strings.Join(fields, " "), "TLSv1.2") {
+		t.Errorf("protocol missing: %v", fields)
+	}
+}
+
+func TestAppendJSONStringMatchesEncodingJSON(t *testing.T) {
+	for _, s := range []string{"plain", "quote\" back\\", "<a&b>", "tab\tnl\n\x01", "bad\xffutf8", "line\u2028sep", "ünïcode"} {
+		want, _ := json.Marshal(s)
+		if got := appendJSONString(nil, s); string(got) != string(want) {
+			t.Errorf("%q: got %s, want %s", s, got, want)
+		}
+	}
+}
+
+func newTestLogger(t *testing.T, cfg Config) (*Logger, string, func()) {
+	t.Helper()
+	cfg.File = filepath.Join(t.TempDir(), "access.log")
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Run(ctx)
+	}()
+	return l, cfg.File, func() { cancel(); <-done }
+}
+
+func TestMiddleware(t *testing.T) {
+	l, file, stop := newTestLogger(t, Config{Format: "json", Buffer: 10, Exclude: []string{"GET /healthz", "*=ELB-HealthChecker/"}})
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.Write([]byte("created"))
+	})
+	mux.HandleFunc("GET /healthz", func(http.ResponseWriter, *http.Request) {})
+	// The copy stands in for middleware between this one and the mux.
+	inner := httpx.Routes(mux)
+	h := logging.Middleware(l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), struct{}{}, 1)))
+	})))
+
+	body := io.NopCloser(strings.NewReader("0123456789"))
+	r := httptest.NewRequest("POST", "/items/7", nil)
+	r.Body = body
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if r.Body != body {
+		t.Error("the caller's request body was replaced")
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	probe := httptest.NewRequest("GET", "/", nil)
+	probe.Header.Set("User-Agent", "ELB-HealthChecker/2.0")
+	h.ServeHTTP(httptest.NewRecorder(), probe)
+	stop()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1:\n%s", len(lines), b)
+	}
+	var m map[string]any
+	json.Unmarshal([]byte(lines[0]), &m)
+	if m["route"] != "POST /items/{id}" || m["bytes_in"] != 10.0 || m["bytes_out"] != 7.0 || m["request_id"] == nil {
+		t.Errorf("line %s", lines[0])
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := openRotating(Config{File: path, MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond) // backups are named to the millisecond
+	}
+	rf.Close()
+	if b, _ := os.ReadFile(path); string(b) != "dddddddd\n" {
+		t.Errorf("current file %q", b)
+	}
+	backups, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "access-*.log"))
+	if len(backups) != 2 {
+		t.Fatalf("backups %v, want the newest 2", backups)
+	}
+	if b, _ := os.ReadFile(backups[0]); string(b) != "bbbbbbbb\n" {
+		t.Errorf("oldest kept backup %q", b)
+	}
+}
+
+func TestRotateFailureKeepsWriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := openRotating(Config{File: path, MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	rf.Write([]byte("aaaaaaaa\n"))
+	// With the file gone, moving it aside fails.
+	os.Remove(path)
+	if _, err := rf.Write([]byte("bbbbbbbb\n")); err != nil {
+		t.Fatalf("write after a failed rotation: %v", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "bbbbbbbb\n" {
+		t.Errorf("file %q", b)
+	}
+	// Rotation is not retried on every write.
+	if _, err := rf.Write([]byte("cccccccc\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "bbbbbbbb\ncccccccc\n" {
+		t.Errorf("file %q", b)
+	}
+}
+
+func TestNewRejectsUnknownFormat(t *testing.T) {
+	if _, err := New(Config{File: filepath.Join(t.TempDir(), "a.log"), Format: "xml"}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
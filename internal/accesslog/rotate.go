@@ -0,0 +1,168 @@
+package accesslog
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFile is an append-only file that is moved aside to
+// name-<timestamp>.ext once it reaches maxSize bytes or crosses an interval
+// boundary. Rotated files are gzipped in the background and only the newest
+// backups are kept.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	compress   bool
+
+	// f is nil after a rotation that could not reopen the file.
+	f    *os.File
+	size int64
+	next time.Time
+	// retry holds off rotating again after a rotation failed.
+	retry time.Time
+	bg    sync.WaitGroup
+}
+
+func openRotating(cfg Config) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       cfg.File,
+		maxSize:    cfg.MaxSize,
+		interval:   cfg.RotateInterval,
+		maxBackups: cfg.MaxBackups,
+		compress:   cfg.Compress,
+	}
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0o755); err != nil {
+		return nil, err
+	}
+	return rf, rf.open(time.Now())
+}
+
+func (rf *rotatingFile) open(now time.Time) error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, st.Size()
+	if rf.interval > 0 {
+		rf.next = now.UTC().Truncate(rf.interval).Add(rf.interval)
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Write(b []byte) (int, error) {
+	now := time.Now()
+	if rf.f != nil && rf.size > 0 && !now.Before(rf.retry) &&
+		(rf.maxSize > 0 && rf.size+int64(len(b)) > rf.maxSize || !rf.next.IsZero() && !now.Before(rf.next)) {
+		if err := rf.rotate(now); err != nil {
+			rf.retry = now.Add(time.Minute)
+			slog.Error("access log rotation failed", "file", rf.path, "err", err)
+		}
+	}
+	if rf.f == nil {
+		// Keep appending to the current file rather than losing every
+		// line until a restart.
+		if err := rf.open(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside and opens a new one. If it fails, rf.f is
+// left nil for Write to reopen.
+func (rf *rotatingFile) rotate(now time.Time) error {
+	err := rf.f.Close()
+	rf.f = nil
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(rf.path)
+	backup := strings.TrimSuffix(rf.path, ext) + "-" + now.UTC().Format("20060102T150405.000") + ext
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+	rotations.With().Inc()
+	if err := rf.open(now); err != nil {
+		return err
+	}
+	rf.bg.Add(1)
+	go func() {
+		defer rf.bg.Done()
+		if rf.compress {
+			if err := gzipFile(backup); err != nil {
+				slog.Error("access log compression failed", "file", backup, "err", err)
+			}
+		}
+		rf.prune()
+	}()
+	return nil
+}
+
+// prune removes all but the newest maxBackups rotated files. Backup names
+// sort by time.
+func (rf *rotatingFile) prune() {
+	if rf.maxBackups <= 0 {
+		return
+	}
+	ext := filepath.Ext(rf.path)
+	backups, _ := filepath.Glob(strings.TrimSuffix(rf.path, ext) + "-*" + ext + "*")
+	backups = slices.DeleteFunc(backups, func(p string) bool {
+		return !strings.HasSuffix(p, ext) && !strings.HasSuffix(p, ext+".gz")
+	})
+	slices.Sort(backups)
+	for len(backups) > rf.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// Close closes the file and waits for background compression.
+func (rf *rotatingFile) Close() error {
+	var err error
+	if rf.f != nil {
+		err = rf.f.Close()
+	}
+	rf.bg.Wait()
+	return err
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
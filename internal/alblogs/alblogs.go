@@ -0,0 +1,382 @@
+// Package alblogs analyses Application Load Balancer access logs, as the
+// ALB writes them to S3 or as accesslog writes them in its alb format.
+package alblogs
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field positions in an ALB access log entry. Later fields are optional:
+// AWS appends new ones over time.
+const (
+	fieldType = iota
+	fieldTime
+	fieldELB
+	fieldClient
+	fieldTarget
+	fieldRequestProcessing
+	fieldTargetProcessing
+	fieldResponseProcessing
+	fieldELBStatus
+	fieldTargetStatus
+	fieldReceivedBytes
+	fieldSentBytes
+	fieldRequest
+	fieldUserAgent
+	fieldSSLCipher
+	fieldSSLProtocol
+	fieldTargetGroup
+	fieldTraceID
+	fieldDomain
+	fieldCert
+	fieldRulePriority
+	fieldRequestCreation
+	fieldActions
+	fieldRedirectURL
+	fieldErrorReason
+	minFields = fieldRequest + 1
+)
+
+// Entry is the part of an access log entry the report uses. Processing
+// times are -1 when the ALB did not get that far, e.g. no target was
+// reachable.
+type Entry struct {
+	Time               time.Time
+	Client             string
+	Target             string
+	RequestProcessing  float64
+	TargetProcessing   float64
+	ResponseProcessing float64
+	ELBStatus          int
+	TargetStatus       int // 0 when the target sent no response
+	Method, Path       string
+	ErrorReason        string
+}
+
+var errMalformed = errors.New("alblogs: malformed entry")
+
+// ParseEntry parses one line.
+func ParseEntry(line string) (Entry, error) {
+	f := splitFields(line)
+	if len(f) < minFields {
+		return Entry{}, errMalformed
+	}
+	var e Entry
+	var err error
+	if e.Time, err = time.Parse(time.RFC3339Nano, f[fieldTime]); err != nil {
+		return Entry{}, errMalformed
+	}
+	e.Client = hostOf(f[fieldClient])
+	e.Target = f[fieldTarget]
+	e.RequestProcessing = parseSeconds(f[fieldRequestProcessing])
+	e.TargetProcessing = parseSeconds(f[fieldTargetProcessing])
+	e.ResponseProcessing = parseSeconds(f[fieldResponseProcessing])
+	e.ELBStatus, _ = strconv.Atoi(f[fieldELBStatus])
+	e.TargetStatus, _ = strconv.Atoi(f[fieldTargetStatus])
+	// The request field is "METHOD URL PROTO", or "- - - " when the ALB
+	// could not parse the request.
+	parts := strings.Fields(f[fieldRequest])
+	if len(parts) >= 2 {
+		e.Method = parts[0]
+		if u, err := url.Parse(parts[1]); err == nil {
+			e.Path = u.Path
+		}
+	}
+	if e.Path == "" {
+		e.Path = "-"
+	}
+	if len(f) > fieldErrorReason && f[fieldErrorReason] != "-" {
+		e.ErrorReason = f[fieldErrorReason]
+	}
+	return e, nil
+}
+
+// splitFields splits on spaces, keeping double-quoted fields, which may
+// contain spaces and backslash escapes, whole and unquoted.
+func splitFields(line string) []string {
+	fields := make([]string, 0, 32)
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ':
+			i++
+		case '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' && j+1 < len(line) {
+					j++
+				}
+				b.WriteByte(line[j])
+			}
+			fields = append(fields, b.String())
+			i = j + 1
+		default:
+			j := strings.IndexByte(line[i:], ' ')
+			if j < 0 {
+				j = len(line) - i
+			}
+			fields = append(fields, line[i:i+j])
+			i += j
+		}
+	}
+	return fields
+}
+
+func parseSeconds(s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+func hostOf(hostport string) string {
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		return h
+	}
+	return hostport
+}
+
+// Options filter what is analysed.
+type Options struct {
+	// Since and Until bound entry times; zero means unbounded.
+	Since, Until time.Time
+	// Top is the length of the top paths and clients lists.
+	Top int
+}
+
+// Analyzer accumulates entries into a Report.
+type Analyzer struct {
+	opts Options
+
+	requests, malformed int
+	from, to            time.Time
+	latency             [4][]float64
+	statuses            map[string]int
+	targetErrors        int
+	elbErrors           map[int]int
+	errorReasons        map[string]int
+	byTarget            map[string]int
+	paths               map[string]*pathStat
+	clients             map[string]int
+}
+
+type pathStat struct {
+	requests, errors int
+	target           []float64
+}
+
+// NewAnalyzer returns an empty analyzer.
+func NewAnalyzer(opts Options) *Analyzer {
+	if opts.Top <= 0 {
+		opts.Top = 10
+	}
+	return &Analyzer{
+		opts:         opts,
+		statuses:     map[string]int{},
+		elbErrors:    map[int]int{},
+		errorReasons: map[string]int{},
+		byTarget:     map[string]int{},
+		paths:        map[string]*pathStat{},
+		clients:      map[string]int{},
+	}
+}
+
+// Read adds every entry in r, one per line.
+func (a *Analyzer) Read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		e, err := ParseEntry(line)
+		if err != nil {
+			a.malformed++
+			continue
+		}
+		a.Add(e)
+	}
+	return sc.Err()
+}
+
+// Add accumulates one entry.
+func (a *Analyzer) Add(e Entry) {
+	if !a.opts.Since.IsZero() && e.Time.Before(a.opts.Since) ||
+		!a.opts.Until.IsZero() && !e.Time.Before(a.opts.Until) {
+		return
+	}
+	a.requests++
+	if a.from.IsZero() || e.Time.Before(a.from) {
+		a.from = e.Time
+	}
+	if e.Time.After(a.to) {
+		a.to = e.Time
+	}
+	for i, v := range [...]float64{e.RequestProcessing, e.TargetProcessing, e.ResponseProcessing} {
+		if v >= 0 {
+			a.latency[i] = append(a.latency[i], v)
+		}
+	}
+	if e.RequestProcessing >= 0 && e.TargetProcessing >= 0 && e.ResponseProcessing >= 0 {
+		a.latency[3] = append(a.latency[3], e.RequestProcessing+e.TargetProcessing+e.ResponseProcessing)
+	}
+	a.statuses[statusClass(e.ELBStatus)]++
+
+	p := a.paths[e.Path]
+	if p == nil {
+		p = &pathStat{}
+		a.paths[e.Path] = p
+	}
+	p.requests++
+	if e.TargetProcessing >= 0 {
+		p.target = append(p.target, e.TargetProcessing)
+	}
+	a.clients[e.Client]++
+
+	if e.ELBStatus < 500 {
+		return
+	}
+	p.errors++
+	a.byTarget[e.Target]++
+	if e.ErrorReason != "" {
+		a.errorReasons[e.ErrorReason]++
+	}
+	// A 5xx the target sent is the application's; one the ALB made up
+	// (502 bad gateway, 503 no healthy targets, 504 timeout) is not.
+	if e.TargetStatus >= 500 {
+		a.targetErrors++
+	} else {
+		a.elbErrors[e.ELBStatus]++
+	}
+}
+
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "other"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// Report is the analysis result. Latencies are in milliseconds.
+type Report struct {
+	Files     int       `json:"files"`
+	Requests  int       `json:"requests"`
+	Malformed int       `json:"malformed"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+
+	Latency  map[string]Percentiles `json:"latency_ms"`
+	Statuses map[string]int         `json:"statuses"`
+
+	TargetErrors         int            `json:"target_5xx"`
+	ELBErrors            map[string]int `json:"elb_5xx"`
+	ErrorReasons         []Count        `json:"error_reasons,omitempty"`
+	ServerErrorsByTarget []Count        `json:"5xx_by_target"`
+
+	TopPaths   []PathReport `json:"top_paths"`
+	TopClients []Count      `json:"top_clients"`
+}
+
+// Percentiles summarises a latency distribution.
+type Percentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Count is a key with its number of requests.
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// PathReport is a path's traffic, 5xx count and target latency.
+type PathReport struct {
+	Path         string  `json:"path"`
+	Requests     int     `json:"requests"`
+	ServerErrors int     `json:"5xx"`
+	TargetP99    float64 `json:"target_p99_ms"`
+}
+
+// Report summarises what has been added.
+func (a *Analyzer) Report() *Report {
+	rep := &Report{
+		Requests:  a.requests,
+		Malformed: a.malformed,
+		From:      a.from,
+		To:        a.to,
+		Latency:   map[string]Percentiles{},
+		Statuses:  a.statuses,
+		ELBErrors: map[string]int{},
+
+		TargetErrors:         a.targetErrors,
+		ErrorReasons:         top(a.errorReasons, a.opts.Top),
+		ServerErrorsByTarget: top(a.byTarget, a.opts.Top),
+		TopClients:           top(a.clients, a.opts.Top),
+	}
+	for i, name := range [...]string{"request", "target", "response", "total"} {
+		rep.Latency[name] = percentiles(a.latency[i])
+	}
+	for code, n := range a.elbErrors {
+		rep.ELBErrors[strconv.Itoa(code)] = n
+	}
+	counts := make(map[string]int, len(a.paths))
+	for path, p := range a.paths {
+		counts[path] = p.requests
+	}
+	for _, c := range top(counts, a.opts.Top) {
+		p := a.paths[c.Key]
+		rep.TopPaths = append(rep.TopPaths, PathReport{
+			Path:         c.Key,
+			Requests:     p.requests,
+			ServerErrors: p.errors,
+			TargetP99:    percentiles(p.target).P99,
+		})
+	}
+	return rep
+}
+
+// percentiles sorts v in place and returns its nearest-rank percentiles in
+// milliseconds.
+func percentiles(v []float64) Percentiles {
+	if len(v) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(v)
+	at := func(q float64) float64 {
+		i := int(math.Ceil(q*float64(len(v)))) - 1
+		return v[min(max(i, 0), len(v)-1)] * 1000
+	}
+	return Percentiles{Count: len(v), P50: at(0.50), P90: at(0.90), P99: at(0.99), Max: v[len(v)-1] * 1000}
+}
+
+// top returns the n largest counts, ties broken by key.
+func top(m map[string]int, n int) []Count {
+	out := make([]Count, 0, len(m))
+	for k, c := range m {
+		out = append(out, Count{k, c})
+	}
+	slices.SortFunc(out, func(a, b Count) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,206 @@
+package alblogs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// awsExample is the HTTP entry from the ALB access log documentation.
+const awsExample = `http 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.000 0.001 0.000 200 200 34 366 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.46.0" - - arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337262-36d228ad5d99923122bbe354" "-" "-" 0 2018-07-02T22:22:48.364000Z "forward" "-" "-" "10.0.0.1:80" "200" "-" "-"`
+
+func TestParseEntry(t *testing.T) {
+	e, err := ParseEntry(awsExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Entry{
+		Time:               time.Date(2018, 7, 2, 22, 23, 0, 186641000, time.UTC),
+		Client:             "192.168.131.39",
+		Target:             "10.0.0.1:80",
+		RequestProcessing:  0,
+		TargetProcessing:   0.001,
+		ResponseProcessing: 0,
+		ELBStatus:          200,
+		TargetStatus:       200,
+		Method:             "GET",
+		Path:               "/",
+	}
+	if e != want {
+		t.Errorf("got  %+v\nwant %+v", e, want)
+	}
+}
+
+func TestParseEntryELBError(t *testing.T) {
+	line := `https 2024-05-01T12:00:00.000000Z app/lb/1 [2001:db8::1]:5000 - -1 -1 -1 503 - 120 0 "- - - " "-" - - - "-" "-" "-" 0 2024-05-01T12:00:00.000000Z "forward" "-" "TargetGroupNoHealthyTargets" "-" "-"`
+	e, err := ParseEntry(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Client != "2001:db8::1" || e.TargetProcessing != -1 || e.TargetStatus != 0 || e.Path != "-" || e.ErrorReason != "TargetGroupNoHealthyTargets" {
+		t.Errorf("%+v", e)
+	}
+}
+
+func TestParseEntryMalformed(t *testing.T) {
+	for _, line := range []string{"", "http nottime a b c d e f g h i j k", "http 2024-05-01T12:00:00Z too few"} {
+		if _, err := ParseEntry(line); err == nil {
+			t.Errorf("%q parsed", line)
+		}
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	got := splitFields(`a "b c" "say \"hi\"" "" d`)
+	want := []string{"a", "b c", `say "hi"`, "", "d"}
+	if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// line builds an entry at second s with the given statuses and target
+// time.
+func line(s int, path string, elb, target int, targetTime string) string {
+	ts := time.Date(2024, 5, 1, 12, 0, s, 0, time.UTC).Format("2006-01-02T15:04:05.000000Z")
+	ts2 := "-"
+	if target != 0 {
+		ts2 = fmt.Sprint(target)
+	}
+	return fmt.Sprintf(`http %s app/lb/1 10.0.0.%d:1000 10.1.0.1:80 0.001 %s 0.000 %d %s 10 20 "GET http://h%s HTTP/1.1" "ua"`,
+		ts, s%2+1, targetTime, elb, ts2, path)
+}
+
+func TestAnalyzer(t *testing.T) {
+	logs := strings.Join([]string{
+		line(1, "/a", 200, 200, "0.010"),
+		line(2, "/a", 200, 200, "0.020"),
+		line(3, "/a", 500, 500, "0.030"),
+		line(4, "/b", 502, 0, "-1"),
+		line(5, "/b", 404, 404, "0.005"),
+		"garbage",
+		"",
+	}, "\n")
+	a := NewAnalyzer(Options{Since: time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC), Top: 1})
+	a.Add(Entry{Time: time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)}) // before Since
+	if err := a.Read(strings.NewReader(logs)); err != nil {
+		t.Fatal(err)
+	}
+	rep := a.Report()
+	if rep.Requests != 5 || rep.Malformed != 1 {
+		t.Errorf("requests %d, malformed %d", rep.Requests, rep.Malformed)
+	}
+	if rep.Statuses["2xx"] != 2 || rep.Statuses["4xx"] != 1 || rep.Statuses["5xx"] != 2 {
+		t.Errorf("statuses %v", rep.Statuses)
+	}
+	if rep.TargetErrors != 1 || rep.ELBErrors["502"] != 1 {
+		t.Errorf("target 5xx %d, elb 5xx %v", rep.TargetErrors, rep.ELBErrors)
+	}
+	if p := rep.Latency["target"]; p.Count != 4 || p.P50 != 10 || p.Max != 30 {
+		t.Errorf("target latency %+v", p)
+	}
+	if p := rep.Latency["total"]; p.Count != 4 {
+		t.Errorf("total latency %+v", p)
+	}
+	if len(rep.TopPaths) != 1 || rep.TopPaths[0] != (PathReport{Path: "/a", Requests: 3, ServerErrors: 1, TargetP99: 30}) {
+		t.Errorf("top paths %+v", rep.TopPaths)
+	}
+	if !rep.From.Equal(time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC)) || !rep.To.Equal(time.Date(2024, 5, 1, 12, 0, 5, 0, time.UTC)) {
+		t.Errorf("from %v to %v", rep.From, rep.To)
+	}
+
+	var out bytes.Buffer
+	rep.WriteText(&out)
+	for _, s := range []string{"5 requests in 0 files", "1 malformed lines skipped", "elb 502", "/a"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	v := make([]float64, 100)
+	for i := range v {
+		v[i] = float64(100-i) / 1000 // 100ms down to 1ms
+	}
+	p := percentiles(v)
+	if p != (Percentiles{Count: 100, P50: 50, P90: 90, P99: 99, Max: 100}) {
+		t.Errorf("%+v", p)
+	}
+	if percentiles(nil) != (Percentiles{}) {
+		t.Error("empty input")
+	}
+}
+
+// The rank is ceil(q*n), so small samples do not report a value below the
+// one that q of them are at or under.
+func TestPercentilesNearestRank(t *testing.T) {
+	tests := []struct {
+		n             int
+		p50, p90, p99 float64
+	}{
+		{1, 1, 1, 1},
+		{2, 1, 2, 2},
+		{6, 3, 6, 6},
+		{10, 5, 9, 10},
+		{20, 10, 18, 20},
+	}
+	for _, tt := range tests {
+		v := make([]float64, tt.n)
+		for i := range v {
+			v[i] = float64(tt.n-i) / 1000 // n ms down to 1ms
+		}
+		want := Percentiles{Count: tt.n, P50: tt.p50, P90: tt.p90, P99: tt.p99, Max: float64(tt.n)}
+		if p := percentiles(v); p != want {
+			t.Errorf("n=%d: got %+v, want %+v", tt.n, p, want)
+		}
+	}
+}
+
+func TestTop(t *testing.T) {
+	got := top(map[string]int{"b": 2, "a": 2, "c": 5, "d": 1}, 3)
+	if fmt.Sprint(got) != "[{c 5} {a 2} {b 2}]" {
+		t.Errorf("top = %v", got)
+	}
+}
+
+func TestReadSourceDirectory(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "one.log"), []byte(line(1, "/a", 200, 200, "0.001")+"\n"), 0o644)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(line(2, "/b", 200, 200, "0.001") + "\n"))
+	zw.Close()
+	os.MkdirAll(filepath.Join(dir, "2024", "05"), 0o755)
+	os.WriteFile(filepath.Join(dir, "2024", "05", "two.log.gz"), gz.Bytes(), 0o644)
+	os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a log\n"), 0o644)
+
+	a := NewAnalyzer(Options{})
+	n, err := readSource(context.Background(), a, dir, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep := a.Report(); n != 2 || rep.Requests != 2 || rep.Malformed != 0 {
+		t.Errorf("read %d files, %d requests, %d malformed", n, rep.Requests, rep.Malformed)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	if tm, err := parseTime(""); err != nil || !tm.IsZero() {
+		t.Errorf("empty = %v, %v", tm, err)
+	}
+	if tm, err := parseTime("1h"); err != nil || time.Since(tm) < time.Hour-time.Second {
+		t.Errorf("1h = %v, %v", tm, err)
+	}
+	if tm, err := parseTime("2024-05-01T12:00:00Z"); err != nil || tm.Hour() != 12 {
+		t.Errorf("RFC 3339 = %v, %v", tm, err)
+	}
+	if _, err := parseTime("yesterday"); err == nil {
+		t.Error("nonsense accepted")
+	}
+}
@@ -0,0 +1,216 @@
+package alblogs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"goaws/internal/aws"
+)
+
+const usage = `usage: app alb-logs [flags] source...
+
+Reads ALB access logs, gzipped or not, and reports latency percentiles per
+processing phase, 5xx by target, target- vs ELB-side errors, and the top
+paths and clients. A source is a file, a directory (read recursively) or
+s3://bucket/prefix; AWS_ENDPOINT_URL_S3 points at S3-compatible stores.
+
+flags:
+`
+
+// Command runs app alb-logs with args, writing the report to stdout.
+func Command(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("alb-logs", flag.ContinueOnError)
+	since := fset.String("since", "", "only entries after this RFC 3339 time, or this long ago, e.g. 24h")
+	until := fset.String("until", "", "only entries before this RFC 3339 time")
+	topN := fset.Int("top", 10, "length of the top paths, clients and targets lists")
+	asJSON := fset.Bool("json", false, "print the report as JSON")
+	fset.Usage = func() {
+		fmt.Fprint(fset.Output(), usage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return flag.ErrHelp
+	}
+	opts := Options{Top: *topN}
+	var err error
+	if opts.Since, err = parseTime(*since); err != nil {
+		return fmt.Errorf("-since: %w", err)
+	}
+	if opts.Until, err = parseTime(*until); err != nil {
+		return fmt.Errorf("-until: %w", err)
+	}
+
+	a := NewAnalyzer(opts)
+	files := 0
+	for _, src := range fset.Args() {
+		n, err := readSource(ctx, a, src, opts.Since)
+		files += n
+		if err != nil {
+			return err
+		}
+	}
+	rep := a.Report()
+	rep.Files = files
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	return rep.WriteText(os.Stdout)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// readSource feeds every log file under src to a and returns how many it
+// read. S3 objects last modified before since cannot hold newer entries
+// and are skipped without being downloaded.
+func readSource(ctx context.Context, a *Analyzer, src string, since time.Time) (int, error) {
+	if rest, ok := strings.CutPrefix(src, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		client := aws.NewClient()
+		objs, err := client.ListObjects(ctx, bucket, prefix)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", src, err)
+		}
+		n := 0
+		for _, o := range objs {
+			if !isLogFile(o.Key) || !since.IsZero() && o.LastModified.Before(since) {
+				continue
+			}
+			body, err := client.GetObject(ctx, bucket, o.Key)
+			if err != nil {
+				return n, fmt.Errorf("s3://%s/%s: %w", bucket, o.Key, err)
+			}
+			err = readLog(a, body)
+			body.Close()
+			if err != nil {
+				return n, fmt.Errorf("s3://%s/%s: %w", bucket, o.Key, err)
+			}
+			n++
+		}
+		return n, nil
+	}
+
+	n := 0
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Files named explicitly are read whatever their name.
+		if d.IsDir() || path != src && !isLogFile(path) {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := readLog(a, f); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func isLogFile(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz") || strings.HasSuffix(name, ".gz")
+}
+
+// readLog reads one file, gunzipping it if it starts with the gzip magic.
+func readLog(a *Analyzer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return a.Read(zr)
+	}
+	return a.Read(br)
+}
+
+// WriteText prints the report as aligned tables.
+func (rep *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%d requests in %d files", rep.Requests, rep.Files)
+	if rep.Requests > 0 {
+		fmt.Fprintf(tw, ", %s to %s", rep.From.Format(time.RFC3339), rep.To.Format(time.RFC3339))
+	}
+	if rep.Malformed > 0 {
+		fmt.Fprintf(tw, " (%d malformed lines skipped)", rep.Malformed)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "\nlatency (ms)\tcount\tp50\tp90\tp99\tmax")
+	for _, name := range []string{"request", "target", "response", "total"} {
+		p := rep.Latency[name]
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.1f\t%.1f\t%.1f\n", name, p.Count, p.P50, p.P90, p.P99, p.Max)
+	}
+
+	fmt.Fprintln(tw, "\nstatus\trequests")
+	for _, class := range []string{"2xx", "3xx", "4xx", "5xx", "other"} {
+		if n := rep.Statuses[class]; n > 0 {
+			fmt.Fprintf(tw, "%s\t%d\n", class, n)
+		}
+	}
+
+	fmt.Fprintln(tw, "\n5xx source\trequests")
+	fmt.Fprintf(tw, "target\t%d\n", rep.TargetErrors)
+	for _, code := range []string{"500", "502", "503", "504"} {
+		if n := rep.ELBErrors[code]; n > 0 {
+			fmt.Fprintf(tw, "elb %s\t%d\n", code, n)
+		}
+	}
+	for code, n := range rep.ELBErrors {
+		switch code {
+		case "500", "502", "503", "504":
+		default:
+			fmt.Fprintf(tw, "elb %s\t%d\n", code, n)
+		}
+	}
+	writeCounts(tw, "error reason", rep.ErrorReasons)
+	writeCounts(tw, "5xx by target", rep.ServerErrorsByTarget)
+
+	fmt.Fprintln(tw, "\npath\trequests\t5xx\ttarget p99 (ms)")
+	for _, p := range rep.TopPaths {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\n", p.Path, p.Requests, p.ServerErrors, p.TargetP99)
+	}
+	writeCounts(tw, "client", rep.TopClients)
+	return tw.Flush()
+}
+
+func writeCounts(w io.Writer, title string, counts []Count) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s\trequests\n", title)
+	for _, c := range counts {
+		fmt.Fprintf(w, "%s\t%d\n", c.Key, c.Count)
+	}
+}
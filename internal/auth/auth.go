@@ -0,0 +1,253 @@
+// Package auth implements browser sign-in through OAuth2 authorization code
+// with PKCE, and maps signed-in users onto coarse RBAC roles. GitHub is the
+// only provider so far.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"goaws/internal/env"
+)
+
+// Config configures the sign-in flow. It is normally built by ConfigFromEnv.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Endpoint     GitHubEndpoint
+
+	// SessionSecret signs session and flow cookies. It must be shared by
+	// every instance behind the load balancer.
+	SessionSecret string
+	SessionTTL    time.Duration
+	SecureCookies bool
+
+	Policy Policy
+}
+
+// Enabled reports whether enough is configured to offer sign-in.
+func (c Config) Enabled() bool {
+	return c.ClientID != "" && c.ClientSecret != "" && c.SessionSecret != ""
+}
+
+// ConfigFromEnv reads the AUTH_* variables.
+func ConfigFromEnv() (Config, error) {
+	c := Config{
+		ClientID:     env.String("AUTH_GITHUB_CLIENT_ID", ""),
+		ClientSecret: env.String("AUTH_GITHUB_CLIENT_SECRET", ""),
+		RedirectURL:  env.String("AUTH_REDIRECT_URL", "http://localhost:8080/auth/callback"),
+		Endpoint: GitHubEndpoint{
+			AuthorizeURL: env.String("AUTH_GITHUB_AUTHORIZE_URL", DefaultGitHubEndpoint.AuthorizeURL),
+			TokenURL:     env.String("AUTH_GITHUB_TOKEN_URL", DefaultGitHubEndpoint.TokenURL),
+			APIURL:       strings.TrimSuffix(env.String("AUTH_GITHUB_API_URL", DefaultGitHubEndpoint.APIURL), "/"),
+		},
+		SessionSecret: env.String("AUTH_SESSION_SECRET", ""),
+		SessionTTL:    env.Duration("AUTH_SESSION_TTL", 12*time.Hour),
+		SecureCookies: env.Bool("AUTH_SECURE_COOKIES", strings.HasPrefix(env.String("AUTH_REDIRECT_URL", ""), "https://")),
+		Policy: Policy{
+			AllowedOrgs:  env.List("AUTH_ALLOWED_ORGS", nil),
+			AllowedTeams: env.List("AUTH_ALLOWED_TEAMS", nil),
+		},
+	}
+	var err error
+	if c.Policy.Rules, err = ParseRoleRules(env.List("AUTH_ROLES", nil)); err != nil {
+		return c, err
+	}
+	if c.Policy.DefaultRole, err = ParseRole(env.String("AUTH_DEFAULT_ROLE", "none")); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// Auth serves the sign-in endpoints and guards handlers by role.
+type Auth struct {
+	cfg      Config
+	provider Provider
+	cookies  signer
+	log      *slog.Logger
+}
+
+// New returns an Auth using provider for sign-in.
+func New(cfg Config, provider Provider) *Auth {
+	return &Auth{cfg: cfg, provider: provider, cookies: signer{key: []byte(cfg.SessionSecret)}, log: slog.Default()}
+}
+
+// NewGitHub returns an Auth signing in through GitHub as configured by cfg.
+func NewGitHub(cfg Config) *Auth {
+	return New(cfg, NewGitHubProvider(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, cfg.Endpoint))
+}
+
+// Handler serves /auth/login, /auth/callback, /auth/logout and /auth/me.
+func (a *Auth) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/login", a.login)
+	mux.HandleFunc("GET /auth/callback", a.callback)
+	mux.HandleFunc("POST /auth/logout", a.logout)
+	mux.HandleFunc("GET /auth/me", a.me)
+	return mux
+}
+
+func (a *Auth) login(w http.ResponseWriter, r *http.Request) {
+	f := flow{
+		State:    randomToken(24),
+		Verifier: randomToken(48),
+		Return:   safeReturn(r.URL.Query().Get("return")),
+		Expires:  time.Now().Add(10 * time.Minute),
+	}
+	if err := a.cookies.setCookie(w, flowCookie, f, f.Expires, a.cfg.SecureCookies); err != nil {
+		http.Error(w, "sign-in unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, a.provider.AuthCodeURL(f.State, codeChallenge(f.Verifier)), http.StatusFound)
+}
+
+func (a *Auth) callback(w http.ResponseWriter, r *http.Request) {
+	var f flow
+	if err := a.cookies.readCookie(r, flowCookie, &f); err != nil || time.Now().After(f.Expires) {
+		http.Error(w, "sign-in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	clearCookie(w, flowCookie)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "sign-in refused: "+e, http.StatusForbidden)
+		return
+	}
+	if q.Get("state") != f.State {
+		http.Error(w, "sign-in state mismatch", http.StatusBadRequest)
+		return
+	}
+
+	token, err := a.provider.Exchange(r.Context(), q.Get("code"), f.Verifier)
+	if err != nil {
+		a.log.Warn("oauth exchange failed", "provider", a.provider.Name(), "err", err)
+		http.Error(w, "sign-in failed", http.StatusBadGateway)
+		return
+	}
+	id, err := a.provider.Identity(r.Context(), token)
+	if err != nil {
+		a.log.Warn("oauth identity lookup failed", "provider", a.provider.Name(), "err", err)
+		http.Error(w, "sign-in failed", http.StatusBadGateway)
+		return
+	}
+	role, err := a.cfg.Policy.Resolve(id)
+	if err != nil {
+		a.log.Info("sign-in denied", "provider", a.provider.Name(), "login", id.Login, "err", err)
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
+	s := Session{
+		Provider: a.provider.Name(),
+		Login:    id.Login,
+		Name:     id.Name,
+		Role:     role,
+		Expires:  time.Now().Add(a.cfg.SessionTTL),
+	}
+	if err := a.cookies.setCookie(w, sessionCookie, s, s.Expires, a.cfg.SecureCookies); err != nil {
+		http.Error(w, "sign-in failed", http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("signed in", "provider", s.Provider, "login", s.Login, "role", s.Role.String())
+	http.Redirect(w, r, f.Return, http.StatusFound)
+}
+
+func (a *Auth) logout(w http.ResponseWriter, r *http.Request) {
+	clearCookie(w, sessionCookie)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Auth) me(w http.ResponseWriter, r *http.Request) {
+	s, err := a.session(r)
+	if err != nil {
+		http.Error(w, "not signed in", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"provider": s.Provider,
+		"login":    s.Login,
+		"name":     s.Name,
+		"role":     s.Role.String(),
+		"expires":  s.Expires,
+	})
+}
+
+func (a *Auth) session(r *http.Request) (*Session, error) {
+	var s Session
+	if err := a.cookies.readCookie(r, sessionCookie, &s); err != nil {
+		return nil, err
+	}
+	if time.Now().After(s.Expires) {
+		return nil, errors.New("auth: session expired")
+	}
+	return &s, nil
+}
+
+// Middleware attaches the session of a signed-in request, so that handlers
+// and evaluations such as feature flags can find the user through
+// FromContext. Requests without a valid session pass through as they are.
+// A nil Auth, as when sign-in is not configured, returns next.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Most requests carry no cookie at all; those skip parsing.
+		if _, ok := r.Header["Cookie"]; ok {
+			if s, err := a.session(r); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), sessionKey{}, s))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Require wraps next so that only sessions with at least role reach it. The
+// session is available to next through FromContext. A nil Auth, as when
+// sign-in is not configured, returns next.
+func (a *Auth) Require(role Role, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := FromContext(r.Context())
+		if !ok {
+			var err error
+			if s, err = a.session(r); err != nil {
+				http.Error(w, "not signed in", http.StatusUnauthorized)
+				return
+			}
+		}
+		if s.Role < role {
+			http.Error(w, fmt.Sprintf("requires role %s", role), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+	})
+}
+
+type sessionKey struct{}
+
+// FromContext returns the session attached by Middleware or Require.
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(*Session)
+	return s, ok
+}
+
+// safeReturn only allows local absolute paths as post-login destinations, so
+// the login endpoint cannot be used as an open redirect.
+func safeReturn(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	return p
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider signs everyone in as id, checking the PKCE verifier against
+// the challenge it was given.
+type fakeProvider struct {
+	id        Identity
+	challenge string
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) AuthCodeURL(state, challenge string) string {
+	p.challenge = challenge
+	return "https://idp.example/authorize?state=" + url.QueryEscape(state)
+}
+
+func (p *fakeProvider) Exchange(_ context.Context, code, verifier string) (string, error) {
+	if code != "code" || codeChallenge(verifier) != p.challenge {
+		return "", context.Canceled
+	}
+	return "token", nil
+}
+
+func (p *fakeProvider) Identity(context.Context, string) (*Identity, error) {
+	id := p.id
+	return &id, nil
+}
+
+func testConfig() Config {
+	return Config{SessionSecret: "secret", SessionTTL: time.Hour, Policy: Policy{
+		Rules: []RoleRule{{Subject: "acme", Role: RoleViewer}, {Subject: "acme/ops", Role: RoleOperator}},
+	}}
+}
+
+// signIn runs the login and callback endpoints and returns the cookies set
+// on the way.
+func signIn(t *testing.T, a *Auth) []*http.Cookie {
+	t.Helper()
+	h := a.Handler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login?return=/dashboard", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: %d", w.Code)
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	state := loc.Query().Get("state")
+
+	r := httptest.NewRequest("GET", "/auth/callback?code=code&state="+url.QueryEscape(state), nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("callback: %d %s", w.Code, w.Body)
+	}
+	return w.Result().Cookies()
+}
+
+func sessionCookieFrom(t *testing.T, cookies []*http.Cookie) *http.Cookie {
+	t.Helper()
+	for _, c := range cookies {
+		if c.Name == sessionCookie && c.Value != "" {
+			return c
+		}
+	}
+	t.Fatal("no session cookie")
+	return nil
+}
+
+func TestSignInAndRequire(t *testing.T) {
+	a := New(testConfig(), &fakeProvider{id: Identity{Login: "ann", Orgs: []string{"acme"}}})
+	cookie := sessionCookieFrom(t, signIn(t, a))
+
+	var seen *Session
+	app := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = FromContext(r.Context())
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	app.ServeHTTP(httptest.NewRecorder(), r)
+	if seen == nil || seen.Login != "ann" || seen.Role != RoleViewer {
+		t.Fatalf("session = %+v", seen)
+	}
+
+	// Without a cookie, or with a tampered one, requests pass through
+	// anonymously.
+	for _, c := range []*http.Cookie{nil, {Name: sessionCookie, Value: cookie.Value + "x"}} {
+		seen = nil
+		r := httptest.NewRequest("GET", "/", nil)
+		if c != nil {
+			r.AddCookie(c)
+		}
+		app.ServeHTTP(httptest.NewRecorder(), r)
+		if seen != nil {
+			t.Errorf("cookie %v: session = %+v", c, seen)
+		}
+	}
+
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for _, tc := range []struct {
+		role   Role
+		cookie bool
+		want   int
+	}{
+		{RoleViewer, true, http.StatusOK},
+		{RoleOperator, true, http.StatusForbidden},
+		{RoleViewer, false, http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tc.cookie {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		a.Middleware(a.Require(tc.role, ok)).ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("Require(%s), cookie %t: %d, want %d", tc.role, tc.cookie, w.Code, tc.want)
+		}
+	}
+}
+
+func TestRequireGuardsManagementEndpoints(t *testing.T) {
+	admin := func(a *Auth) http.Handler {
+		mux := http.NewServeMux()
+		ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+		mux.Handle("/flags/", a.Require(RoleOperator, ok))
+		mux.Handle("GET /debug/slo", a.Require(RoleViewer, ok))
+		return mux
+	}
+	viewer := New(testConfig(), &fakeProvider{id: Identity{Login: "ann", Orgs: []string{"acme"}}})
+	operator := New(testConfig(), &fakeProvider{id: Identity{Login: "bo", Orgs: []string{"acme"}, Teams: []string{"acme/ops"}}})
+	for _, tc := range []struct {
+		a           *Auth
+		method, url string
+		want        int
+	}{
+		{viewer, "PUT", "/flags/new-checkout/override", http.StatusForbidden},
+		{viewer, "GET", "/flags/", http.StatusForbidden},
+		{viewer, "GET", "/debug/slo", http.StatusOK},
+		{operator, "PUT", "/flags/new-checkout/override", http.StatusOK},
+	} {
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.AddCookie(sessionCookieFrom(t, signIn(t, tc.a)))
+		w := httptest.NewRecorder()
+		admin(tc.a).ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.url, w.Code, tc.want)
+		}
+	}
+
+	// Without sign-in configured the endpoints are open, as before.
+	w := httptest.NewRecorder()
+	admin(nil).ServeHTTP(w, httptest.NewRequest("PUT", "/flags/new-checkout/override", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("nil Auth: %d", w.Code)
+	}
+}
+
+func TestCallbackRejectsStateMismatch(t *testing.T) {
+	a := New(testConfig(), &fakeProvider{id: Identity{Login: "ann", Orgs: []string{"acme"}}})
+	w := httptest.NewRecorder()
+	a.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/auth/login", nil))
+
+	r := httptest.NewRequest("GET", "/auth/callback?code=code&state=forged", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	a.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d", w.Code)
+	}
+}
+
+func TestNilAuthMiddleware(t *testing.T) {
+	var a *Auth
+	h := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	if got := a.Middleware(h); got == nil {
+		t.Fatal("nil handler")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// BASE64URL(SHA256(verifier)) without padding, as RFC 7636 4.2 asks.
+	if got := codeChallenge("dBjftJeZ4CVP-mJ92K9qsyjqbT7vR5w6DbiNRfbUSkw"); got != "RFXgRAVhZ_p1bugRtYL0gJ5p-H9LqevHMLzcKf8uvKU" {
+		t.Errorf("codeChallenge = %s", got)
+	}
+	if v := randomToken(48); len(v) != 64 || strings.ContainsAny(v, "+/=") {
+		t.Errorf("randomToken = %q", v)
+	}
+}
+
+func TestPolicyResolve(t *testing.T) {
+	rules, err := ParseRoleRules([]string{"acme=viewer", "ACME/ops=operator", "user:Root=admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		id     Identity
+		want   Role
+		denied bool
+	}{
+		{"org rule", Policy{Rules: rules}, Identity{Login: "a", Orgs: []string{"acme"}}, RoleViewer, false},
+		{"highest rule wins", Policy{Rules: rules}, Identity{Login: "a", Orgs: []string{"acme"}, Teams: []string{"acme/ops"}}, RoleOperator, false},
+		{"user rule", Policy{Rules: rules}, Identity{Login: "root"}, RoleAdmin, false},
+		{"no rule", Policy{Rules: rules}, Identity{Login: "a", Orgs: []string{"other"}}, RoleNone, true},
+		{"default role", Policy{DefaultRole: RoleViewer}, Identity{Login: "a"}, RoleViewer, false},
+		{"not a member", Policy{AllowedOrgs: []string{"acme"}, DefaultRole: RoleAdmin}, Identity{Login: "a", Orgs: []string{"other"}}, RoleNone, true},
+		{"team member", Policy{AllowedTeams: []string{"Acme/Ops"}, DefaultRole: RoleViewer}, Identity{Login: "a", Teams: []string{"acme/ops"}}, RoleViewer, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.policy.Resolve(&tc.id)
+			if got != tc.want || (err != nil) != tc.denied {
+				t.Errorf("Resolve = %s, %v; want %s, denied %t", got, err, tc.want, tc.denied)
+			}
+		})
+	}
+
+	for _, bad := range []string{"acme", "=viewer", "acme=owner"} {
+		if _, err := ParseRoleRules([]string{bad}); err == nil {
+			t.Errorf("ParseRoleRules(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSafeReturn(t *testing.T) {
+	for in, want := range map[string]string{
+		"/dashboard?x=1":       "/dashboard?x=1",
+		"":                     "/",
+		"https://evil.example": "/",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+	} {
+		if got := safeReturn(in); got != want {
+			t.Errorf("safeReturn(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSignerRejectsTampering(t *testing.T) {
+	s := signer{key: []byte("k")}
+	v, err := s.encode(Session{Login: "ann", Role: RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Session
+	if err := s.decode(v, &got); err != nil || got.Login != "ann" {
+		t.Fatalf("decode = %+v, %v", got, err)
+	}
+	payload, sig, _ := strings.Cut(v, ".")
+	forged, _ := signer{key: []byte("other")}.encode(Session{Login: "ann", Role: RoleAdmin})
+	for _, bad := range []string{payload, payload + ".", forged, "x" + payload + "." + sig} {
+		if err := s.decode(bad, &got); err == nil {
+			t.Errorf("decode(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GitHubEndpoint holds the URLs used by GitHubProvider. The defaults point at
+// github.com; tests and GitHub Enterprise override them.
+type GitHubEndpoint struct {
+	AuthorizeURL string
+	TokenURL     string
+	APIURL       string
+}
+
+var DefaultGitHubEndpoint = GitHubEndpoint{
+	AuthorizeURL: "https://github.com/login/oauth/authorize",
+	TokenURL:     "https://github.com/login/oauth/access_token",
+	APIURL:       "https://api.github.com",
+}
+
+// GitHubProvider implements Provider against a GitHub OAuth app.
+type GitHubProvider struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Endpoint     GitHubEndpoint
+	Client       *http.Client
+}
+
+// NewGitHubProvider returns a provider with sensible defaults filled in.
+func NewGitHubProvider(clientID, clientSecret, redirectURL string, endpoint GitHubEndpoint) *GitHubProvider {
+	return &GitHubProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint:     endpoint,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *GitHubProvider) Name() string { return "github" }
+
+func (p *GitHubProvider) AuthCodeURL(state, challenge string) string {
+	q := url.Values{
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {"read:user read:org"},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"allow_signup":          {"false"},
+	}
+	return p.Endpoint.AuthorizeURL + "?" + q.Encode()
+}
+
+func (p *GitHubProvider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("github: token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("github: token exchange: %s: %w", resp.Status, err)
+	}
+	// GitHub reports OAuth errors with a 200 status and an error field.
+	if body.Error != "" {
+		return "", fmt.Errorf("github: token exchange: %s: %s", body.Error, body.Description)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", fmt.Errorf("github: token exchange: %s", resp.Status)
+	}
+	return body.AccessToken, nil
+}
+
+func (p *GitHubProvider) Identity(ctx context.Context, token string) (*Identity, error) {
+	var user struct {
+		Login string `json:"login"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if _, err := p.get(ctx, token, p.Endpoint.APIURL+"/user", &user); err != nil {
+		return nil, err
+	}
+	id := &Identity{Login: user.Login, Name: user.Name, Email: user.Email}
+
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err := p.getAll(ctx, token, "/user/orgs", &orgs); err != nil {
+		return nil, err
+	}
+	for _, o := range orgs {
+		id.Orgs = append(id.Orgs, strings.ToLower(o.Login))
+	}
+
+	var teams []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err := p.getAll(ctx, token, "/user/teams", &teams); err != nil {
+		return nil, err
+	}
+	for _, t := range teams {
+		id.Teams = append(id.Teams, strings.ToLower(t.Organization.Login+"/"+t.Slug))
+	}
+	return id, nil
+}
+
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// getAll follows Link rel="next" pagination, appending every page to out,
+// which must point to a slice.
+func (p *GitHubProvider) getAll(ctx context.Context, token, path string, out any) error {
+	u := p.Endpoint.APIURL + path + "?per_page=100"
+	var all []json.RawMessage
+	for u != "" {
+		var page []json.RawMessage
+		link, err := p.get(ctx, token, u, &page)
+		if err != nil {
+			return err
+		}
+		all = append(all, page...)
+		u = ""
+		if m := nextLink.FindStringSubmatch(link); m != nil {
+			u = m[1]
+		}
+	}
+	b, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (p *GitHubProvider) get(ctx context.Context, token, u string, out any) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("github: GET %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("github: GET %s: %s", u, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(out); err != nil {
+		return "", fmt.Errorf("github: GET %s: %w", u, err)
+	}
+	return resp.Header.Get("Link"), nil
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Identity is what a provider knows about the signed-in user.
+type Identity struct {
+	Login string
+	Name  string
+	Email string
+	// Orgs holds lower-cased organization logins, Teams lower-cased
+	// "org/team-slug" pairs.
+	Orgs  []string
+	Teams []string
+}
+
+func (id *Identity) matches(subject string) bool {
+	if login, ok := strings.CutPrefix(subject, "user:"); ok {
+		return strings.EqualFold(login, id.Login)
+	}
+	list := id.Orgs
+	if strings.Contains(subject, "/") {
+		list = id.Teams
+	}
+	for _, s := range list {
+		if s == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// Provider is an OAuth2 authorization server plus the API used to look up the
+// user behind an access token.
+type Provider interface {
+	Name() string
+	// AuthCodeURL returns the URL the browser is sent to in order to
+	// authorize, with the PKCE S256 challenge attached.
+	AuthCodeURL(state, challenge string) string
+	// Exchange trades an authorization code for an access token.
+	Exchange(ctx context.Context, code, verifier string) (string, error)
+	// Identity looks up the user, their organizations and teams.
+	Identity(ctx context.Context, token string) (*Identity, error)
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role is a coarse permission level. Higher roles include everything the
+// lower ones can do.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// ParseRole is the inverse of Role.String.
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	case "none", "":
+		return RoleNone, nil
+	}
+	return RoleNone, fmt.Errorf("auth: unknown role %q", s)
+}
+
+// RoleRule grants Role to identities matching Subject. Subject is one of
+// "org", "org/team" or "user:login".
+type RoleRule struct {
+	Subject string
+	Role    Role
+}
+
+// ParseRoleRules parses entries of the form "subject=role", as found in
+// AUTH_ROLES.
+func ParseRoleRules(entries []string) ([]RoleRule, error) {
+	var rules []RoleRule
+	for _, e := range entries {
+		subject, role, ok := strings.Cut(e, "=")
+		if !ok || strings.TrimSpace(subject) == "" {
+			return nil, fmt.Errorf("auth: malformed role rule %q", e)
+		}
+		r, err := ParseRole(role)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, RoleRule{Subject: strings.ToLower(strings.TrimSpace(subject)), Role: r})
+	}
+	return rules, nil
+}
+
+// Policy decides whether an identity may sign in and which role it gets.
+type Policy struct {
+	// AllowedOrgs and AllowedTeams restrict sign-in to members. When both
+	// are empty any identity matching a role rule, or any identity at all
+	// when DefaultRole is set, may sign in.
+	AllowedOrgs  []string
+	AllowedTeams []string
+	Rules        []RoleRule
+	DefaultRole  Role
+}
+
+// Resolve returns the highest role granted to id, or an error explaining why
+// access is denied.
+func (p Policy) Resolve(id *Identity) (Role, error) {
+	if !p.member(id) {
+		return RoleNone, fmt.Errorf("auth: %s is not a member of an allowed organization or team", id.Login)
+	}
+	role := p.DefaultRole
+	for _, rule := range p.Rules {
+		if rule.Role > role && id.matches(rule.Subject) {
+			role = rule.Role
+		}
+	}
+	if role == RoleNone {
+		return RoleNone, fmt.Errorf("auth: no role granted to %s", id.Login)
+	}
+	return role, nil
+}
+
+func (p Policy) member(id *Identity) bool {
+	if len(p.AllowedOrgs) == 0 && len(p.AllowedTeams) == 0 {
+		return true
+	}
+	for _, org := range p.AllowedOrgs {
+		if id.matches(strings.ToLower(org)) {
+			return true
+		}
+	}
+	for _, team := range p.AllowedTeams {
+		if id.matches(strings.ToLower(team)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+// Package aws is a minimal AWS API client: SigV4 signing, credentials from
+// the environment or the instance profile, and the JSON and Query protocols.
+// Endpoints follow the SDK convention of AWS_ENDPOINT_URL_<SERVICE> and
+// AWS_ENDPOINT_URL, so every service can be pointed at a local stand-in.
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"goaws/internal/env"
+)
+
+// Service identifies an AWS API.
+type Service struct {
+	// ID is the suffix of the AWS_ENDPOINT_URL_<ID> override.
+	ID string
+	// Host is the regional endpoint host prefix.
+	Host string
+	// SigningName is the SigV4 service name.
+	SigningName string
+}
+
+var (
+	SNS            = Service{ID: "SNS", Host: "sns", SigningName: "sns"}
+	EventBridge    = Service{ID: "EVENTBRIDGE", Host: "events", SigningName: "events"}
+	CloudWatchLogs = Service{ID: "CLOUDWATCH_LOGS", Host: "logs", SigningName: "logs"}
+	S3             = Service{ID: "S3", Host: "s3", SigningName: "s3"}
+)
+
+// Client sends signed requests to AWS or a compatible endpoint.
+type Client struct {
+	Region      string
+	Credentials CredentialsProvider
+	HTTP        *http.Client
+}
+
+// NewClient returns a client for AWS_REGION using the default credential
+// chain.
+func NewClient() *Client {
+	return &Client{
+		Region:      env.String("AWS_REGION", env.String("AWS_DEFAULT_REGION", "ap-southeast-1")),
+		Credentials: DefaultCredentials(),
+		HTTP:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Endpoint returns the base URL for svc, honouring overrides.
+func (c *Client) Endpoint(svc Service) string {
+	if u := env.String("AWS_ENDPOINT_URL_"+svc.ID, env.String("AWS_ENDPOINT_URL", "")); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "https://" + svc.Host + "." + c.Region + ".amazonaws.com"
+}
+
+// APIError is an error response from an AWS API.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("aws: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Retryable reports whether the request may succeed if sent again.
+func (e *APIError) Retryable() bool {
+	if e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	switch e.Code {
+	case "Throttling", "ThrottlingException", "ThrottledException", "RequestLimitExceeded",
+		"TooManyRequestsException", "ServiceUnavailable", "SlowDown", "RequestTimeout":
+		return true
+	}
+	return false
+}
+
+// Do signs and sends a request to path under svc's endpoint.
+func (c *Client) Do(ctx context.Context, svc Service, method, path string, header http.Header, body []byte) (*http.Response, error) {
+	return c.DoURL(ctx, svc, method, c.Endpoint(svc)+path, header, body)
+}
+
+// DoURL signs and sends a request to an absolute URL.
+func (c *Client) DoURL(ctx context.Context, svc Service, method, rawURL string, header http.Header, body []byte) (*http.Response, error) {
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	Sign(req, PayloadHash(body), creds, svc.SigningName, c.Region, time.Now())
+	return c.HTTP.Do(req)
+}
+
+// JSON calls an operation of an AWS JSON 1.1 protocol service, such as
+// EventBridge or CloudWatch Logs. target is e.g. "AWSEvents.PutEvents".
+func (c *Client) JSON(ctx context.Context, svc Service, target string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	h := http.Header{}
+	h.Set("Content-Type", "application/x-amz-json-1.1")
+	h.Set("X-Amz-Target", target)
+	resp, err := c.Do(ctx, svc, http.MethodPost, "/", h, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// encoding/json matches "message" and "Message" alike.
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(b, &e)
+		// __type may be namespaced, e.g. "com.amazonaws...#ThrottlingException".
+		if i := strings.LastIndex(e.Type, "#"); i >= 0 {
+			e.Type = e.Type[i+1:]
+		}
+		return &APIError{StatusCode: resp.StatusCode, Code: e.Type, Message: e.Message}
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// Query calls an operation of an AWS Query protocol service, such as SNS,
+// decoding the XML response into out.
+func (c *Client) Query(ctx context.Context, svc Service, form url.Values, out any) error {
+	h := http.Header{}
+	h.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	resp, err := c.Do(ctx, svc, http.MethodPost, "/", h, []byte(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return xmlError(resp.StatusCode, b)
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(b, out)
+}
+
+// xmlError decodes both the Query (<ErrorResponse><Error>) and the S3
+// (<Error>) error shapes.
+func xmlError(status int, b []byte) error {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+		Inner   struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		} `xml:"Error"`
+	}
+	xml.Unmarshal(b, &e)
+	if e.Code == "" {
+		e.Code, e.Message = e.Inner.Code, e.Inner.Message
+	}
+	return &APIError{StatusCode: status, Code: e.Code, Message: e.Message}
+}
@@ -0,0 +1,95 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testClient returns a client whose every service is served by h.
+func testClient(t *testing.T, h http.HandlerFunc) *Client {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	return &Client{Region: "us-east-1", Credentials: StaticCredentials(testCreds), HTTP: srv.Client()}
+}
+
+func TestEndpoint(t *testing.T) {
+	c := &Client{Region: "eu-west-1"}
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	t.Setenv("AWS_ENDPOINT_URL_SNS", "")
+	if got := c.Endpoint(SNS); got != "https://sns.eu-west-1.amazonaws.com" {
+		t.Errorf("default endpoint %s", got)
+	}
+	t.Setenv("AWS_ENDPOINT_URL", "http://localhost:4566/")
+	t.Setenv("AWS_ENDPOINT_URL_SNS", "http://localhost:9911/")
+	if got := c.Endpoint(SNS); got != "http://localhost:9911" {
+		t.Errorf("service override %s", got)
+	}
+	if got := c.Endpoint(EventBridge); got != "http://localhost:4566" {
+		t.Errorf("global override %s", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/events/aws4_request") {
+			t.Errorf("Authorization = %s", r.Header.Get("Authorization"))
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "AWSEvents.PutEvents":
+			io.WriteString(w, `{"FailedEntryCount":0}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"__type":"com.amazonaws.events#ThrottlingException","message":"slow down"}`)
+		}
+	})
+
+	var out struct{ FailedEntryCount int }
+	if err := c.JSON(context.Background(), EventBridge, "AWSEvents.PutEvents", struct{}{}, &out); err != nil {
+		t.Fatal(err)
+	}
+	err := c.JSON(context.Background(), EventBridge, "AWSEvents.Other", struct{}{}, nil)
+	var ae *APIError
+	if !errors.As(err, &ae) || ae.Code != "ThrottlingException" || ae.Message != "slow down" || !ae.Retryable() {
+		t.Errorf("err = %#v", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") == "Publish" {
+			io.WriteString(w, `<PublishResponse><PublishResult><MessageId>m-1</MessageId></PublishResult></PublishResponse>`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `<ErrorResponse><Error><Code>InvalidParameter</Code><Message>bad topic</Message></Error></ErrorResponse>`)
+	})
+
+	var out struct {
+		MessageID string `xml:"PublishResult>MessageId"`
+	}
+	if err := c.Query(context.Background(), SNS, url.Values{"Action": {"Publish"}}, &out); err != nil || out.MessageID != "m-1" {
+		t.Fatalf("Query = %+v, %v", out, err)
+	}
+	err := c.Query(context.Background(), SNS, url.Values{"Action": {"Other"}}, nil)
+	var ae *APIError
+	if !errors.As(err, &ae) || ae.Code != "InvalidParameter" || ae.Retryable() {
+		t.Errorf("err = %#v", err)
+	}
+}
+
+func TestXMLErrorS3Shape(t *testing.T) {
+	err := xmlError(503, []byte(`<Error><Code>SlowDown</Code><Message>reduce rate</Message></Error>`))
+	var ae *APIError
+	if !errors.As(err, &ae) || ae.Code != "SlowDown" || !ae.Retryable() {
+		t.Errorf("err = %#v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials sign requests. Expires is zero for long-lived keys.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// CredentialsProvider returns current credentials, refreshing them as needed.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials always returns the same keys; handy for local fakes.
+type StaticCredentials Credentials
+
+func (c StaticCredentials) Retrieve(context.Context) (Credentials, error) {
+	return Credentials(c), nil
+}
+
+// EnvCredentials reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+type EnvCredentials struct{}
+
+func (EnvCredentials) Retrieve(context.Context) (Credentials, error) {
+	c := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, errors.New("aws: no credentials in environment")
+	}
+	return c, nil
+}
+
+// IMDSCredentials fetches the instance profile's role credentials from the
+// EC2 instance metadata service using IMDSv2 session tokens.
+type IMDSCredentials struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+func (p IMDSCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 2 * time.Second}
+	}
+	token, err := imdsToken(ctx, client, endpoint)
+	if err != nil {
+		return Credentials{}, err
+	}
+	role, err := imdsGet(ctx, client, endpoint, token, "/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return Credentials{}, err
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	body, err := imdsGet(ctx, client, endpoint, token, "/latest/meta-data/iam/security-credentials/"+role)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var v struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		return Credentials{}, fmt.Errorf("aws: decoding instance credentials: %w", err)
+	}
+	return Credentials{AccessKeyID: v.AccessKeyID, SecretAccessKey: v.SecretAccessKey, SessionToken: v.Token, Expires: v.Expiration}, nil
+}
+
+func imdsToken(ctx context.Context, client *http.Client, endpoint string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("aws: instance metadata unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aws: instance metadata token: %s", resp.Status)
+	}
+	return string(b), nil
+}
+
+func imdsGet(ctx context.Context, client *http.Client, endpoint, token, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("aws: instance metadata %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aws: instance metadata %s: %s", path, resp.Status)
+	}
+	return string(b), nil
+}
+
+// InstanceMetadata fetches a single value, e.g. "instance-id", from the
+// instance metadata service.
+func InstanceMetadata(ctx context.Context, name string) (string, error) {
+	client := &http.Client{Timeout: time.Second}
+	endpoint := "http://169.254.169.254"
+	token, err := imdsToken(ctx, client, endpoint)
+	if err != nil {
+		return "", err
+	}
+	return imdsGet(ctx, client, endpoint, token, "/latest/meta-data/"+name)
+}
+
+// ChainCredentials tries each provider in order and caches the first
+// success until shortly before it expires.
+type ChainCredentials struct {
+	Providers []CredentialsProvider
+
+	mu     sync.Mutex
+	cached Credentials
+	ok     bool
+}
+
+// DefaultCredentials looks in the environment, then at the instance profile.
+func DefaultCredentials() *ChainCredentials {
+	return &ChainCredentials{Providers: []CredentialsProvider{EnvCredentials{}, IMDSCredentials{}}}
+}
+
+func (c *ChainCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ok && (c.cached.Expires.IsZero() || time.Until(c.cached.Expires) > 5*time.Minute) {
+		return c.cached, nil
+	}
+	var errs []error
+	for _, p := range c.Providers {
+		creds, err := p.Retrieve(ctx)
+		if err == nil {
+			c.cached, c.ok = creds, true
+			return creds, nil
+		}
+		errs = append(errs, err)
+	}
+	return Credentials{}, fmt.Errorf("aws: no credentials found: %w", errors.Join(errs...))
+}
@@ -0,0 +1,98 @@
+package aws
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Object is one entry of a bucket listing.
+type S3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// s3Path returns the path-style path of key in bucket. Path-style
+// addressing works with AWS and with S3-compatible stores such as MinIO.
+func s3Path(bucket, key string) string {
+	segs := strings.Split(key, "/")
+	for i, s := range segs {
+		segs[i] = uriEncode(s)
+	}
+	return "/" + uriEncode(bucket) + "/" + strings.Join(segs, "/")
+}
+
+// ListObjects returns every object in bucket whose key starts with prefix,
+// following continuation tokens.
+func (c *Client) ListObjects(ctx context.Context, bucket, prefix string) ([]S3Object, error) {
+	var objs []S3Object
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := c.Do(ctx, S3, http.MethodGet, "/"+uriEncode(bucket)+"?"+q.Encode(), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, xmlError(resp.StatusCode, b)
+		}
+		var out struct {
+			Contents              []S3Object
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(b, &out); err != nil {
+			return nil, err
+		}
+		objs = append(objs, out.Contents...)
+		if !out.IsTruncated || out.NextContinuationToken == "" {
+			return objs, nil
+		}
+		token = out.NextContinuationToken
+	}
+}
+
+// GetObject returns the body of key in bucket. The caller closes it.
+func (c *Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	resp, err := c.Do(ctx, S3, http.MethodGet, s3Path(bucket, key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		return nil, xmlError(resp.StatusCode, b)
+	}
+	return resp.Body, nil
+}
+
+// PutObject uploads body as key in bucket.
+func (c *Client) PutObject(ctx context.Context, bucket, key, contentType string, body []byte) error {
+	h := http.Header{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	resp, err := c.Do(ctx, S3, http.MethodPut, s3Path(bucket, key), h, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return xmlError(resp.StatusCode, b)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package aws
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigv4Algorithm = "AWS4-HMAC-SHA256"
+	amzDate        = "20060102T150405Z"
+)
+
+// UnsignedPayload may be passed as the payload hash for S3 uploads that are
+// streamed rather than buffered.
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// PayloadHash returns the hex SHA-256 of body as SigV4 expects it.
+func PayloadHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign adds Signature Version 4 headers to req. payloadHash is the value of
+// PayloadHash for the body, or UnsignedPayload.
+func Sign(req *http.Request, payloadHash string, creds Credentials, service, region string, now time.Time) {
+	now = now.UTC()
+	stamp := now.Format(amzDate)
+	day := stamp[:8]
+
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+
+	names := []string{"host"}
+	canonHeaders := map[string]string{"host": req.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "authorization" || lk == "user-agent" {
+			continue
+		}
+		names = append(names, lk)
+		canonHeaders[lk] = strings.Join(trimAll(v), ",")
+	}
+	sort.Strings(names)
+	var hb strings.Builder
+	for _, n := range names {
+		hb.WriteString(n + ":" + canonHeaders[n] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL, service),
+		canonicalQuery(req.URL.Query()),
+		hb.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := strings.Join([]string{sigv4Algorithm, stamp, scope, PayloadHash([]byte(canonical))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", sigv4Algorithm+" Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func trimAll(vs []string) []string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return out
+}
+
+// canonicalPath URI-encodes each path segment. S3 keys are encoded once,
+// every other service twice, as the SigV4 spec requires.
+func canonicalPath(u *url.URL, service string) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	if service == "s3" {
+		return p
+	}
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		segs[i] = uriEncode(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except RFC 3986 unreserved characters.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+// Package bench measures the request hot path with testing.Benchmark and
+// holds each benchmark to an allocation budget, so that a change which
+// makes serving a request allocate more fails app bench rather than
+// showing up later as GC time on a small instance.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"testing"
+	"text/tabwriter"
+)
+
+// Case is one benchmark.
+type Case struct {
+	Name string
+	// MaxAllocs is the budget of heap allocations per operation.
+	MaxAllocs int64
+	// Setup prepares the operation to time. done releases what Setup
+	// acquired.
+	Setup func() (op func(), done func(), err error)
+}
+
+const warmup = 10000
+
+// Result is the outcome of one case.
+type Result struct {
+	Name        string `json:"name"`
+	Iterations  int    `json:"iterations"`
+	NsPerOp     int64  `json:"ns_per_op"`
+	BytesPerOp  int64  `json:"bytes_per_op"`
+	AllocsPerOp int64  `json:"allocs_per_op"`
+	MaxAllocs   int64  `json:"max_allocs"`
+	Over        bool   `json:"over_budget"`
+}
+
+// Run benchmarks the cases whose names match filter, or all if it is nil,
+// stopping early if ctx is done.
+func Run(ctx context.Context, cases []Case, filter *regexp.Regexp) ([]Result, error) {
+	var out []Result
+	for _, c := range cases {
+		if filter != nil && !filter.MatchString(c.Name) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		op, done, err := c.Setup()
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", c.Name, err)
+		}
+		// Warm up first, so that pools, maps and queues have reached their
+		// working size and only the steady state is measured.
+		for range warmup {
+			op()
+		}
+		r := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				op()
+			}
+		})
+		done()
+		out = append(out, Result{
+			Name:        c.Name,
+			Iterations:  r.N,
+			NsPerOp:     r.NsPerOp(),
+			BytesPerOp:  r.AllocedBytesPerOp(),
+			AllocsPerOp: r.AllocsPerOp(),
+			MaxAllocs:   c.MaxAllocs,
+			Over:        r.AllocsPerOp() > c.MaxAllocs,
+		})
+	}
+	return out, nil
+}
+
+// WriteText prints results as a table.
+func WriteText(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "benchmark\titerations\tns/op\tB/op\tallocs/op\tbudget\tresult")
+	for _, r := range results {
+		verdict := "ok"
+		if r.Over {
+			verdict = "OVER BUDGET"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", r.Name, r.Iterations, r.NsPerOp, r.BytesPerOp, r.AllocsPerOp, r.MaxAllocs, verdict)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,63 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCasesWithinBudget(t *testing.T) {
+	// Measured as app bench does: testing.AllocsPerRun runs with
+	// GOMAXPROCS=1, which starves the access log writer that hands
+	// buffers back to the pool.
+	prev := flag.Lookup("test.benchtime").Value.String()
+	flag.Set("test.benchtime", "20000x")
+	defer flag.Set("test.benchtime", prev)
+
+	results, err := Run(context.Background(), Cases(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Over {
+			t.Errorf("%s: %d allocs per op, budget %d", r.Name, r.AllocsPerOp, r.MaxAllocs)
+		}
+	}
+}
+
+// grown keeps the allocation of an over-budget case alive.
+var grown []byte
+
+func TestRunFlagsCasesOverBudget(t *testing.T) {
+	cases := []Case{
+		{Name: "none", Setup: func() (func(), func(), error) { return func() {}, func() {}, nil }},
+		{Name: "grows", Setup: func() (func(), func(), error) {
+			return func() { grown = make([]byte, 64) }, func() {}, nil
+		}},
+		{Name: "skipped", Setup: func() (func(), func(), error) { panic("not filtered out") }},
+	}
+	results, err := Run(context.Background(), cases, regexp.MustCompile("^(none|grows)$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Over || !results[1].Over || results[1].AllocsPerOp != 1 {
+		t.Errorf("results %+v", results)
+	}
+
+	var out bytes.Buffer
+	if err := WriteText(&out, results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "OVER BUDGET") || strings.Count(out.String(), "\n") != 3 {
+		t.Errorf("table:\n%s", out.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Run(ctx, cases, nil); err == nil {
+		t.Error("Run ignored a done context")
+	}
+}
@@ -0,0 +1,191 @@
+package bench
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"goaws/internal/accesslog"
+	"goaws/internal/flags"
+	"goaws/internal/httpx"
+	"goaws/internal/logging"
+	"goaws/internal/metrics"
+)
+
+// Cases are the hot path benchmarks. Budgets are what the code allocates
+// today; raise one only with a reason.
+func Cases() []Case {
+	cases := []Case{
+		{Name: "static", MaxAllocs: 0, Setup: handlerCase(hello)},
+		{Name: "client-ip", MaxAllocs: 0, Setup: clientIP},
+		{Name: "mux", MaxAllocs: 0, Setup: handlerCase(mux(hello))},
+		{Name: "logging", MaxAllocs: 2, Setup: handlerCase(logging.Middleware(hello))},
+		{Name: "server", MaxAllocs: 2, Setup: handlerCase(logging.Middleware(mux(hello)))},
+		{Name: "log-record", MaxAllocs: 0, Setup: logRecord},
+		{Name: "metrics-counter", MaxAllocs: 0, Setup: counter},
+		{Name: "flags-evaluate", MaxAllocs: 0, Setup: flagEvaluation},
+	}
+	// The ALB format's request line is too long for the stack buffer
+	// string concatenation uses.
+	for _, f := range []struct {
+		format string
+		allocs int64
+	}{{"combined", 1}, {"json", 1}, {"alb", 2}} {
+		cases = append(cases, Case{Name: "accesslog-" + f.format, MaxAllocs: f.allocs, Setup: accessLogCase(f.format, false)})
+	}
+	cases = append(cases, Case{Name: "server-accesslog", MaxAllocs: 3, Setup: accessLogCase("combined", true)})
+	return cases
+}
+
+var hello = httpx.Static("text/plain; charset=utf-8", []byte("Hello, Go AWS Auto Deployed"))
+
+// sink keeps results of pure functions alive.
+var sink string
+
+// newRequest returns a request as the ALB forwards it.
+func newRequest() *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "10.0.1.17:43512"
+	r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Amzn-Trace-Id", "Root=1-67891233-abcdef012345678912345678")
+	return r
+}
+
+// discardWriter is a ResponseWriter that keeps nothing but its header map,
+// which is cleared between operations so that it is reused as the server
+// reuses its own.
+type discardWriter struct{ h http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.h }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func handlerCase(h http.Handler) func() (func(), func(), error) {
+	return func() (func(), func(), error) {
+		w, r := &discardWriter{h: http.Header{}}, newRequest()
+		return func() {
+			clear(w.h)
+			h.ServeHTTP(w, r)
+		}, func() {}, nil
+	}
+}
+
+func mux(h http.Handler) http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/", h)
+	return httpx.Routes(m)
+}
+
+func clientIP() (func(), func(), error) {
+	r := newRequest()
+	return func() { sink = httpx.ClientIP(r) }, func() {}, nil
+}
+
+// logRecord logs a record with a request's context through the async
+// pipeline, which is what a handler's slog call costs it.
+func logRecord() (func(), func(), error) {
+	h, pipeline := logging.NewAsync(slog.NewJSONHandler(io.Discard, nil), logging.AsyncConfig{Enabled: true, Buffer: 8192, Overflow: "drop"})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		pipeline.Run(ctx)
+	}()
+	logger := slog.New(logging.ContextHandler(h))
+
+	// Take the context of a request that went through the middleware.
+	reqCtx := context.Background()
+	logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCtx = r.Context()
+	})).ServeHTTP(&discardWriter{h: http.Header{}}, newRequest())
+
+	return func() {
+			logger.InfoContext(reqCtx, "order placed", "items", 3, "total_cents", 4599)
+		}, func() {
+			cancel()
+			<-stopped
+		}, nil
+}
+
+func counter() (func(), func(), error) {
+	c := metrics.NewRegistry().Counter("bench_requests_total", "Requests.", "code")
+	return func() { c.With("200").Inc() }, func() {}, nil
+}
+
+// flagEvaluation evaluates a flag that has to go through its targeting
+// rules to a percentage rollout for a request.
+func flagEvaluation() (func(), func(), error) {
+	dir, err := os.MkdirTemp("", "bench-flags")
+	if err != nil {
+		return nil, nil, err
+	}
+	done := func() { os.RemoveAll(dir) }
+	file := filepath.Join(dir, "flags.json")
+	doc := `{"flags": {"new-checkout": {"type": "bool", "default": false,
+	  "rules": [{"users": ["alice"], "value": true},
+	            {"header": "X-Beta", "values": ["1"], "value": true},
+	            {"ips": ["192.0.2.0/24"], "value": true}],
+	  "rollout": [{"percent": 50, "value": true}]}}}`
+	if err := os.WriteFile(file, []byte(doc), 0o644); err != nil {
+		done()
+		return nil, nil, err
+	}
+	set, err := flags.New(flags.Config{File: file})
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	r := newRequest()
+	return func() {
+		if set.Bool(flags.For(r), "new-checkout", false) {
+			sink = "on"
+		}
+	}, done, nil
+}
+
+// accessLogCase serves requests through the access log writing format to
+// a temporary file, inside logging.Middleware and around a mux if server
+// is set.
+func accessLogCase(format string, server bool) func() (func(), func(), error) {
+	return func() (func(), func(), error) {
+		dir, err := os.MkdirTemp("", "bench-accesslog")
+		if err != nil {
+			return nil, nil, err
+		}
+		l, err := accesslog.New(accesslog.Config{File: filepath.Join(dir, "access.log"), Format: format, Buffer: 10000})
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			l.Run(ctx)
+		}()
+		done := func() {
+			cancel()
+			<-stopped
+			os.RemoveAll(dir)
+		}
+
+		w, r := &discardWriter{h: http.Header{}}, newRequest()
+		if server {
+			h := logging.Middleware(l.Middleware(mux(hello)))
+			return func() {
+				clear(w.h)
+				h.ServeHTTP(w, r)
+			}, done, nil
+		}
+		h := l.Middleware(hello)
+		return func() {
+			clear(w.h)
+			h.ServeHTTP(w, r)
+		}, done, nil
+	}
+}
@@ -0,0 +1,78 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+)
+
+const usage = `usage: app bench [flags]
+
+Benchmarks the request hot path: the handlers and middleware every
+request goes through, the access log and a handler's log call. Each
+benchmark has a budget of allocations per operation; the command fails if
+any goes over, so it can gate a build.
+
+flags:
+`
+
+// Command runs app bench with args.
+func Command(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("bench", flag.ContinueOnError)
+	run := fset.String("run", "", "only benchmarks whose names match this regexp")
+	benchtime := fset.String("benchtime", "1s", "time to run each benchmark for, or Nx for N iterations; very short runs count warm-up allocations")
+	asJSON := fset.Bool("json", false, "print the results as JSON")
+	fset.Usage = func() {
+		fmt.Fprint(fset.Output(), usage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() != 0 {
+		fset.Usage()
+		return flag.ErrHelp
+	}
+	var filter *regexp.Regexp
+	if *run != "" {
+		var err error
+		if filter, err = regexp.Compile(*run); err != nil {
+			return fmt.Errorf("-run: %w", err)
+		}
+	}
+	// testing.Benchmark reads the duration from the testing package's own
+	// flag.
+	testing.Init()
+	if err := flag.Set("test.benchtime", *benchtime); err != nil {
+		return fmt.Errorf("-benchtime: %w", err)
+	}
+
+	results, err := Run(ctx, Cases(), filter)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	} else {
+		err = WriteText(os.Stdout, results)
+	}
+	if err != nil {
+		return err
+	}
+	over := 0
+	for _, r := range results {
+		if r.Over {
+			over++
+		}
+	}
+	if over > 0 {
+		return fmt.Errorf("%d of %d benchmarks over their allocation budget", over, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package buildinfo describes the running binary. The variables are set at
+// link time by buildspec.yml:
+//
+//	go build -ldflags "-X goaws/internal/buildinfo.Commit=..." ...
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Commit string
+	Tag    string
+	// Time is the build time, e.g. 2024-05-01_12:00:00.
+	Time string
+)
+
+// Version returns the release tag, else the commit, else the VCS revision
+// the Go toolchain stamped, else "devel".
+func Version() string {
+	switch {
+	case Tag != "":
+		return Tag
+	case Commit != "":
+		return short(Commit)
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				return short(s.Value)
+			}
+		}
+	}
+	return "devel"
+}
+
+func short(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
+
+// Info is the build description included in reports.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// Get returns the running binary's Info.
+func Get() Info {
+	return Info{
+		Version:   Version(),
+		Commit:    Commit,
+		BuildTime: Time,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
@@ -0,0 +1,504 @@
+// Package crash keeps a record of the process dying. Each run writes its
+// build info to a run directory and points the runtime's crash output at a
+// file there; Recover, deferred at the top of main and of each worker,
+// adds every goroutine's stack, the most recent log records and the
+// requests in flight before the panic continues. The next run turns what
+// it finds into a crash bundle, reports a crash loop through readiness and
+// metrics, and can upload the bundles to an S3-compatible bucket.
+package crash
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goaws/internal/aws"
+	"goaws/internal/buildinfo"
+	"goaws/internal/env"
+	"goaws/internal/logging"
+	"goaws/internal/metrics"
+	"goaws/internal/redact"
+)
+
+var uploads = metrics.NewCounter("crash_bundles_uploaded_total", "Crash bundle uploads, by outcome.", "result")
+
+// Config configures crash reporting. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Dir holds the live run's directory and the newest Keep bundles.
+	Dir  string
+	Keep int
+	// LogRecords is how many recent log records a bundle holds.
+	LogRecords int
+	// LoopThreshold crashes within LoopWindow make a crash loop.
+	LoopThreshold int
+	LoopWindow    time.Duration
+	// Bucket, if set, receives bundles under Prefix<instance>/<bundle>/.
+	Bucket string
+	Prefix string
+	// Instance and Redactor are set by the caller. Request URIs and log
+	// records in bundles are redacted.
+	Instance string
+	Redactor *redact.Redactor
+}
+
+// ConfigFromEnv reads the CRASH_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Dir:           env.String("CRASH_DIR", "/var/lib/srv/crashes"),
+		Keep:          env.Int("CRASH_KEEP", 20),
+		LogRecords:    env.Int("CRASH_LOG_RECORDS", 200),
+		LoopThreshold: env.Int("CRASH_LOOP_THRESHOLD", 3),
+		LoopWindow:    env.Duration("CRASH_LOOP_WINDOW", 10*time.Minute),
+		Bucket:        env.String("CRASH_S3_BUCKET", ""),
+		Prefix:        env.String("CRASH_S3_PREFIX", "crashes/"),
+	}
+}
+
+// Bundle describes a crash. It is stored in the bundle's directory as
+// crash.json.
+type Bundle struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	// Reason is "panic" when Recover saw the panic, or "fatal" when only
+	// the runtime's crash output is available, e.g. for a panic in a
+	// goroutine without Recover or a fatal error such as a concurrent map
+	// write.
+	Reason   string         `json:"reason"`
+	Message  string         `json:"message"`
+	Build    buildinfo.Info `json:"build"`
+	Instance string         `json:"instance"`
+	Started  time.Time      `json:"started"`
+	Files    []string       `json:"files"`
+	Uploaded bool           `json:"uploaded,omitempty"`
+}
+
+// runInfo is written to build.json when a run starts.
+type runInfo struct {
+	Build    buildinfo.Info `json:"build"`
+	Instance string         `json:"instance"`
+	PID      int            `json:"pid"`
+	Started  time.Time      `json:"started"`
+}
+
+type request struct {
+	ID      string    `json:"request_id"`
+	Method  string    `json:"method"`
+	URI     string    `json:"uri"`
+	Started time.Time `json:"started"`
+	Age     string    `json:"age"`
+}
+
+// Reporter is the crash state of the running process. A nil *Reporter is
+// valid and does nothing.
+type Reporter struct {
+	cfg      Config
+	client   *aws.Client
+	run      string
+	started  time.Time
+	crashOut *os.File
+	ring     *logRing
+	panicked atomic.Bool
+
+	reqSeq   atomic.Uint64
+	mu       sync.Mutex
+	inflight map[uint64]inflight
+	// bundles are the crashes on disk at startup, newest first; previous
+	// is the one the last run left, if it crashed.
+	bundles  []Bundle
+	previous *Bundle
+}
+
+// New collects what the previous run left in cfg.Dir and prepares the
+// directory for this one. client is used when cfg.Bucket is set.
+func New(cfg Config, client *aws.Client) (*Reporter, error) {
+	if cfg.Instance == "" {
+		cfg.Instance, _ = os.Hostname()
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	r := &Reporter{
+		cfg:      cfg,
+		client:   client,
+		run:      filepath.Join(cfg.Dir, "run"),
+		started:  time.Now(),
+		inflight: map[uint64]inflight{},
+	}
+	previous, err := r.collect()
+	if err != nil {
+		return nil, fmt.Errorf("crash: collecting the previous run: %w", err)
+	}
+	r.prune()
+	r.bundles = r.load()
+	if previous != "" {
+		for i := range r.bundles {
+			if r.bundles[i].Name == previous {
+				r.previous = &r.bundles[i]
+			}
+		}
+	}
+
+	if err := os.MkdirAll(r.run, 0o755); err != nil {
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	info, _ := json.MarshalIndent(runInfo{buildinfo.Get(), cfg.Instance, os.Getpid(), r.started.UTC()}, "", "  ")
+	if err := os.WriteFile(filepath.Join(r.run, "build.json"), info, 0o644); err != nil {
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	r.crashOut, err = os.Create(filepath.Join(r.run, "stderr.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	if err := debug.SetCrashOutput(r.crashOut, debug.CrashOptions{}); err != nil {
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	if cfg.LogRecords > 0 {
+		r.ring = &logRing{entries: make([]ringEntry, cfg.LogRecords)}
+	}
+
+	metrics.NewGaugeFunc("crash_loop", "1 while the process is crash-looping.", func() float64 {
+		if r.CrashLoop() {
+			return 1
+		}
+		return 0
+	})
+	metrics.NewGaugeFunc("crashes_recent", "Crashes within the crash loop window.", func() float64 {
+		return float64(r.recent(time.Now()))
+	})
+	metrics.NewGaugeFunc("crash_last_timestamp_seconds", "Time of the newest crash bundle; 0 if there is none.", func() float64 {
+		if len(r.bundles) == 0 {
+			return 0
+		}
+		return float64(r.bundles[0].Time.Unix())
+	})
+	return r, nil
+}
+
+// collect turns the previous run's directory into a bundle if the run
+// crashed, and removes it if it didn't. It returns the bundle's name.
+func (r *Reporter) collect() (string, error) {
+	entries, err := os.ReadDir(r.run)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var b Bundle
+	for _, name := range []string{"panic.txt", "stderr.txt"} {
+		st, err := os.Stat(filepath.Join(r.run, name))
+		if err != nil || st.Size() == 0 {
+			continue
+		}
+		b.Time = st.ModTime().UTC()
+		b.Reason = map[string]string{"panic.txt": "panic", "stderr.txt": "fatal"}[name]
+		b.Message = firstLine(filepath.Join(r.run, name))
+		break
+	}
+	if b.Reason == "" {
+		// A clean exit, or one without output such as SIGKILL.
+		return "", os.RemoveAll(r.run)
+	}
+	var info runInfo
+	if data, err := os.ReadFile(filepath.Join(r.run, "build.json")); err == nil {
+		json.Unmarshal(data, &info)
+	}
+	b.Build, b.Instance, b.Started = info.Build, info.Instance, info.Started
+	for _, e := range entries {
+		b.Files = append(b.Files, e.Name())
+	}
+	b.Name = b.Time.Format("20060102T150405Z")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filepath.Join(r.cfg.Dir, b.Name)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		b.Name = fmt.Sprintf("%s-%d", b.Time.Format("20060102T150405Z"), i)
+	}
+	if err := os.Rename(r.run, filepath.Join(r.cfg.Dir, b.Name)); err != nil {
+		return "", err
+	}
+	return b.Name, writeBundle(filepath.Join(r.cfg.Dir, b.Name), b)
+}
+
+func writeBundle(dir string, b Bundle) error {
+	data, _ := json.MarshalIndent(b, "", "  ")
+	return os.WriteFile(filepath.Join(dir, "crash.json"), data, 0o644)
+}
+
+func firstLine(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// bundleNames returns the bundle directories in Dir, oldest first; their
+// names sort by time.
+func (r *Reporter) bundleNames() []string {
+	entries, _ := os.ReadDir(r.cfg.Dir)
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != "run" {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func (r *Reporter) prune() {
+	names := r.bundleNames()
+	for r.cfg.Keep > 0 && len(names) > r.cfg.Keep {
+		os.RemoveAll(filepath.Join(r.cfg.Dir, names[0]))
+		names = names[1:]
+	}
+}
+
+func (r *Reporter) load() []Bundle {
+	var out []Bundle
+	for _, name := range slices.Backward(r.bundleNames()) {
+		data, err := os.ReadFile(filepath.Join(r.cfg.Dir, name, "crash.json"))
+		if err != nil {
+			continue
+		}
+		var b Bundle
+		if json.Unmarshal(data, &b) == nil {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// recent counts the crashes within LoopWindow of now.
+func (r *Reporter) recent(now time.Time) int {
+	if r == nil {
+		return 0
+	}
+	n := 0
+	for _, b := range r.bundles {
+		if now.Sub(b.Time) <= r.cfg.LoopWindow {
+			n++
+		}
+	}
+	return n
+}
+
+// CrashLoop reports whether LoopThreshold or more crashes happened within
+// LoopWindow. It clears by itself once the process stays up.
+func (r *Reporter) CrashLoop() bool {
+	return r != nil && r.cfg.LoopThreshold > 0 && r.recent(time.Now()) >= r.cfg.LoopThreshold
+}
+
+// Recover, deferred first thing in a goroutine, writes a bundle for a
+// panic and lets it continue, so that the process still dies.
+func (r *Reporter) Recover() {
+	if r == nil {
+		return
+	}
+	v := recover()
+	if v == nil {
+		return
+	}
+	r.writePanic(v)
+	panic(v)
+}
+
+// writePanic records the first panic in the run directory. The runtime
+// adds its own report to stderr.txt as the process dies.
+func (r *Reporter) writePanic(v any) {
+	if !r.panicked.CompareAndSwap(false, true) {
+		return
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "panic: %v\n\n", v)
+	b.Write(stacks())
+	os.WriteFile(filepath.Join(r.run, "panic.txt"), b.Bytes(), 0o644)
+	if r.ring != nil {
+		os.WriteFile(filepath.Join(r.run, "logs.jsonl"), r.ring.lines(), 0o644)
+	}
+	data, _ := json.MarshalIndent(r.requests(time.Now()), "", "  ")
+	os.WriteFile(filepath.Join(r.run, "inflight.json"), data, 0o644)
+}
+
+// stacks returns the stacks of all goroutines.
+func stacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 16<<20 {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+func (r *Reporter) requests(now time.Time) []request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := []request{}
+	for _, f := range r.inflight {
+		out = append(out, request{
+			ID:      logging.RequestID(f.req.Context()),
+			Method:  f.req.Method,
+			URI:     r.cfg.Redactor.RequestURI(redact.SourceCrash, f.req.RequestURI),
+			Started: f.started,
+			Age:     now.Sub(f.started).Round(time.Millisecond).String(),
+		})
+	}
+	slices.SortFunc(out, func(a, b request) int { return a.Started.Compare(b.Started) })
+	return out
+}
+
+type inflight struct {
+	req     *http.Request
+	started time.Time
+}
+
+// Middleware tracks the requests in flight, for bundles. It goes inside
+// logging.Middleware so that requests have their IDs.
+func (r *Reporter) Middleware(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := r.reqSeq.Add(1)
+		r.mu.Lock()
+		r.inflight[id] = inflight{req, time.Now()}
+		r.mu.Unlock()
+		defer func() {
+			r.mu.Lock()
+			delete(r.inflight, id)
+			r.mu.Unlock()
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Handler returns h, with the records it handles also kept for bundles.
+// Records are redacted when a bundle is written.
+func (r *Reporter) Handler(h slog.Handler) slog.Handler {
+	if r == nil || r.ring == nil {
+		return h
+	}
+	format := r.cfg.Redactor.Handler(slog.NewJSONHandler(&r.ring.out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return &ringHandler{ring: r.ring, format: format, next: h}
+}
+
+// Run logs what the previous run left and, with a bucket configured,
+// uploads bundles until they are all stored, retrying every few minutes.
+func (r *Reporter) Run(ctx context.Context) {
+	if b := r.previous; b != nil {
+		slog.Error("previous run crashed", "bundle", filepath.Join(r.cfg.Dir, b.Name), "reason", b.Reason, "message", b.Message, "version", b.Build.Version)
+	}
+	if r.CrashLoop() {
+		slog.Error("crash loop", "crashes", r.recent(time.Now()), "window", r.cfg.LoopWindow.String())
+	}
+	if r.cfg.Bucket == "" {
+		return
+	}
+	tick := time.NewTicker(5 * time.Minute)
+	defer tick.Stop()
+	for {
+		if r.upload(ctx) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// upload stores the bundles not yet uploaded and reports whether all are.
+func (r *Reporter) upload(ctx context.Context) bool {
+	done := true
+	for i := range r.bundles {
+		b := &r.bundles[i]
+		if b.Uploaded {
+			continue
+		}
+		dir := filepath.Join(r.cfg.Dir, b.Name)
+		prefix := r.cfg.Prefix + r.cfg.Instance + "/" + b.Name + "/"
+		err := func() error {
+			for _, name := range append(slices.Clone(b.Files), "crash.json") {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					return err
+				}
+				typ := "text/plain"
+				if strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".jsonl") {
+					typ = "application/json"
+				}
+				if err := r.client.PutObject(ctx, r.cfg.Bucket, prefix+name, typ, data); err != nil {
+					return err
+				}
+			}
+			return nil
+		}()
+		if err != nil {
+			uploads.With("error").Inc()
+			slog.Warn("crash bundle upload failed", "bundle", b.Name, "err", err)
+			done = false
+			continue
+		}
+		uploads.With("ok").Inc()
+		b.Uploaded = true
+		writeBundle(dir, *b)
+	}
+	return done
+}
+
+// ReadyHandler answers readiness checks: 503 while crash-looping, so that
+// the load balancer stops sending traffic to a flapping instance.
+func (r *Reporter) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if !r.CrashLoop() {
+			w.Write([]byte("ok\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":  "crash_loop",
+			"crashes": r.recent(time.Now()),
+			"window":  r.cfg.LoopWindow.String(),
+			"last":    r.bundles[0],
+		})
+	})
+}
+
+// ListHandler lists the crash bundles on disk as of startup.
+func (r *Reporter) ListHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]any{
+			"crash_loop": r.CrashLoop(),
+			"recent":     r.recent(time.Now()),
+			"bundles":    r.bundles,
+		})
+	})
+}
@@ -0,0 +1,238 @@
+package crash
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goaws/internal/aws"
+	"goaws/internal/logging"
+	"goaws/internal/redact"
+)
+
+// start runs New on dir as a fresh process would.
+func start(t *testing.T, cfg Config, client *aws.Client) *Reporter {
+	t.Helper()
+	r, err := New(cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		debug.SetCrashOutput(nil, debug.CrashOptions{})
+		r.crashOut.Close()
+	})
+	return r
+}
+
+func testConfig(t *testing.T) Config {
+	red, err := redact.New(redact.Rules{QueryParams: []string{"token"}, Patterns: []string{"email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Config{Dir: t.TempDir(), Keep: 5, LogRecords: 10, LoopThreshold: 2, LoopWindow: time.Hour, Instance: "i-1", Redactor: red}
+}
+
+func ready(r *Reporter) int {
+	rec := httptest.NewRecorder()
+	r.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	return rec.Code
+}
+
+func TestCleanExitLeavesNoBundle(t *testing.T) {
+	cfg := testConfig(t)
+	start(t, cfg, nil)
+	r := start(t, cfg, nil)
+	if r.previous != nil || len(r.bundles) != 0 {
+		t.Errorf("bundles after a clean exit: %+v", r.bundles)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "run", "build.json")); err != nil {
+		t.Error(err)
+	}
+	if ready(r) != http.StatusOK {
+		t.Error("not ready")
+	}
+}
+
+func TestPanicBecomesBundle(t *testing.T) {
+	cfg := testConfig(t)
+	r := start(t, cfg, nil)
+	slog.New(r.Handler(slog.NewTextHandler(io.Discard, nil))).With("user", "bob@example.com").Info("signed in")
+
+	// A request is in flight when the panic happens.
+	inside, release := make(chan struct{}), make(chan struct{})
+	h := logging.Middleware(r.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(inside)
+		<-release
+	})))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow?token=abc", nil))
+	}()
+	<-inside
+
+	func() {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("recovered %v; the panic should continue", v)
+			}
+		}()
+		defer r.Recover()
+		panic("boom")
+	}()
+	close(release)
+	wg.Wait()
+
+	run := filepath.Join(cfg.Dir, "run")
+	if b, _ := os.ReadFile(filepath.Join(run, "panic.txt")); !strings.HasPrefix(string(b), "panic: boom\n\ngoroutine ") {
+		t.Errorf("panic.txt:\n%.200s", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(run, "logs.jsonl")); !strings.Contains(string(b), `"msg":"signed in","user":"[REDACTED]"`) {
+		t.Errorf("logs.jsonl: %s", b)
+	}
+	var reqs []request
+	b, _ := os.ReadFile(filepath.Join(run, "inflight.json"))
+	if json.Unmarshal(b, &reqs); len(reqs) != 1 || reqs[0].URI != "/slow?token=[REDACTED]" || reqs[0].ID == "" {
+		t.Errorf("inflight.json: %s", b)
+	}
+
+	// The next run collects it.
+	next := start(t, cfg, nil)
+	p := next.previous
+	if p == nil {
+		t.Fatal("no previous crash")
+	}
+	if p.Reason != "panic" || p.Message != "panic: boom" || p.Instance != "i-1" || !slices.Contains(p.Files, "inflight.json") {
+		t.Errorf("bundle %+v", p)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, p.Name, "crash.json")); err != nil {
+		t.Error(err)
+	}
+	if next.CrashLoop() || ready(next) != http.StatusOK {
+		t.Error("one crash is a crash loop")
+	}
+}
+
+func TestFatalErrorsAndCrashLoop(t *testing.T) {
+	cfg := testConfig(t)
+	for range 2 {
+		r := start(t, cfg, nil)
+		// What the runtime writes to the crash output as it dies.
+		r.crashOut.WriteString("\nfatal error: concurrent map writes\n\ngoroutine 1 [running]:\n")
+	}
+	r := start(t, cfg, nil)
+	if len(r.bundles) != 2 || r.bundles[0].Reason != "fatal" || r.bundles[0].Message != "fatal error: concurrent map writes" {
+		t.Fatalf("bundles %+v", r.bundles)
+	}
+	if r.bundles[0].Name == r.bundles[1].Name {
+		t.Error("bundles share a name")
+	}
+	if !r.CrashLoop() {
+		t.Error("no crash loop")
+	}
+	rec := httptest.NewRecorder()
+	r.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"status":"crash_loop"`) {
+		t.Errorf("readyz %d %s", rec.Code, rec.Body)
+	}
+	if r.recent(time.Now().Add(2*time.Hour)) != 0 {
+		t.Error("crashes do not age out of the window")
+	}
+}
+
+func TestPruneKeepsNewest(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Keep = 2
+	for _, name := range []string{"20240101T000000Z", "20240102T000000Z", "20240103T000000Z"} {
+		os.MkdirAll(filepath.Join(cfg.Dir, name), 0o755)
+		writeBundle(filepath.Join(cfg.Dir, name), Bundle{Name: name})
+	}
+	r := start(t, cfg, nil)
+	var names []string
+	for _, b := range r.bundles {
+		names = append(names, b.Name)
+	}
+	if !slices.Equal(names, []string{"20240103T000000Z", "20240102T000000Z"}) {
+		t.Errorf("bundles %v", names)
+	}
+}
+
+func TestLogRingWraps(t *testing.T) {
+	ring := &logRing{entries: make([]ringEntry, 3)}
+	h := &ringHandler{ring: ring, format: slog.NewJSONHandler(&ring.out, nil), next: slog.NewTextHandler(io.Discard, nil)}
+	l := slog.New(h).WithGroup("g")
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		l.Info(msg, "k", 1)
+	}
+	lines := strings.Split(strings.TrimSpace(string(ring.lines())), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"msg":"b","g":{"k":1}`) || !strings.Contains(lines[2], `"msg":"d"`) {
+		t.Errorf("lines %q", lines)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var mu sync.Mutex
+	puts := map[string]string{}
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		puts[req.URL.Path] = req.Header.Get("Content-Type")
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	client := &aws.Client{Region: "us-east-1", Credentials: aws.StaticCredentials{AccessKeyID: "a", SecretAccessKey: "s"}, HTTP: srv.Client()}
+
+	cfg := testConfig(t)
+	cfg.Bucket, cfg.Prefix = "b", "crashes/"
+	first := start(t, cfg, client)
+	first.crashOut.WriteString("fatal error: out of memory\n")
+	r := start(t, cfg, client)
+	name := r.bundles[0].Name
+
+	if r.upload(context.Background()) {
+		t.Error("failed upload reported done")
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	if !r.upload(context.Background()) {
+		t.Error("upload not done")
+	}
+	prefix := "/b/crashes/i-1/" + name + "/"
+	if puts[prefix+"stderr.txt"] != "text/plain" || puts[prefix+"build.json"] != "application/json" || puts[prefix+"crash.json"] != "application/json" {
+		t.Errorf("puts %v", puts)
+	}
+	// The upload is recorded, so the next run does not repeat it.
+	if again := start(t, cfg, client); !again.bundles[0].Uploaded {
+		t.Error("upload not recorded in crash.json")
+	}
+}
+
+func TestNilReporter(t *testing.T) {
+	var r *Reporter
+	if r.CrashLoop() || r.recent(time.Now()) != 0 {
+		t.Error("nil reporter reports crashes")
+	}
+	rec := httptest.NewRecorder()
+	r.Middleware(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d", rec.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package crash
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// logRing keeps the most recent log records. Records are stored as they
+// are and only formatted, through the handler they were logged with, when
+// a crash bundle is written.
+type logRing struct {
+	mu      sync.Mutex
+	entries []ringEntry
+	next    int
+	full    bool
+	// out is what the formatting handlers write to; it is only used while
+	// mu is held.
+	out bytes.Buffer
+}
+
+type ringEntry struct {
+	h slog.Handler
+	r slog.Record
+}
+
+func (l *logRing) add(h slog.Handler, r slog.Record) {
+	l.mu.Lock()
+	l.entries[l.next] = ringEntry{h, r}
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.mu.Unlock()
+}
+
+// lines returns the buffered records as JSON lines, oldest first.
+func (l *logRing) lines() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Reset()
+	start, n := 0, l.next
+	if l.full {
+		start, n = l.next, len(l.entries)
+	}
+	for i := range n {
+		e := l.entries[(start+i)%len(l.entries)]
+		e.h.Handle(context.Background(), e.r)
+	}
+	return bytes.Clone(l.out.Bytes())
+}
+
+type ringHandler struct {
+	ring *logRing
+	// format writes JSON to ring.out, with the attributes and groups this
+	// handler was derived with.
+	format slog.Handler
+	next   slog.Handler
+}
+
+func (h *ringHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *ringHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.ring.add(h.format, r.Clone())
+	return h.next.Handle(ctx, r)
+}
+
+func (h *ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ringHandler{ring: h.ring, format: h.format.WithAttrs(attrs), next: h.next.WithAttrs(attrs)}
+}
+
+func (h *ringHandler) WithGroup(name string) slog.Handler {
+	return &ringHandler{ring: h.ring, format: h.format.WithGroup(name), next: h.next.WithGroup(name)}
+}
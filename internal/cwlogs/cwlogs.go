@@ -0,0 +1,311 @@
+// Package cwlogs ships log lines to CloudWatch Logs with PutLogEvents, so
+// logs outlive the instance without running the CloudWatch agent.
+package cwlogs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"goaws/internal/aws"
+	"goaws/internal/env"
+	"goaws/internal/metrics"
+)
+
+// PutLogEvents limits.
+const (
+	maxBatchEvents = 10000
+	maxBatchBytes  = 1048576
+	eventOverhead  = 26
+	maxEventBytes  = 262144 - eventOverhead
+	maxBatchSpan   = 24 * time.Hour
+)
+
+var (
+	sentEvents    = metrics.NewCounter("cwlogs_events_sent_total", "Log events accepted by CloudWatch Logs.")
+	droppedEvents = metrics.NewCounter("cwlogs_events_dropped_total", "Log events that were never delivered.", "reason")
+	putErrors     = metrics.NewCounter("cwlogs_put_errors_total", "Failed PutLogEvents calls, including retried ones.", "code")
+)
+
+// Config configures shipping. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Group and Stream name the destination. Shipping is off when Group
+	// is empty. The group and stream are created if missing.
+	Group  string
+	Stream string
+
+	FlushInterval time.Duration
+	// Buffer is the number of lines held while a batch is in flight;
+	// lines beyond it are dropped and counted.
+	Buffer     int
+	MaxRetries int
+}
+
+// ConfigFromEnv reads the CWLOGS_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Group:         env.String("CWLOGS_GROUP", ""),
+		Stream:        env.String("CWLOGS_STREAM", ""),
+		FlushInterval: env.Duration("CWLOGS_FLUSH_INTERVAL", 5*time.Second),
+		Buffer:        env.Int("CWLOGS_BUFFER", 10000),
+		MaxRetries:    env.Int("CWLOGS_MAX_RETRIES", 5),
+	}
+}
+
+type event struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// Shipper is an io.Writer that sends each Write as one log event. Wrap it
+// in slog.NewJSONHandler to ship structured records. Writes never block:
+// when the buffer is full the line is dropped.
+type Shipper struct {
+	cfg    Config
+	client *aws.Client
+	ch     chan event
+	// log goes to stderr only: logging the shipper's own failures through
+	// itself could feed a failing shipper faster than it drains.
+	log *slog.Logger
+
+	token string
+}
+
+// New returns a shipper for cfg; run it with Run.
+func New(cfg Config, client *aws.Client) *Shipper {
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = 10000
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	s := &Shipper{
+		cfg:    cfg,
+		client: client,
+		ch:     make(chan event, cfg.Buffer),
+		log:    slog.New(slog.NewTextHandler(os.Stderr, nil)).With("component", "cwlogs"),
+	}
+	shippers.Lock()
+	shippers.all = append(shippers.all, s)
+	shippers.Unlock()
+	metrics.NewGaugeFunc("cwlogs_buffered", "Log events waiting to be shipped.", buffered)
+	return s
+}
+
+// shippers lists every Shipper, as a process may ship to more than one
+// stream.
+var shippers struct {
+	sync.Mutex
+	all []*Shipper
+}
+
+func buffered() float64 {
+	shippers.Lock()
+	defer shippers.Unlock()
+	n := 0
+	for _, s := range shippers.all {
+		n += len(s.ch)
+	}
+	return float64(n)
+}
+
+// Write queues p, minus a trailing newline, as one event.
+func (s *Shipper) Write(p []byte) (int, error) {
+	msg := string(p)
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+	if msg == "" {
+		return len(p), nil
+	}
+	if len(msg) > maxEventBytes {
+		msg = truncate(msg, maxEventBytes)
+	}
+	select {
+	case s.ch <- event{Timestamp: time.Now().UnixMilli(), Message: msg}:
+	default:
+		droppedEvents.With("buffer_full").Inc()
+	}
+	return len(p), nil
+}
+
+// Run ships batches until ctx is done, then flushes what is buffered.
+func (s *Shipper) Run(ctx context.Context) {
+	t := time.NewTicker(s.cfg.FlushInterval)
+	defer t.Stop()
+	var batch []event
+	var size int
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 {
+			s.put(ctx, batch)
+			batch, size = nil, 0
+		}
+	}
+	add := func(ctx context.Context, e event) {
+		n := len(e.Message) + eventOverhead
+		if len(batch) == maxBatchEvents || size+n > maxBatchBytes {
+			flush(ctx)
+		}
+		batch = append(batch, e)
+		size += n
+	}
+	for {
+		select {
+		case e := <-s.ch:
+			add(ctx, e)
+		case <-t.C:
+			flush(ctx)
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			for {
+				select {
+				case e := <-s.ch:
+					add(drainCtx, e)
+				default:
+					flush(drainCtx)
+					return
+				}
+			}
+		}
+	}
+}
+
+// put sends batch, which must fit the size limits, splitting it where its
+// events span more than a day.
+func (s *Shipper) put(ctx context.Context, batch []event) {
+	sort.SliceStable(batch, func(i, j int) bool { return batch[i].Timestamp < batch[j].Timestamp })
+	start := 0
+	for i := range batch {
+		if time.Duration(batch[i].Timestamp-batch[start].Timestamp)*time.Millisecond >= maxBatchSpan {
+			s.putChunk(ctx, batch[start:i])
+			start = i
+		}
+	}
+	s.putChunk(ctx, batch[start:])
+}
+
+// expectedToken pulls the sequence token out of an InvalidSequenceToken
+// message. CloudWatch no longer requires tokens, but older stand-ins do.
+var expectedToken = regexp.MustCompile(`sequenceToken(?: is)?: (\S+)`)
+
+func (s *Shipper) putChunk(ctx context.Context, batch []event) {
+	backoff := 200 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := s.putOnce(ctx, batch)
+		if err == nil {
+			return
+		}
+		var apiErr *aws.APIError
+		code := "network"
+		if errors.As(err, &apiErr) {
+			code = apiErr.Code
+		}
+		putErrors.With(code).Inc()
+
+		retry := apiErr == nil || apiErr.Retryable()
+		switch code {
+		case "ResourceNotFoundException":
+			if cerr := s.create(ctx); cerr != nil {
+				err = cerr
+				retry = false
+			} else {
+				retry = true
+			}
+		case "InvalidSequenceTokenException", "DataAlreadyAcceptedException":
+			if m := expectedToken.FindStringSubmatch(apiErr.Message); m != nil && m[1] != "null" {
+				s.token = m[1]
+			} else {
+				s.token = ""
+			}
+			if code == "DataAlreadyAcceptedException" {
+				return
+			}
+			retry = true
+		}
+		if !retry || attempt >= s.cfg.MaxRetries || ctx.Err() != nil {
+			droppedEvents.With("put_failed").Add(float64(len(batch)))
+			s.log.Warn("shipping logs", "events", len(batch), "err", err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff = min(2*backoff, 10*time.Second)
+	}
+}
+
+func (s *Shipper) putOnce(ctx context.Context, batch []event) error {
+	in := struct {
+		LogGroupName  string  `json:"logGroupName"`
+		LogStreamName string  `json:"logStreamName"`
+		LogEvents     []event `json:"logEvents"`
+		SequenceToken string  `json:"sequenceToken,omitempty"`
+	}{s.cfg.Group, s.cfg.Stream, batch, s.token}
+	var out struct {
+		NextSequenceToken     string `json:"nextSequenceToken"`
+		RejectedLogEventsInfo *struct {
+			TooNewLogEventStartIndex *int `json:"tooNewLogEventStartIndex"`
+			TooOldLogEventEndIndex   *int `json:"tooOldLogEventEndIndex"`
+			ExpiredLogEventEndIndex  *int `json:"expiredLogEventEndIndex"`
+		} `json:"rejectedLogEventsInfo"`
+	}
+	if err := s.client.JSON(ctx, aws.CloudWatchLogs, "Logs_20140328.PutLogEvents", in, &out); err != nil {
+		return err
+	}
+	s.token = out.NextSequenceToken
+	rejected := 0
+	if r := out.RejectedLogEventsInfo; r != nil {
+		// The indexes bound the rejected prefix (too old, expired) and
+		// suffix (too new) of the batch.
+		old := -1
+		if r.TooOldLogEventEndIndex != nil {
+			old = *r.TooOldLogEventEndIndex
+		}
+		if r.ExpiredLogEventEndIndex != nil {
+			old = max(old, *r.ExpiredLogEventEndIndex)
+		}
+		rejected += old + 1
+		if r.TooNewLogEventStartIndex != nil {
+			rejected += len(batch) - *r.TooNewLogEventStartIndex
+		}
+		droppedEvents.With("rejected").Add(float64(rejected))
+	}
+	sentEvents.With().Add(float64(len(batch) - rejected))
+	return nil
+}
+
+// create makes the log group and stream, tolerating either existing.
+func (s *Shipper) create(ctx context.Context) error {
+	group := map[string]string{"logGroupName": s.cfg.Group}
+	if err := s.client.JSON(ctx, aws.CloudWatchLogs, "Logs_20140328.CreateLogGroup", group, nil); err != nil && !exists(err) {
+		return fmt.Errorf("cwlogs: creating log group: %w", err)
+	}
+	stream := map[string]string{"logGroupName": s.cfg.Group, "logStreamName": s.cfg.Stream}
+	if err := s.client.JSON(ctx, aws.CloudWatchLogs, "Logs_20140328.CreateLogStream", stream, nil); err != nil && !exists(err) {
+		return fmt.Errorf("cwlogs: creating log stream: %w", err)
+	}
+	s.token = ""
+	return nil
+}
+
+func exists(err error) bool {
+	var apiErr *aws.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == "ResourceAlreadyExistsException"
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,181 @@
+package cwlogs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goaws/internal/aws"
+	"goaws/internal/metrics"
+)
+
+// dropped reads cwlogs_events_dropped_total for reason.
+func dropped(reason string) float64 {
+	for _, f := range metrics.Default.Gather() {
+		if f.Name != "cwlogs_events_dropped_total" {
+			continue
+		}
+		for _, s := range f.Samples {
+			if s.Labels[0].Value == reason {
+				return s.Value
+			}
+		}
+	}
+	return 0
+}
+
+// fakeLogs is a CloudWatch Logs stand-in that starts without the group.
+type fakeLogs struct {
+	mu      sync.Mutex
+	created bool
+	calls   []string
+	events  []event
+	// reject, if set, is returned as rejectedLogEventsInfo once.
+	reject string
+}
+
+func (f *fakeLogs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.")
+	f.calls = append(f.calls, target)
+	switch target {
+	case "CreateLogGroup", "CreateLogStream":
+		if f.created && target == "CreateLogGroup" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"__type":"ResourceAlreadyExistsException"}`)
+			return
+		}
+		f.created = true
+		io.WriteString(w, `{}`)
+	case "PutLogEvents":
+		if !f.created {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"__type":"ResourceNotFoundException","message":"The specified log group does not exist."}`)
+			return
+		}
+		var in struct {
+			LogGroupName, LogStreamName string
+			LogEvents                   []event
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		if in.LogGroupName != "app" || in.LogStreamName != "i-123" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.events = append(f.events, in.LogEvents...)
+		io.WriteString(w, `{"nextSequenceToken":"t1"`)
+		if f.reject != "" {
+			io.WriteString(w, `,"rejectedLogEventsInfo":`+f.reject)
+			f.reject = ""
+		}
+		io.WriteString(w, `}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newTestShipper(t *testing.T, f *fakeLogs) *Shipper {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_CLOUDWATCH_LOGS", srv.URL)
+	client := &aws.Client{
+		Region:      "us-east-1",
+		Credentials: aws.StaticCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		HTTP:        srv.Client(),
+	}
+	return New(Config{Group: "app", Stream: "i-123", FlushInterval: time.Hour, MaxRetries: 2}, client)
+}
+
+func TestShipperCreatesGroupAndShipsOnShutdown(t *testing.T) {
+	f := &fakeLogs{}
+	s := newTestShipper(t, f)
+	io.WriteString(s, "first\n")
+	io.WriteString(s, "\n") // empty lines are not events
+	io.WriteString(s, "second")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Run(ctx)
+
+	if got := strings.Join(f.calls, " "); got != "PutLogEvents CreateLogGroup CreateLogStream PutLogEvents" {
+		t.Errorf("calls: %s", got)
+	}
+	if len(f.events) != 2 || f.events[0].Message != "first" || f.events[1].Message != "second" {
+		t.Errorf("events %+v", f.events)
+	}
+	if s.token != "t1" {
+		t.Errorf("token %q", s.token)
+	}
+}
+
+func TestPutSplitsBatchesSpanningADay(t *testing.T) {
+	f := &fakeLogs{created: true}
+	s := newTestShipper(t, f)
+	day := (24 * time.Hour).Milliseconds()
+	s.put(context.Background(), []event{{day + 1, "c"}, {0, "a"}, {1, "b"}})
+	if got := strings.Join(f.calls, " "); got != "PutLogEvents PutLogEvents" {
+		t.Errorf("calls: %s", got)
+	}
+	var msgs []string
+	for _, e := range f.events {
+		msgs = append(msgs, e.Message)
+	}
+	if strings.Join(msgs, "") != "abc" {
+		t.Errorf("events out of order: %v", msgs)
+	}
+}
+
+func TestRejectedEventsAreCounted(t *testing.T) {
+	f := &fakeLogs{created: true, reject: `{"tooOldLogEventEndIndex":1,"tooNewLogEventStartIndex":4}`}
+	s := newTestShipper(t, f)
+	before := dropped("rejected")
+	s.put(context.Background(), make([]event, 5))
+	// Events 0 and 1 are too old and event 4 too new.
+	if got := dropped("rejected") - before; got != 3 {
+		t.Errorf("rejected %v, want 3", got)
+	}
+}
+
+func TestExpectedToken(t *testing.T) {
+	for msg, want := range map[string]string{
+		"The given sequenceToken is invalid. The next expected sequenceToken is: 4962": "4962",
+		"The next batch can be sent with sequenceToken: 8271":                          "8271",
+		"nothing here": "",
+	} {
+		got := ""
+		if m := expectedToken.FindStringSubmatch(msg); m != nil {
+			got = m[1]
+		}
+		if got != want {
+			t.Errorf("%q: token %q, want %q", msg, got, want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	s := "aé" // é is two bytes
+	if got := truncate(s, 2); got != "a" {
+		t.Errorf("truncate split a rune: %q", got)
+	}
+	if got := truncate(s+"b", 3); got != "aé" {
+		t.Errorf("truncate = %q", got)
+	}
+}
+
+func TestWriteDropsWhenFull(t *testing.T) {
+	s := New(Config{Group: "app", Buffer: 1}, nil)
+	before := dropped("buffer_full")
+	io.WriteString(s, "a")
+	io.WriteString(s, "b")
+	if got := dropped("buffer_full") - before; got != 1 || len(s.ch) != 1 {
+		t.Errorf("dropped %v, buffered %d", got, len(s.ch))
+	}
+}
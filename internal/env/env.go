@@ -0,0 +1,71 @@
+// Package env reads typed configuration values from the process environment.
+// srv.service sets variables with Environment= lines, so every subsystem is
+// configured through here rather than through flags or config files.
+package env
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns the value of key, or def when it is unset or empty.
+func String(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Int returns key parsed as an int, or def when unset or malformed.
+func Int(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Float returns key parsed as a float64, or def when unset or malformed.
+func Float(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Bool returns key parsed as a bool, or def when unset or malformed.
+func Bool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Duration returns key parsed with time.ParseDuration, or def when unset or
+// malformed.
+func Duration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// List returns key split on commas with blanks removed, or def when unset.
+func List(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
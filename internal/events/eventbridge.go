@@ -0,0 +1,77 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	"goaws/internal/aws"
+)
+
+// EventBridgeSink sends events to an event bus with PutEvents. The CloudEvent
+// becomes the detail; its source and type become Source and DetailType so
+// rules can match on them.
+type EventBridgeSink struct {
+	Client *aws.Client
+	Bus    string
+}
+
+func (s *EventBridgeSink) Name() string  { return "eventbridge" }
+func (s *EventBridgeSink) MaxBatch() int { return 10 }
+
+type putEventsEntry struct {
+	Source       string
+	DetailType   string
+	Detail       string
+	EventBusName string `json:",omitempty"`
+	Time         int64  `json:",omitempty"`
+	Resources    []string
+}
+
+func (s *EventBridgeSink) Send(ctx context.Context, batch []Event) error {
+	in := struct{ Entries []putEventsEntry }{}
+	for _, e := range batch {
+		detail, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		entry := putEventsEntry{
+			Source:       e.Source,
+			DetailType:   e.Type,
+			Detail:       string(detail),
+			EventBusName: s.Bus,
+			Time:         e.Time.Unix(),
+			Resources:    []string{},
+		}
+		if e.Subject != "" {
+			entry.Resources = []string{e.Subject}
+		}
+		in.Entries = append(in.Entries, entry)
+	}
+
+	var out struct {
+		FailedEntryCount int
+		Entries          []struct {
+			EventID      string `json:"EventId"`
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	if err := s.Client.JSON(ctx, aws.EventBridge, "AWSEvents.PutEvents", in, &out); err != nil {
+		return err
+	}
+	if out.FailedEntryCount == 0 {
+		return nil
+	}
+	// Result entries are positional, matching the request.
+	pe := &PartialError{Failed: map[string]string{}}
+	for i, r := range out.Entries {
+		if r.ErrorCode == "" || i >= len(batch) {
+			continue
+		}
+		pe.Failed[batch[i].ID] = r.ErrorCode + ": " + r.ErrorMessage
+		if r.ErrorCode == "InternalFailure" || r.ErrorCode == "ThrottlingException" {
+			pe.Retryable = true
+		}
+	}
+	return pe
+}
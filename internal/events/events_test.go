@@ -0,0 +1,267 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goaws/internal/aws"
+)
+
+func mustNew(t *testing.T, typ, subject string) Event {
+	t.Helper()
+	e, err := New(typ, subject, map[string]string{"subject": subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestValidate(t *testing.T) {
+	e := mustNew(t, "order.created", "order/1")
+	if err := e.Validate(); err == nil {
+		t.Error("event without source validated")
+	}
+	e.Source = "urn:test"
+	if err := e.Validate(); err != nil {
+		t.Error(err)
+	}
+	e.SpecVersion = "0.3"
+	if err := e.Validate(); err == nil {
+		t.Error("wrong specversion validated")
+	}
+}
+
+// flakySink rejects each event the first time it sees it.
+type flakySink struct {
+	mu       sync.Mutex
+	seen     map[string]bool
+	accepted []string
+	calls    int
+}
+
+func (s *flakySink) Name() string  { return "flaky" }
+func (s *flakySink) MaxBatch() int { return 2 }
+
+func (s *flakySink) Send(_ context.Context, batch []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	pe := &PartialError{Failed: map[string]string{}, Retryable: true}
+	for _, e := range batch {
+		if !s.seen[e.ID] {
+			s.seen[e.ID] = true
+			pe.Failed[e.ID] = "try again"
+			continue
+		}
+		s.accepted = append(s.accepted, e.Subject)
+	}
+	if len(pe.Failed) > 0 {
+		return pe
+	}
+	return nil
+}
+
+func TestPublishSyncRetriesRejectedEvents(t *testing.T) {
+	mem := &MemorySink{}
+	flaky := &flakySink{seen: map[string]bool{}}
+	p := NewPublisher(Config{Source: "urn:test", MaxRetries: 2}, mem, flaky)
+	batch := []Event{mustNew(t, "t", "a"), mustNew(t, "t", "b"), mustNew(t, "t", "c")}
+	if err := p.PublishSync(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if got := mem.Events(); len(got) != 3 || got[0].Source != "urn:test" {
+		t.Errorf("memory sink got %+v", got)
+	}
+	// Two chunks of at most MaxBatch, each failing once.
+	if len(flaky.accepted) != 3 || flaky.calls != 4 {
+		t.Errorf("flaky sink accepted %v in %d calls", flaky.accepted, flaky.calls)
+	}
+}
+
+// pickySink rejects the events whose subjects it is told to, until it has
+// rejected each of them times times.
+type pickySink struct {
+	reject   map[string]int
+	times    int
+	accepted []string
+}
+
+func (s *pickySink) Name() string  { return "picky" }
+func (s *pickySink) MaxBatch() int { return 10 }
+
+func (s *pickySink) Send(_ context.Context, batch []Event) error {
+	pe := &PartialError{Failed: map[string]string{}, Retryable: true}
+	for _, e := range batch {
+		if n, ok := s.reject[e.Subject]; ok && n < s.times {
+			s.reject[e.Subject] = n + 1
+			pe.Failed[e.ID] = "no"
+			continue
+		}
+		s.accepted = append(s.accepted, e.Subject)
+	}
+	if len(pe.Failed) > 0 {
+		return pe
+	}
+	return nil
+}
+
+func TestPublishOrdered(t *testing.T) {
+	batch := func() []Event {
+		return []Event{mustNew(t, "t", "a"), mustNew(t, "t", "b"), mustNew(t, "t", "c")}
+	}
+	for _, tc := range []struct {
+		name     string
+		ordered  bool
+		times    int
+		want     string
+		accepted int
+	}{
+		// PublishSync retries only b, which ends up after c.
+		{"sync", false, 1, "a c b", 3},
+		// Resending from b on delivers c again after it.
+		{"ordered", true, 1, "a c b c", 3},
+		// Only a counts while b keeps being rejected.
+		{"ordered gives up", true, 10, "a c c c", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &pickySink{reject: map[string]int{"b": 0}, times: tc.times}
+			p := NewPublisher(Config{Source: "urn:test", MaxRetries: 2}, sink)
+			var n int
+			var err error
+			if tc.ordered {
+				n, err = p.PublishOrdered(context.Background(), batch())
+			} else {
+				err = p.PublishSync(context.Background(), batch())
+				n = 3
+			}
+			if (err != nil) != (tc.accepted < 3) {
+				t.Errorf("err = %v", err)
+			}
+			if n != tc.accepted {
+				t.Errorf("accepted %d, want %d", n, tc.accepted)
+			}
+			if got := strings.Join(sink.accepted, " "); got != tc.want {
+				t.Errorf("sink got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+type failingSink struct{ err error }
+
+func (s failingSink) Name() string                        { return "failing" }
+func (s failingSink) MaxBatch() int                       { return 10 }
+func (s failingSink) Send(context.Context, []Event) error { return s.err }
+
+func TestPublishSyncGivesUpOnPermanentErrors(t *testing.T) {
+	denied := &aws.APIError{StatusCode: 400, Code: "AccessDenied"}
+	p := NewPublisher(Config{Source: "urn:test", MaxRetries: 5}, failingSink{denied})
+	start := time.Now()
+	err := p.PublishSync(context.Background(), []Event{mustNew(t, "t", "a")})
+	if !errors.Is(err, denied) {
+		t.Errorf("err = %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("a permanent error was retried")
+	}
+}
+
+func TestPublishAndRun(t *testing.T) {
+	mem := &MemorySink{}
+	p := NewPublisher(Config{Source: "urn:test", BatchSize: 2, FlushInterval: time.Hour}, mem)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	for _, s := range []string{"a", "b", "c"} {
+		if err := p.Publish(mustNew(t, "t", s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The full batch goes out at once; the rest is flushed on shutdown.
+	deadline := time.Now().Add(time.Second)
+	for len(mem.Events()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	var got []string
+	for _, e := range mem.Events() {
+		got = append(got, e.Subject)
+	}
+	if len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Errorf("published %v", got)
+	}
+	if err := p.Publish(Event{}); err == nil {
+		t.Error("invalid event accepted")
+	}
+}
+
+func awsClient(t *testing.T, h http.HandlerFunc) *aws.Client {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	return &aws.Client{Region: "us-east-1", Credentials: aws.StaticCredentials{AccessKeyID: "a", SecretAccessKey: "s"}, HTTP: srv.Client()}
+}
+
+func TestSNSSink(t *testing.T) {
+	a, b := mustNew(t, "order.created", "order/1"), mustNew(t, "order.created", "")
+	a.Source, b.Source = "urn:test", "urn:test"
+	client := awsClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f := r.Form
+		if f.Get("Action") != "PublishBatch" || f.Get("TopicArn") != "arn:aws:sns:us-east-1:1:t.fifo" {
+			t.Errorf("form %v", f)
+		}
+		p := "PublishBatchRequestEntries.member.1."
+		var msg Event
+		json.Unmarshal([]byte(f.Get(p+"Message")), &msg)
+		if f.Get(p+"Id") != a.ID || msg.ID != a.ID || f.Get(p+"MessageGroupId") != "order/1" || f.Get(p+"MessageDeduplicationId") != a.ID {
+			t.Errorf("entry 1: %v", f)
+		}
+		if f.Get(p+"MessageAttributes.entry.1.Value.StringValue") != "order.created" {
+			t.Errorf("ce_type attribute missing: %v", f)
+		}
+		// Without a subject, FIFO groups by type.
+		if got := f.Get("PublishBatchRequestEntries.member.2.MessageGroupId"); got != "order.created" {
+			t.Errorf("entry 2 group %q", got)
+		}
+		io.WriteString(w, `<PublishBatchResponse><PublishBatchResult><Failed><member><Id>`+b.ID+
+			`</Id><Code>InternalError</Code><Message>oops</Message><SenderFault>false</SenderFault></member></Failed></PublishBatchResult></PublishBatchResponse>`)
+	})
+
+	err := (&SNSSink{Client: client, TopicARN: "arn:aws:sns:us-east-1:1:t.fifo"}).Send(context.Background(), []Event{a, b})
+	var pe *PartialError
+	if !errors.As(err, &pe) || len(pe.Failed) != 1 || pe.Failed[b.ID] == "" || !pe.Retryable {
+		t.Errorf("err = %#v", err)
+	}
+}
+
+func TestEventBridgeSink(t *testing.T) {
+	a, b := mustNew(t, "order.created", "order/1"), mustNew(t, "order.shipped", "")
+	client := awsClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var in struct{ Entries []putEventsEntry }
+		json.NewDecoder(r.Body).Decode(&in)
+		if len(in.Entries) != 2 || in.Entries[0].DetailType != "order.created" || in.Entries[0].Resources[0] != "order/1" ||
+			in.Entries[1].EventBusName != "bus" {
+			t.Errorf("entries %+v", in.Entries)
+		}
+		io.WriteString(w, `{"FailedEntryCount":1,"Entries":[{"EventId":"x"},{"ErrorCode":"ValidationException","ErrorMessage":"bad"}]}`)
+	})
+
+	err := (&EventBridgeSink{Client: client, Bus: "bus"}).Send(context.Background(), []Event{a, b})
+	var pe *PartialError
+	if !errors.As(err, &pe) || len(pe.Failed) != 1 || pe.Failed[b.ID] != "ValidationException: bad" || pe.Retryable {
+		t.Errorf("err = %#v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"goaws/internal/aws"
+	"goaws/internal/env"
+	"goaws/internal/metrics"
+)
+
+// ErrBufferFull is returned by Publish when the background buffer is full
+// and the event was dropped.
+var ErrBufferFull = errors.New("events: publish buffer full")
+
+// Config configures publishing. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Sinks lists the sink names to publish to: memory, file, sns,
+	// eventbridge.
+	Sinks  []string
+	Source string
+
+	File           string
+	SNSTopicARN    string
+	EventBridgeBus string
+
+	BatchSize     int
+	FlushInterval time.Duration
+	Buffer        int
+	MaxRetries    int
+}
+
+// ConfigFromEnv reads the EVENTS_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Sinks:          env.List("EVENTS_SINKS", nil),
+		Source:         env.String("EVENTS_SOURCE", "urn:goaws:srv"),
+		File:           env.String("EVENTS_FILE", "/var/lib/srv/events.jsonl"),
+		SNSTopicARN:    env.String("EVENTS_SNS_TOPIC_ARN", ""),
+		EventBridgeBus: env.String("EVENTS_EVENTBRIDGE_BUS", "default"),
+		BatchSize:      env.Int("EVENTS_BATCH_SIZE", 10),
+		FlushInterval:  env.Duration("EVENTS_FLUSH_INTERVAL", time.Second),
+		Buffer:         env.Int("EVENTS_BUFFER", 1024),
+		MaxRetries:     env.Int("EVENTS_MAX_RETRIES", 5),
+	}
+}
+
+// NewSinks builds the sinks named in cfg.Sinks. client is only used by the
+// AWS sinks and may be nil otherwise.
+func NewSinks(cfg Config, client *aws.Client) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range cfg.Sinks {
+		switch strings.ToLower(name) {
+		case "memory":
+			sinks = append(sinks, &MemorySink{})
+		case "file":
+			s, err := NewFileSink(cfg.File)
+			if err != nil {
+				return nil, fmt.Errorf("events: %w", err)
+			}
+			sinks = append(sinks, s)
+		case "sns":
+			if cfg.SNSTopicARN == "" {
+				return nil, errors.New("events: sns sink needs EVENTS_SNS_TOPIC_ARN")
+			}
+			sinks = append(sinks, &SNSSink{Client: client, TopicARN: cfg.SNSTopicARN})
+		case "eventbridge":
+			sinks = append(sinks, &EventBridgeSink{Client: client, Bus: cfg.EventBridgeBus})
+		default:
+			return nil, fmt.Errorf("events: unknown sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+var (
+	publishedTotal = metrics.NewCounter("events_published_total", "Events accepted by a sink.", "sink")
+	failedTotal    = metrics.NewCounter("events_failed_total", "Events a sink rejected after all retries.", "sink")
+	retriesTotal   = metrics.NewCounter("events_retries_total", "Batch send retries.", "sink")
+	droppedTotal   = metrics.NewCounter("events_dropped_total", "Events dropped before reaching any sink.", "reason")
+	batchSize      = metrics.NewHistogram("events_batch_size", "Events per batch sent to a sink.",
+		[]float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}, "sink")
+	publishSeconds = metrics.NewHistogram("events_publish_duration_seconds", "Time to send one batch to a sink, including retries.", nil, "sink")
+)
+
+// Publisher fans events out to its sinks in batches.
+type Publisher struct {
+	cfg   Config
+	sinks []Sink
+	ch    chan Event
+	log   *slog.Logger
+}
+
+// NewPublisher returns a publisher; call Run to start the background
+// batching used by Publish.
+func NewPublisher(cfg Config, sinks ...Sink) *Publisher {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 10
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = 1024
+	}
+	p := &Publisher{cfg: cfg, sinks: sinks, ch: make(chan Event, cfg.Buffer), log: slog.Default().With("component", "events")}
+	metrics.NewGaugeFunc("events_buffered", "Events waiting to be batched.", func() float64 { return float64(len(p.ch)) })
+	return p
+}
+
+// Publish queues e for background delivery without blocking.
+func (p *Publisher) Publish(e Event) error {
+	if e.Source == "" {
+		e.Source = p.cfg.Source
+	}
+	if err := e.Validate(); err != nil {
+		droppedTotal.With("invalid").Inc()
+		return err
+	}
+	select {
+	case p.ch <- e:
+		return nil
+	default:
+		droppedTotal.With("buffer_full").Inc()
+		return ErrBufferFull
+	}
+}
+
+// PublishSync sends batch to every sink before returning, retrying as
+// configured. It returns an error if any sink did not accept every event,
+// which callers needing at-least-once delivery should treat as "try again".
+// Only the events a sink rejected are retried, so they may arrive after
+// later ones; use PublishOrdered when order matters.
+func (p *Publisher) PublishSync(ctx context.Context, batch []Event) error {
+	_, err := p.publish(ctx, batch, false)
+	return err
+}
+
+// PublishOrdered is PublishSync for events that must arrive in order, such
+// as those of one aggregate. A sink that rejects an event is sent it again
+// together with every event after it, so the last copy of each event
+// arrives after the events before it, and a sink that keeps rejecting one
+// is sent no later chunks. It returns how many leading events of batch
+// every sink accepted.
+func (p *Publisher) PublishOrdered(ctx context.Context, batch []Event) (int, error) {
+	return p.publish(ctx, batch, true)
+}
+
+func (p *Publisher) publish(ctx context.Context, batch []Event, ordered bool) (int, error) {
+	for i := range batch {
+		if batch[i].Source == "" {
+			batch[i].Source = p.cfg.Source
+		}
+		if err := batch[i].Validate(); err != nil {
+			return 0, err
+		}
+	}
+	accepted := len(batch)
+	var errs []error
+	for _, s := range p.sinks {
+		n, err := p.sendTo(ctx, s, batch, ordered)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+		accepted = min(accepted, n)
+	}
+	return accepted, errors.Join(errs...)
+}
+
+// Run batches events queued by Publish until ctx is done, then flushes what
+// is left with a short grace period.
+func (p *Publisher) Run(ctx context.Context) {
+	t := time.NewTicker(p.cfg.FlushInterval)
+	defer t.Stop()
+	var batch []Event
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.PublishSync(ctx, batch); err != nil {
+			p.log.Error("publishing events", "count", len(batch), "err", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case e := <-p.ch:
+			batch = append(batch, e)
+			if len(batch) >= p.cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-t.C:
+			flush(ctx)
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			for {
+				select {
+				case e := <-p.ch:
+					batch = append(batch, e)
+					if len(batch) >= p.cfg.BatchSize {
+						flush(drainCtx)
+					}
+				default:
+					flush(drainCtx)
+					return
+				}
+			}
+		}
+	}
+}
+
+// sendTo delivers batch to s in chunks of at most s.MaxBatch, retrying
+// failed events with exponential backoff. In ordered mode it stops at the
+// first chunk that fails. It returns how many leading events of batch s
+// accepted.
+func (p *Publisher) sendTo(ctx context.Context, s Sink, batch []Event, ordered bool) (int, error) {
+	var errs []error
+	accepted := 0
+	for len(batch) > 0 {
+		n := min(len(batch), s.MaxBatch())
+		k, err := p.sendChunk(ctx, s, batch[:n], ordered)
+		if err != nil {
+			if ordered {
+				return accepted + k, err
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 {
+			accepted += k
+		}
+		batch = batch[n:]
+	}
+	return accepted, errors.Join(errs...)
+}
+
+// sendChunk sends chunk to s, retrying what it rejects, and returns how
+// many leading events of chunk s accepted.
+func (p *Publisher) sendChunk(ctx context.Context, s Sink, chunk []Event, ordered bool) (int, error) {
+	name := s.Name()
+	start := time.Now()
+	defer func() { publishSeconds.With(name).Observe(time.Since(start).Seconds()) }()
+	batchSize.With(name).Observe(float64(len(chunk)))
+
+	pending := chunk
+	// accepted counts the leading events of chunk that are done. In
+	// unordered mode a partial failure leaves gaps, so it stays put.
+	accepted := 0
+	backoff := 200 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := s.Send(ctx, pending)
+		if err == nil {
+			publishedTotal.With(name).Add(float64(len(pending)))
+			return len(chunk), nil
+		}
+
+		retryable := true
+		var pe *PartialError
+		var ae *aws.APIError
+		switch {
+		case errors.As(err, &pe) && ordered:
+			// Resend from the first rejected event on, so that the sink
+			// never gets an event without the ones before it.
+			i := 0
+			for i < len(pending) {
+				if _, ok := pe.Failed[pending[i].ID]; ok {
+					break
+				}
+				i++
+			}
+			publishedTotal.With(name).Add(float64(i))
+			accepted += i
+			pending = pending[i:]
+			retryable = pe.Retryable
+		case errors.As(err, &pe):
+			var failed []Event
+			for _, e := range pending {
+				if _, ok := pe.Failed[e.ID]; ok {
+					failed = append(failed, e)
+				}
+			}
+			publishedTotal.With(name).Add(float64(len(pending) - len(failed)))
+			pending = failed
+			retryable = pe.Retryable
+		case errors.As(err, &ae):
+			retryable = ae.Retryable()
+		}
+		if len(pending) == 0 {
+			// The sink named none of the events it was sent.
+			return len(chunk), nil
+		}
+
+		if !retryable || attempt >= p.cfg.MaxRetries || ctx.Err() != nil {
+			failedTotal.With(name).Add(float64(len(pending)))
+			return accepted, err
+		}
+		retriesTotal.With(name).Inc()
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,98 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Sink delivers batches of events somewhere.
+type Sink interface {
+	Name() string
+	// MaxBatch is the largest batch Send accepts.
+	MaxBatch() int
+	Send(ctx context.Context, batch []Event) error
+}
+
+// PartialError reports that only some events of a batch were rejected. The
+// publisher retries just those.
+type PartialError struct {
+	// Failed maps event IDs to the reason they were rejected.
+	Failed map[string]string
+	// Retryable is false when the sink said retrying will not help.
+	Retryable bool
+}
+
+func (e *PartialError) Error() string {
+	for id, reason := range e.Failed {
+		return fmt.Sprintf("events: %d event(s) rejected, e.g. %s: %s", len(e.Failed), id, reason)
+	}
+	return "events: batch partially rejected"
+}
+
+// MemorySink keeps events in memory. It is meant for tests and local runs.
+type MemorySink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *MemorySink) Name() string  { return "memory" }
+func (s *MemorySink) MaxBatch() int { return 1000 }
+
+func (s *MemorySink) Send(_ context.Context, batch []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, batch...)
+	return nil
+}
+
+// Events returns a copy of everything sent so far.
+func (s *MemorySink) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// FileSink appends events as JSON lines to a file.
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Name() string  { return "file" }
+func (s *FileSink) MaxBatch() int { return 1000 }
+
+func (s *FileSink) Send(_ context.Context, batch []Event) error {
+	var buf []byte
+	for _, e := range batch {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(buf); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Close closes the underlying file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"goaws/internal/aws"
+)
+
+// SNSSink publishes events to an SNS topic with PublishBatch. The message is
+// the structured-mode CloudEvent; type and source are also sent as message
+// attributes so subscriptions can filter on them.
+type SNSSink struct {
+	Client   *aws.Client
+	TopicARN string
+}
+
+func (s *SNSSink) Name() string  { return "sns" }
+func (s *SNSSink) MaxBatch() int { return 10 }
+
+func (s *SNSSink) Send(ctx context.Context, batch []Event) error {
+	fifo := strings.HasSuffix(s.TopicARN, ".fifo")
+	form := url.Values{
+		"Action":   {"PublishBatch"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {s.TopicARN},
+	}
+	for i, e := range batch {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		p := "PublishBatchRequestEntries.member." + strconv.Itoa(i+1) + "."
+		form.Set(p+"Id", e.ID)
+		form.Set(p+"Message", string(body))
+		attr := func(n int, name, value string) {
+			a := p + "MessageAttributes.entry." + strconv.Itoa(n) + "."
+			form.Set(a+"Name", name)
+			form.Set(a+"Value.DataType", "String")
+			form.Set(a+"Value.StringValue", value)
+		}
+		attr(1, "ce_type", e.Type)
+		attr(2, "ce_source", e.Source)
+		if fifo {
+			group := e.Subject
+			if group == "" {
+				group = e.Type
+			}
+			form.Set(p+"MessageGroupId", group)
+			form.Set(p+"MessageDeduplicationId", e.ID)
+		}
+	}
+
+	var out struct {
+		Failed []struct {
+			ID          string `xml:"Id"`
+			Code        string `xml:"Code"`
+			Message     string `xml:"Message"`
+			SenderFault bool   `xml:"SenderFault"`
+		} `xml:"PublishBatchResult>Failed>member"`
+	}
+	if err := s.Client.Query(ctx, aws.SNS, form, &out); err != nil {
+		return err
+	}
+	if len(out.Failed) == 0 {
+		return nil
+	}
+	pe := &PartialError{Failed: map[string]string{}}
+	for _, f := range out.Failed {
+		pe.Failed[f.ID] = f.Code + ": " + f.Message
+		// Retry unless SNS blamed every failure on the request itself.
+		if !f.SenderFault {
+			pe.Retryable = true
+		}
+	}
+	return pe
+}
@@ -0,0 +1,137 @@
+package flags
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"goaws/internal/httpx"
+)
+
+// Handler serves the flag API for the admin listener:
+//
+//	GET    /flags
+//	GET    /flags/{name}/evaluate?user=&ip=&header=Name:value&key=
+//	PUT    /flags/{name}/override
+//	DELETE /flags/{name}/override
+//
+// An override sets a flag's value for everyone until its TTL runs out or
+// it is deleted. Overrides are kept in memory only, so a restart also ends
+// them.
+func (s *Set) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /flags", s.list)
+	mux.HandleFunc("GET /flags/{name}/evaluate", s.evaluate)
+	mux.HandleFunc("PUT /flags/{name}/override", s.setOverride)
+	mux.HandleFunc("DELETE /flags/{name}/override", s.deleteOverride)
+	return mux
+}
+
+// FlagInfo describes a flag in effect.
+type FlagInfo struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Flag
+	Override *Override `json:"override,omitempty"`
+}
+
+func (s *Set) list(w http.ResponseWriter, r *http.Request) {
+	snap := s.state.Load()
+	out := []FlagInfo{}
+	for name, f := range snap.flags {
+		info := FlagInfo{Name: name, Source: f.source, Flag: f.def}
+		if o, ok := snap.overrides[name]; ok && time.Now().Before(o.Expires) {
+			info.Override = o
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	httpx.WriteJSON(w, http.StatusOK, out)
+}
+
+// evaluate explains what a subject described by the query gets, without
+// counting towards the evaluation metrics.
+func (s *Set) evaluate(w http.ResponseWriter, r *http.Request) {
+	snap := s.state.Load()
+	f, ok := snap.flags[r.PathValue("name")]
+	if !ok {
+		httpx.WriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+	q := r.URL.Query()
+	sub := Subject{User: q.Get("user"), IP: q.Get("ip"), Key: q.Get("key"), Header: http.Header{}}
+	for _, h := range q["header"] {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			httpx.WriteError(w, http.StatusBadRequest, "header must be Name:value")
+			return
+		}
+		sub.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	o := snap.match(f, sub)
+	httpx.WriteJSON(w, http.StatusOK, Result{Value: o.value, Reason: o.reason, Rule: o.rule})
+}
+
+type overrideRequest struct {
+	Value  any    `json:"value"`
+	TTL    string `json:"ttl"`
+	Reason string `json:"reason"`
+}
+
+func (s *Set) setOverride(w http.ResponseWriter, r *http.Request) {
+	var req overrideRequest
+	if !httpx.ReadJSON(w, r, &req) {
+		return
+	}
+	ttl := time.Hour
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			httpx.WriteError(w, http.StatusBadRequest, "ttl must be a positive duration such as 30m")
+			return
+		}
+	}
+	if ttl > s.cfg.MaxOverrideTTL {
+		httpx.WriteError(w, http.StatusBadRequest, fmt.Sprintf("ttl must be at most %s", s.cfg.MaxOverrideTTL))
+		return
+	}
+	name := r.PathValue("name")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.flags[name]
+	if !ok {
+		httpx.WriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if typeOf(req.Value) != f.def.Type {
+		httpx.WriteError(w, http.StatusBadRequest, "value must be a "+f.def.Type)
+		return
+	}
+	o := &Override{
+		Value:   req.Value,
+		Reason:  req.Reason,
+		Expires: time.Now().Add(ttl).UTC(),
+		outcome: newOutcome(name, ReasonOverride, 0, req.Value),
+	}
+	s.overrides[name] = o
+	s.publish()
+	slog.Info("feature flag overridden", "flag", name, "value", req.Value, "ttl", ttl, "reason", req.Reason)
+	httpx.WriteJSON(w, http.StatusOK, o)
+}
+
+func (s *Set) deleteOverride(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.overrides[name]; !ok {
+		httpx.WriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+	delete(s.overrides, name)
+	s.publish()
+	slog.Info("feature flag override removed", "flag", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package flags
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	s := newSet(t, `{"flags": {
+		"b": {"type": "string", "default": "x", "rules": [{"header": "X-Beta", "values": ["1"], "value": "beta"}]},
+		"a": {"type": "bool", "default": false, "rules": [{"users": ["alice"], "value": true}]}}}`)
+	h := s.Handler()
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var list []FlagInfo
+	json.NewDecoder(do("GET", "/flags", "").Body).Decode(&list)
+	if len(list) != 2 || list[0].Name != "a" || list[0].Source != "file" || list[1].Type != TypeString {
+		t.Errorf("list %+v", list)
+	}
+
+	var res Result
+	json.NewDecoder(do("GET", "/flags/b/evaluate?header=X-Beta:+1", "").Body).Decode(&res)
+	if res.Value != "beta" || res.Reason != ReasonRule || res.Rule != 1 {
+		t.Errorf("evaluate %+v", res)
+	}
+	for target, code := range map[string]int{"/flags/b/evaluate?header=X-Beta": 400, "/flags/nope/evaluate": 404} {
+		if rec := do("GET", target, ""); rec.Code != code {
+			t.Errorf("GET %s: %d, want %d", target, rec.Code, code)
+		}
+	}
+
+	for body, code := range map[string]int{
+		`{"value": "on"}`:                 400,
+		`{"value": true, "ttl": "-1m"}`:   400,
+		`{"value": true, "ttl": "2h"}`:    400,
+		`{"value": true, "ttl": "200ms"}`: 200,
+	} {
+		if rec := do("PUT", "/flags/a/override", body); rec.Code != code {
+			t.Errorf("PUT %s: %d %s, want %d", body, rec.Code, rec.Body, code)
+		}
+	}
+	if rec := do("PUT", "/flags/nope/override", `{"value": true}`); rec.Code != 404 {
+		t.Errorf("override of a missing flag: %d", rec.Code)
+	}
+	if r := s.Evaluate(Subject{User: "bob"}, "a"); r.Value != true || r.Reason != ReasonOverride {
+		t.Errorf("overridden %+v", r)
+	}
+	json.NewDecoder(do("GET", "/flags", "").Body).Decode(&list)
+	if list[0].Override == nil || list[0].Override.Value != true {
+		t.Errorf("list while overridden %+v", list[0])
+	}
+
+	// Overrides lapse on their own.
+	time.Sleep(250 * time.Millisecond)
+	if r := s.Evaluate(Subject{User: "bob"}, "a"); r.Value != false || r.Reason != ReasonDefault {
+		t.Errorf("after the TTL %+v", r)
+	}
+
+	do("PUT", "/flags/a/override", `{"value": true}`)
+	if rec := do("DELETE", "/flags/a/override", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: %d", rec.Code)
+	}
+	if rec := do("DELETE", "/flags/a/override", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: %d", rec.Code)
+	}
+	if s.Bool(Subject{User: "bob"}, "a", true) {
+		t.Error("override outlived its deletion")
+	}
+}
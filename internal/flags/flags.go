@@ -0,0 +1,581 @@
+// Package flags evaluates feature flags, so that code can ship dark and be
+// turned on per environment, per user or for a share of traffic without a
+// deploy. Flags are defined in a JSON file, reloaded when it changes, and
+// optionally in a document fetched from a URL that takes precedence. Each
+// flag is a bool or a string; targeting rules match users, headers, client
+// IPs or the environment, and percentage rollouts hash the subject so that
+// the same user keeps the same answer as the percentage grows. Operators can
+// override a flag for a while from the admin listener.
+package flags
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goaws/internal/auth"
+	"goaws/internal/env"
+	"goaws/internal/httpx"
+	"goaws/internal/metrics"
+)
+
+var (
+	evaluations = metrics.NewCounter("flags_evaluations_total", "Feature flag evaluations.", "flag", "reason", "value")
+	reloads     = metrics.NewCounter("flags_reloads_total", "Feature flag definition loads.", "source", "result")
+	overridden  = metrics.NewGauge("flags_overrides_active", "Feature flags currently overridden from the admin API.")
+)
+
+// Flag types.
+const (
+	TypeBool   = "bool"
+	TypeString = "string"
+)
+
+// Reasons an evaluation gave its value.
+const (
+	ReasonOverride = "override"
+	ReasonRule     = "rule"
+	ReasonRollout  = "rollout"
+	ReasonDefault  = "default"
+	ReasonMissing  = "missing"
+)
+
+// Config configures feature flags. It is normally built by ConfigFromEnv.
+type Config struct {
+	// File holds the flag definitions and is reloaded when it changes.
+	File           string
+	ReloadInterval time.Duration
+	// URL, if set, serves a definitions document whose flags replace
+	// the file's flags of the same name. It is polled every URLInterval
+	// with If-None-Match; while it cannot be fetched the last document
+	// fetched stays in effect.
+	URL         string
+	URLInterval time.Duration
+	URLTimeout  time.Duration
+	// MaxOverrideTTL bounds how long an admin override lasts.
+	MaxOverrideTTL time.Duration
+	// Environment is matched by rules' env conditions.
+	Environment string
+}
+
+// ConfigFromEnv reads the FLAGS_* variables and ENV.
+func ConfigFromEnv() Config {
+	return Config{
+		File:           env.String("FLAGS_FILE", ""),
+		ReloadInterval: env.Duration("FLAGS_RELOAD_INTERVAL", 5*time.Second),
+		URL:            env.String("FLAGS_URL", ""),
+		URLInterval:    env.Duration("FLAGS_URL_INTERVAL", 30*time.Second),
+		URLTimeout:     env.Duration("FLAGS_URL_TIMEOUT", 5*time.Second),
+		MaxOverrideTTL: env.Duration("FLAGS_OVERRIDE_MAX_TTL", 24*time.Hour),
+		Environment:    env.String("ENV", "development"),
+	}
+}
+
+// Enabled reports whether any source of flags is configured.
+func (c Config) Enabled() bool {
+	return c.File != "" || c.URL != ""
+}
+
+// Flag is one definition of a definitions document:
+//
+//	{"flags": {
+//	  "new-checkout": {
+//	    "type": "bool", "default": false,
+//	    "rules": [
+//	      {"env": ["staging"], "value": true},
+//	      {"users": ["alice", "bob"], "value": true},
+//	      {"header": "X-Beta", "values": ["1"], "percent": 50, "value": true},
+//	      {"ips": ["10.0.0.0/8"], "value": true}],
+//	    "rollout": [{"percent": 5, "value": true}]},
+//	  "search-backend": {
+//	    "type": "string", "default": "postgres",
+//	    "rollout": [{"percent": 10, "value": "opensearch"}]}}}
+//
+// Rules are tried in order and the first that matches decides. Then the
+// rollout gives each variant its percentage of subjects, and the rest get
+// the default.
+type Flag struct {
+	Type        string    `json:"type"`
+	Description string    `json:"description,omitempty"`
+	Default     any       `json:"default"`
+	Rules       []Rule    `json:"rules,omitempty"`
+	Rollout     []Variant `json:"rollout,omitempty"`
+	// Salt seeds the rollout hash; it defaults to the flag's name.
+	// Changing it reshuffles who is in a rollout.
+	Salt string `json:"salt,omitempty"`
+}
+
+// Rule matches subjects on every condition it sets; a rule with no
+// conditions matches everyone. Users lists user IDs, IPs client addresses
+// or CIDR prefixes, Header a request header that must be present and, if
+// Values is set, have one of them, and Env environments. Percent, if set,
+// admits only that share of the matching subjects.
+type Rule struct {
+	Users   []string `json:"users,omitempty"`
+	IPs     []string `json:"ips,omitempty"`
+	Header  string   `json:"header,omitempty"`
+	Values  []string `json:"values,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Percent *float64 `json:"percent,omitempty"`
+	Value   any      `json:"value"`
+}
+
+// Variant is a value given to a percentage of subjects.
+type Variant struct {
+	Percent float64 `json:"percent"`
+	Value   any     `json:"value"`
+}
+
+// Document is a definitions file or URL response.
+type Document struct {
+	Flags map[string]Flag `json:"flags"`
+}
+
+// Subject is who a flag is evaluated for. Key, if set, is hashed for
+// rollouts instead of User, or of IP when there is no user.
+type Subject struct {
+	User   string
+	IP     string
+	Header http.Header
+	Key    string
+}
+
+// For returns the subject of r: the signed-in user that auth.Middleware or
+// auth.Require attached to the request, the client IP and the request
+// headers. Requests without a session are evaluated by IP. The IP is the
+// one the ALB saw, so a client cannot match an ips rule or pick its
+// rollout bucket with a forged X-Forwarded-For.
+func For(r *http.Request) Subject {
+	s := Subject{IP: httpx.TrustedClientIP(r), Header: r.Header}
+	if sess, ok := auth.FromContext(r.Context()); ok {
+		s.User = sess.Login
+	}
+	return s
+}
+
+func (s Subject) rolloutKey() string {
+	switch {
+	case s.Key != "":
+		return s.Key
+	case s.User != "":
+		return s.User
+	}
+	return s.IP
+}
+
+// Result is the outcome of an evaluation.
+type Result struct {
+	Value  any    `json:"value"`
+	Reason string `json:"reason"`
+	// Rule is the 1-based index of the rule that matched.
+	Rule int `json:"rule,omitempty"`
+}
+
+// outcome is a value a flag can give, with its evaluation counter made up
+// front so that evaluating allocates nothing.
+type outcome struct {
+	value   any
+	reason  string
+	rule    int
+	counter metrics.Counter
+}
+
+func newOutcome(name, reason string, rule int, v any) outcome {
+	return outcome{v, reason, rule, evaluations.With(name, reason, valueLabel(v))}
+}
+
+func (o outcome) result() Result {
+	o.counter.Inc()
+	return Result{Value: o.value, Reason: o.reason, Rule: o.rule}
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case bool:
+		return TypeBool
+	case string:
+		return TypeString
+	}
+	return ""
+}
+
+func valueLabel(v any) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+// flag is a compiled definition.
+type flag struct {
+	name    string
+	def     Flag
+	source  string
+	salt    string
+	rules   []rule
+	rollout []variant
+	dflt    outcome
+}
+
+type rule struct {
+	users    map[string]bool
+	prefixes []netip.Prefix
+	header   string
+	values   map[string]bool
+	envMatch bool
+	// threshold is the rollout bucket below which subjects are admitted,
+	// or -1 to admit all.
+	threshold int
+	outcome
+}
+
+type variant struct {
+	// upTo is the cumulative bucket bound.
+	upTo int
+	outcome
+}
+
+// buckets is the resolution of percentages: 0.01%.
+const buckets = 10000
+
+func percentBuckets(p float64) (int, error) {
+	if p < 0 || p > 100 {
+		return 0, fmt.Errorf("percent %v is not between 0 and 100", p)
+	}
+	return int(math.Round(p * buckets / 100)), nil
+}
+
+func compile(name, source string, def Flag, environment string) (*flag, error) {
+	if def.Type != TypeBool && def.Type != TypeString {
+		return nil, fmt.Errorf("flag %s: type must be bool or string", name)
+	}
+	check := func(what string, v any) error {
+		if typeOf(v) != def.Type {
+			return fmt.Errorf("flag %s: %s must be a %s", name, what, def.Type)
+		}
+		return nil
+	}
+	if err := check("default", def.Default); err != nil {
+		return nil, err
+	}
+	f := &flag{name: name, def: def, source: source, salt: def.Salt, dflt: newOutcome(name, ReasonDefault, 0, def.Default)}
+	if f.salt == "" {
+		f.salt = name
+	}
+	for i, d := range def.Rules {
+		if err := check(fmt.Sprintf("rule %d value", i+1), d.Value); err != nil {
+			return nil, err
+		}
+		r := rule{
+			header:    textproto.CanonicalMIMEHeaderKey(d.Header),
+			envMatch:  len(d.Env) == 0,
+			threshold: -1,
+			outcome:   newOutcome(name, ReasonRule, i+1, d.Value),
+		}
+		if len(d.Users) > 0 {
+			r.users = map[string]bool{}
+			for _, u := range d.Users {
+				r.users[u] = true
+			}
+		}
+		if len(d.Values) > 0 {
+			if d.Header == "" {
+				return nil, fmt.Errorf("flag %s: rule %d has values but no header", name, i+1)
+			}
+			r.values = map[string]bool{}
+			for _, v := range d.Values {
+				r.values[v] = true
+			}
+		}
+		for _, s := range d.IPs {
+			p, err := parsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("flag %s: rule %d: %w", name, i+1, err)
+			}
+			r.prefixes = append(r.prefixes, p)
+		}
+		for _, e := range d.Env {
+			r.envMatch = r.envMatch || e == environment
+		}
+		if d.Percent != nil {
+			t, err := percentBuckets(*d.Percent)
+			if err != nil {
+				return nil, fmt.Errorf("flag %s: rule %d: %w", name, i+1, err)
+			}
+			r.threshold = t
+		}
+		f.rules = append(f.rules, r)
+	}
+	upTo := 0
+	for i, d := range def.Rollout {
+		if err := check(fmt.Sprintf("rollout %d value", i+1), d.Value); err != nil {
+			return nil, err
+		}
+		n, err := percentBuckets(d.Percent)
+		if err != nil {
+			return nil, fmt.Errorf("flag %s: rollout %d: %w", name, i+1, err)
+		}
+		if upTo += n; upTo > buckets {
+			return nil, fmt.Errorf("flag %s: rollout percentages add up to more than 100", name)
+		}
+		f.rollout = append(f.rollout, variant{upTo, newOutcome(name, ReasonRollout, 0, d.Value)})
+	}
+	return f, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// match returns the outcome for s.
+func (f *flag) match(s Subject) *outcome {
+	var addr netip.Addr
+	bucket := -1
+	for i := range f.rules {
+		r := &f.rules[i]
+		if !r.envMatch || r.users != nil && !r.users[s.User] {
+			continue
+		}
+		if r.header != "" && !r.headerMatch(s.Header[r.header]) {
+			continue
+		}
+		if r.prefixes != nil {
+			if !addr.IsValid() {
+				addr, _ = netip.ParseAddr(s.IP)
+			}
+			if !r.ipMatch(addr) {
+				continue
+			}
+		}
+		if r.threshold >= 0 {
+			if bucket < 0 {
+				bucket = f.bucket(s)
+			}
+			if bucket >= r.threshold {
+				continue
+			}
+		}
+		return &r.outcome
+	}
+	if len(f.rollout) > 0 {
+		if bucket < 0 {
+			bucket = f.bucket(s)
+		}
+		for i := range f.rollout {
+			if bucket < f.rollout[i].upTo {
+				return &f.rollout[i].outcome
+			}
+		}
+	}
+	return &f.dflt
+}
+
+func (r *rule) headerMatch(values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if r.values == nil {
+		return true
+	}
+	for _, v := range values {
+		if r.values[v] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) ipMatch(a netip.Addr) bool {
+	a = a.Unmap()
+	for _, p := range r.prefixes {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket places s in [0, buckets) by hashing its rollout key with the
+// flag's salt (FNV-1a). A subject's bucket never changes, so raising a
+// percentage only adds subjects and different flags roll out to different
+// subjects.
+func (f *flag) bucket(s Subject) int {
+	const offset, prime = 14695981039346656037, 1099511628211
+	h := uint64(offset)
+	for i := 0; i < len(f.salt); i++ {
+		h = (h ^ uint64(f.salt[i])) * prime
+	}
+	// A zero byte separates the salt from the key.
+	h *= prime
+	key := s.rolloutKey()
+	for i := 0; i < len(key); i++ {
+		h = (h ^ uint64(key[i])) * prime
+	}
+	return int(h % buckets)
+}
+
+// Override is a value set from the admin API until it expires.
+type Override struct {
+	Value   any       `json:"value"`
+	Reason  string    `json:"reason,omitempty"`
+	Expires time.Time `json:"expires"`
+	outcome outcome
+}
+
+// snapshot is what evaluations read. It is replaced, never modified, so
+// that they need no lock.
+type snapshot struct {
+	flags     map[string]*flag
+	overrides map[string]*Override
+}
+
+// Set holds the flags in effect. A nil *Set evaluates every flag to the
+// caller's default.
+type Set struct {
+	cfg    Config
+	client *http.Client
+	state  atomic.Pointer[snapshot]
+
+	// mu serialises changes to the sources and overrides.
+	mu        sync.Mutex
+	file      Document
+	fileStamp fileStamp
+	remote    Document
+	etag      string
+	flags     map[string]*flag
+	overrides map[string]*Override
+}
+
+// Evaluate returns the value of the named flag for s, and why.
+func (s *Set) Evaluate(sub Subject, name string) Result {
+	if s == nil {
+		return Result{Reason: ReasonMissing}
+	}
+	snap := s.state.Load()
+	f, ok := snap.flags[name]
+	if !ok {
+		evaluations.With(name, ReasonMissing, "").Inc()
+		return Result{Reason: ReasonMissing}
+	}
+	return snap.match(f, sub).result()
+}
+
+func (snap *snapshot) match(f *flag, sub Subject) *outcome {
+	if o, ok := snap.overrides[f.name]; ok && time.Now().Before(o.Expires) {
+		return &o.outcome
+	}
+	return f.match(sub)
+}
+
+// Bool returns the named bool flag's value for sub, or def if the flag is
+// not defined or is not a bool.
+func (s *Set) Bool(sub Subject, name string, def bool) bool {
+	if v, ok := s.Evaluate(sub, name).Value.(bool); ok {
+		return v
+	}
+	return def
+}
+
+// String returns the named string flag's value for sub, or def if the flag
+// is not defined or is not a string.
+func (s *Set) String(sub Subject, name, def string) string {
+	if v, ok := s.Evaluate(sub, name).Value.(string); ok {
+		return v
+	}
+	return def
+}
+
+// compile compiles the sources into s.flags, the URL's flags replacing
+// the file's. The caller holds s.mu. On error s.flags is left as it was.
+func (s *Set) compile() error {
+	flags := map[string]*flag{}
+	for name, def := range s.file.Flags {
+		if _, ok := s.remote.Flags[name]; ok {
+			continue
+		}
+		f, err := compile(name, "file", def, s.cfg.Environment)
+		if err != nil {
+			return fmt.Errorf("flags: %s: %w", s.cfg.File, err)
+		}
+		flags[name] = f
+	}
+	for name, def := range s.remote.Flags {
+		f, err := compile(name, "url", def, s.cfg.Environment)
+		if err != nil {
+			return fmt.Errorf("flags: %s: %w", s.cfg.URL, err)
+		}
+		flags[name] = f
+	}
+	s.flags = flags
+	s.publish()
+	return nil
+}
+
+// publish replaces the snapshot with s.flags and the overrides that have
+// not expired. The caller holds s.mu.
+func (s *Set) publish() {
+	snap := &snapshot{flags: s.flags, overrides: map[string]*Override{}}
+	now := time.Now()
+	for name, o := range s.overrides {
+		if now.After(o.Expires) {
+			delete(s.overrides, name)
+			continue
+		}
+		snap.overrides[name] = o
+	}
+	overridden.With().Set(float64(len(s.overrides)))
+	s.state.Store(snap)
+}
+
+// parseDocument reads a definitions document. Unknown fields are errors,
+// so that a misspelt condition is not silently ignored and the rule made
+// to match everyone.
+func parseDocument(b []byte) (Document, error) {
+	var doc Document
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+var defaultSet atomic.Pointer[Set]
+
+// SetDefault makes s the set the package-level functions use.
+func SetDefault(s *Set) { defaultSet.Store(s) }
+
+// Default returns the set given to SetDefault, or nil.
+func Default() *Set { return defaultSet.Load() }
+
+// Bool evaluates a bool flag in the default set:
+//
+//	if flags.Bool(flags.For(r), "new-checkout", false) {
+func Bool(sub Subject, name string, def bool) bool {
+	return Default().Bool(sub, name, def)
+}
+
+// String evaluates a string flag in the default set.
+func String(sub Subject, name, def string) string {
+	return Default().String(sub, name, def)
+}
@@ -0,0 +1,255 @@
+package flags
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"goaws/internal/auth"
+)
+
+func compileFlag(t *testing.T, name, doc string) *flag {
+	t.Helper()
+	d, err := parseDocument([]byte(`{"flags": {"` + name + `": ` + doc + `}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := compile(name, "file", d.Flags[name], "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestBucketIsFNV1a(t *testing.T) {
+	f := compileFlag(t, "new-checkout", `{"type": "bool", "default": false}`)
+	for _, sub := range []Subject{{User: "alice"}, {IP: "203.0.113.7"}, {User: "alice", Key: "tenant-9"}} {
+		key := sub.Key
+		if key == "" {
+			key = sub.User
+		}
+		if key == "" {
+			key = sub.IP
+		}
+		h := fnv.New64a()
+		h.Write([]byte("new-checkout\x00" + key))
+		if got, want := f.bucket(sub), int(h.Sum64()%buckets); got != want {
+			t.Errorf("bucket(%+v) = %d, want %d", sub, got, want)
+		}
+	}
+	salted := compileFlag(t, "new-checkout", `{"type": "bool", "default": false, "salt": "reshuffle"}`)
+	if f.bucket(Subject{User: "alice"}) == salted.bucket(Subject{User: "alice"}) && f.bucket(Subject{User: "bob"}) == salted.bucket(Subject{User: "bob"}) {
+		t.Error("salt does not change buckets")
+	}
+}
+
+func TestRolloutOnlyAddsSubjects(t *testing.T) {
+	at := func(name string, percent int) *flag {
+		return compileFlag(t, name, `{"type": "bool", "default": false, "rollout": [{"percent": `+strconv.Itoa(percent)+`, "value": true}]}`)
+	}
+	ten, twenty, other := at("a", 10), at("a", 20), at("b", 10)
+	var inTen, inTwenty, inBoth int
+	for i := range 20000 {
+		sub := Subject{User: "user" + strconv.Itoa(i)}
+		a, b := ten.match(sub).value == true, twenty.match(sub).value == true
+		if a && !b {
+			t.Fatalf("%s left the rollout when it grew", sub.User)
+		}
+		if a && other.match(sub).value == true {
+			inBoth++
+		}
+		if a {
+			inTen++
+		}
+		if b {
+			inTwenty++
+		}
+	}
+	if inTen < 1800 || inTen > 2200 || inTwenty < 3700 || inTwenty > 4300 {
+		t.Errorf("%d in 10%%, %d in 20%% of 20000", inTen, inTwenty)
+	}
+	// Independent flags overlap by about 10% of 10%.
+	if inBoth > 300 {
+		t.Errorf("%d subjects in both 10%% rollouts", inBoth)
+	}
+	if o := ten.match(Subject{User: "user1"}); o.reason != ReasonRollout && o.reason != ReasonDefault {
+		t.Errorf("reason %q", o.reason)
+	}
+}
+
+func TestRules(t *testing.T) {
+	f := compileFlag(t, "search", `{"type": "string", "default": "postgres", "rules": [
+		{"env": ["production"], "value": "prod"},
+		{"users": ["alice"], "value": "alice"},
+		{"header": "x-beta", "values": ["1", "yes"], "value": "beta"},
+		{"header": "X-Any", "value": "any"},
+		{"ips": ["10.0.0.0/8", "192.0.2.1", "2001:db8::/32"], "value": "office"},
+		{"users": ["bob"], "percent": 0, "value": "never"},
+		{"env": ["staging"], "users": ["carol"], "value": "staging"}],
+		"rollout": [{"percent": 100, "value": "everyone"}]}`)
+	for _, tc := range []struct {
+		sub        Subject
+		want       string
+		rule       int
+		wantReason string
+	}{
+		{Subject{User: "alice", Header: http.Header{"X-Beta": {"1"}}}, "alice", 2, ReasonRule},
+		{Subject{Header: http.Header{"X-Beta": {"no", "yes"}}}, "beta", 3, ReasonRule},
+		{Subject{Header: http.Header{"X-Beta": {"no"}}}, "everyone", 0, ReasonRollout},
+		{Subject{Header: http.Header{"X-Any": {""}}}, "any", 4, ReasonRule},
+		{Subject{IP: "10.1.2.3"}, "office", 5, ReasonRule},
+		{Subject{IP: "::ffff:192.0.2.1"}, "office", 5, ReasonRule},
+		{Subject{IP: "2001:db8::9"}, "office", 5, ReasonRule},
+		{Subject{IP: "192.0.2.2"}, "everyone", 0, ReasonRollout},
+		{Subject{IP: "not an ip"}, "everyone", 0, ReasonRollout},
+		{Subject{User: "bob"}, "everyone", 0, ReasonRollout},
+		{Subject{User: "carol"}, "staging", 7, ReasonRule},
+	} {
+		o := f.match(tc.sub)
+		if o.value != tc.want || o.rule != tc.rule || o.reason != tc.wantReason {
+			t.Errorf("match(%+v) = %v, rule %d, %s; want %v, rule %d, %s", tc.sub, o.value, o.rule, o.reason, tc.want, tc.rule, tc.wantReason)
+		}
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	for _, doc := range []string{
+		`{"type": "int", "default": 1}`,
+		`{"type": "bool", "default": "yes"}`,
+		`{"type": "bool", "default": false, "rules": [{"value": "x"}]}`,
+		`{"type": "bool", "default": false, "rules": [{"values": ["1"], "value": true}]}`,
+		`{"type": "bool", "default": false, "rules": [{"ips": ["10.0.0.0/33"], "value": true}]}`,
+		`{"type": "bool", "default": false, "rules": [{"percent": 101, "value": true}]}`,
+		`{"type": "bool", "default": false, "rollout": [{"percent": 60, "value": true}, {"percent": 50, "value": false}]}`,
+		`{"type": "string", "default": "a", "rollout": [{"percent": 5, "value": true}]}`,
+	} {
+		d, err := parseDocument([]byte(`{"flags": {"f": ` + doc + `}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := compile("f", "file", d.Flags["f"], ""); err == nil {
+			t.Errorf("%s compiled", doc)
+		}
+	}
+	if _, err := parseDocument([]byte(`{"flags": {"f": {"type": "bool", "default": false, "rules": [{"user": ["alice"], "value": true}]}}}`)); err == nil {
+		t.Error("misspelt condition parsed")
+	}
+}
+
+func newSet(t *testing.T, doc string) *Set {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "flags.json")
+	if err := os.WriteFile(file, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{File: file, MaxOverrideTTL: time.Hour, Environment: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSetEvaluate(t *testing.T) {
+	s := newSet(t, `{"flags": {
+		"new-checkout": {"type": "bool", "default": false, "rules": [{"users": ["alice"], "value": true}]},
+		"backend": {"type": "string", "default": "postgres"}}}`)
+	if !s.Bool(Subject{User: "alice"}, "new-checkout", false) || s.Bool(Subject{User: "bob"}, "new-checkout", true) {
+		t.Error("new-checkout")
+	}
+	if s.String(Subject{}, "backend", "x") != "postgres" || s.String(Subject{}, "new-checkout", "x") != "x" || !s.Bool(Subject{}, "missing", true) {
+		t.Error("defaults for the wrong type or a missing flag")
+	}
+	if r := s.Evaluate(Subject{}, "missing"); r.Reason != ReasonMissing || r.Value != nil {
+		t.Errorf("missing flag %+v", r)
+	}
+
+	var nilSet *Set
+	if r := nilSet.Evaluate(Subject{}, "new-checkout"); r.Reason != ReasonMissing || !nilSet.Bool(Subject{}, "x", true) {
+		t.Errorf("nil set %+v", r)
+	}
+	SetDefault(s)
+	defer SetDefault(nil)
+	if !Bool(Subject{User: "alice"}, "new-checkout", false) || String(Subject{}, "backend", "") != "postgres" {
+		t.Error("default set")
+	}
+}
+
+// signIn signs alice in through a with a stand-in provider and returns her
+// session cookie.
+func signIn(t *testing.T, a *auth.Auth) *http.Cookie {
+	t.Helper()
+	h := a.Handler()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/auth/login", nil))
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/auth/callback?code=c&state="+loc.Query().Get("state"), nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Value != "" && rec.Code == http.StatusFound {
+			return c
+		}
+	}
+	t.Fatalf("sign-in failed: %d %s", rec.Code, rec.Body)
+	return nil
+}
+
+type provider struct{}
+
+func (provider) Name() string                       { return "test" }
+func (provider) AuthCodeURL(state, _ string) string { return "https://idp.example/?state=" + state }
+func (provider) Exchange(context.Context, string, string) (string, error) {
+	return "token", nil
+}
+func (provider) Identity(context.Context, string) (*auth.Identity, error) {
+	return &auth.Identity{Login: "alice"}, nil
+}
+
+func TestForFindsTheSession(t *testing.T) {
+	a := auth.New(auth.Config{SessionSecret: "secret", SessionTTL: time.Hour, Policy: auth.Policy{DefaultRole: auth.RoleViewer}}, provider{})
+	cookie := signIn(t, a)
+
+	var got Subject
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = For(r) }))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.9:1234"
+	req.Header.Set("X-Beta", "1")
+	req.AddCookie(cookie)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.User != "alice" || got.IP != "192.0.2.9" || got.Header.Get("X-Beta") != "1" || got.rolloutKey() != "alice" {
+		t.Errorf("signed in: %+v", got)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got.User != "" || got.rolloutKey() != got.IP {
+		t.Errorf("anonymous: %+v", got)
+	}
+}
+
+func TestForIgnoresForgedForwardedFor(t *testing.T) {
+	f := compileFlag(t, "office-only", `{"type": "bool", "default": false, "rules": [{"ips": ["10.0.0.0/8"], "value": true}]}`)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.1.17:43512"
+	// The client claims an office address; the ALB appends the real one.
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 203.0.113.7")
+	sub := For(r)
+	if sub.IP != "203.0.113.7" || sub.rolloutKey() != "203.0.113.7" {
+		t.Errorf("subject %+v", sub)
+	}
+	if o := f.match(sub); o.value != false {
+		t.Error("forged X-Forwarded-For matched an ips rule")
+	}
+}
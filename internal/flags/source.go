@@ -0,0 +1,176 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// fileStamp identifies a version of the definitions file.
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// New loads the flag definitions. The file must load; the URL is fetched
+// once, and if that fails the file's flags serve until it answers.
+func New(cfg Config) (*Set, error) {
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = 5 * time.Second
+	}
+	if cfg.URLInterval <= 0 {
+		cfg.URLInterval = 30 * time.Second
+	}
+	s := &Set{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.URLTimeout},
+		overrides: map[string]*Override{},
+	}
+	s.state.Store(&snapshot{})
+	if cfg.File != "" {
+		if _, err := s.reloadFile(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.URL != "" {
+		if _, err := s.fetch(context.Background()); err != nil {
+			slog.Warn("fetching feature flags failed", "url", cfg.URL, "err", err)
+		}
+	}
+	return s, nil
+}
+
+// Run reloads the file when it changes and polls the URL until ctx is
+// done. A document that fails to load or compile is logged and the flags
+// in effect are kept.
+func (s *Set) Run(ctx context.Context) {
+	fileTick := time.NewTicker(s.cfg.ReloadInterval)
+	defer fileTick.Stop()
+	var urlTick <-chan time.Time
+	if s.cfg.URL != "" {
+		t := time.NewTicker(s.cfg.URLInterval)
+		defer t.Stop()
+		urlTick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-fileTick.C:
+			if s.cfg.File != "" {
+				if changed, err := s.reloadFile(); err != nil {
+					slog.Error("reloading feature flags failed", "err", err)
+				} else if changed {
+					slog.Info("feature flags reloaded", "file", s.cfg.File)
+				}
+			}
+			// Retire expired overrides, so that the gauge and the admin
+			// API agree with evaluations.
+			s.mu.Lock()
+			s.publish()
+			s.mu.Unlock()
+		case <-urlTick:
+			if changed, err := s.fetch(ctx); err != nil {
+				slog.Error("fetching feature flags failed", "url", s.cfg.URL, "err", err)
+			} else if changed {
+				slog.Info("feature flags fetched", "url", s.cfg.URL)
+			}
+		}
+	}
+}
+
+// reloadFile reads the file if it changed since it was last read, and
+// reports whether it did. A version that does not load is remembered too,
+// so that it is reported once rather than on every check.
+func (s *Set) reloadFile() (bool, error) {
+	st, err := os.Stat(s.cfg.File)
+	if err != nil {
+		reloads.With("file", "error").Inc()
+		return false, fmt.Errorf("flags: %w", err)
+	}
+	stamp := fileStamp{st.ModTime(), st.Size()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stamp == s.fileStamp {
+		return false, nil
+	}
+	s.fileStamp = stamp
+	b, err := os.ReadFile(s.cfg.File)
+	if err == nil {
+		var doc Document
+		if doc, err = parseDocument(b); err != nil {
+			err = fmt.Errorf("flags: parsing %s: %w", s.cfg.File, err)
+		} else {
+			prev := s.file
+			s.file = doc
+			if err = s.compile(); err != nil {
+				s.file = prev
+			}
+		}
+	}
+	if err != nil {
+		reloads.With("file", "error").Inc()
+		return false, err
+	}
+	reloads.With("file", "ok").Inc()
+	return true, nil
+}
+
+// fetch gets the URL's document if it changed since it was last fetched,
+// and reports whether it did.
+func (s *Set) fetch(ctx context.Context) (bool, error) {
+	changed, err := s.get(ctx)
+	if err != nil {
+		reloads.With("url", "error").Inc()
+	} else if changed {
+		reloads.With("url", "ok").Inc()
+	}
+	return changed, err
+}
+
+func (s *Set) get(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	s.mu.Unlock()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return false, err
+	}
+	doc, err := parseDocument(b)
+	if err != nil {
+		return false, fmt.Errorf("parsing: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The ETag is kept even if the document does not compile, so that
+	// the same broken document is not fetched and reported again.
+	s.etag = resp.Header.Get("ETag")
+	prev := s.remote
+	s.remote = doc
+	if err := s.compile(); err != nil {
+		s.remote = prev
+		return false, err
+	}
+	return true, nil
+}
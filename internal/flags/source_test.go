@@ -0,0 +1,106 @@
+package flags
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReloadFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "flags.json")
+	write := func(doc string, mod time.Time) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write(`{"flags": {"f": {"type": "bool", "default": false}}}`, start)
+	if _, err := New(Config{File: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("New loaded a missing file")
+	}
+	s, err := New(Config{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.reloadFile(); changed || err != nil {
+		t.Errorf("unchanged file: %v, %v", changed, err)
+	}
+
+	write(`{"flags": {"f": {"type": "bool", "default": true}}}`, start.Add(time.Minute))
+	if changed, err := s.reloadFile(); !changed || err != nil || !s.Bool(Subject{}, "f", false) {
+		t.Errorf("changed file: %v, %v", changed, err)
+	}
+
+	// A broken version is reported once and the flags in effect are kept.
+	write(`{"flags": {"f": {"type": "bool", "default": "on"}}}`, start.Add(2*time.Minute))
+	if _, err := s.reloadFile(); err == nil {
+		t.Error("broken file loaded")
+	}
+	if _, err := s.reloadFile(); err != nil {
+		t.Errorf("broken file reported twice: %v", err)
+	}
+	if !s.Bool(Subject{}, "f", false) {
+		t.Error("broken file replaced the flags")
+	}
+}
+
+func TestURLReplacesFileFlags(t *testing.T) {
+	var mu sync.Mutex
+	doc, etag, fetches := `{"flags": {"f": {"type": "string", "default": "url"}}}`, `"v1"`, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(doc))
+	}))
+	defer srv.Close()
+	file := filepath.Join(t.TempDir(), "flags.json")
+	os.WriteFile(file, []byte(`{"flags": {"f": {"type": "string", "default": "file"}, "g": {"type": "bool", "default": true}}}`), 0o644)
+
+	s, err := New(Config{File: file, URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String(Subject{}, "f", "") != "url" || !s.Bool(Subject{}, "g", false) {
+		t.Error("URL flags do not replace the file's")
+	}
+	if changed, err := s.fetch(context.Background()); changed || err != nil {
+		t.Errorf("not modified: %v, %v", changed, err)
+	}
+
+	mu.Lock()
+	doc, etag = `{"flags": {"f": {"type": "string", "default": 1}}}`, `"v2"`
+	mu.Unlock()
+	if _, err := s.fetch(context.Background()); err == nil {
+		t.Error("broken document fetched")
+	}
+	if s.String(Subject{}, "f", "") != "url" {
+		t.Error("broken document replaced the flags")
+	}
+	// Its ETag is kept, so it is not fetched and reported again.
+	if changed, err := s.fetch(context.Background()); changed || err != nil {
+		t.Errorf("broken document refetched: %v, %v", changed, err)
+	}
+
+	srv.Close()
+	if _, err := s.fetch(context.Background()); err == nil || s.String(Subject{}, "f", "") != "url" {
+		t.Errorf("unreachable URL: %v", err)
+	}
+	if _, err := New(Config{File: file, URL: srv.URL}); err != nil {
+		t.Errorf("an unreachable URL fails New: %v", err)
+	}
+}
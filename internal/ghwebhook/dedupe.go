@@ -0,0 +1,78 @@
+package ghwebhook
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// seenSet remembers delivery IDs that were handled for ttl, holding at
+// most max of them, and those being handled. It is per instance: a
+// redelivery routed to another instance behind the ALB is not caught, which
+// is acceptable because handlers must be idempotent anyway.
+type seenSet struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	max      int
+	order    *list.List // of seenEntry, oldest first
+	index    map[string]*list.Element
+	inFlight map[string]bool
+}
+
+type seenEntry struct {
+	id string
+	at time.Time
+}
+
+func newSeenSet(max int, ttl time.Duration) *seenSet {
+	return &seenSet{ttl: ttl, max: max, order: list.New(), index: map[string]*list.Element{}, inFlight: map[string]bool{}}
+}
+
+// claim marks id as being handled and reports whether it was free to:
+// neither handled already nor in flight. busy reports that it was in
+// flight.
+func (s *seenSet) claim(id string, now time.Time) (claimed, busy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	if s.inFlight[id] {
+		return false, true
+	}
+	if _, ok := s.index[id]; ok {
+		return false, false
+	}
+	s.inFlight[id] = true
+	return true, false
+}
+
+// done records a claimed id as handled.
+func (s *seenSet) done(id string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, id)
+	s.expire(now)
+	if _, ok := s.index[id]; !ok {
+		s.index[id] = s.order.PushBack(seenEntry{id: id, at: now})
+	}
+}
+
+// release drops the claim on an id whose handling failed, so that a
+// redelivery is accepted.
+func (s *seenSet) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, id)
+}
+
+// expire drops IDs older than ttl, and the oldest ones beyond max - 1 to
+// make room for another. s.mu is held.
+func (s *seenSet) expire(now time.Time) {
+	for e := s.order.Front(); e != nil; e = s.order.Front() {
+		ent := e.Value.(seenEntry)
+		if now.Sub(ent.at) < s.ttl && s.order.Len() < s.max {
+			break
+		}
+		s.order.Remove(e)
+		delete(s.index, ent.id)
+	}
+}
@@ -0,0 +1,145 @@
+package ghwebhook
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Repository, User and Commit are the subsets of GitHub's payload objects
+// that handlers have needed so far.
+type Repository struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+	HTMLURL       string `json:"html_url"`
+	Private       bool   `json:"private"`
+}
+
+type User struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+}
+
+type Commit struct {
+	ID        string    `json:"id"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+	URL       string    `json:"url"`
+	Author    struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	} `json:"author"`
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+type PingEvent struct {
+	Zen    string `json:"zen"`
+	HookID int64  `json:"hook_id"`
+}
+
+type PushEvent struct {
+	Ref        string     `json:"ref"`
+	Before     string     `json:"before"`
+	After      string     `json:"after"`
+	Created    bool       `json:"created"`
+	Deleted    bool       `json:"deleted"`
+	Forced     bool       `json:"forced"`
+	Commits    []Commit   `json:"commits"`
+	HeadCommit *Commit    `json:"head_commit"`
+	Repository Repository `json:"repository"`
+	Sender     User       `json:"sender"`
+}
+
+type PullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		ID       int64      `json:"id"`
+		Number   int        `json:"number"`
+		State    string     `json:"state"`
+		Title    string     `json:"title"`
+		Draft    bool       `json:"draft"`
+		Merged   bool       `json:"merged"`
+		HTMLURL  string     `json:"html_url"`
+		User     User       `json:"user"`
+		MergedAt *time.Time `json:"merged_at"`
+		Head     struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository Repository `json:"repository"`
+	Sender     User       `json:"sender"`
+}
+
+type ReleaseEvent struct {
+	Action  string `json:"action"`
+	Release struct {
+		ID          int64      `json:"id"`
+		TagName     string     `json:"tag_name"`
+		Name        string     `json:"name"`
+		Draft       bool       `json:"draft"`
+		Prerelease  bool       `json:"prerelease"`
+		HTMLURL     string     `json:"html_url"`
+		PublishedAt *time.Time `json:"published_at"`
+		Author      User       `json:"author"`
+	} `json:"release"`
+	Repository Repository `json:"repository"`
+	Sender     User       `json:"sender"`
+}
+
+type WorkflowRunEvent struct {
+	Action      string `json:"action"`
+	WorkflowRun struct {
+		ID         int64     `json:"id"`
+		Name       string    `json:"name"`
+		RunNumber  int       `json:"run_number"`
+		RunAttempt int       `json:"run_attempt"`
+		Event      string    `json:"event"`
+		Status     string    `json:"status"`
+		Conclusion string    `json:"conclusion"`
+		HeadBranch string    `json:"head_branch"`
+		HeadSHA    string    `json:"head_sha"`
+		HTMLURL    string    `json:"html_url"`
+		CreatedAt  time.Time `json:"created_at"`
+		UpdatedAt  time.Time `json:"updated_at"`
+	} `json:"workflow_run"`
+	Repository Repository `json:"repository"`
+	Sender     User       `json:"sender"`
+}
+
+// ParseEvent decodes body according to the X-GitHub-Event name. Events
+// without a typed struct decode to map[string]any.
+func ParseEvent(name string, body []byte) (any, error) {
+	var v any
+	switch name {
+	case "ping":
+		v = new(PingEvent)
+	case "push":
+		v = new(PushEvent)
+	case "pull_request":
+		v = new(PullRequestEvent)
+	case "release":
+		v = new(ReleaseEvent)
+	case "workflow_run":
+		v = new(WorkflowRunEvent)
+	default:
+		m := map[string]any{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,226 @@
+// Package ghwebhook receives GitHub repository webhooks: it verifies their
+// signatures, drops duplicate deliveries, decodes the events the service
+// cares about and dispatches them to registered handlers.
+package ghwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"goaws/internal/env"
+	"goaws/internal/queue"
+)
+
+// GitHub caps webhook payloads at 25 MB.
+const maxPayload = 25 << 20
+
+// Config configures a Receiver. It is normally built by ConfigFromEnv.
+type Config struct {
+	Secret string
+	Path   string
+	// QueueDir, when set, makes the receiver acknowledge deliveries as soon
+	// as they are durably queued and run handlers in the background.
+	QueueDir string
+	Workers  int
+}
+
+// ConfigFromEnv reads the GITHUB_WEBHOOK_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Secret:   env.String("GITHUB_WEBHOOK_SECRET", ""),
+		Path:     env.String("GITHUB_WEBHOOK_PATH", "/webhooks/github"),
+		QueueDir: env.String("GITHUB_WEBHOOK_QUEUE_DIR", ""),
+		Workers:  env.Int("GITHUB_WEBHOOK_WORKERS", 2),
+	}
+}
+
+// Delivery identifies one webhook request.
+type Delivery struct {
+	ID      string          `json:"id"`
+	Event   string          `json:"event"`
+	HookID  string          `json:"hook_id,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Handler is called with the decoded event, e.g. *PushEvent.
+type Handler func(ctx context.Context, d Delivery, event any) error
+
+// Receiver is an http.Handler for the webhook endpoint.
+type Receiver struct {
+	secret []byte
+	seen   *seenSet
+	queue  *queue.Queue
+	log    *slog.Logger
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// New returns a Receiver. When cfg.QueueDir is set the queue is opened
+// immediately and Run must be called to process it.
+func New(cfg Config) (*Receiver, error) {
+	if cfg.Secret == "" {
+		return nil, errors.New("ghwebhook: a secret is required")
+	}
+	r := &Receiver{
+		secret:   []byte(cfg.Secret),
+		seen:     newSeenSet(10000, 24*time.Hour),
+		log:      slog.Default().With("component", "ghwebhook"),
+		handlers: map[string][]Handler{},
+	}
+	if cfg.QueueDir != "" {
+		q, err := queue.Open(cfg.QueueDir, queue.Options{Workers: cfg.Workers})
+		if err != nil {
+			return nil, fmt.Errorf("ghwebhook: opening queue: %w", err)
+		}
+		r.queue = q
+	}
+	return r, nil
+}
+
+// On registers h for event, or for every event when event is "*".
+func (r *Receiver) On(event string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[event] = append(r.handlers[event], h)
+}
+
+func (r *Receiver) OnPush(h func(context.Context, Delivery, *PushEvent) error) {
+	r.On("push", typed(h))
+}
+
+func (r *Receiver) OnPullRequest(h func(context.Context, Delivery, *PullRequestEvent) error) {
+	r.On("pull_request", typed(h))
+}
+
+func (r *Receiver) OnRelease(h func(context.Context, Delivery, *ReleaseEvent) error) {
+	r.On("release", typed(h))
+}
+
+func (r *Receiver) OnWorkflowRun(h func(context.Context, Delivery, *WorkflowRunEvent) error) {
+	r.On("workflow_run", typed(h))
+}
+
+func typed[E any](h func(context.Context, Delivery, *E) error) Handler {
+	return func(ctx context.Context, d Delivery, event any) error {
+		e, ok := event.(*E)
+		if !ok {
+			return fmt.Errorf("ghwebhook: unexpected %T for %s", event, d.Event)
+		}
+		return h(ctx, d, e)
+	}
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxPayload))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := Verify(r.secret, body, req.Header.Get("X-Hub-Signature-256")); err != nil {
+		r.log.Warn("rejected webhook", "remote", req.RemoteAddr, "err", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	d := Delivery{
+		ID:      req.Header.Get("X-GitHub-Delivery"),
+		Event:   req.Header.Get("X-GitHub-Event"),
+		HookID:  req.Header.Get("X-GitHub-Hook-ID"),
+		Payload: body,
+	}
+	if d.ID == "" || d.Event == "" {
+		http.Error(w, "missing X-GitHub-Delivery or X-GitHub-Event", http.StatusBadRequest)
+		return
+	}
+	// A delivery counts as seen once it has been queued or handled. A
+	// redelivery of one still in flight is refused, not acknowledged, in
+	// case the first attempt fails.
+	claimed, busy := r.seen.claim(d.ID, time.Now())
+	if busy {
+		r.log.Info("delivery already in flight", "delivery", d.ID, "event", d.Event)
+		http.Error(w, "delivery in progress", http.StatusConflict)
+		return
+	}
+	if !claimed {
+		r.log.Info("duplicate delivery ignored", "delivery", d.ID, "event", d.Event)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if d.Event == "ping" {
+		r.seen.done(d.ID, time.Now())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.queue != nil {
+		if _, err := r.queue.Enqueue(d.Event, d); err != nil {
+			r.seen.release(d.ID)
+			r.log.Error("queueing delivery", "delivery", d.ID, "err", err)
+			http.Error(w, "could not queue delivery", http.StatusServiceUnavailable)
+			return
+		}
+		r.seen.done(d.ID, time.Now())
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if err := r.Dispatch(req.Context(), d); err != nil {
+		// Let GitHub's redelivery see this one again.
+		r.seen.release(d.ID)
+		r.log.Error("handling delivery", "delivery", d.ID, "event", d.Event, "err", err)
+		http.Error(w, "handler failed", http.StatusInternalServerError)
+		return
+	}
+	r.seen.done(d.ID, time.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Dispatch decodes d and calls every handler registered for its event.
+func (r *Receiver) Dispatch(ctx context.Context, d Delivery) error {
+	r.mu.RLock()
+	hs := append(append([]Handler(nil), r.handlers[d.Event]...), r.handlers["*"]...)
+	r.mu.RUnlock()
+	if len(hs) == 0 {
+		return nil
+	}
+	event, err := ParseEvent(d.Event, d.Payload)
+	if err != nil {
+		return fmt.Errorf("ghwebhook: decoding %s: %w", d.Event, err)
+	}
+	var errs []error
+	for _, h := range hs {
+		if err := h(ctx, d, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run processes queued deliveries until ctx is done. It returns immediately
+// when the receiver has no queue.
+func (r *Receiver) Run(ctx context.Context) {
+	if r.queue == nil {
+		return
+	}
+	r.queue.Run(ctx, func(ctx context.Context, j *queue.Job) error {
+		var d Delivery
+		if err := json.Unmarshal(j.Payload, &d); err != nil {
+			return fmt.Errorf("%w: %v", queue.Permanent, err)
+		}
+		return r.Dispatch(ctx, d)
+	})
+}
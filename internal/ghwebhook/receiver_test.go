@@ -0,0 +1,173 @@
+package ghwebhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	// The example from GitHub's "Validating webhook deliveries".
+	secret, body := []byte("It's a Secret to Everybody"), []byte("Hello, World!")
+	const want = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if got := Sign(secret, body); got != want {
+		t.Fatalf("Sign = %s", got)
+	}
+	for _, tc := range []struct {
+		header string
+		err    error
+	}{
+		{want, nil},
+		{"", ErrMissingSignature},
+		{strings.TrimPrefix(want, "sha256="), ErrBadSignature},
+		{"sha1=" + want[7:], ErrBadSignature},
+		{"sha256=zz", ErrBadSignature},
+		{want[:len(want)-1] + "0", ErrBadSignature},
+	} {
+		if err := Verify(secret, body, tc.header); !errors.Is(err, tc.err) {
+			t.Errorf("Verify(%q) = %v, want %v", tc.header, err, tc.err)
+		}
+	}
+}
+
+func delivery(secret, id, event, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(body))
+	r.Header.Set("X-Hub-Signature-256", Sign([]byte(secret), []byte(body)))
+	r.Header.Set("X-GitHub-Delivery", id)
+	r.Header.Set("X-GitHub-Event", event)
+	return r
+}
+
+func TestReceiver(t *testing.T) {
+	rcv, err := New(Config{Secret: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pushes []string
+	fail := false
+	rcv.OnPush(func(_ context.Context, d Delivery, e *PushEvent) error {
+		pushes = append(pushes, d.ID+" "+e.Ref)
+		if fail {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+	push := `{"ref":"refs/heads/main","repository":{"full_name":"acme/app"}}`
+
+	for _, tc := range []struct {
+		name string
+		req  *http.Request
+		fail bool
+		want int
+	}{
+		{"bad signature", delivery("other", "1", "push", push), false, http.StatusUnauthorized},
+		{"missing delivery", delivery("s", "", "push", push), false, http.StatusBadRequest},
+		{"ping", delivery("s", "p", "ping", `{"zen":"z"}`), false, http.StatusOK},
+		{"failed handler", delivery("s", "2", "push", push), true, http.StatusInternalServerError},
+		// A failed delivery is forgotten, so GitHub's redelivery runs.
+		{"redelivery", delivery("s", "2", "push", push), false, http.StatusNoContent},
+		{"duplicate", delivery("s", "2", "push", push), false, http.StatusOK},
+		{"unhandled event", delivery("s", "3", "issues", `{}`), false, http.StatusNoContent},
+		{"get", httptest.NewRequest(http.MethodGet, "/webhooks/github", nil), false, http.StatusMethodNotAllowed},
+	} {
+		fail = tc.fail
+		w := httptest.NewRecorder()
+		rcv.ServeHTTP(w, tc.req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+	if got := strings.Join(pushes, ","); got != "2 refs/heads/main,2 refs/heads/main" {
+		t.Errorf("pushes = %s", got)
+	}
+}
+
+func TestSeenSet(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := newSeenSet(2, time.Minute)
+	claim := func(id string, at time.Time) string {
+		switch claimed, busy := s.claim(id, at); {
+		case claimed:
+			return "claimed"
+		case busy:
+			return "busy"
+		}
+		return "seen"
+	}
+	if got := claim("a", now); got != "claimed" {
+		t.Fatalf("a %s", got)
+	}
+	if got := claim("a", now); got != "busy" {
+		t.Errorf("a in flight: %s", got)
+	}
+	s.done("a", now)
+	if got := claim("a", now); got != "seen" {
+		t.Errorf("handled a: %s", got)
+	}
+	// Expired IDs are accepted again.
+	if got := claim("a", now.Add(2*time.Minute)); got != "claimed" {
+		t.Errorf("expired a: %s", got)
+	}
+	s.done("a", now.Add(2*time.Minute))
+	// At capacity the oldest is evicted.
+	for _, id := range []string{"b", "c"} {
+		claim(id, now.Add(2*time.Minute))
+		s.done(id, now.Add(2*time.Minute))
+	}
+	if got := claim("a", now.Add(2*time.Minute)); got != "claimed" {
+		t.Errorf("evicted a: %s", got)
+	}
+	// A released claim is free again.
+	claim("d", now.Add(2*time.Minute))
+	s.release("d")
+	if got := claim("d", now.Add(2*time.Minute)); got != "claimed" {
+		t.Errorf("released d: %s", got)
+	}
+}
+
+func TestRedeliveryWhileInFlight(t *testing.T) {
+	rcv, err := New(Config{Secret: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, finish := make(chan struct{}), make(chan error)
+	calls := 0
+	rcv.OnPush(func(context.Context, Delivery, *PushEvent) error {
+		calls++
+		if calls == 1 {
+			close(started)
+			return <-finish
+		}
+		return nil
+	})
+	push := `{"ref":"refs/heads/main"}`
+
+	first := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		rcv.ServeHTTP(w, delivery("s", "7", "push", push))
+		first <- w.Code
+	}()
+	<-started
+	// GitHub redelivers while the first attempt is still running.
+	w := httptest.NewRecorder()
+	rcv.ServeHTTP(w, delivery("s", "7", "push", push))
+	if w.Code != http.StatusConflict {
+		t.Errorf("redelivery in flight: %d", w.Code)
+	}
+
+	// The first attempt fails, so the next redelivery is handled.
+	finish <- errors.New("handler failed")
+	if code := <-first; code != http.StatusInternalServerError {
+		t.Errorf("first attempt: %d", code)
+	}
+	w = httptest.NewRecorder()
+	rcv.ServeHTTP(w, delivery("s", "7", "push", push))
+	if w.Code != http.StatusNoContent || calls != 2 {
+		t.Errorf("redelivery after the failure: %d, %d calls", w.Code, calls)
+	}
+}
@@ -0,0 +1,42 @@
+package ghwebhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrMissingSignature = errors.New("ghwebhook: missing X-Hub-Signature-256")
+	ErrBadSignature     = errors.New("ghwebhook: signature mismatch")
+)
+
+// Sign returns the X-Hub-Signature-256 value GitHub sends for body.
+func Sign(secret, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// Verify checks header against the HMAC-SHA256 of body in constant time.
+func Verify(secret, body []byte, header string) error {
+	if header == "" {
+		return ErrMissingSignature
+	}
+	hexSig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return ErrBadSignature
+	}
+	got, err := hex.DecodeString(hexSig)
+	if err != nil {
+		return ErrBadSignature
+	}
+	m := hmac.New(sha256.New, secret)
+	m.Write(body)
+	if !hmac.Equal(got, m.Sum(nil)) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// maxJSONBody bounds request bodies read by ReadJSON.
+const maxJSONBody = 1 << 20
+
+// ReadJSON decodes the request body into v. If it cannot, it answers 400
+// and reports false, so handlers can simply return.
+func ReadJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBody)).Decode(v); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// WriteJSON answers with status and v encoded as JSON.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError answers with status and {"error": msg}.
+func WriteError(w http.ResponseWriter, status int, msg string) {
+	WriteJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,40 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadJSON(t *testing.T) {
+	var v struct{ Name string }
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"x"}`))
+	if !ReadJSON(w, r, &v) || v.Name != "x" {
+		t.Fatalf("ReadJSON = %+v", v)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{`))
+	if ReadJSON(w, r, &v) {
+		t.Fatal("ReadJSON accepted a truncated body")
+	}
+	if w.Code != http.StatusBadRequest || !strings.HasPrefix(w.Body.String(), `{"error":"invalid JSON: `) {
+		t.Errorf("got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteError(w, http.StatusNotFound, "not found")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if got := w.Body.String(); got != "{\"error\":\"not found\"}\n" {
+		t.Errorf("body = %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+// Package httpx holds small net/http helpers shared by middleware and
+// handlers.
+package httpx
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Recorder wraps a ResponseWriter to remember the status code and the
+// number of body bytes written.
+type Recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// NewRecorder wraps w. If w is already a Recorder it is returned as is, so
+// stacked middleware share one.
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	if r, ok := w.(*Recorder); ok {
+		return r
+	}
+	return &Recorder{ResponseWriter: w}
+}
+
+// Reset points r at w with nothing recorded. Middleware that allocates a
+// struct per request anyway can embed a Recorder in it and Reset it rather
+// than calling NewRecorder.
+func (r *Recorder) Reset(w http.ResponseWriter) {
+	*r = Recorder{ResponseWriter: w}
+}
+
+func (r *Recorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status sent, or 200 if the handler wrote nothing.
+func (r *Recorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Bytes returns the number of body bytes written.
+func (r *Recorder) Bytes() int64 { return r.bytes }
+
+func (r *Recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *Recorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// ClientIP returns the first address in X-Forwarded-For, as set by the ALB,
+// or the peer address. The client may have sent that first address itself,
+// so ClientIP is for logs; decisions use TrustedClientIP.
+func ClientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		ip, _, _ := strings.Cut(xff, ",")
+		return strings.TrimSpace(ip)
+	}
+	return peerIP(r)
+}
+
+// TrustedClientIP returns the last address in X-Forwarded-For, the one the
+// ALB appended for the connection it accepted, or the peer address. Unlike
+// the addresses before it, the client cannot choose it.
+func TrustedClientIP(r *http.Request) string {
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		last := xff[len(xff)-1]
+		if i := strings.LastIndexByte(last, ','); i >= 0 {
+			last = last[i+1:]
+		}
+		if ip := strings.TrimSpace(last); ip != "" {
+			return ip
+		}
+	}
+	return peerIP(r)
+}
+
+func peerIP(r *http.Request) string {
+	host := r.RemoteAddr
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
@@ -0,0 +1,35 @@
+package httpx
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	for _, tc := range []struct {
+		remote  string
+		xff     []string
+		client  string
+		trusted string
+	}{
+		{"10.0.1.17:43512", nil, "10.0.1.17", "10.0.1.17"},
+		{"[2001:db8::1]:443", nil, "2001:db8::1", "2001:db8::1"},
+		{"10.0.1.17:43512", []string{"203.0.113.7"}, "203.0.113.7", "203.0.113.7"},
+		// The client sent the first entry; the ALB appended the last.
+		{"10.0.1.17:43512", []string{"10.0.0.1, 203.0.113.7"}, "10.0.0.1", "203.0.113.7"},
+		{"10.0.1.17:43512", []string{"10.0.0.1", "198.51.100.2,203.0.113.7"}, "10.0.0.1", "203.0.113.7"},
+		{"10.0.1.17:43512", []string{"10.0.0.1, "}, "10.0.0.1", "10.0.1.17"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := ClientIP(r); got != tc.client {
+			t.Errorf("ClientIP(%s, %q) = %s, want %s", tc.remote, tc.xff, got, tc.client)
+		}
+		if got := TrustedClientIP(r); got != tc.trusted {
+			t.Errorf("TrustedClientIP(%s, %q) = %s, want %s", tc.remote, tc.xff, got, tc.trusted)
+		}
+	}
+}
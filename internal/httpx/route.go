@@ -0,0 +1,54 @@
+package httpx
+
+import "net/http"
+
+// Routing remembers the request a ServeMux routed. The mux sets Pattern on
+// the request it is handed, and middleware that passes a copy on, as
+// r.WithContext does, never sees it on its own request. Routes records the
+// routed request in the Routing found in the request context, and Route
+// reads the pattern from there.
+type Routing struct {
+	req *http.Request
+}
+
+// RoutingKey is the context key of a *Routing. Middleware that already
+// gives the request its own context type can answer Value(RoutingKey{})
+// with a Routing it embeds, rather than adding one with context.WithValue.
+type RoutingKey struct{}
+
+// Pattern returns the pattern the routed request matched, or "" if it has
+// not been routed yet.
+func (rt *Routing) Pattern() string {
+	if rt.req == nil {
+		return ""
+	}
+	return rt.req.Pattern
+}
+
+// Routes wraps a mux so that the request it routes is recorded in the
+// request context's Routing, if there is one. The pattern is then known to
+// handlers logging through the context as well as to middleware once next
+// returns.
+func Routes(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rt, ok := r.Context().Value(RoutingKey{}).(*Routing); ok {
+			rt.req = r
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Route returns the ServeMux pattern that matched r, or "unmatched". It is
+// only set once the mux has routed the request, so middleware should call
+// it after the wrapped handler returns.
+func Route(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	if rt, ok := r.Context().Value(RoutingKey{}).(*Routing); ok {
+		if p := rt.Pattern(); p != "" {
+			return p
+		}
+	}
+	return "unmatched"
+}
@@ -0,0 +1,51 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type routingContext struct {
+	context.Context
+	routing Routing
+}
+
+func (c *routingContext) Value(key any) any {
+	if _, ok := key.(RoutingKey); ok {
+		return &c.routing
+	}
+	return c.Context.Value(key)
+}
+
+func TestRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items/{id}", func(http.ResponseWriter, *http.Request) {})
+
+	r := httptest.NewRequest("GET", "/items/1", nil)
+	if got := Route(r); got != "unmatched" {
+		t.Errorf("before routing: %q", got)
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), r)
+	if got := Route(r); got != "/items/{id}" {
+		t.Errorf("routed request: %q", got)
+	}
+
+	// A copy handed to the mux is found through the Routing.
+	ctx := &routingContext{Context: context.Background()}
+	r = httptest.NewRequest("GET", "/items/1", nil).WithContext(ctx)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Routes(mux).ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), struct{}{}, 1)))
+	})
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got := Route(r); got != "/items/{id}" {
+		t.Errorf("copied request: %q", got)
+	}
+
+	r = httptest.NewRequest("GET", "/nowhere", nil).WithContext(&routingContext{Context: context.Background()})
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got := Route(r); got != "unmatched" {
+		t.Errorf("unrouted: %q", got)
+	}
+}
@@ -0,0 +1,168 @@
+package inspect
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"goaws/internal/httpx"
+)
+
+//go:embed page.html
+var page []byte
+
+// Filter selects requests. Zero fields match everything.
+type Filter struct {
+	Method string
+	// Status is a code such as 404 or a class such as 5xx.
+	Status string
+	// Path matches a substring of the URI.
+	Path  string
+	Route string
+	// MinLatency is in milliseconds.
+	MinLatency float64
+	// Query matches a substring of the request ID, trace ID, URI or
+	// client IP.
+	Query  string
+	Pinned bool
+}
+
+// FilterFromQuery reads a Filter from the method, status, path, route,
+// min_ms, q and pinned parameters.
+func FilterFromQuery(q url.Values) Filter {
+	f := Filter{
+		Method: strings.ToUpper(q.Get("method")),
+		Status: strings.ToLower(q.Get("status")),
+		Path:   q.Get("path"),
+		Route:  q.Get("route"),
+		Query:  q.Get("q"),
+	}
+	f.MinLatency, _ = strconv.ParseFloat(q.Get("min_ms"), 64)
+	f.Pinned, _ = strconv.ParseBool(q.Get("pinned"))
+	return f
+}
+
+// Match reports whether s passes f.
+func (f Filter) Match(s Summary) bool {
+	switch {
+	case f.Method != "" && s.Method != f.Method,
+		f.Route != "" && s.Route != f.Route,
+		f.Path != "" && !strings.Contains(s.URI, f.Path),
+		s.LatencyMS < f.MinLatency,
+		f.Pinned && !s.Pinned:
+		return false
+	}
+	if f.Status != "" {
+		code := strconv.Itoa(s.Status)
+		if class, ok := strings.CutSuffix(f.Status, "xx"); ok {
+			if !strings.HasPrefix(code, class) {
+				return false
+			}
+		} else if code != f.Status {
+			return false
+		}
+	}
+	if f.Query != "" {
+		found := false
+		for _, v := range []string{s.RequestID, s.TraceID, s.URI, s.ClientIP} {
+			if strings.Contains(v, f.Query) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP serves the inspector page and its API:
+//
+//	GET    /debug/requests                 the page
+//	GET    /debug/requests/api             recent requests, newest first
+//	GET    /debug/requests/api/stream      new requests as server-sent events
+//	GET    /debug/requests/api/{id}        headers and log lines
+//	PUT    /debug/requests/api/{id}/pin
+//	DELETE /debug/requests/api/{id}/pin
+//
+// The list and the stream take the parameters of FilterFromQuery; the list
+// also takes limit.
+func (i *Inspector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i.mux.ServeHTTP(w, r)
+}
+
+func (i *Inspector) routes() {
+	i.mux = http.NewServeMux()
+	i.mux.HandleFunc("GET /debug/requests", i.page)
+	i.mux.HandleFunc("GET /debug/requests/api", i.list)
+	i.mux.HandleFunc("GET /debug/requests/api/stream", i.stream)
+	i.mux.HandleFunc("GET /debug/requests/api/{id}", i.get)
+	i.mux.HandleFunc("PUT /debug/requests/api/{id}/pin", i.pin)
+	i.mux.HandleFunc("DELETE /debug/requests/api/{id}/pin", i.pin)
+}
+
+func (i *Inspector) page(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(page)
+}
+
+func (i *Inspector) list(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 200
+	}
+	httpx.WriteJSON(w, http.StatusOK, i.List(FilterFromQuery(r.URL.Query()), limit))
+}
+
+func (i *Inspector) get(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	d, ok := i.Get(id)
+	if !ok {
+		httpx.WriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, d)
+}
+
+func (i *Inspector) pin(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if !i.Pin(id, r.Method == http.MethodPut) {
+		httpx.WriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (i *Inspector) stream(w http.ResponseWriter, r *http.Request) {
+	ch, cancel := i.subscribe(FilterFromQuery(r.URL.Query()))
+	defer cancel()
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	// Comments keep idle connections from being closed by proxies.
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case s := <-ch:
+			b, _ := json.Marshal(s)
+			fmt.Fprintf(w, "data: %s\n\n", b)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
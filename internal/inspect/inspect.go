@@ -0,0 +1,375 @@
+// Package inspect keeps the most recent requests in memory, with their
+// headers and the records logged while serving them, for a live view on the
+// admin listener. Headers, URIs and log lines are redacted as they are
+// captured, so nothing the redactor would mask is held.
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goaws/internal/env"
+	"goaws/internal/httpx"
+	"goaws/internal/logging"
+	"goaws/internal/metrics"
+	"goaws/internal/redact"
+	"goaws/internal/trace"
+)
+
+var streamDropped = metrics.NewCounter("inspect_stream_dropped_total", "Requests not sent to a live view that was falling behind.")
+
+// maxPinned bounds the requests kept past their eviction from the ring.
+const maxPinned = 100
+
+// Config configures the inspector. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Enabled records requests; it is off by default.
+	Enabled bool
+	// Size is how many requests the ring buffer holds.
+	Size int
+	// MaxLogs is how many log records are kept per request.
+	MaxLogs int
+	// Redactor is set by the caller.
+	Redactor *redact.Redactor
+}
+
+// ConfigFromEnv reads INSPECT, INSPECT_BUFFER and INSPECT_MAX_LOGS.
+func ConfigFromEnv() Config {
+	return Config{
+		Enabled: env.Bool("INSPECT", false),
+		Size:    env.Int("INSPECT_BUFFER", 500),
+		MaxLogs: env.Int("INSPECT_MAX_LOGS", 100),
+	}
+}
+
+// Summary is what the list and the live stream show of a request.
+type Summary struct {
+	ID        uint64    `json:"id"`
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Route     string    `json:"route"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	BytesOut  int64     `json:"bytes_out"`
+	RequestID string    `json:"request_id"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	Logs      int       `json:"logs"`
+	Pinned    bool      `json:"pinned"`
+}
+
+// Detail is everything recorded about a request.
+type Detail struct {
+	Summary
+	Proto           string            `json:"proto"`
+	Host            string            `json:"host"`
+	RequestHeaders  http.Header       `json:"request_headers"`
+	ResponseHeaders http.Header       `json:"response_headers"`
+	LogLines        []json.RawMessage `json:"log_lines"`
+	LogsDropped     int               `json:"logs_dropped,omitempty"`
+}
+
+type entry struct {
+	Detail
+
+	mu      sync.Mutex
+	logs    []json.RawMessage
+	dropped int
+}
+
+func (e *entry) addLog(line []byte, max int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.logs) >= max {
+		e.dropped++
+		return
+	}
+	e.logs = append(e.logs, line)
+}
+
+// Inspector records requests. A nil *Inspector does nothing.
+type Inspector struct {
+	cfg Config
+	seq atomic.Uint64
+	mux *http.ServeMux
+
+	mu     sync.Mutex
+	ring   []*entry
+	next   int
+	active map[uint64]*entry
+	pinned map[uint64]*entry
+	subs   map[*subscriber]struct{}
+
+	// format renders log records as JSON into fmtBuf, under fmtMu.
+	fmtMu  sync.Mutex
+	fmtBuf bytes.Buffer
+	format slog.Handler
+}
+
+// entryKey is the context key of the ID of the entry recording a request.
+type entryKey struct{}
+
+type subscriber struct {
+	f  Filter
+	ch chan Summary
+}
+
+// New returns an Inspector for cfg.
+func New(cfg Config) *Inspector {
+	if cfg.Size <= 0 {
+		cfg.Size = 500
+	}
+	i := &Inspector{
+		cfg:    cfg,
+		ring:   make([]*entry, cfg.Size),
+		active: map[uint64]*entry{},
+		pinned: map[uint64]*entry{},
+		subs:   map[*subscriber]struct{}{},
+	}
+	i.format = slog.NewJSONHandler(&i.fmtBuf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	i.routes()
+	return i
+}
+
+// Middleware records each request once it has been served, with the
+// records logged through the request's context while serving it. It goes
+// inside logging.Middleware, so that requests have their IDs and the route
+// the mux sets is seen through httpx.Route.
+func (i *Inspector) Middleware(next http.Handler) http.Handler {
+	if i == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		e := &entry{}
+		e.ID = i.seq.Add(1)
+		e.Time = start
+		e.RequestID = logging.RequestID(r.Context())
+		if sc := trace.SpanFromContext(r.Context()).SpanContext(); sc.TraceID.IsValid() {
+			e.TraceID = sc.TraceID.String()
+		}
+		i.mu.Lock()
+		i.active[e.ID] = e
+		i.mu.Unlock()
+
+		// Client-supplied request IDs need not be unique, so records are
+		// tied to the request by the entry's own ID.
+		r = r.WithContext(context.WithValue(r.Context(), entryKey{}, e.ID))
+		rec := httpx.NewRecorder(w)
+		// Deferred, so that a request whose handler panicked is recorded
+		// too rather than left in active.
+		panicked := true
+		defer func() { i.finish(e, start, r, rec, panicked) }()
+		next.ServeHTTP(rec, r)
+		panicked = false
+	})
+}
+
+// finish records e once its request has been served, and streams it to
+// the subscribers whose filters it matches.
+func (i *Inspector) finish(e *entry, start time.Time, r *http.Request, rec *httpx.Recorder, panicked bool) {
+	e.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	e.Method = r.Method
+	e.URI = i.cfg.Redactor.RequestURI(redact.SourceRecording, r.RequestURI)
+	e.Route = httpx.Route(r)
+	e.Status = rec.Status()
+	if panicked {
+		// net/http drops the connection of a handler that panics.
+		e.Status = http.StatusInternalServerError
+	}
+	e.BytesOut = rec.Bytes()
+	e.ClientIP = httpx.ClientIP(r)
+	e.Proto = r.Proto
+	e.Host = r.Host
+	e.RequestHeaders = i.cfg.Redactor.Headers(redact.SourceRecording, r.Header.Clone())
+	e.ResponseHeaders = i.cfg.Redactor.Headers(redact.SourceRecording, rec.Header().Clone())
+
+	i.mu.Lock()
+	delete(i.active, e.ID)
+	i.ring[i.next] = e
+	i.next = (i.next + 1) % len(i.ring)
+	var subs []*subscriber
+	for s := range i.subs {
+		subs = append(subs, s)
+	}
+	_, pinned := i.pinned[e.ID]
+	i.mu.Unlock()
+
+	if len(subs) == 0 {
+		return
+	}
+	s := e.summary(pinned)
+	for _, sub := range subs {
+		if !sub.f.Match(s) {
+			continue
+		}
+		select {
+		case sub.ch <- s:
+		default:
+			streamDropped.With().Inc()
+		}
+	}
+}
+
+func (e *entry) summary(pinned bool) Summary {
+	s := e.Summary
+	e.mu.Lock()
+	s.Logs = len(e.logs)
+	e.mu.Unlock()
+	s.Pinned = pinned
+	return s
+}
+
+func (e *entry) detail(pinned bool) Detail {
+	d := e.Detail
+	d.Summary = e.summary(pinned)
+	e.mu.Lock()
+	d.LogLines = append([]json.RawMessage{}, e.logs...)
+	d.LogsDropped = e.dropped
+	e.mu.Unlock()
+	return d
+}
+
+// List returns the recorded requests that match f, newest first, at most
+// limit of them. Pinned requests evicted from the ring are included.
+func (i *Inspector) List(f Filter, limit int) []Summary {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	out := []Summary{}
+	seen := map[uint64]bool{}
+	for n := 1; n <= len(i.ring) && len(out) < limit; n++ {
+		e := i.ring[(i.next-n+len(i.ring))%len(i.ring)]
+		if e == nil {
+			break
+		}
+		seen[e.ID] = true
+		_, pinned := i.pinned[e.ID]
+		if s := e.summary(pinned); f.Match(s) {
+			out = append(out, s)
+		}
+	}
+	for id, e := range i.pinned {
+		if !seen[id] && len(out) < limit {
+			if s := e.summary(true); f.Match(s) {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// lookup finds a recorded request by ID.
+func (i *Inspector) lookup(id uint64) (*entry, bool) {
+	if e, ok := i.pinned[id]; ok {
+		return e, true
+	}
+	for _, e := range i.ring {
+		if e != nil && e.ID == id {
+			_, pinned := i.pinned[id]
+			return e, pinned
+		}
+	}
+	return nil, false
+}
+
+// Get returns one request's details.
+func (i *Inspector) Get(id uint64) (Detail, bool) {
+	i.mu.Lock()
+	e, pinned := i.lookup(id)
+	i.mu.Unlock()
+	if e == nil {
+		return Detail{}, false
+	}
+	return e.detail(pinned), true
+}
+
+// Pin keeps a request after it leaves the ring buffer, or with pin false
+// lets it go. It reports whether the request was found.
+func (i *Inspector) Pin(id uint64, pin bool) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	e, _ := i.lookup(id)
+	if e == nil {
+		return false
+	}
+	if !pin {
+		delete(i.pinned, id)
+		return true
+	}
+	if len(i.pinned) >= maxPinned {
+		// Unpin the oldest.
+		var oldest uint64
+		for pid := range i.pinned {
+			if oldest == 0 || pid < oldest {
+				oldest = pid
+			}
+		}
+		delete(i.pinned, oldest)
+	}
+	i.pinned[id] = e
+	return true
+}
+
+// subscribe returns a channel of new requests matching f; cancel stops it.
+func (i *Inspector) subscribe(f Filter) (<-chan Summary, func()) {
+	s := &subscriber{f: f, ch: make(chan Summary, 256)}
+	i.mu.Lock()
+	i.subs[s] = struct{}{}
+	i.mu.Unlock()
+	return s.ch, func() {
+		i.mu.Lock()
+		delete(i.subs, s)
+		i.mu.Unlock()
+	}
+}
+
+// Handler returns h, with records logged in the context of a request in
+// flight also kept with that request.
+func (i *Inspector) Handler(h slog.Handler) slog.Handler {
+	if i == nil {
+		return h
+	}
+	return &logHandler{i: i, format: i.format, next: h}
+}
+
+type logHandler struct {
+	i      *Inspector
+	format slog.Handler
+	next   slog.Handler
+}
+
+func (h *logHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := ctx.Value(entryKey{}).(uint64); ok {
+		h.i.mu.Lock()
+		e := h.i.active[id]
+		h.i.mu.Unlock()
+		if e != nil {
+			h.i.fmtMu.Lock()
+			h.i.fmtBuf.Reset()
+			h.format.Handle(ctx, r)
+			line := bytes.TrimSuffix(bytes.Clone(h.i.fmtBuf.Bytes()), []byte("\n"))
+			h.i.fmtMu.Unlock()
+			e.addLog(h.i.cfg.Redactor.JSON(redact.SourceRecording, line), h.i.cfg.MaxLogs)
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{i: h.i, format: h.format.WithAttrs(attrs), next: h.next.WithAttrs(attrs)}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{i: h.i, format: h.format.WithGroup(name), next: h.next.WithGroup(name)}
+}
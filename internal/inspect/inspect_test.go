@@ -0,0 +1,229 @@
+package inspect
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"goaws/internal/httpx"
+	"goaws/internal/logging"
+	"goaws/internal/redact"
+)
+
+// newServer returns an inspector and the public handler chain it records,
+// wired as the server does, with log records going through its Handler.
+func newServer(t *testing.T, cfg Config, mux *http.ServeMux) (*Inspector, http.Handler, *slog.Logger) {
+	t.Helper()
+	red, err := redact.New(redact.Rules{Headers: []string{"Authorization"}, QueryParams: []string{"token"}, Patterns: []string{"email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Enabled, cfg.Redactor = true, red
+	i := New(cfg)
+	inner := httpx.Routes(mux)
+	// The copy stands in for middleware between the inspector and the mux.
+	h := logging.Middleware(i.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), struct{}{}, 1)))
+	})))
+	log := slog.New(logging.ContextHandler(i.Handler(slog.NewTextHandler(io.Discard, nil))))
+	return i, h, log
+}
+
+func TestMiddlewareRecordsRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	i, h, log := newServer(t, Config{Size: 10, MaxLogs: 2}, mux)
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		for n := range 3 {
+			log.InfoContext(r.Context(), "lookup", "n", n, "user", "bob@example.com")
+		}
+		w.Header().Set("Authorization", "secret")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+	r := httptest.NewRequest("GET", "/items/7?token=abc", nil)
+	r.Header.Set("Authorization", "Bearer x")
+	r.Header.Set("X-Request-Id", "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	list := i.List(Filter{}, 10)
+	if len(list) != 1 {
+		t.Fatalf("list %+v", list)
+	}
+	s := list[0]
+	if s.Route != "GET /items/{id}" || s.Status != 418 || s.BytesOut != 15 || s.URI != "/items/7?token=[REDACTED]" || s.RequestID != "req-1" || s.Logs != 2 {
+		t.Errorf("summary %+v", s)
+	}
+	d, ok := i.Get(s.ID)
+	if !ok {
+		t.Fatal("not found")
+	}
+	if d.RequestHeaders.Get("Authorization") != redact.Mask || d.ResponseHeaders.Get("Authorization") != redact.Mask || d.LogsDropped != 1 {
+		t.Errorf("detail %+v", d)
+	}
+	if line := string(d.LogLines[0]); !strings.Contains(line, `"msg":"lookup"`) || !strings.Contains(line, `"user":"[REDACTED]"`) {
+		t.Errorf("log line %s", line)
+	}
+}
+
+func TestPanickingRequestIsRecorded(t *testing.T) {
+	mux := http.NewServeMux()
+	i, h, log := newServer(t, Config{Size: 10, MaxLogs: 10}, mux)
+	mux.HandleFunc("GET /boom", func(w http.ResponseWriter, r *http.Request) {
+		log.InfoContext(r.Context(), "about to fail")
+		panic("boom")
+	})
+	func() {
+		// net/http recovers the panic in the server.
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/boom", nil))
+	}()
+
+	i.mu.Lock()
+	active := len(i.active)
+	i.mu.Unlock()
+	if active != 0 {
+		t.Errorf("%d requests still active", active)
+	}
+	list := i.List(Filter{}, 10)
+	if len(list) != 1 || list[0].Route != "GET /boom" || list[0].Status != http.StatusInternalServerError || list[0].Logs != 1 {
+		t.Errorf("list %+v", list)
+	}
+}
+
+func TestLogsStayWithTheirRequestWhenIDsRepeat(t *testing.T) {
+	mux := http.NewServeMux()
+	i, h, log := newServer(t, Config{Size: 10, MaxLogs: 10}, mux)
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	mux.HandleFunc("GET /{name}", func(w http.ResponseWriter, r *http.Request) {
+		// Both requests are in flight before either logs.
+		arrived.Done()
+		arrived.Wait()
+		log.InfoContext(r.Context(), "serving "+r.PathValue("name"))
+	})
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/"+name, nil)
+			r.Header.Set("X-Request-Id", "same")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+	}
+	wg.Wait()
+	// Records logged after the request finished are not kept.
+	log.InfoContext(context.WithValue(context.Background(), entryKey{}, uint64(1)), "late")
+
+	for _, s := range i.List(Filter{}, 10) {
+		d, _ := i.Get(s.ID)
+		if len(d.LogLines) != 1 || !strings.Contains(string(d.LogLines[0]), "serving "+strings.TrimPrefix(s.URI, "/")) {
+			t.Errorf("%s has %d logs: %s", s.URI, len(d.LogLines), d.LogLines)
+		}
+	}
+	if len(i.active) != 0 {
+		t.Errorf("%d requests still active", len(i.active))
+	}
+}
+
+func TestRingAndPins(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(http.ResponseWriter, *http.Request) {})
+	i, h, _ := newServer(t, Config{Size: 2}, mux)
+	for _, p := range []string{"/1", "/2"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+	if !i.Pin(1, true) || i.Pin(99, true) {
+		t.Error("Pin")
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/3", nil))
+
+	var uris []string
+	for _, s := range i.List(Filter{}, 10) {
+		uris = append(uris, s.URI)
+	}
+	// /1 left the ring but is pinned.
+	if strings.Join(uris, " ") != "/3 /2 /1" {
+		t.Errorf("list %v", uris)
+	}
+	if l := i.List(Filter{Pinned: true}, 10); len(l) != 1 || l[0].URI != "/1" || !l[0].Pinned {
+		t.Errorf("pinned %+v", l)
+	}
+	if l := i.List(Filter{}, 1); len(l) != 1 || l[0].URI != "/3" {
+		t.Errorf("limit 1: %+v", l)
+	}
+	i.Pin(1, false)
+	if _, ok := i.Get(1); ok {
+		t.Error("unpinned request evicted from the ring is still found")
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	s := Summary{Method: "POST", URI: "/orders?x=1", Route: "POST /orders", Status: 503, LatencyMS: 120, RequestID: "abc", ClientIP: "10.0.0.1"}
+	for _, tc := range []struct {
+		f    Filter
+		want bool
+	}{
+		{Filter{}, true},
+		{Filter{Method: "POST", Status: "5xx", Path: "/orders", Route: "POST /orders", MinLatency: 100}, true},
+		{Filter{Status: "503"}, true},
+		{Filter{Status: "4xx"}, false},
+		{Filter{Status: "500"}, false},
+		{Filter{Method: "GET"}, false},
+		{Filter{MinLatency: 200}, false},
+		{Filter{Query: "10.0.0"}, true},
+		{Filter{Query: "zzz"}, false},
+		{Filter{Pinned: true}, false},
+	} {
+		if got := tc.f.Match(s); got != tc.want {
+			t.Errorf("%+v: %v", tc.f, got)
+		}
+	}
+	f := FilterFromQuery(map[string][]string{"method": {"get"}, "status": {"5XX"}, "min_ms": {"2.5"}, "pinned": {"true"}})
+	if f.Method != "GET" || f.Status != "5xx" || f.MinLatency != 2.5 || !f.Pinned {
+		t.Errorf("FilterFromQuery = %+v", f)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(404) })
+	i, h, _ := newServer(t, Config{Size: 10}, mux)
+	ch, cancel := i.subscribe(Filter{Status: "4xx"})
+	defer cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	if s := <-ch; s.URI != "/missing" {
+		t.Errorf("streamed %+v", s)
+	}
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		i.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	var list []Summary
+	json.Unmarshal(do("GET", "/debug/requests/api?status=404").Body.Bytes(), &list)
+	if len(list) != 1 {
+		t.Fatalf("list %+v", list)
+	}
+	if rec := do("PUT", "/debug/requests/api/1/pin"); rec.Code != http.StatusNoContent {
+		t.Errorf("pin: %d", rec.Code)
+	}
+	var d Detail
+	json.Unmarshal(do("GET", "/debug/requests/api/1").Body.Bytes(), &d)
+	if !d.Pinned || d.Method != "GET" {
+		t.Errorf("detail %+v", d)
+	}
+	if rec := do("GET", "/debug/requests/api/42"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown request: %d", rec.Code)
+	}
+	if rec := do("GET", "/debug/requests"); !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("page content type %q", rec.Header().Get("Content-Type"))
+	}
+}
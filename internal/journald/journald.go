@@ -0,0 +1,231 @@
+// Package journald is a slog handler speaking the systemd journal's native
+// protocol, so every attribute becomes a journal field that journalctl can
+// match on, e.g. journalctl -u srv.service REQUEST_ID=....
+package journald
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"goaws/internal/env"
+)
+
+// DefaultSocket is where journald listens for native protocol datagrams.
+const DefaultSocket = "/run/systemd/journal/socket"
+
+// Config configures the handler. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Mode is "auto", which uses the journal when systemd connected
+	// stdout to it (JOURNAL_STREAM is set) and the socket exists, "on" or
+	// "off".
+	Mode       string
+	Socket     string
+	Identifier string
+	Level      slog.Leveler
+}
+
+// ConfigFromEnv reads LOG_JOURNALD and LOG_JOURNALD_SOCKET.
+func ConfigFromEnv() Config {
+	return Config{
+		Mode:       env.String("LOG_JOURNALD", "auto"),
+		Socket:     env.String("LOG_JOURNALD_SOCKET", DefaultSocket),
+		Identifier: filepath.Base(os.Args[0]),
+		Level:      slog.LevelInfo,
+	}
+}
+
+// Use reports whether logs should go to the journal.
+func (c Config) Use() bool {
+	switch c.Mode {
+	case "on", "true", "1":
+		return true
+	case "off", "false", "0":
+		return false
+	}
+	if os.Getenv("JOURNAL_STREAM") == "" {
+		return false
+	}
+	_, err := os.Stat(c.Socket)
+	return err == nil
+}
+
+// Handler writes records to the journal. Attribute keys become upper-case
+// field names (request_id becomes REQUEST_ID, groups are joined with _),
+// and levels map onto syslog PRIORITY.
+type Handler struct {
+	conn   *net.UnixConn
+	cfg    Config
+	prefix string
+	// fields holds attributes added with WithAttrs, already encoded.
+	fields []byte
+}
+
+// New connects to cfg.Socket. It fails when no journal is listening, so the
+// caller can fall back to stdout.
+func New(cfg Config) (*Handler, error) {
+	if cfg.Level == nil {
+		cfg.Level = slog.LevelInfo
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: cfg.Socket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("journald: %w", err)
+	}
+	return &Handler{conn: conn, cfg: cfg}, nil
+}
+
+func (h *Handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.cfg.Level.Level()
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	b := make([]byte, 0, 512)
+	b = appendField(b, "MESSAGE", r.Message)
+	b = appendField(b, "PRIORITY", strconv.Itoa(priority(r.Level)))
+	b = appendField(b, "SYSLOG_IDENTIFIER", h.cfg.Identifier)
+	b = appendField(b, "LEVEL", r.Level.String())
+	if r.PC != 0 {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		b = appendField(b, "CODE_FILE", f.File)
+		b = appendField(b, "CODE_LINE", strconv.Itoa(f.Line))
+		b = appendField(b, "CODE_FUNC", f.Function)
+	}
+	b = append(b, h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		b = appendAttr(b, h.prefix, a)
+		return true
+	})
+	return h.send(b)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.fields = slices.Clip(h.fields)
+	for _, a := range attrs {
+		h2.fields = appendAttr(h2.fields, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + fieldName(name) + "_"
+	return &h2
+}
+
+// send writes one datagram. Entries too large for a datagram are written to
+// an unlinked temporary file whose descriptor is passed instead, as
+// sd_journal_send does.
+func (h *Handler) send(b []byte) error {
+	_, err := h.conn.Write(b)
+	if err == nil || !(errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)) {
+		return err
+	}
+	f, err := os.CreateTemp("/dev/shm", "journal.")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	// net refuses WriteMsgUnix on a connected datagram socket, so send
+	// the descriptor with sendmsg directly.
+	raw, err := h.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Write(func(fd uintptr) bool {
+		serr = syscall.Sendmsg(int(fd), nil, syscall.UnixRights(int(f.Fd())), nil, 0)
+		return serr != syscall.EAGAIN
+	})
+	return errors.Join(err, serr)
+}
+
+func priority(l slog.Level) int {
+	switch {
+	case l >= slog.LevelError:
+		return 3
+	case l >= slog.LevelWarn:
+		return 4
+	case l >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+func appendAttr(b []byte, prefix string, a slog.Attr) []byte {
+	v := a.Value.Resolve()
+	if a.Key == "" && v.Kind() != slog.KindGroup {
+		return b
+	}
+	switch v.Kind() {
+	case slog.KindGroup:
+		p := prefix
+		if a.Key != "" {
+			p += fieldName(a.Key) + "_"
+		}
+		for _, ga := range v.Group() {
+			b = appendAttr(b, p, ga)
+		}
+		return b
+	case slog.KindTime:
+		return appendField(b, prefix+fieldName(a.Key), v.Time().Format(time.RFC3339Nano))
+	}
+	return appendField(b, prefix+fieldName(a.Key), v.String())
+}
+
+// appendField encodes one field. Values containing a newline use the binary
+// form: the name, a newline, the little-endian 64-bit length, then the
+// value.
+func appendField(b []byte, name, value string) []byte {
+	b = append(b, name...)
+	if !strings.Contains(value, "\n") {
+		b = append(b, '=')
+		b = append(b, value...)
+		return append(b, '\n')
+	}
+	b = append(b, '\n')
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(value)))
+	b = append(b, value...)
+	return append(b, '\n')
+}
+
+// fieldName maps a key onto the journal's field syntax: upper-case letters,
+// digits and underscores, not starting with an underscore (reserved for
+// trusted fields) or a digit, at most 64 characters.
+func fieldName(key string) string {
+	n := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+	n = strings.TrimLeft(n, "_")
+	if n == "" || n[0] >= '0' && n[0] <= '9' {
+		n = "X" + n
+	}
+	if len(n) > 64 {
+		n = n[:64]
+	}
+	return n
+}
@@ -0,0 +1,199 @@
+package journald
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// listen stands in for journald on a socket in a temporary directory.
+func listen(t *testing.T) (*net.UnixConn, Config) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, Config{Mode: "on", Socket: path, Identifier: "srv", Level: slog.LevelDebug}
+}
+
+// receive reads one entry, following a passed descriptor for large ones.
+func receive(t *testing.T, conn *net.UnixConn) []byte {
+	t.Helper()
+	buf := make([]byte, 1<<16)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oobn == 0 {
+		return buf[:n]
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "entry")
+	defer f.Close()
+	f.Seek(0, io.SeekStart)
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// decode parses the native protocol into fields.
+func decode(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "=\n")
+		if i < 0 {
+			t.Fatalf("truncated entry at %q", b)
+		}
+		name := string(b[:i])
+		if b[i] == '=' {
+			j := bytes.IndexByte(b[i:], '\n')
+			fields[name] = string(b[i+1 : i+j])
+			b = b[i+j+1:]
+			continue
+		}
+		n := int(binary.LittleEndian.Uint64(b[i+1 : i+9]))
+		fields[name] = string(b[i+9 : i+9+n])
+		if b[i+9+n] != '\n' {
+			t.Fatalf("binary field %s not terminated", name)
+		}
+		b = b[i+9+n+1:]
+	}
+	return fields
+}
+
+func TestHandlerWireFormat(t *testing.T) {
+	conn, cfg := listen(t)
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(h).With("request_id", "r-1").WithGroup("http")
+	log.Error("request failed\nwith detail",
+		"status", 503,
+		slog.Group("client", "ip", "203.0.113.9"),
+		"at", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	)
+
+	f := decode(t, receive(t, conn))
+	for name, want := range map[string]string{
+		"MESSAGE":           "request failed\nwith detail",
+		"PRIORITY":          "3",
+		"SYSLOG_IDENTIFIER": "srv",
+		"LEVEL":             "ERROR",
+		"REQUEST_ID":        "r-1",
+		"HTTP_STATUS":       "503",
+		"HTTP_CLIENT_IP":    "203.0.113.9",
+		"HTTP_AT":           "2024-05-01T12:00:00Z",
+	} {
+		if f[name] != want {
+			t.Errorf("%s = %q, want %q", name, f[name], want)
+		}
+	}
+	if !strings.HasSuffix(f["CODE_FILE"], "journald_test.go") || f["CODE_LINE"] == "" {
+		t.Errorf("code location %q:%q", f["CODE_FILE"], f["CODE_LINE"])
+	}
+}
+
+func TestHandlerPassesLargeEntriesByDescriptor(t *testing.T) {
+	conn, cfg := listen(t)
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := strings.Repeat("x", 8<<20)
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, big, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if f := decode(t, receive(t, conn)); f["MESSAGE"] != big {
+		t.Errorf("MESSAGE has %d bytes, want %d", len(f["MESSAGE"]), len(big))
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	_, cfg := listen(t)
+	cfg.Level = slog.LevelWarn
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Enabled(context.Background(), slog.LevelInfo) || !h.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("level not respected")
+	}
+}
+
+func TestNewFailsWithoutJournal(t *testing.T) {
+	if _, err := New(Config{Socket: filepath.Join(t.TempDir(), "none")}); err == nil {
+		t.Error("New succeeded without a listener")
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	for key, want := range map[string]string{
+		"request_id":               "REQUEST_ID",
+		"http.status":              "HTTP_STATUS",
+		"_private":                 "PRIVATE",
+		"1st":                      "X1ST",
+		"":                         "X",
+		"ünïcode":                  "N_CODE",
+		strings.Repeat("a", 70):    strings.Repeat("A", 64),
+		"already_UPPER_and_digit9": "ALREADY_UPPER_AND_DIGIT9",
+	} {
+		if got := fieldName(key); got != want {
+			t.Errorf("fieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	for l, want := range map[slog.Level]int{
+		slog.LevelDebug: 7, slog.LevelInfo: 6, slog.LevelWarn: 4, slog.LevelError: 3, slog.LevelError + 4: 3,
+	} {
+		if got := priority(l); got != want {
+			t.Errorf("priority(%v) = %d, want %d", l, got, want)
+		}
+	}
+}
+
+func TestUse(t *testing.T) {
+	_, cfg := listen(t)
+	cfg.Mode = "auto"
+	t.Setenv("JOURNAL_STREAM", "")
+	if cfg.Use() {
+		t.Error("auto used the journal without JOURNAL_STREAM")
+	}
+	t.Setenv("JOURNAL_STREAM", "8:1234")
+	if !cfg.Use() {
+		t.Error("auto ignored the journal")
+	}
+	cfg.Socket = filepath.Join(t.TempDir(), "none")
+	if cfg.Use() {
+		t.Error("auto used a missing socket")
+	}
+	cfg.Mode = "off"
+	if cfg.Use() {
+		t.Error("off used the journal")
+	}
+}
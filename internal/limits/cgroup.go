@@ -0,0 +1,237 @@
+package limits
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// unlimited is the smallest value treated as no limit. cgroup v1 reports an
+// unset memory limit as a page-aligned int64 max.
+const unlimited = 1 << 62
+
+// mount is a cgroup filesystem from /proc/self/mountinfo.
+type mount struct {
+	root, point string
+	v2          bool
+	controllers []string
+}
+
+// read returns the cgroup version and the tightest memory (bytes) and CPU
+// (cores) limits on the process's cgroup or any of its ancestors, with 0
+// meaning unlimited. root prefixes every path read.
+func read(root string) (version int, memory int64, cpu float64) {
+	mounts := readMounts(root)
+	paths := readPaths(root)
+	// A v2 hierarchy only has limits if it owns the controllers; on hybrid
+	// hosts it is mounted for systemd alone, so fall through to v1.
+	if m, ok := find(mounts, true, ""); ok {
+		if dir, ok := m.dir(root, paths[""]); ok && hasController(filepath.Join(root, m.point), "memory", "cpu") {
+			return 2, memoryV2(dir, filepath.Join(root, m.point)), cpuV2(dir, filepath.Join(root, m.point))
+		}
+	}
+	if m, ok := find(mounts, false, "memory"); ok {
+		if dir, ok := m.dir(root, paths["memory"]); ok {
+			version, memory = 1, memoryV1(dir, filepath.Join(root, m.point))
+		}
+	}
+	if m, ok := find(mounts, false, "cpu"); ok {
+		if dir, ok := m.dir(root, paths["cpu"]); ok {
+			version, cpu = 1, cpuV1(dir, filepath.Join(root, m.point))
+		}
+	}
+	return version, memory, cpu
+}
+
+// readMounts parses mountinfo lines such as
+//
+//	33 32 0:29 / /sys/fs/cgroup/cpu,cpuacct rw - cgroup cgroup rw,cpu,cpuacct
+func readMounts(root string) []mount {
+	f, err := os.Open(filepath.Join(root, "proc/self/mountinfo"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var out []mount
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		pre, post, ok := strings.Cut(sc.Text(), " - ")
+		fields, fs := strings.Fields(pre), strings.Fields(post)
+		if !ok || len(fields) < 5 || len(fs) < 3 {
+			continue
+		}
+		m := mount{root: fields[3], point: fields[4]}
+		switch fs[0] {
+		case "cgroup2":
+			m.v2 = true
+		case "cgroup":
+			m.controllers = strings.Split(fs[2], ",")
+		default:
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// readPaths maps each v1 controller, and "" for v2, to the process's
+// cgroup from /proc/self/cgroup lines such as "4:memory:/system.slice".
+func readPaths(root string) map[string]string {
+	b, err := os.ReadFile(filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		return nil
+	}
+	out := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			out[""] = parts[2]
+			continue
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			out[c] = parts[2]
+		}
+	}
+	return out
+}
+
+func find(mounts []mount, v2 bool, controller string) (mount, bool) {
+	for _, m := range mounts {
+		if m.v2 == v2 && (v2 || slices.Contains(m.controllers, controller)) {
+			return m, true
+		}
+	}
+	return mount{}, false
+}
+
+// dir returns the directory of cgroup path under m. Inside a container the
+// mount's root is the container's cgroup and the path may be relative to
+// the host's hierarchy.
+func (m mount) dir(root, path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	if m.root != "/" {
+		rel, ok := strings.CutPrefix(path, m.root)
+		if !ok {
+			rel = "/"
+		}
+		path = rel
+	}
+	dir := filepath.Join(root, m.point, path)
+	if _, err := os.Stat(dir); err != nil {
+		// The namespace hides the path; the mount point is our cgroup.
+		dir = filepath.Join(root, m.point)
+	}
+	return dir, true
+}
+
+func hasController(mountPoint string, names ...string) bool {
+	b, err := os.ReadFile(filepath.Join(mountPoint, "cgroup.controllers"))
+	if err != nil {
+		return false
+	}
+	have := strings.Fields(string(b))
+	for _, n := range names {
+		if slices.Contains(have, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestors calls fn for dir and each parent up to and including top.
+func ancestors(dir, top string, fn func(dir string)) {
+	for {
+		fn(dir)
+		if dir == top || len(dir) <= len(top) {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// tighter returns the smaller of two limits, where 0 is unlimited.
+func tighter[T int64 | float64](a, b T) T {
+	switch {
+	case a <= 0:
+		return b
+	case b <= 0:
+		return a
+	}
+	return min(a, b)
+}
+
+func readInt(path string) (int64, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return n, err == nil
+}
+
+// memoryV2 reads memory.max and memory.high, above which the kernel
+// throttles and reclaims; "max" means unlimited.
+func memoryV2(dir, top string) int64 {
+	var limit int64
+	ancestors(dir, top, func(dir string) {
+		for _, name := range []string{"memory.max", "memory.high"} {
+			if n, ok := readInt(filepath.Join(dir, name)); ok && n < unlimited {
+				limit = tighter(limit, n)
+			}
+		}
+	})
+	return limit
+}
+
+// cpuV2 reads cpu.max, "$QUOTA $PERIOD" or "max $PERIOD".
+func cpuV2(dir, top string) float64 {
+	var limit float64
+	ancestors(dir, top, func(dir string) {
+		b, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+		if err != nil {
+			return
+		}
+		f := strings.Fields(string(b))
+		if len(f) != 2 {
+			return
+		}
+		quota, err1 := strconv.ParseFloat(f[0], 64)
+		period, err2 := strconv.ParseFloat(f[1], 64)
+		if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+			limit = tighter(limit, quota/period)
+		}
+	})
+	return limit
+}
+
+func memoryV1(dir, top string) int64 {
+	var limit int64
+	ancestors(dir, top, func(dir string) {
+		if n, ok := readInt(filepath.Join(dir, "memory.limit_in_bytes")); ok && n > 0 && n < unlimited {
+			limit = tighter(limit, n)
+		}
+	})
+	return limit
+}
+
+// cpuV1 reads cpu.cfs_quota_us, which is -1 when unlimited, over
+// cpu.cfs_period_us.
+func cpuV1(dir, top string) float64 {
+	var limit float64
+	ancestors(dir, top, func(dir string) {
+		quota, ok1 := readInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+		period, ok2 := readInt(filepath.Join(dir, "cpu.cfs_period_us"))
+		if ok1 && ok2 && quota > 0 && period > 0 {
+			limit = tighter(limit, float64(quota)/float64(period))
+		}
+	})
+	return limit
+}
@@ -0,0 +1,138 @@
+package limits
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates files under a new root, with paths relative to it.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestRead(t *testing.T) {
+	const v2Mount = "30 23 0:26 / /sys/fs/cgroup rw,nosuid - cgroup2 cgroup2 rw,nsdelegate\n"
+	const v1Mounts = "25 24 0:22 / /sys/fs/cgroup rw - tmpfs tmpfs ro,mode=755\n" +
+		"26 25 0:23 / /sys/fs/cgroup/unified rw - cgroup2 cgroup2 rw,nsdelegate\n" +
+		"28 25 0:25 / /sys/fs/cgroup/memory rw - cgroup cgroup rw,memory\n" +
+		"29 25 0:26 / /sys/fs/cgroup/cpu,cpuacct rw - cgroup cgroup rw,cpu,cpuacct\n"
+	for _, tc := range []struct {
+		name    string
+		files   map[string]string
+		version int
+		memory  int64
+		cpu     float64
+	}{
+		{
+			name: "v2 systemd service, parent tighter",
+			files: map[string]string{
+				"proc/self/mountinfo":                                v2Mount,
+				"proc/self/cgroup":                                   "0::/system.slice/app.service\n",
+				"sys/fs/cgroup/cgroup.controllers":                   "cpuset cpu io memory pids\n",
+				"sys/fs/cgroup/system.slice/app.service/memory.max":  "536870912\n",
+				"sys/fs/cgroup/system.slice/app.service/memory.high": "max\n",
+				"sys/fs/cgroup/system.slice/app.service/cpu.max":     "150000 100000\n",
+				"sys/fs/cgroup/system.slice/memory.max":              "268435456\n",
+				"sys/fs/cgroup/system.slice/cpu.max":                 "max 100000\n",
+			},
+			version: 2, memory: 268435456, cpu: 1.5,
+		},
+		{
+			name: "v2 memory.high below memory.max",
+			files: map[string]string{
+				"proc/self/mountinfo":              v2Mount,
+				"proc/self/cgroup":                 "0::/app\n",
+				"sys/fs/cgroup/cgroup.controllers": "memory\n",
+				"sys/fs/cgroup/app/memory.max":     "max\n",
+				"sys/fs/cgroup/app/memory.high":    "104857600\n",
+			},
+			version: 2, memory: 104857600,
+		},
+		{
+			name: "v2 container with a hidden host path",
+			files: map[string]string{
+				"proc/self/mountinfo":              v2Mount,
+				"proc/self/cgroup":                 "0::/../../kubepods/pod1/abc\n",
+				"sys/fs/cgroup/cgroup.controllers": "cpu memory\n",
+				"sys/fs/cgroup/memory.max":         "1073741824\n",
+				"sys/fs/cgroup/cpu.max":            "200000 100000\n",
+			},
+			version: 2, memory: 1 << 30, cpu: 2,
+		},
+		{
+			name: "hybrid host falls through to v1",
+			files: map[string]string{
+				"proc/self/mountinfo":                      v1Mounts,
+				"proc/self/cgroup":                         "0::/system.slice/app.service\n5:memory:/system.slice/app.service\n4:cpu,cpuacct:/system.slice/app.service\n",
+				"sys/fs/cgroup/unified/cgroup.controllers": "",
+				"sys/fs/cgroup/memory/system.slice/app.service/memory.limit_in_bytes":  "9223372036854771712\n",
+				"sys/fs/cgroup/memory/system.slice/memory.limit_in_bytes":              "2147483648\n",
+				"sys/fs/cgroup/cpu,cpuacct/system.slice/app.service/cpu.cfs_quota_us":  "50000\n",
+				"sys/fs/cgroup/cpu,cpuacct/system.slice/app.service/cpu.cfs_period_us": "100000\n",
+				"sys/fs/cgroup/cpu,cpuacct/system.slice/cpu.cfs_quota_us":              "-1\n",
+				"sys/fs/cgroup/cpu,cpuacct/system.slice/cpu.cfs_period_us":             "100000\n",
+			},
+			version: 1, memory: 2 << 30, cpu: 0.5,
+		},
+		{
+			name: "v1 docker container",
+			files: map[string]string{
+				"proc/self/mountinfo": "28 25 0:25 /docker/abc /sys/fs/cgroup/memory ro - cgroup cgroup rw,memory\n" +
+					"29 25 0:26 /docker/abc /sys/fs/cgroup/cpu,cpuacct ro - cgroup cgroup rw,cpu,cpuacct\n",
+				"proc/self/cgroup":                            "5:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes":  "536870912\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "-1\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+			},
+			version: 1, memory: 512 << 20,
+		},
+		{
+			name:  "no cgroup filesystem",
+			files: map[string]string{"proc/self/mountinfo": "22 1 8:1 / / rw - ext4 /dev/root rw\n"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			version, memory, cpu := read(writeTree(t, tc.files))
+			if version != tc.version || memory != tc.memory || cpu != tc.cpu {
+				t.Errorf("read = %d, %d, %v; want %d, %d, %v", version, memory, cpu, tc.version, tc.memory, tc.cpu)
+			}
+		})
+	}
+}
+
+func TestReadMounts(t *testing.T) {
+	root := writeTree(t, map[string]string{"proc/self/mountinfo": "" +
+		"29 25 0:26 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid shared:9 - cgroup cgroup rw,cpu,cpuacct\n" +
+		"30 23 0:27 /sub /mnt/cg rw - cgroup2 cgroup2 rw\n" +
+		"garbage\n"})
+	ms := readMounts(root)
+	if len(ms) != 2 {
+		t.Fatalf("mounts %+v", ms)
+	}
+	if ms[0].point != "/sys/fs/cgroup/cpu,cpuacct" || ms[0].v2 || len(ms[0].controllers) != 3 || ms[0].controllers[1] != "cpu" {
+		t.Errorf("v1 mount %+v", ms[0])
+	}
+	if !ms[1].v2 || ms[1].root != "/sub" {
+		t.Errorf("v2 mount %+v", ms[1])
+	}
+}
+
+func TestTighter(t *testing.T) {
+	for _, tc := range [][3]int64{{0, 5, 5}, {5, 0, 5}, {3, 5, 3}, {0, 0, 0}, {-1, 4, 4}} {
+		if got := tighter(tc[0], tc[1]); got != tc[2] {
+			t.Errorf("tighter(%d, %d) = %d", tc[0], tc[1], got)
+		}
+	}
+}
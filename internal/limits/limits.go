@@ -0,0 +1,128 @@
+// Package limits sizes the Go runtime to the cgroup the process runs in.
+// The runtime sees every CPU and all of the host's memory, so on a small
+// instance with systemd's MemoryMax or CPUQuota it will happily run more
+// threads than it may use and let the heap grow until the OOM killer steps
+// in. Apply sets GOMAXPROCS from the CPU quota and GOMEMLIMIT from the memory
+// limit, less some headroom for memory the Go heap doesn't account for.
+package limits
+
+import (
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"goaws/internal/env"
+	"goaws/internal/metrics"
+)
+
+var (
+	memLimitGauge  = metrics.NewGauge("runtime_gomemlimit_bytes", "The Go runtime's soft memory limit, by where it came from.", "source")
+	maxProcsGauge  = metrics.NewGauge("runtime_gomaxprocs", "GOMAXPROCS, by where it came from.", "source")
+	cgroupMemGauge = metrics.NewGauge("cgroup_memory_limit_bytes", "Memory limit of the process's cgroup; 0 when unlimited.")
+	cgroupCPUGauge = metrics.NewGauge("cgroup_cpu_limit_cores", "CPU quota of the process's cgroup in cores; 0 when unlimited.")
+	versionGauge   = metrics.NewGauge("cgroup_version", "cgroup hierarchy the limits were read from; 0 when none was found.")
+)
+
+// Config configures Apply. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Enabled applies cgroup limits; when false the runtime defaults (or
+	// GOMEMLIMIT and GOMAXPROCS, if set) are only reported.
+	Enabled bool
+	// MemoryHeadroom is the percentage of the memory limit left outside
+	// GOMEMLIMIT for stacks, cgo and the runtime's own overhead.
+	MemoryHeadroom float64
+}
+
+// ConfigFromEnv reads RUNTIME_LIMITS and GOMEMLIMIT_HEADROOM.
+func ConfigFromEnv() Config {
+	return Config{
+		Enabled:        env.Bool("RUNTIME_LIMITS", true),
+		MemoryHeadroom: env.Float("GOMEMLIMIT_HEADROOM", 10),
+	}
+}
+
+// Sources of a setting.
+const (
+	SourceEnv     = "env"
+	SourceCgroup  = "cgroup"
+	SourceDefault = "default"
+)
+
+// Result is what Apply found and chose.
+type Result struct {
+	// CgroupVersion is 1 or 2, or 0 if no cgroup limits could be read.
+	CgroupVersion int
+	// MemoryLimit is the cgroup memory limit in bytes and CPULimit the CPU
+	// quota in cores; 0 means unlimited.
+	MemoryLimit int64
+	CPULimit    float64
+
+	GOMEMLIMIT       int64
+	GOMEMLIMITSource string
+	GOMAXPROCS       int
+	GOMAXPROCSSource string
+}
+
+// LogValue reports r in log records.
+func (r Result) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Int("gomaxprocs", r.GOMAXPROCS),
+		slog.String("gomaxprocs_source", r.GOMAXPROCSSource),
+		slog.String("gomemlimit_source", r.GOMEMLIMITSource),
+		slog.Int("cgroup_version", r.CgroupVersion),
+	}
+	if r.GOMEMLIMIT != math.MaxInt64 {
+		attrs = append(attrs, slog.Int64("gomemlimit", r.GOMEMLIMIT))
+	}
+	if r.MemoryLimit > 0 {
+		attrs = append(attrs, slog.Int64("cgroup_memory_limit", r.MemoryLimit))
+	}
+	if r.CPULimit > 0 {
+		attrs = append(attrs, slog.Float64("cgroup_cpu_limit", r.CPULimit))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Apply sets GOMAXPROCS and GOMEMLIMIT from the cgroup limits unless the
+// corresponding environment variable is set, which the runtime has already
+// applied. It should run first thing in main.
+func Apply(cfg Config) Result {
+	var r Result
+	if cfg.Enabled {
+		r.CgroupVersion, r.MemoryLimit, r.CPULimit = read("/")
+	}
+
+	r.GOMAXPROCS, r.GOMAXPROCSSource = runtime.GOMAXPROCS(0), SourceDefault
+	switch {
+	case os.Getenv("GOMAXPROCS") != "":
+		r.GOMAXPROCSSource = SourceEnv
+	case r.CPULimit > 0:
+		// A quota of 1.5 cores can keep two threads busy part of the
+		// time, so round up, but never past the CPUs there are.
+		r.GOMAXPROCS = min(max(int(math.Ceil(r.CPULimit)), 1), runtime.NumCPU())
+		r.GOMAXPROCSSource = SourceCgroup
+		runtime.GOMAXPROCS(r.GOMAXPROCS)
+	}
+
+	r.GOMEMLIMIT, r.GOMEMLIMITSource = debug.SetMemoryLimit(-1), SourceDefault
+	switch {
+	case os.Getenv("GOMEMLIMIT") != "":
+		r.GOMEMLIMITSource = SourceEnv
+	case r.MemoryLimit > 0:
+		headroom := min(max(cfg.MemoryHeadroom, 0), 90)
+		r.GOMEMLIMIT = int64(float64(r.MemoryLimit) * (1 - headroom/100))
+		r.GOMEMLIMITSource = SourceCgroup
+		debug.SetMemoryLimit(r.GOMEMLIMIT)
+	}
+
+	versionGauge.With().Set(float64(r.CgroupVersion))
+	cgroupMemGauge.With().Set(float64(r.MemoryLimit))
+	cgroupCPUGauge.With().Set(r.CPULimit)
+	maxProcsGauge.With(r.GOMAXPROCSSource).Set(float64(r.GOMAXPROCS))
+	if r.GOMEMLIMIT != math.MaxInt64 {
+		memLimitGauge.With(r.GOMEMLIMITSource).Set(float64(r.GOMEMLIMIT))
+	}
+	return r
+}
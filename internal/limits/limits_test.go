@@ -0,0 +1,25 @@
+package limits
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestApplyLeavesEnvironmentSettings(t *testing.T) {
+	t.Setenv("GOMAXPROCS", "3")
+	t.Setenv("GOMEMLIMIT", "1GiB")
+	r := Apply(Config{Enabled: true, MemoryHeadroom: 10})
+	if r.GOMAXPROCSSource != SourceEnv || r.GOMEMLIMITSource != SourceEnv || r.GOMAXPROCS != runtime.GOMAXPROCS(0) {
+		t.Errorf("result %+v", r)
+	}
+}
+
+func TestApplyDisabled(t *testing.T) {
+	t.Setenv("GOMAXPROCS", "")
+	t.Setenv("GOMEMLIMIT", "")
+	procs := runtime.GOMAXPROCS(0)
+	r := Apply(Config{})
+	if r.CgroupVersion != 0 || r.GOMAXPROCSSource != SourceDefault || r.GOMEMLIMITSource != SourceDefault || r.GOMAXPROCS != procs {
+		t.Errorf("result %+v", r)
+	}
+}
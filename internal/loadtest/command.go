@@ -0,0 +1,103 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const usage = `usage: app loadtest [flags]
+
+Sends HTTP load to -url and reports throughput, errors and latency. The
+open model (-model open) sends -rate requests per second whatever the
+server does; the closed model (-model closed) runs -concurrency clients
+that each wait for a response before sending again. -ramp ramps up to the
+load over that long before holding it for -duration; -stages gives a
+profile instead, as duration:target steps ramped linearly from the last.
+
+Requests are GET -url unless -mix names a JSON array of requests or a JSONL
+capture such as a JSON access log. Response latency is measured from when
+each request was due to be sent, correcting for coordinated omission;
+service latency from when it was.
+
+flags:
+`
+
+// Command runs app loadtest with args.
+func Command(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	base := fset.String("url", "", "target URL, and base for relative URLs in the mix")
+	model := fset.String("model", Open, "open (constant arrival rate) or closed (fixed clients)")
+	rate := fset.Float64("rate", 50, "requests per second; in the closed model, paces the clients if set explicitly")
+	concurrency := fset.Int("concurrency", 10, "clients in the closed model")
+	duration := fset.Duration("duration", 30*time.Second, "how long to hold the load")
+	ramp := fset.Duration("ramp", 0, "ramp up to the load over this long first")
+	stages := fset.String("stages", "", "load profile as duration:target,..., overriding -rate/-concurrency, -ramp and -duration")
+	mixFile := fset.String("mix", "", "JSON request mix or JSONL capture file")
+	maxInFlight := fset.Int("max-inflight", 1000, "concurrent requests allowed in the open model")
+	timeout := fset.Duration("timeout", 10*time.Second, "per request")
+	asJSON := fset.Bool("json", false, "print the report as JSON")
+	quiet := fset.Bool("quiet", false, "no progress lines on stderr")
+	fset.Usage = func() {
+		fmt.Fprint(fset.Output(), usage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() != 0 || *model != Open && *model != Closed || *base == "" && *mixFile == "" {
+		fset.Usage()
+		return flag.ErrHelp
+	}
+	explicitRate := false
+	fset.Visit(func(f *flag.Flag) { explicitRate = explicitRate || f.Name == "rate" })
+
+	opts := Options{
+		Model:       *model,
+		MaxInFlight: *maxInFlight,
+		Timeout:     *timeout,
+	}
+	if *stages != "" {
+		var err error
+		if opts.Stages, err = ParseStages(*stages); err != nil {
+			return fmt.Errorf("-stages: %w", err)
+		}
+	} else {
+		target := *rate
+		if *model == Closed {
+			target = float64(*concurrency)
+		}
+		// A zero-length first stage starts at the target rather than
+		// ramping from nothing.
+		opts.Stages = []Stage{{*ramp, target}, {*duration, target}}
+	}
+	if *model == Closed && explicitRate {
+		opts.Rate = *rate
+	}
+	if !*quiet {
+		opts.Progress, opts.ProgressInterval = os.Stderr, 5*time.Second
+	}
+
+	reqs := []*Request{{}}
+	if *mixFile != "" {
+		var err error
+		if reqs, err = LoadMix(*mixFile); err != nil {
+			return err
+		}
+	}
+	mix, err := NewMix(*base, reqs)
+	if err != nil {
+		return err
+	}
+
+	rep := Run(ctx, mix, opts)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	return rep.WriteText(os.Stdout)
+}
@@ -0,0 +1,124 @@
+package loadtest
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBuckets per power of two: values are kept to within 1/128 (under 1%)
+// of what was recorded, from a microsecond to hours, in a few thousand
+// counters.
+const (
+	subBucketBits = 7
+	subBuckets    = 1 << subBucketBits
+	maxShift      = 40
+)
+
+// histogram counts latencies in log-linear buckets, like HdrHistogram.
+// Values are in microseconds. It is not safe for concurrent use.
+type histogram struct {
+	counts   []int64
+	total    int64
+	sum      float64
+	min, max int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, subBuckets*(maxShift+2)), min: math.MaxInt64}
+}
+
+func bucketOf(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits - 1
+	return shift*subBuckets + int(v>>shift)
+}
+
+// bucketHigh is the largest value counted in bucket i.
+func bucketHigh(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	shift := (i - subBuckets) / subBuckets
+	mantissa := int64((i-subBuckets)%subBuckets + subBuckets)
+	return (mantissa+1)<<shift - 1
+}
+
+// Record counts one latency.
+func (h *histogram) Record(d time.Duration) {
+	v := max(d.Microseconds(), 0)
+	i := min(bucketOf(v), len(h.counts)-1)
+	h.counts[i]++
+	h.total++
+	h.sum += float64(v)
+	h.min = min(h.min, v)
+	h.max = max(h.max, v)
+}
+
+// Merge adds the counts of o.
+func (h *histogram) Merge(o *histogram) {
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.total += o.total
+	h.sum += o.sum
+	h.min = min(h.min, o.min)
+	h.max = max(h.max, o.max)
+}
+
+func (h *histogram) Count() int64 { return h.total }
+
+// Quantile returns the latency at or under which a fraction q of the
+// recorded values fall.
+func (h *histogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.total)))
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= max(rank, 1) {
+			return time.Duration(min(bucketHigh(i), h.max)) * time.Microsecond
+		}
+	}
+	return time.Duration(h.max) * time.Microsecond
+}
+
+func (h *histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum/float64(h.total)) * time.Microsecond
+}
+
+func (h *histogram) Max() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.max) * time.Microsecond
+}
+
+func (h *histogram) Min() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.min) * time.Microsecond
+}
+
+// CountBetween returns how many values fell in [lo, hi).
+func (h *histogram) CountBetween(lo, hi time.Duration) int64 {
+	var n int64
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		v := time.Duration(bucketHigh(i)) * time.Microsecond
+		if v >= lo && v < hi {
+			n += c
+		}
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package loadtest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuckets(t *testing.T) {
+	prev := -1
+	for v := int64(0); v < 1<<22; v += 1 + v/300 {
+		i := bucketOf(v)
+		if i < prev {
+			t.Fatalf("bucketOf(%d) = %d, below bucketOf of a smaller value (%d)", v, i, prev)
+		}
+		prev = i
+		high := bucketHigh(i)
+		if high < v || i > 0 && bucketHigh(i-1) >= v {
+			t.Fatalf("%d in bucket %d, which ends at %d after %d", v, i, high, bucketHigh(i-1))
+		}
+		if float64(high-v) > float64(v)/subBuckets {
+			t.Fatalf("bucket of %d ends at %d, over 1/%d away", v, high, subBuckets)
+		}
+	}
+	// The largest value that has a bucket of its own lands in the last one.
+	largest := int64(1)<<(maxShift+subBucketBits+1) - 1
+	if got, want := bucketOf(largest), subBuckets*(maxShift+2)-1; got != want {
+		t.Errorf("bucketOf(%d) = %d, want %d", largest, got, want)
+	}
+	if bucketHigh(bucketOf(largest)) != largest {
+		t.Errorf("last bucket ends at %d", bucketHigh(bucketOf(largest)))
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	if h.Quantile(0.5) != 0 || h.Mean() != 0 || h.Min() != 0 || h.Max() != 0 {
+		t.Error("empty histogram is not all zeros")
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	h.Record(-time.Second)
+	h.Record(1000 * time.Hour)
+
+	within := func(got, want time.Duration) bool {
+		return got >= want && got-want <= want/subBuckets
+	}
+	for q, want := range map[float64]time.Duration{0.5: 500 * time.Millisecond, 0.9: 900 * time.Millisecond, 0.99: 990 * time.Millisecond} {
+		if got := h.Quantile(q); !within(got, want) {
+			t.Errorf("Quantile(%v) = %v, want %v", q, got, want)
+		}
+	}
+	if h.Quantile(0) != 0 || h.Quantile(1) != 1000*time.Hour || h.Count() != 1002 {
+		t.Errorf("Quantile(0) = %v, Quantile(1) = %v, Count = %d", h.Quantile(0), h.Quantile(1), h.Count())
+	}
+	if h.Min() != 0 || h.Max() != 1000*time.Hour {
+		t.Errorf("min %v, max %v", h.Min(), h.Max())
+	}
+
+	o := newHistogram()
+	o.Record(3 * time.Millisecond)
+	o.Record(4 * time.Millisecond)
+	if o.Mean() != 3500*time.Microsecond {
+		t.Errorf("mean %v", o.Mean())
+	}
+	h.Merge(o)
+	if h.Count() != 1004 {
+		t.Errorf("merged count %d", h.Count())
+	}
+	if got := h.CountBetween(time.Millisecond, 5*time.Millisecond); got != 6 {
+		t.Errorf("%d values in [1ms, 5ms), want 6", got)
+	}
+}
@@ -0,0 +1,262 @@
+// Package loadtest generates HTTP load to find where latency degrades. In
+// the open model requests arrive at a set rate whatever the server does, as
+// users' do; in the closed model a set number of clients each wait for a
+// response before sending the next request. Latency is measured from when
+// a request was meant to be sent, so a server that stalls is charged for
+// the requests that queued behind the stall (coordinated omission).
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Models.
+const (
+	Open   = "open"
+	Closed = "closed"
+)
+
+// Stage moves the load linearly from the previous stage's target (zero
+// before the first) to Target over Duration. Target is requests per second
+// in the open model and concurrent clients in the closed model.
+type Stage struct {
+	Duration time.Duration
+	Target   float64
+}
+
+// ParseStages reads stages written as duration:target, comma-separated,
+// e.g. 30s:50,2m:50,30s:200.
+func ParseStages(s string) ([]Stage, error) {
+	var out []Stage
+	for _, part := range strings.Split(s, ",") {
+		d, t, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("stage %q is not duration:target", part)
+		}
+		dur, err := time.ParseDuration(d)
+		if err != nil || dur < 0 {
+			return nil, fmt.Errorf("stage %q: bad duration", part)
+		}
+		target, err := strconv.ParseFloat(t, 64)
+		if err != nil || target < 0 {
+			return nil, fmt.Errorf("stage %q: bad target", part)
+		}
+		out = append(out, Stage{dur, target})
+	}
+	return out, nil
+}
+
+// level returns the target at elapsed time t and whether the test is still
+// running.
+func level(stages []Stage, t time.Duration) (float64, bool) {
+	prev := 0.0
+	for _, s := range stages {
+		if t < s.Duration {
+			return prev + (s.Target-prev)*float64(t)/float64(s.Duration), true
+		}
+		t -= s.Duration
+		prev = s.Target
+	}
+	return prev, false
+}
+
+func totalDuration(stages []Stage) time.Duration {
+	var d time.Duration
+	for _, s := range stages {
+		d += s.Duration
+	}
+	return d
+}
+
+// Options configure a run.
+type Options struct {
+	Model  string
+	Stages []Stage
+	// Rate paces each client in the closed model so that together they
+	// send this many requests per second; zero sends back to back.
+	Rate float64
+	// MaxInFlight bounds concurrent requests in the open model. Arrivals
+	// past it wait, and their wait counts towards their latency.
+	MaxInFlight int
+	Timeout     time.Duration
+	// Progress, if set, receives a line every ProgressInterval.
+	Progress         io.Writer
+	ProgressInterval time.Duration
+}
+
+// Run generates load from mix until the stages end or ctx is done.
+func Run(ctx context.Context, mix *Mix, opts Options) *Report {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 1000
+	}
+	peak := 0.0
+	for _, s := range opts.Stages {
+		peak = max(peak, s.Target)
+	}
+	conns := opts.MaxInFlight
+	if opts.Model == Closed {
+		conns = int(peak + 0.5)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = conns
+	transport.MaxIdleConnsPerHost = conns
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	c := newCollector(mix, opts)
+	ctx, cancel := context.WithTimeout(ctx, totalDuration(opts.Stages))
+	defer cancel()
+	if opts.Progress != nil {
+		go c.progress(ctx, opts.Progress, opts.ProgressInterval)
+	}
+	send := func(intended time.Time) {
+		r := mix.pick()
+		start := time.Now()
+		status, err := do(client, r)
+		c.record(r, intended, start, time.Now(), status, err)
+	}
+	if opts.Model == Closed {
+		runClosed(ctx, c.start, opts, int(peak+0.5), send)
+	} else {
+		runOpen(ctx, c.start, opts, send)
+	}
+	return c.report(time.Now())
+}
+
+// runOpen sends requests at the stages' rate, each from its own goroutine.
+func runOpen(ctx context.Context, start time.Time, opts Options, send func(time.Time)) {
+	sem := make(chan struct{}, opts.MaxInFlight)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	next := start
+	for {
+		rate, running := level(opts.Stages, next.Sub(start))
+		if !running {
+			return
+		}
+		if rate < 0.1 {
+			// Ramping up from nothing: look again shortly.
+			next = next.Add(10 * time.Millisecond)
+			continue
+		}
+		if d := time.Until(next); d > 0 {
+			sleep(ctx, d)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(intended time.Time) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			send(intended)
+		}(next)
+		next = next.Add(time.Duration(float64(time.Second) / rate))
+	}
+}
+
+// runClosed runs clients goroutines, of which the first level(t) send
+// requests back to back, or paced to opts.Rate in total.
+func runClosed(ctx context.Context, start time.Time, opts Options, clients int, send func(time.Time)) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var next time.Time
+			for ctx.Err() == nil {
+				active, running := level(opts.Stages, time.Since(start))
+				if !running {
+					return
+				}
+				if float64(i) >= active {
+					next = time.Time{}
+					sleep(ctx, 10*time.Millisecond)
+					continue
+				}
+				intended := time.Now()
+				if opts.Rate > 0 {
+					if next.IsZero() {
+						next = intended
+					}
+					if d := time.Until(next); d > 0 {
+						sleep(ctx, d)
+					}
+					intended = next
+					next = next.Add(time.Duration(active / opts.Rate * float64(time.Second)))
+				}
+				if ctx.Err() == nil {
+					send(intended)
+				}
+			}
+		}()
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// do sends r and reads the whole response.
+func do(client *http.Client, r *Request) (int, error) {
+	req, err := http.NewRequest(r.Method, r.target.String(), r.body())
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "goaws-loadtest")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, err
+}
+
+// errorKind sorts transport errors into a few kinds for the report.
+func errorKind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection reset"
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.EADDRNOTAVAIL):
+		return "client out of sockets"
+	}
+	return "other"
+}
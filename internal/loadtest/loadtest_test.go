@@ -0,0 +1,202 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseStages(t *testing.T) {
+	got, err := ParseStages("30s:50, 2m:50,0s:200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Stage{{30 * time.Second, 50}, {2 * time.Minute, 50}, {0, 200}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ParseStages = %v, want %v", got, want)
+	}
+	for _, s := range []string{"", "30s", "x:1", "-1s:1", "1s:-1", "1s:x"} {
+		if _, err := ParseStages(s); err == nil {
+			t.Errorf("ParseStages(%q) accepted", s)
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	stages := []Stage{{10 * time.Second, 100}, {10 * time.Second, 100}, {0, 20}, {10 * time.Second, 0}}
+	for _, tc := range []struct {
+		at      time.Duration
+		want    float64
+		running bool
+	}{
+		{0, 0, true},
+		{5 * time.Second, 50, true},
+		{15 * time.Second, 100, true},
+		{20 * time.Second, 20, true},
+		{25 * time.Second, 10, true},
+		{30 * time.Second, 0, false},
+	} {
+		if got, running := level(stages, tc.at); got != tc.want || running != tc.running {
+			t.Errorf("level at %v = %v, %v; want %v, %v", tc.at, got, running, tc.want, tc.running)
+		}
+	}
+	if totalDuration(stages) != 30*time.Second {
+		t.Errorf("total %v", totalDuration(stages))
+	}
+}
+
+func testTarget(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	var n atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.Add(1)
+		if r.UserAgent() != "goaws-loadtest" || r.Header.Get("X-Test") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+// lockedWriter collects progress lines, which Run may still be writing
+// from its own goroutine as it returns.
+type lockedWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *lockedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestRunOpen(t *testing.T) {
+	srv, served := testTarget(t)
+	h := map[string]string{"X-Test": "1"}
+	mix, err := NewMix(srv.URL, []*Request{{Name: "ok", URL: "/", Headers: h, Weight: 3}, {Name: "fail", URL: "/fail", Headers: h}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := &lockedWriter{}
+	rep := Run(context.Background(), mix, Options{
+		Model:    Open,
+		Stages:   []Stage{{time.Second, 200}},
+		Progress: progress, ProgressInterval: 300 * time.Millisecond,
+	})
+
+	// Ramping from 0 to 200 req/s over a second sends about 100 requests.
+	if rep.Requests < 70 || rep.Requests > 110 || rep.Requests != served.Load() {
+		t.Errorf("%d requests, %d served", rep.Requests, served.Load())
+	}
+	if rep.Statuses[200]+rep.Statuses[503] != rep.Requests || rep.Errors != rep.Statuses[503] || rep.Errors == 0 {
+		t.Errorf("statuses %v, %d errors", rep.Statuses, rep.Errors)
+	}
+	if len(rep.ByRequest) != 2 || rep.ByRequest[0].Name != "ok" || rep.ByRequest[1].Errors != rep.ByRequest[1].Requests {
+		t.Errorf("by request %+v", rep.ByRequest)
+	}
+	if len(rep.Timeline) != 1 || rep.Timeline[0].Target != 100 || rep.Timeline[0].Requests != rep.Requests {
+		t.Errorf("timeline %+v", rep.Timeline)
+	}
+	if rep.Response.Count != rep.Requests || rep.Response.P99 < rep.Service.P50 || len(rep.Distribution) == 0 {
+		t.Errorf("response %+v, service %+v, distribution %v", rep.Response, rep.Service, rep.Distribution)
+	}
+	if !strings.Contains(progress.String(), "req/s") {
+		t.Errorf("progress %q", progress.String())
+	}
+}
+
+func TestRunClosedPaced(t *testing.T) {
+	srv, served := testTarget(t)
+	mix, err := NewMix(srv.URL, []*Request{{URL: "/", Headers: map[string]string{"X-Test": "1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Four clients share 40 req/s, so each sends every 100ms.
+	rep := Run(context.Background(), mix, Options{Model: Closed, Stages: []Stage{{0, 4}, {time.Second, 4}}, Rate: 40})
+	if rep.Requests < 30 || rep.Requests > 44 || rep.Requests != served.Load() || rep.Errors != 0 {
+		t.Errorf("%d requests, %d served, %d errors", rep.Requests, served.Load(), rep.Errors)
+	}
+}
+
+func TestRunCountsTransportErrors(t *testing.T) {
+	srv, _ := testTarget(t)
+	mix, err := NewMix(srv.URL, []*Request{{URL: "/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	rep := Run(context.Background(), mix, Options{Model: Closed, Stages: []Stage{{0, 1}, {100 * time.Millisecond, 1}}, Rate: 50})
+	if rep.Requests == 0 || rep.ErrorKinds["connection refused"] != rep.Requests || rep.Errors != rep.Requests || len(rep.Statuses) != 0 {
+		t.Errorf("%d requests, %d errors, kinds %v", rep.Requests, rep.Errors, rep.ErrorKinds)
+	}
+}
+
+func TestResponseTimeIncludesQueueing(t *testing.T) {
+	mix, err := NewMix("http://example.com", []*Request{{URL: "/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCollector(mix, Options{Model: Open, Stages: []Stage{{10 * time.Second, 10}}})
+	// The request was due at 2s but only went out at 3s, behind a stall.
+	intended := c.start.Add(2 * time.Second)
+	c.record(mix.Requests[0], intended, intended.Add(time.Second), intended.Add(time.Second+10*time.Millisecond), 200, nil)
+	rep := c.report(c.start.Add(4 * time.Second))
+	if rep.Response.Max != 1010 || rep.Service.Max != 10 {
+		t.Errorf("response %v ms, service %v ms", rep.Response.Max, rep.Service.Max)
+	}
+	if len(rep.Timeline) != 3 || rep.Timeline[2].Requests != 1 || rep.Timeline[2].Target != 2.5 {
+		t.Errorf("timeline %+v", rep.Timeline)
+	}
+	if rep.Throughput != 0.25 {
+		t.Errorf("throughput %v", rep.Throughput)
+	}
+	if last := rep.Distribution[len(rep.Distribution)-1]; last.UpToMS != 2000 || last.Count != 1 {
+		t.Errorf("distribution %v", rep.Distribution)
+	}
+
+	var out bytes.Buffer
+	if err := rep.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"open model, 1 requests in 4.0s, 0.2 req/s, 0 errors (0.00%)", "response      1010.0", "≤ 2s", "200     1"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	for err, want := range map[error]string{
+		context.DeadlineExceeded:                        "timeout",
+		fmt.Errorf("dial: %w", syscall.ECONNREFUSED):    "connection refused",
+		io.ErrUnexpectedEOF:                             "connection reset",
+		fmt.Errorf("socket: %w", syscall.EADDRNOTAVAIL): "client out of sockets",
+		errors.New("tls: bad certificate"):              "other",
+	} {
+		if got := errorKind(err); got != want {
+			t.Errorf("errorKind(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
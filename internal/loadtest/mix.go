@@ -0,0 +1,160 @@
+package loadtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Request is one kind of request in a mix. A mix file is either a JSON
+// array of them:
+//
+//	[{"name": "home", "url": "/", "weight": 8},
+//	 {"method": "POST", "url": "/api/items", "weight": 1,
+//	  "headers": {"Content-Type": "application/json"}, "body": "{\"n\": 1}"}]
+//
+// or a JSONL capture with one request per line, such as an access log
+// written with ACCESS_LOG_FORMAT=json. Lines of a capture are grouped by
+// method, URI and body, and each group is weighted by how often it occurs
+// and named after its route when the line has one.
+type Request struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Weight  float64           `json:"weight"`
+
+	// URI and Route are read from access log lines.
+	URI   string `json:"uri"`
+	Route string `json:"route"`
+
+	target *url.URL
+}
+
+// Mix picks requests at random in proportion to their weights.
+type Mix struct {
+	Requests []*Request
+	// cum holds the running total of the weights.
+	cum []float64
+}
+
+// LoadMix reads a mix file.
+func LoadMix(path string) ([]*Request, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		var reqs []*Request
+		if err := json.Unmarshal(trimmed, &reqs); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		return reqs, nil
+	}
+
+	groups := map[string]*Request{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var r Request
+		if err := json.Unmarshal(line, &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		key := r.Method + " " + r.URL + r.URI + "\x00" + r.Body
+		if g, ok := groups[key]; ok {
+			g.Weight += max(r.Weight, 1)
+			continue
+		}
+		r.Weight = max(r.Weight, 1)
+		if r.Name == "" && r.Route != "" {
+			r.Name = r.Route
+		}
+		groups[key] = &r
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	reqs := make([]*Request, 0, len(groups))
+	for _, r := range groups {
+		reqs = append(reqs, r)
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Weight > reqs[j].Weight })
+	return reqs, nil
+}
+
+// NewMix resolves the requests' URLs against base and validates them.
+func NewMix(base string, reqs []*Request) (*Mix, error) {
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("empty request mix")
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("base URL: %w", err)
+	}
+	m := &Mix{Requests: reqs}
+	total := 0.0
+	for i, r := range reqs {
+		if r.Method == "" {
+			r.Method = "GET"
+		}
+		raw := r.URL
+		if raw == "" {
+			raw = r.URI
+		}
+		ref, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %w", i+1, err)
+		}
+		r.target = baseURL.ResolveReference(ref)
+		if r.target.Scheme != "http" && r.target.Scheme != "https" || r.target.Host == "" {
+			return nil, fmt.Errorf("request %d: %q is not an absolute URL and there is no -url", i+1, raw)
+		}
+		if r.Name == "" {
+			r.Name = r.Method + " " + r.target.Path
+		}
+		if r.Weight <= 0 {
+			r.Weight = 1
+		}
+		total += r.Weight
+		m.cum = append(m.cum, total)
+	}
+	return m, nil
+}
+
+// pick returns a request chosen by weight.
+func (m *Mix) pick() *Request {
+	if len(m.Requests) == 1 {
+		return m.Requests[0]
+	}
+	x := rand.Float64() * m.cum[len(m.cum)-1]
+	i := sort.SearchFloat64s(m.cum, x)
+	return m.Requests[min(i, len(m.Requests)-1)]
+}
+
+// names lists the distinct request names in mix order.
+func (m *Mix) names() []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, r := range m.Requests {
+		if !seen[r.Name] {
+			seen[r.Name] = true
+			out = append(out, r.Name)
+		}
+	}
+	return out
+}
+
+func (r *Request) body() *strings.Reader {
+	return strings.NewReader(r.Body)
+}
@@ -0,0 +1,100 @@
+package loadtest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeMix(t *testing.T, doc string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mix")
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMixArray(t *testing.T) {
+	reqs, err := LoadMix(writeMix(t, ` [{"name": "home", "url": "/", "weight": 8}, {"method": "POST", "url": "/api/items", "body": "{}"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 || reqs[0].Name != "home" || reqs[0].Weight != 8 || reqs[1].Body != "{}" {
+		t.Errorf("requests %+v %+v", reqs[0], reqs[1])
+	}
+	if _, err := LoadMix(writeMix(t, `[{"url": 1}]`)); err == nil {
+		t.Error("bad array loaded")
+	}
+}
+
+func TestLoadMixCapture(t *testing.T) {
+	reqs, err := LoadMix(writeMix(t, `{"method": "GET", "uri": "/items/1", "route": "GET /items/{id}"}
+{"method": "GET", "uri": "/"}
+
+{"method": "GET", "uri": "/items/1", "route": "GET /items/{id}"}
+{"method": "GET", "uri": "/items/1", "route": "GET /items/{id}"}
+{"method": "POST", "uri": "/items/1", "body": "a"}
+{"method": "POST", "uri": "/items/1", "body": "b"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 4 {
+		t.Fatalf("%d groups, want 4", len(reqs))
+	}
+	if reqs[0].Name != "GET /items/{id}" || reqs[0].Weight != 3 || reqs[1].Weight != 1 {
+		t.Errorf("heaviest %+v, next %+v", reqs[0], reqs[1])
+	}
+	if _, err := LoadMix(writeMix(t, "{\"uri\": \"/\"}\nnot json\n")); err == nil || !strings.Contains(err.Error(), "mix:2:") {
+		t.Errorf("bad line: %v", err)
+	}
+}
+
+func TestNewMix(t *testing.T) {
+	m, err := NewMix("http://example.com/base/", []*Request{{URL: "items", Weight: 3}, {URI: "/other"}, {URL: "https://other.example/x", Name: "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := m.Requests
+	if r[0].target.String() != "http://example.com/base/items" || r[0].Name != "GET /base/items" || r[0].Method != "GET" {
+		t.Errorf("first %+v %s", r[0], r[0].target)
+	}
+	if r[1].target.String() != "http://example.com/other" || r[1].Weight != 1 || r[2].target.Host != "other.example" {
+		t.Errorf("others %s %v %s", r[1].target, r[1].Weight, r[2].target)
+	}
+	if got := m.names(); len(got) != 3 || got[2] != "x" {
+		t.Errorf("names %q", got)
+	}
+
+	for _, tc := range []struct {
+		base string
+		reqs []*Request
+	}{
+		{"http://example.com", nil},
+		{"", []*Request{{URL: "/"}}},
+		{"ftp://example.com", []*Request{{URL: "/"}}},
+		{"http://example.com", []*Request{{URL: "%zz"}}},
+	} {
+		if _, err := NewMix(tc.base, tc.reqs); err == nil {
+			t.Errorf("NewMix(%q, %v) accepted", tc.base, tc.reqs)
+		}
+	}
+}
+
+func TestPickFollowsWeights(t *testing.T) {
+	m, err := NewMix("http://example.com", []*Request{{URL: "/a", Weight: 9}, {URL: "/b", Weight: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := 0
+	for range 10000 {
+		if m.pick() == m.Requests[0] {
+			a++
+		}
+	}
+	if a < 8700 || a > 9300 {
+		t.Errorf("/a picked %d times in 10000, want about 9000", a)
+	}
+}
@@ -0,0 +1,286 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// collector aggregates request outcomes. Latency is kept twice: response
+// time runs from when the request was meant to be sent, service time from
+// when it actually was.
+type collector struct {
+	opts  Options
+	start time.Time
+
+	mu       sync.Mutex
+	response *histogram
+	service  *histogram
+	byName   map[string]*nameStats
+	statuses map[int]int64
+	errors   map[string]int64
+	seconds  []*second
+}
+
+type nameStats struct {
+	requests, errors int64
+	response         *histogram
+}
+
+type second struct {
+	requests, errors int64
+	response         *histogram
+}
+
+func newCollector(mix *Mix, opts Options) *collector {
+	c := &collector{
+		opts:     opts,
+		start:    time.Now(),
+		response: newHistogram(),
+		service:  newHistogram(),
+		byName:   map[string]*nameStats{},
+		statuses: map[int]int64{},
+		errors:   map[string]int64{},
+	}
+	for _, name := range mix.names() {
+		c.byName[name] = &nameStats{response: newHistogram()}
+	}
+	return c
+}
+
+func (c *collector) record(r *Request, intended, start, end time.Time, status int, err error) {
+	response, service := end.Sub(intended), end.Sub(start)
+	failed := err != nil || status >= 500
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.response.Record(response)
+	c.service.Record(service)
+	if err != nil {
+		c.errors[errorKind(err)]++
+	} else {
+		c.statuses[status]++
+	}
+	ns := c.byName[r.Name]
+	ns.requests++
+	ns.response.Record(response)
+	// Requests are counted in the second they were meant to be sent.
+	i := max(int(intended.Sub(c.start)/time.Second), 0)
+	for len(c.seconds) <= i {
+		c.seconds = append(c.seconds, &second{response: newHistogram()})
+	}
+	s := c.seconds[i]
+	s.requests++
+	s.response.Record(response)
+	if failed {
+		ns.errors++
+		s.errors++
+	}
+}
+
+// progress writes a line for each interval until ctx is done.
+func (c *collector) progress(ctx context.Context, w io.Writer, interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	var lastReqs, lastErrs int64
+	last := c.start
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			c.mu.Lock()
+			reqs, errs := c.response.Count(), c.errors5xx()
+			p99 := c.response.Quantile(0.99)
+			c.mu.Unlock()
+			target, _ := level(c.opts.Stages, now.Sub(c.start))
+			fmt.Fprintf(w, "%6s  target %-8.1f %8.1f req/s  %d errors  p99 %s\n",
+				now.Sub(c.start).Round(time.Second), target,
+				float64(reqs-lastReqs)/now.Sub(last).Seconds(), errs-lastErrs, p99)
+			lastReqs, lastErrs, last = reqs, errs, now
+		}
+	}
+}
+
+// errors5xx counts transport errors and 5xx responses. The caller holds
+// c.mu.
+func (c *collector) errors5xx() int64 {
+	var n int64
+	for _, v := range c.errors {
+		n += v
+	}
+	for code, v := range c.statuses {
+		if code >= 500 {
+			n += v
+		}
+	}
+	return n
+}
+
+// Latency summarises a histogram, in milliseconds.
+type Latency struct {
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	P999  float64 `json:"p99_9"`
+	Max   float64 `json:"max"`
+	Count int64   `json:"count"`
+}
+
+func latencyOf(h *histogram) Latency {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return Latency{
+		Mean:  ms(h.Mean()),
+		P50:   ms(h.Quantile(0.5)),
+		P90:   ms(h.Quantile(0.9)),
+		P99:   ms(h.Quantile(0.99)),
+		P999:  ms(h.Quantile(0.999)),
+		Max:   ms(h.Max()),
+		Count: h.Count(),
+	}
+}
+
+// Bucket is one bar of the latency distribution.
+type Bucket struct {
+	UpToMS float64 `json:"up_to_ms"`
+	Count  int64   `json:"count"`
+}
+
+// NameReport is the outcome for one request of the mix.
+type NameReport struct {
+	Name     string  `json:"name"`
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	Response Latency `json:"response_ms"`
+}
+
+// SecondReport is one second of the run.
+type SecondReport struct {
+	Second   int     `json:"second"`
+	Target   float64 `json:"target"`
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	P50MS    float64 `json:"p50_ms"`
+	P99MS    float64 `json:"p99_ms"`
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Model      string  `json:"model"`
+	DurationS  float64 `json:"duration_s"`
+	Requests   int64   `json:"requests"`
+	Errors     int64   `json:"errors"`
+	Throughput float64 `json:"throughput"`
+	// Response is corrected for coordinated omission; Service is not.
+	Response     Latency          `json:"response_ms"`
+	Service      Latency          `json:"service_ms"`
+	Distribution []Bucket         `json:"distribution"`
+	Statuses     map[int]int64    `json:"statuses"`
+	ErrorKinds   map[string]int64 `json:"error_kinds,omitempty"`
+	ByRequest    []NameReport     `json:"by_request"`
+	Timeline     []SecondReport   `json:"timeline"`
+}
+
+// distributionBounds are the upper bounds of the distribution's bars.
+var distributionBounds = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, time.Hour,
+}
+
+func (c *collector) report(end time.Time) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elapsed := end.Sub(c.start)
+	rep := &Report{
+		Model:      c.opts.Model,
+		DurationS:  elapsed.Seconds(),
+		Requests:   c.response.Count(),
+		Errors:     c.errors5xx(),
+		Throughput: float64(c.response.Count()) / elapsed.Seconds(),
+		Response:   latencyOf(c.response),
+		Service:    latencyOf(c.service),
+		Statuses:   c.statuses,
+		ErrorKinds: c.errors,
+	}
+	lo := time.Duration(0)
+	for _, hi := range distributionBounds {
+		if rep.Requests == 0 || lo > c.response.Max() {
+			break
+		}
+		rep.Distribution = append(rep.Distribution, Bucket{float64(hi.Microseconds()) / 1000, c.response.CountBetween(lo, hi)})
+		lo = hi
+	}
+	for name, ns := range c.byName {
+		rep.ByRequest = append(rep.ByRequest, NameReport{name, ns.requests, ns.errors, latencyOf(ns.response)})
+	}
+	sort.Slice(rep.ByRequest, func(i, j int) bool { return rep.ByRequest[i].Requests > rep.ByRequest[j].Requests })
+	for i, s := range c.seconds {
+		target, _ := level(c.opts.Stages, time.Duration(i)*time.Second+time.Second/2)
+		rep.Timeline = append(rep.Timeline, SecondReport{
+			Second:   i,
+			Target:   target,
+			Requests: s.requests,
+			Errors:   s.errors,
+			P50MS:    float64(s.response.Quantile(0.5).Microseconds()) / 1000,
+			P99MS:    float64(s.response.Quantile(0.99).Microseconds()) / 1000,
+		})
+	}
+	return rep
+}
+
+// WriteText prints the report as aligned tables.
+func (rep *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s model, %d requests in %.1fs, %.1f req/s, %d errors (%.2f%%)\n",
+		rep.Model, rep.Requests, rep.DurationS, rep.Throughput, rep.Errors, 100*float64(rep.Errors)/float64(max(rep.Requests, 1)))
+
+	fmt.Fprintln(tw, "\nlatency (ms)\tmean\tp50\tp90\tp99\tp99.9\tmax")
+	for _, l := range []struct {
+		name string
+		l    Latency
+	}{{"response", rep.Response}, {"service", rep.Service}} {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\n", l.name, l.l.Mean, l.l.P50, l.l.P90, l.l.P99, l.l.P999, l.l.Max)
+	}
+
+	fmt.Fprintln(tw, "\nresponse time\trequests\t")
+	for _, b := range rep.Distribution {
+		bar := strings.Repeat("#", int(40*float64(b.Count)/float64(max(rep.Requests, 1))+0.5))
+		fmt.Fprintf(tw, "≤ %s\t%d\t%s\n", time.Duration(b.UpToMS*float64(time.Millisecond)), b.Count, bar)
+	}
+
+	fmt.Fprintln(tw, "\nstatus\trequests")
+	codes := make([]int, 0, len(rep.Statuses))
+	for code := range rep.Statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(tw, "%d\t%d\n", code, rep.Statuses[code])
+	}
+	for kind, n := range rep.ErrorKinds {
+		fmt.Fprintf(tw, "%s\t%d\n", kind, n)
+	}
+
+	if len(rep.ByRequest) > 1 {
+		fmt.Fprintln(tw, "\nrequest\trequests\terrors\tp50\tp99")
+		for _, n := range rep.ByRequest {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.1f\n", n.Name, n.Requests, n.Errors, n.Response.P50, n.Response.P99)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nsecond\ttarget\trequests\terrors\tp50\tp99")
+	for _, s := range rep.Timeline {
+		fmt.Fprintf(tw, "%d\t%.1f\t%d\t%d\t%.1f\t%.1f\n", s.Second, s.Target, s.Requests, s.Errors, s.P50MS, s.P99MS)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,252 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"goaws/internal/env"
+	"goaws/internal/metrics"
+)
+
+var (
+	droppedRecords = metrics.NewCounter("log_records_dropped_total", "Log records discarded before reaching a sink.", "reason")
+	sampledRecords = metrics.NewCounter("log_records_sampled_total", "Repetitive log records suppressed by sampling.")
+)
+
+// AsyncConfig configures the logging pipeline. It is normally built by
+// AsyncConfigFromEnv.
+type AsyncConfig struct {
+	// Enabled routes records through the pipeline; when false they are
+	// written on the logging goroutine.
+	Enabled bool
+	// Buffer is the ring buffer's capacity in records.
+	Buffer int
+	// Overflow is "drop", which discards the oldest buffered record to
+	// make room, or "block", which makes the logging call wait.
+	Overflow string
+	// SampleFirst records per second are kept for each level and message;
+	// after that only every SampleThereafter-th, or none if it is 0.
+	// SampleFirst 0 turns sampling off.
+	SampleFirst      int
+	SampleThereafter int
+	// HealthChecks is what happens to records below warn logged while
+	// serving a load balancer health check: "drop", "sample" (one per
+	// minute per message) or "keep".
+	HealthChecks string
+}
+
+// AsyncConfigFromEnv reads LOG_ASYNC, LOG_BUFFER, LOG_OVERFLOW, LOG_SAMPLE_FIRST,
+// LOG_SAMPLE_THEREAFTER and LOG_HEALTHCHECKS.
+func AsyncConfigFromEnv() AsyncConfig {
+	return AsyncConfig{
+		Enabled:          env.Bool("LOG_ASYNC", true),
+		Buffer:           env.Int("LOG_BUFFER", 8192),
+		Overflow:         env.String("LOG_OVERFLOW", "drop"),
+		SampleFirst:      env.Int("LOG_SAMPLE_FIRST", 100),
+		SampleThereafter: env.Int("LOG_SAMPLE_THEREAFTER", 100),
+		HealthChecks:     env.String("LOG_HEALTHCHECKS", "drop"),
+	}
+}
+
+type entry struct {
+	h slog.Handler
+	r slog.Record
+}
+
+// Async is the shared state behind the handlers NewAsync returns: the ring
+// buffer, the samplers and the worker that drains into the wrapped handler.
+type Async struct {
+	cfg AsyncConfig
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	ring     []entry
+	head, n  int
+	closed   bool
+
+	sampler       *sampler
+	healthSampler *sampler
+}
+
+// NewAsync returns a handler that filters and samples records on the
+// calling goroutine and hands the survivors to h on a background one, so a
+// slow sink never stalls request handling. Run must be running for records
+// to be written.
+func NewAsync(h slog.Handler, cfg AsyncConfig) (slog.Handler, *Async) {
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = 8192
+	}
+	a := &Async{cfg: cfg, ring: make([]entry, cfg.Buffer)}
+	a.notEmpty = sync.NewCond(&a.mu)
+	a.notFull = sync.NewCond(&a.mu)
+	if cfg.SampleFirst > 0 {
+		a.sampler = newSampler(time.Second, cfg.SampleFirst, cfg.SampleThereafter)
+	}
+	a.healthSampler = newSampler(time.Minute, 1, 0)
+	metrics.NewGaugeFunc("log_records_buffered", "Log records waiting in the async buffer.", func() float64 {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return float64(a.n)
+	})
+	return &asyncHandler{a: a, h: h}, a
+}
+
+// Run writes buffered records until ctx is done, then drains the buffer
+// and returns. Records logged after that are written synchronously.
+func (a *Async) Run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() {
+		a.mu.Lock()
+		a.closed = true
+		a.notEmpty.Broadcast()
+		a.notFull.Broadcast()
+		a.mu.Unlock()
+	})
+	defer stop()
+	for {
+		a.mu.Lock()
+		for a.n == 0 && !a.closed {
+			a.notEmpty.Wait()
+		}
+		if a.n == 0 {
+			a.mu.Unlock()
+			return
+		}
+		e := a.ring[a.head]
+		a.ring[a.head] = entry{}
+		a.head = (a.head + 1) % len(a.ring)
+		a.n--
+		a.notFull.Signal()
+		a.mu.Unlock()
+
+		e.h.Handle(context.Background(), e.r)
+	}
+}
+
+func (a *Async) enqueue(h slog.Handler, r slog.Record) {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		h.Handle(context.Background(), r)
+		return
+	}
+	if a.n == len(a.ring) {
+		if a.cfg.Overflow == "block" {
+			for a.n == len(a.ring) && !a.closed {
+				a.notFull.Wait()
+			}
+		} else {
+			// Make room by discarding the oldest record.
+			a.ring[a.head] = entry{}
+			a.head = (a.head + 1) % len(a.ring)
+			a.n--
+			droppedRecords.With("overflow").Inc()
+		}
+	}
+	if a.closed {
+		a.mu.Unlock()
+		h.Handle(context.Background(), r)
+		return
+	}
+	a.ring[(a.head+a.n)%len(a.ring)] = entry{h, r}
+	a.n++
+	a.notEmpty.Signal()
+	a.mu.Unlock()
+}
+
+type asyncHandler struct {
+	a *Async
+	h slog.Handler
+}
+
+func (h *asyncHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.h.Enabled(ctx, l)
+}
+
+func (h *asyncHandler) Handle(ctx context.Context, r slog.Record) error {
+	a := h.a
+	if r.Level < slog.LevelWarn && isHealthCheck(ctx) {
+		switch a.cfg.HealthChecks {
+		case "drop":
+			droppedRecords.With("healthcheck").Inc()
+			return nil
+		case "sample":
+			if !a.healthSampler.allow(r.Message) {
+				droppedRecords.With("healthcheck").Inc()
+				return nil
+			}
+		}
+	}
+	if a.sampler != nil && !a.sampler.allow(r.Level.String()+"\xff"+r.Message) {
+		sampledRecords.With().Inc()
+		return nil
+	}
+	// The record's attributes may share memory with the caller's; clone
+	// before it crosses goroutines.
+	a.enqueue(h.h, r.Clone())
+	return nil
+}
+
+func (h *asyncHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &asyncHandler{a: h.a, h: h.h.WithAttrs(attrs)}
+}
+
+func (h *asyncHandler) WithGroup(name string) slog.Handler {
+	return &asyncHandler{a: h.a, h: h.h.WithGroup(name)}
+}
+
+// sampler keeps the first n records per key in each period, then every
+// thereafter-th.
+type sampler struct {
+	period     time.Duration
+	first      int
+	thereafter int
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+func newSampler(period time.Duration, first, thereafter int) *sampler {
+	return &sampler{period: period, first: first, thereafter: thereafter, counts: map[string]int{}}
+}
+
+func (s *sampler) allow(key string) bool {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.start) >= s.period {
+		// Start a new period; clearing also bounds the map to the keys
+		// seen within one period.
+		s.start = now
+		clear(s.counts)
+	}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// isHealthCheck reports whether ctx belongs to a request from a load
+// balancer health checker.
+func isHealthCheck(ctx context.Context) bool {
+	ri, ok := ctx.Value(requestKey{}).(*requestInfo)
+	return ok && ri.healthCheck
+}
+
+// healthCheckAgents are User-Agent prefixes of load balancer probes.
+var healthCheckAgents = []string{"ELB-HealthChecker/", "GoogleHC/", "kube-probe/"}
+
+func healthCheckAgent(ua string) bool {
+	for _, p := range healthCheckAgents {
+		if strings.HasPrefix(ua, p) {
+			return true
+		}
+	}
+	return false
+}
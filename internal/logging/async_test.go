@@ -0,0 +1,184 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func messages(h *captureHandler) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]string, len(h.records))
+	for i, r := range h.records {
+		out[i] = r["msg"].(string)
+	}
+	return out
+}
+
+func TestAsyncDeliversInOrderAndDrains(t *testing.T) {
+	sink := &captureHandler{}
+	h, a := NewAsync(sink, AsyncConfig{Buffer: 16, Overflow: "block"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx)
+	}()
+	log := slog.New(h)
+	for i := range 100 {
+		log.Info(fmt.Sprint(i), "i", i)
+	}
+	cancel()
+	<-done
+
+	got := messages(sink)
+	if len(got) != 100 {
+		t.Fatalf("delivered %d records, want 100", len(got))
+	}
+	for i, m := range got {
+		if m != fmt.Sprint(i) {
+			t.Fatalf("record %d is %q", i, m)
+		}
+	}
+	// Once stopped, records are written on the caller's goroutine.
+	log.Info("late")
+	if got := messages(sink); got[len(got)-1] != "late" {
+		t.Errorf("late record not written: %v", got[len(got)-5:])
+	}
+}
+
+func TestAsyncDropsOldestWhenFull(t *testing.T) {
+	sink := &captureHandler{}
+	h, a := NewAsync(sink, AsyncConfig{Buffer: 3, Overflow: "drop"})
+	log := slog.New(h)
+	for i := range 5 {
+		log.Info(fmt.Sprint(i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Run(ctx)
+	if got := fmt.Sprint(messages(sink)); got != "[2 3 4]" {
+		t.Errorf("delivered %s, want the newest three", got)
+	}
+}
+
+func TestAsyncBlocksWhenFull(t *testing.T) {
+	sink := &captureHandler{}
+	h, a := NewAsync(sink, AsyncConfig{Buffer: 1, Overflow: "block"})
+	log := slog.New(h)
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		for i := range 3 {
+			log.Info(fmt.Sprint(i))
+		}
+	}()
+	select {
+	case <-logged:
+		t.Fatal("logging did not wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx)
+	}()
+	<-logged
+	cancel()
+	<-done
+	if got := fmt.Sprint(messages(sink)); got != "[0 1 2]" {
+		t.Errorf("delivered %s", got)
+	}
+}
+
+func TestAsyncSamplesRepeatedMessages(t *testing.T) {
+	sink := &captureHandler{}
+	h, a := NewAsync(sink, AsyncConfig{Buffer: 64, SampleFirst: 2, SampleThereafter: 3})
+	log := slog.New(h)
+	for range 8 {
+		log.Info("same")
+	}
+	log.Warn("same") // levels are sampled separately
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Run(ctx)
+	// The first two, then the 5th and 8th.
+	if got := len(messages(sink)); got != 5 {
+		t.Errorf("kept %d records, want 5", got)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	s := newSampler(time.Hour, 2, 3)
+	var got []bool
+	for range 8 {
+		got = append(got, s.allow("k"))
+	}
+	if fmt.Sprint(got) != "[true true false false true false false true]" {
+		t.Errorf("allow = %v", got)
+	}
+	if !s.allow("other") {
+		t.Error("keys share a count")
+	}
+	s.start = s.start.Add(-time.Hour)
+	if !s.allow("k") {
+		t.Error("a new period did not reset the count")
+	}
+	if none := newSampler(time.Hour, 1, 0); !none.allow("k") || none.allow("k") {
+		t.Error("thereafter 0 should keep only the first")
+	}
+}
+
+func TestAsyncHealthChecks(t *testing.T) {
+	hc := context.WithValue(context.Background(), requestKey{}, &requestInfo{healthCheck: true})
+	for _, tc := range []struct {
+		mode string
+		want string
+	}{
+		{"drop", "[warn]"},
+		{"sample", "[probe warn]"},
+		{"keep", "[probe probe probe warn]"},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			sink := &captureHandler{}
+			h, a := NewAsync(sink, AsyncConfig{Buffer: 8, HealthChecks: tc.mode})
+			log := slog.New(h)
+			for range 3 {
+				log.InfoContext(hc, "probe")
+			}
+			log.WarnContext(hc, "warn")
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			a.Run(ctx)
+			if got := fmt.Sprint(messages(sink)); got != tc.want {
+				t.Errorf("kept %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHealthCheckAgent(t *testing.T) {
+	for ua, want := range map[string]bool{
+		"ELB-HealthChecker/2.0": true,
+		"kube-probe/1.29":       true,
+		"GoogleHC/1.0":          true,
+		"curl/8.5.0":            false,
+		"":                      false,
+	} {
+		if got := healthCheckAgent(ua); got != want {
+			t.Errorf("healthCheckAgent(%q) = %v", ua, got)
+		}
+	}
+}
+
+func TestTee(t *testing.T) {
+	a, b := &captureHandler{}, &captureHandler{}
+	slog.New(Tee(a, b)).Info("both")
+	if len(a.records) != 1 || len(b.records) != 1 {
+		t.Errorf("tee delivered %d and %d records", len(a.records), len(b.records))
+	}
+}
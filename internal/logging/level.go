@@ -0,0 +1,17 @@
+package logging
+
+import (
+	"log/slog"
+
+	"goaws/internal/env"
+)
+
+// LevelFromEnv returns LOG_LEVEL (debug, info, warn, error), defaulting to
+// info.
+func LevelFromEnv() slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(env.String("LOG_LEVEL", "info"))); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"goaws/internal/httpx"
+)
+
+type requestKey struct{}
+
+// requestInfo is the request's context: rather than wrapping the parent
+// with context.WithValue, it answers Value(requestKey{}) itself, and it
+// carries the response recorder, so that besides the request copy the
+// middleware allocates only this.
+type requestInfo struct {
+	context.Context
+	id string
+	// idHeader backs the X-Request-Id response header value.
+	idHeader [1]string
+	// routing is filled in with the request the mux routes, so the route
+	// is known by the time handlers log, whatever copies of the request
+	// the middleware in between made.
+	routing httpx.Routing
+	// healthCheck is set for load balancer probes, whose routine logging
+	// the async pipeline filters.
+	healthCheck bool
+	rec         httpx.Recorder
+}
+
+func (ri *requestInfo) Value(key any) any {
+	switch key.(type) {
+	case requestKey:
+		return ri
+	case httpx.RoutingKey:
+		return &ri.routing
+	}
+	return ri.Context.Value(key)
+}
+
+// RequestID returns the ID the middleware assigned to the request in ctx,
+// or "".
+func RequestID(ctx context.Context) string {
+	if ri, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		return ri.id
+	}
+	return ""
+}
+
+// Middleware gives every request an ID, taken from X-Request-Id, else the
+// ALB's X-Amzn-Trace-Id root, else random. The ID is echoed in the
+// X-Request-Id response header, and records logged with the request's
+// context (slog.InfoContext and friends) carry request_id and route through
+// ContextHandler. Every request is logged with its status when it
+// completes, at debug level unless it ended in a 5xx.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ri := &requestInfo{Context: r.Context(), id: requestID(r), healthCheck: healthCheckAgent(r.UserAgent())}
+		ri.idHeader[0] = ri.id
+		// The canonical key is assigned directly, as Header.Set would
+		// allocate a slice for the value.
+		w.Header()["X-Request-Id"] = ri.idHeader[:]
+		r = r.WithContext(ri)
+
+		rec, ok := w.(*httpx.Recorder)
+		if !ok {
+			ri.rec.Reset(w)
+			rec = &ri.rec
+		}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		logCompletion(r, rec.Status(), time.Since(start))
+	})
+}
+
+// logCompletion logs the request's outcome: at debug level, unless it
+// ended in a 5xx. The record carries request_id and route through
+// ContextHandler.
+func logCompletion(r *http.Request, status int, d time.Duration) {
+	level, msg := slog.LevelDebug, "request completed"
+	if status >= 500 {
+		level, msg = slog.LevelError, "request failed"
+	}
+	ctx := r.Context()
+	// The check comes first so that a disabled level costs nothing.
+	if l := slog.Default(); l.Enabled(ctx, level) {
+		l.LogAttrs(ctx, level, msg,
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("duration", d),
+		)
+	}
+}
+
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" && len(id) <= 128 && !strings.ContainsAny(id, "\r\n") {
+		return id
+	}
+	for rest := r.Header.Get("X-Amzn-Trace-Id"); rest != ""; {
+		var field string
+		field, rest, _ = strings.Cut(rest, ";")
+		if root, ok := strings.CutPrefix(strings.TrimSpace(field), "Root="); ok && root != "" {
+			return root
+		}
+	}
+	var b [12]byte
+	var id [24]byte
+	rand.Read(b[:])
+	hex.Encode(id[:], b[:])
+	return string(id[:])
+}
+
+// ContextHandler adds request_id and route from the record's context, so
+// sinks such as journald can index them.
+func ContextHandler(h slog.Handler) slog.Handler {
+	return contextHandler{h}
+}
+
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ri, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		r.AddAttrs(slog.String("request_id", ri.id))
+		if p := ri.routing.Pattern(); p != "" {
+			r.AddAttrs(slog.String("route", p))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"goaws/internal/httpx"
+)
+
+// captureHandler keeps the records it is given, with their attributes.
+type captureHandler struct {
+	mu      sync.Mutex
+	records []map[string]any
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	m := map[string]any{"msg": r.Message, "level": r.Level}
+	r.Attrs(func(a slog.Attr) bool {
+		m[a.Key] = a.Value.Any()
+		return true
+	})
+	h.mu.Lock()
+	h.records = append(h.records, m)
+	h.mu.Unlock()
+	return nil
+}
+
+func captureDefault(t *testing.T) *captureHandler {
+	h := &captureHandler{}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(ContextHandler(h)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return h
+}
+
+func TestMiddlewareLogsCompletion(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "handling")
+	})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	// The copy stands in for middleware that adds to the context.
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpx.Routes(mux).ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), struct{}{}, 1)))
+	}))
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		level  slog.Level
+		msg    string
+		route  any
+	}{
+		{"/ok", 200, slog.LevelDebug, "request completed", "GET /ok"},
+		{"/fail", 502, slog.LevelError, "request failed", "GET /fail"},
+		{"/missing", 404, slog.LevelDebug, "request completed", nil},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			c := captureDefault(t)
+			r := httptest.NewRequest("GET", tc.path, nil)
+			r.Header.Set("X-Request-Id", "req-1")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if got := w.Header().Get("X-Request-Id"); got != "req-1" {
+				t.Errorf("X-Request-Id = %q", got)
+			}
+
+			last := c.records[len(c.records)-1]
+			if last["msg"] != tc.msg || last["level"] != tc.level {
+				t.Errorf("record = %v", last)
+			}
+			if last["request_id"] != "req-1" || last["route"] != tc.route || last["status"] != int64(tc.status) {
+				t.Errorf("record = %v", last)
+			}
+			// Records logged by the handler carry the route too.
+			for _, rec := range c.records {
+				if rec["request_id"] != "req-1" || rec["route"] != tc.route {
+					t.Errorf("record = %v", rec)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"request id", map[string]string{"X-Request-Id": "abc", "X-Amzn-Trace-Id": "Root=1-2-3"}, "abc"},
+		{"alb root", map[string]string{"X-Amzn-Trace-Id": "Self=1-a-b; Root=1-67891233-abcdef;Sampled=1"}, "1-67891233-abcdef"},
+		{"header injection", map[string]string{"X-Request-Id": "a\nb", "X-Amzn-Trace-Id": "Root=r"}, "r"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			if got := requestID(r); got != tc.want {
+				t.Errorf("requestID = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if a, b := requestID(r), requestID(r); len(a) != 24 || a == b {
+		t.Errorf("random IDs %q, %q", a, b)
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+
+	"goaws/internal/env"
+)
+
+// Config selects and configures exporters. It is normally built by
+// ConfigFromEnv.
+type Config struct {
+	// Exporters lists the outputs: prometheus (the /metrics endpoint),
+	// emf, and statsd (configured by the statsd package).
+	Exporters []string
+
+	EMF EMFConfig
+}
+
+// ConfigFromEnv reads the METRICS_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Exporters: env.List("METRICS_EXPORTERS", []string{"prometheus"}),
+		EMF: EMFConfig{
+			Namespace:      env.String("METRICS_EMF_NAMESPACE", "srv"),
+			Interval:       env.Duration("METRICS_EMF_INTERVAL", time.Minute),
+			HighResolution: env.Bool("METRICS_EMF_HIGH_RESOLUTION", false),
+			Dimensions:     parseDimensions(env.List("METRICS_EMF_DIMENSIONS", nil)),
+		},
+	}
+}
+
+// Enabled reports whether the named exporter is selected.
+func (c Config) Enabled(name string) bool {
+	for _, e := range c.Exporters {
+		if strings.EqualFold(e, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDimensions(kvs []string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range kvs {
+		if k, v, ok := strings.Cut(kv, "="); ok && k != "" && v != "" {
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return m
+}
@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudWatch limits on a single EMF directive.
+const (
+	emfMaxMetrics    = 100
+	emfMaxDimensions = 30
+)
+
+// EMFConfig configures the CloudWatch Embedded Metric Format exporter.
+type EMFConfig struct {
+	Namespace string
+	Interval  time.Duration
+	// HighResolution stores metrics at one-second resolution, which
+	// CloudWatch bills as custom high-resolution metrics.
+	HighResolution bool
+	// Dimensions are added to every metric, e.g. Environment and
+	// InstanceId, ahead of the metric's own labels.
+	Dimensions map[string]string
+}
+
+// EMF periodically writes the registry as EMF JSON lines, which CloudWatch
+// Logs turns into metrics without any API calls. Counters and histogram
+// counts are written as the change since the previous flush, so CloudWatch
+// sums them correctly; gauges as their current value. Histograms are
+// reduced to _count and _sum.
+type EMF struct {
+	r   *Registry
+	w   io.Writer
+	cfg EMFConfig
+	log *slog.Logger
+
+	mu   sync.Mutex
+	prev map[string]float64
+}
+
+// NewEMF returns an exporter writing r to w: stdout for the CloudWatch
+// agent to pick the lines up, or a cwlogs.Shipper. Each line is a separate
+// Write, which the shipper sends as one log event.
+func NewEMF(r *Registry, w io.Writer, cfg EMFConfig) *EMF {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	return &EMF{r: r, w: w, cfg: cfg, log: slog.Default().With("component", "metrics"), prev: map[string]float64{}}
+}
+
+// Run flushes every interval until ctx is done, then once more.
+func (e *EMF) Run(ctx context.Context) {
+	t := time.NewTicker(e.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			if err := e.Flush(now); err != nil {
+				e.log.Warn("writing emf", "err", err)
+			}
+		case <-ctx.Done():
+			if err := e.Flush(time.Now()); err != nil {
+				e.log.Warn("writing emf", "err", err)
+			}
+			return
+		}
+	}
+}
+
+type emfMetric struct {
+	name  string
+	unit  string
+	value float64
+}
+
+// emfGroup is the set of metrics sharing one combination of dimensions;
+// each becomes one or more lines.
+type emfGroup struct {
+	dims       []Label
+	properties []Label
+	metrics    []emfMetric
+}
+
+// Flush writes one set of lines stamped with now.
+func (e *EMF) Flush(now time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	groups := map[string]*emfGroup{}
+	var order []string
+	add := func(labels []Label, m emfMetric) {
+		dims := e.dimensions(labels)
+		key := labelKey(dims)
+		g, ok := groups[key]
+		if !ok {
+			g = &emfGroup{dims: dims}
+			if n := len(dims); n > emfMaxDimensions {
+				g.dims, g.properties = dims[:emfMaxDimensions], dims[emfMaxDimensions:]
+			}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.metrics = append(g.metrics, m)
+	}
+
+	for _, f := range e.r.Gather() {
+		for _, s := range f.Samples {
+			switch f.Kind {
+			case KindCounter:
+				add(s.Labels, emfMetric{f.Name, "Count", e.delta(f.Name, s.Labels, s.Value)})
+			case KindGauge:
+				add(s.Labels, emfMetric{f.Name, unitFor(f.Name), s.Value})
+			case KindHistogram:
+				add(s.Labels, emfMetric{f.Name + "_count", "Count", e.delta(f.Name+"_count", s.Labels, float64(s.Count))})
+				add(s.Labels, emfMetric{f.Name + "_sum", unitFor(f.Name), e.delta(f.Name+"_sum", s.Labels, s.Value)})
+			}
+		}
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		for len(g.metrics) > 0 {
+			n := min(len(g.metrics), emfMaxMetrics)
+			line, err := e.line(now, g, g.metrics[:n])
+			if err != nil {
+				return err
+			}
+			if _, err := e.w.Write(append(line, '\n')); err != nil {
+				return err
+			}
+			g.metrics = g.metrics[n:]
+		}
+	}
+	return nil
+}
+
+// dimensions puts the configured dimensions, sorted by name, ahead of the
+// metric's labels.
+func (e *EMF) dimensions(labels []Label) []Label {
+	dims := make([]Label, 0, len(e.cfg.Dimensions)+len(labels))
+	for k, v := range e.cfg.Dimensions {
+		dims = append(dims, Label{k, v})
+	}
+	sort.Slice(dims, func(i, j int) bool { return dims[i].Name < dims[j].Name })
+	for _, l := range labels {
+		// CloudWatch rejects empty dimension values.
+		if l.Value == "" {
+			l.Value = "none"
+		}
+		dims = append(dims, l)
+	}
+	return dims
+}
+
+// delta returns how much a cumulative value grew since the last flush,
+// treating a decrease as a reset.
+func (e *EMF) delta(name string, labels []Label, v float64) float64 {
+	key := name + "\xff" + labelKey(labels)
+	prev, seen := e.prev[key]
+	e.prev[key] = v
+	if !seen || v < prev {
+		return v
+	}
+	return v - prev
+}
+
+func (e *EMF) line(now time.Time, g *emfGroup, metrics []emfMetric) ([]byte, error) {
+	dimNames := make([]string, len(g.dims))
+	for i, d := range g.dims {
+		dimNames[i] = d.Name
+	}
+	defs := make([]map[string]any, len(metrics))
+	for i, m := range metrics {
+		def := map[string]any{"Name": m.name, "Unit": m.unit}
+		if e.cfg.HighResolution {
+			def["StorageResolution"] = 1
+		}
+		defs[i] = def
+	}
+	doc := map[string]any{
+		"_aws": map[string]any{
+			"Timestamp": now.UnixMilli(),
+			"CloudWatchMetrics": []map[string]any{{
+				"Namespace":  e.cfg.Namespace,
+				"Dimensions": [][]string{dimNames},
+				"Metrics":    defs,
+			}},
+		},
+	}
+	for _, d := range g.dims {
+		doc[d.Name] = d.Value
+	}
+	for _, p := range g.properties {
+		doc[p.Name] = p.Value
+	}
+	for _, m := range metrics {
+		doc[m.name] = m.value
+	}
+	return json.Marshal(doc)
+}
+
+func labelKey(labels []Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte('=')
+		b.WriteString(l.Value)
+		b.WriteByte('\xff')
+	}
+	return b.String()
+}
+
+// unitFor infers a CloudWatch unit from Prometheus naming conventions.
+func unitFor(name string) string {
+	name = strings.TrimSuffix(name, "_total")
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return "Seconds"
+	case strings.HasSuffix(name, "_bytes"):
+		return "Bytes"
+	}
+	return "None"
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// lineWriter keeps each Write separately, as a cwlogs.Shipper would.
+type lineWriter struct{ writes []string }
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func (w *lineWriter) docs(t *testing.T) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, s := range w.writes {
+		if s[len(s)-1] != '\n' {
+			t.Errorf("write %q is not one line", s)
+		}
+		var doc map[string]any
+		if err := json.Unmarshal([]byte(s), &doc); err != nil {
+			t.Fatalf("write %q: %v", s, err)
+		}
+		out = append(out, doc)
+	}
+	return out
+}
+
+func directive(doc map[string]any) map[string]any {
+	return doc["_aws"].(map[string]any)["CloudWatchMetrics"].([]any)[0].(map[string]any)
+}
+
+func TestEMFFlush(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("http_requests_total", "", "code")
+	r.Gauge("queue_depth", "").With().Set(7)
+	latency := r.Histogram("http_request_seconds", "", []float64{1})
+	requests.With("200").Add(5)
+	latency.With().Observe(0.25)
+
+	w := &lineWriter{}
+	e := NewEMF(r, w, EMFConfig{Namespace: "srv", Dimensions: map[string]string{"Environment": "prod"}})
+	now := time.UnixMilli(1700000000000)
+	if err := e.Flush(now); err != nil {
+		t.Fatal(err)
+	}
+	docs := w.docs(t)
+	// One line per set of dimensions: code=200, and none for the rest.
+	if len(docs) != 2 {
+		t.Fatalf("%d lines: %q", len(docs), w.writes)
+	}
+	byDims := map[string]map[string]any{}
+	for _, d := range docs {
+		if d["_aws"].(map[string]any)["Timestamp"] != float64(now.UnixMilli()) {
+			t.Errorf("timestamp %v", d["_aws"])
+		}
+		dir := directive(d)
+		if dir["Namespace"] != "srv" {
+			t.Errorf("namespace %v", dir["Namespace"])
+		}
+		byDims[fmt.Sprint(dir["Dimensions"])] = d
+	}
+	counter := byDims["[[Environment code]]"]
+	if counter == nil || counter["http_requests_total"] != float64(5) || counter["code"] != "200" || counter["Environment"] != "prod" {
+		t.Errorf("counter line %v", counter)
+	}
+	rest := byDims["[[Environment]]"]
+	if rest == nil || rest["queue_depth"] != float64(7) || rest["http_request_seconds_count"] != float64(1) || rest["http_request_seconds_sum"] != 0.25 {
+		t.Fatalf("other line %v", rest)
+	}
+	units := map[string]string{}
+	for _, m := range directive(rest)["Metrics"].([]any) {
+		m := m.(map[string]any)
+		units[m["Name"].(string)] = m["Unit"].(string)
+	}
+	if units["http_request_seconds_sum"] != "Seconds" || units["http_request_seconds_count"] != "Count" || units["queue_depth"] != "None" {
+		t.Errorf("units %v", units)
+	}
+
+	// Counters are written as the increase since the last flush; gauges
+	// as they are.
+	requests.With("200").Add(2)
+	w.writes = nil
+	if err := e.Flush(now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range w.docs(t) {
+		if v, ok := d["http_requests_total"]; ok && v != float64(2) {
+			t.Errorf("counter delta %v, want 2", v)
+		}
+		if v, ok := d["queue_depth"]; ok && v != float64(7) {
+			t.Errorf("gauge %v, want 7", v)
+		}
+	}
+}
+
+func TestEMFSplitsLargeGroups(t *testing.T) {
+	r := NewRegistry()
+	for i := range emfMaxMetrics + 1 {
+		r.Gauge(fmt.Sprintf("g%03d", i), "").With().Set(1)
+	}
+	w := &lineWriter{}
+	if err := NewEMF(r, w, EMFConfig{HighResolution: true}).Flush(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	docs := w.docs(t)
+	if len(docs) != 2 {
+		t.Fatalf("%d lines, want 2", len(docs))
+	}
+	defs := directive(docs[0])["Metrics"].([]any)
+	if len(defs) != emfMaxMetrics || len(directive(docs[1])["Metrics"].([]any)) != 1 {
+		t.Errorf("split %d + %d", len(defs), len(directive(docs[1])["Metrics"].([]any)))
+	}
+	if defs[0].(map[string]any)["StorageResolution"] != float64(1) {
+		t.Errorf("metric %v lacks high resolution", defs[0])
+	}
+}
+
+func TestEMFDimensions(t *testing.T) {
+	e := NewEMF(NewRegistry(), nil, EMFConfig{Dimensions: map[string]string{"b": "2", "a": "1"}})
+	got := e.dimensions([]Label{{"code", ""}})
+	want := []Label{{"a", "1"}, {"b", "2"}, {"code", "none"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("dimensions = %v, want %v", got, want)
+	}
+}
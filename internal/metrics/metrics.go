@@ -0,0 +1,280 @@
+// Package metrics is a small in-process metrics registry: counters, gauges
+// and histograms with labels. Exporters read it through Gather; the
+// Prometheus text format is served by Handler.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is the type of a metric family.
+type Kind int
+
+const (
+	KindCounter Kind = iota
+	KindGauge
+	KindHistogram
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindCounter:
+		return "counter"
+	case KindGauge:
+		return "gauge"
+	}
+	return "histogram"
+}
+
+// DefBuckets suit request latencies in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families by name.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Default is the registry used by the package-level constructors.
+var Default = NewRegistry()
+
+type family struct {
+	name    string
+	help    string
+	kind    Kind
+	labels  []string
+	buckets []float64
+	fn      func() float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  atomicFloat
+	counts []atomic.Uint64
+	count  atomic.Uint64
+}
+
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (f *atomicFloat) Load() float64   { return math.Float64frombits(f.bits.Load()) }
+func (f *atomicFloat) Store(v float64) { f.bits.Store(math.Float64bits(v)) }
+func (f *atomicFloat) Add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// register returns the family called name, creating it on first use, so
+// constructors may be called from several places for the same metric.
+func (r *Registry) register(name, help string, kind Kind, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || len(f.labels) != len(labels) {
+			panic(fmt.Sprintf("metrics: %s re-registered with a different shape", name))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == KindHistogram {
+			s.counts = make([]atomic.Uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// CounterVec is a counter family partitioned by labels.
+type CounterVec struct{ f *family }
+
+// Counter only goes up.
+type Counter struct{ s *series }
+
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, KindCounter, nil, labels)}
+}
+
+// NewCounter registers a counter family in Default.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return Default.Counter(name, help, labels...)
+}
+
+func (v *CounterVec) With(values ...string) Counter { return Counter{v.f.with(values)} }
+
+func (c Counter) Inc()          { c.s.value.Add(1) }
+func (c Counter) Add(v float64) { c.s.value.Add(v) }
+
+// GaugeVec is a gauge family partitioned by labels.
+type GaugeVec struct{ f *family }
+
+// Gauge goes up and down.
+type Gauge struct{ s *series }
+
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, KindGauge, nil, labels)}
+}
+
+// NewGauge registers a gauge family in Default.
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return Default.Gauge(name, help, labels...)
+}
+
+func (v *GaugeVec) With(values ...string) Gauge { return Gauge{v.f.with(values)} }
+
+func (g Gauge) Set(v float64) { g.s.value.Store(v) }
+func (g Gauge) Add(v float64) { g.s.value.Add(v) }
+func (g Gauge) Inc()          { g.s.value.Add(1) }
+func (g Gauge) Dec()          { g.s.value.Add(-1) }
+
+// GaugeFunc registers an unlabelled gauge whose value is read from fn at
+// gather time. Registering the same name again replaces fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	f := r.register(name, help, KindGauge, nil, nil)
+	f.mu.Lock()
+	f.fn = fn
+	f.mu.Unlock()
+}
+
+// NewGaugeFunc registers a function gauge in Default.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.GaugeFunc(name, help, fn)
+}
+
+// HistogramVec is a histogram family partitioned by labels.
+type HistogramVec struct{ f *family }
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return &HistogramVec{r.register(name, help, KindHistogram, buckets, labels)}
+}
+
+// NewHistogram registers a histogram family in Default.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.Histogram(name, help, buckets, labels...)
+}
+
+func (v *HistogramVec) With(values ...string) Histogram {
+	return Histogram{v.f.with(values), v.f.buckets}
+}
+
+func (h Histogram) Observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.s.counts[i].Add(1)
+	}
+	h.s.count.Add(1)
+	h.s.value.Add(v)
+}
+
+// Family is a point-in-time copy of a metric family.
+type Family struct {
+	Name    string
+	Help    string
+	Kind    Kind
+	Samples []Sample
+}
+
+// Label is one name/value pair of a sample.
+type Label struct {
+	Name, Value string
+}
+
+// Sample is one series. For histograms Value is the sum of observations and
+// Buckets holds cumulative counts per upper bound, excluding +Inf.
+type Sample struct {
+	Labels  []Label
+	Value   float64
+	Count   uint64
+	Buckets []Bucket
+}
+
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Gather copies every family, sorted by name, with samples sorted by labels.
+func (r *Registry) Gather() []Family {
+	r.mu.Lock()
+	fams := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		fams = append(fams, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(fams, func(i, j int) bool { return fams[i].name < fams[j].name })
+
+	out := make([]Family, 0, len(fams))
+	for _, f := range fams {
+		out = append(out, f.gather())
+	}
+	return out
+}
+
+func (f *family) gather() Family {
+	fam := Family{Name: f.name, Help: f.help, Kind: f.kind}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fn != nil {
+		fam.Samples = []Sample{{Value: f.fn()}}
+		return fam
+	}
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		smp := Sample{Value: s.value.Load()}
+		for i, name := range f.labels {
+			smp.Labels = append(smp.Labels, Label{name, s.values[i]})
+		}
+		if f.kind == KindHistogram {
+			smp.Count = s.count.Load()
+			var cum uint64
+			for i, ub := range f.buckets {
+				cum += s.counts[i].Load()
+				smp.Buckets = append(smp.Buckets, Bucket{ub, cum})
+			}
+		}
+		fam.Samples = append(fam.Samples, smp)
+	}
+	return fam
+}
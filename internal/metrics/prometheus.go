@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handler serves r in the Prometheus text exposition format, version 0.0.4.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, f := range r.Gather() {
+			writeFamily(bw, f)
+		}
+		bw.Flush()
+	})
+}
+
+func writeFamily(w *bufio.Writer, f Family) {
+	w.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+	w.WriteString("# TYPE " + f.Name + " " + f.Kind.String() + "\n")
+	for _, s := range f.Samples {
+		if f.Kind != KindHistogram {
+			writeSample(w, f.Name, s.Labels, s.Value)
+			continue
+		}
+		for _, b := range s.Buckets {
+			writeSample(w, f.Name+"_bucket", append(s.Labels[:len(s.Labels):len(s.Labels)], Label{"le", formatFloat(b.UpperBound)}), float64(b.Count))
+		}
+		writeSample(w, f.Name+"_bucket", append(s.Labels[:len(s.Labels):len(s.Labels)], Label{"le", "+Inf"}), float64(s.Count))
+		writeSample(w, f.Name+"_sum", s.Labels, s.Value)
+		writeSample(w, f.Name+"_count", s.Labels, float64(s.Count))
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels []Label, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l.Name + `="` + escapeValue(l.Value) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeValue(s string) string { return valueEscaper.Replace(s) }
@@ -0,0 +1,195 @@
+// Package outbox implements the transactional outbox pattern. Handlers add
+// events to the outbox table inside the same SQL transaction as their data
+// changes; a relay, running on a single instance at a time, publishes them
+// in order per aggregate and marks them published afterwards. Delivery is at
+// least once: consumers deduplicate on the CloudEvent ID.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"goaws/internal/env"
+	"goaws/internal/events"
+)
+
+// Config configures the outbox. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Driver is a database/sql driver name that must be linked into the
+	// binary, e.g. "pgx" or "postgres".
+	Driver      string
+	DatabaseURL string
+	Dialect     Dialect
+
+	BatchSize    int
+	PollInterval time.Duration
+	LeaseTTL     time.Duration
+	Retention    time.Duration
+}
+
+// ConfigFromEnv reads the OUTBOX_* variables.
+func ConfigFromEnv() (Config, error) {
+	d, err := ParseDialect(env.String("OUTBOX_DIALECT", "postgres"))
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{
+		Driver:       env.String("OUTBOX_DB_DRIVER", "pgx"),
+		DatabaseURL:  env.String("OUTBOX_DATABASE_URL", ""),
+		Dialect:      d,
+		BatchSize:    env.Int("OUTBOX_BATCH_SIZE", 100),
+		PollInterval: env.Duration("OUTBOX_POLL_INTERVAL", time.Second),
+		LeaseTTL:     env.Duration("OUTBOX_LEASE_TTL", 30*time.Second),
+		Retention:    env.Duration("OUTBOX_RETENTION", 7*24*time.Hour),
+	}, nil
+}
+
+// Dialect papers over the SQL differences between supported databases.
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	SQLite
+)
+
+// ParseDialect accepts "postgres" or "sqlite".
+func ParseDialect(s string) (Dialect, error) {
+	switch strings.ToLower(s) {
+	case "postgres", "postgresql", "pg":
+		return Postgres, nil
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	}
+	return 0, fmt.Errorf("outbox: unknown dialect %q", s)
+}
+
+// bind rewrites ? placeholders for the dialect.
+func (d Dialect) bind(q string) string {
+	if d != Postgres {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (d Dialect) schema() []string {
+	id := "BIGSERIAL PRIMARY KEY"
+	ts := "TIMESTAMPTZ"
+	if d == SQLite {
+		id = "INTEGER PRIMARY KEY AUTOINCREMENT"
+		ts = "TIMESTAMP"
+	}
+	return []string{
+		`CREATE TABLE IF NOT EXISTS outbox (
+			id ` + id + `,
+			event_id VARCHAR(64) NOT NULL UNIQUE,
+			aggregate_type VARCHAR(128) NOT NULL,
+			aggregate_id VARCHAR(255) NOT NULL,
+			event_type VARCHAR(255) NOT NULL,
+			payload TEXT NOT NULL,
+			created_at ` + ts + ` NOT NULL,
+			published_at ` + ts + `,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS outbox_unpublished ON outbox (id) WHERE published_at IS NULL`,
+		`CREATE TABLE IF NOT EXISTS outbox_lease (
+			name VARCHAR(64) PRIMARY KEY,
+			holder VARCHAR(255) NOT NULL,
+			expires_at ` + ts + ` NOT NULL
+		)`,
+	}
+}
+
+// Outbox writes events and relays them.
+type Outbox struct {
+	cfg Config
+	db  *sql.DB
+	// blocked holds aggregates whose oldest event failed to publish, until
+	// they may be tried again. Only the relay uses it.
+	blocked map[string]time.Time
+}
+
+// Open connects to cfg.DatabaseURL. The caller closes the returned DB.
+func Open(cfg Config) (*Outbox, *sql.DB, error) {
+	db, err := sql.Open(cfg.Driver, cfg.DatabaseURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("outbox: %w (is the %q driver linked into this binary?)", err, cfg.Driver)
+	}
+	return New(cfg, db), db, nil
+}
+
+// New returns an outbox over an existing database handle.
+func New(cfg Config, db *sql.DB) *Outbox {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = 30 * time.Second
+	}
+	return &Outbox{cfg: cfg, db: db, blocked: map[string]time.Time{}}
+}
+
+// Migrate creates the outbox tables if they do not exist.
+func (o *Outbox) Migrate(ctx context.Context) error {
+	for _, stmt := range o.cfg.Dialect.schema() {
+		if _, err := o.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("outbox: migrate: %w", err)
+		}
+	}
+	_, err := o.db.ExecContext(ctx, o.cfg.Dialect.bind(
+		`INSERT INTO outbox_lease (name, holder, expires_at) VALUES (?, '', ?) ON CONFLICT (name) DO NOTHING`),
+		leaseName, time.Unix(0, 0).UTC())
+	if err != nil {
+		return fmt.Errorf("outbox: migrate: %w", err)
+	}
+	return nil
+}
+
+// Tx is satisfied by *sql.Tx but not *sql.DB, which makes it hard to write
+// to the outbox outside a transaction by accident.
+type Tx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	Commit() error
+}
+
+// Add inserts e into the outbox as part of tx. aggregateType and
+// aggregateID name the entity the event is about; events of one aggregate
+// are published in the order they were added.
+func (o *Outbox) Add(ctx context.Context, tx Tx, aggregateType, aggregateID string, e events.Event) error {
+	if e.ID == "" {
+		e.ID = events.NewID()
+	}
+	if e.Subject == "" {
+		e.Subject = aggregateType + "/" + aggregateID
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, o.cfg.Dialect.bind(
+		`INSERT INTO outbox (event_id, aggregate_type, aggregate_id, event_type, payload, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`),
+		e.ID, aggregateType, aggregateID, e.Type, string(payload), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("outbox: add %s: %w", e.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"goaws/internal/events"
+	"goaws/internal/metrics"
+)
+
+const leaseName = "relay"
+
+var (
+	publishedTotal = metrics.NewCounter("outbox_published_total", "Outbox events published and marked.")
+	errorsTotal    = metrics.NewCounter("outbox_publish_errors_total", "Failed attempts to publish an outbox event.")
+	pendingGauge   = metrics.NewGauge("outbox_pending", "Unpublished outbox events.")
+	lagGauge       = metrics.NewGauge("outbox_lag_seconds", "Age of the oldest unpublished outbox event.")
+	leaderGauge    = metrics.NewGauge("outbox_relay_leader", "1 while this instance holds the relay lease.")
+)
+
+// Publisher is what the relay publishes through; *events.Publisher
+// satisfies it.
+type Publisher interface {
+	// PublishOrdered sends batch in order and returns how many leading
+	// events of it were published.
+	PublishOrdered(ctx context.Context, batch []events.Event) (int, error)
+}
+
+type row struct {
+	id            int64
+	aggregateType string
+	aggregateID   string
+	attempts      int
+	event         events.Event
+}
+
+func (r row) aggregate() string { return r.aggregateType + "/" + r.aggregateID }
+
+// Run relays events through pub until ctx is done. Every instance may call
+// Run; a lease row makes sure only one of them relays at a time.
+func (o *Outbox) Run(ctx context.Context, pub Publisher) {
+	log := slog.Default().With("component", "outbox")
+	holder := holderID()
+	t := time.NewTicker(o.cfg.PollInterval)
+	defer t.Stop()
+	lastCleanup := time.Time{}
+	leader := false
+	for {
+		ok, err := o.acquire(ctx, holder)
+		if err != nil {
+			log.Error("acquiring relay lease", "err", err)
+		}
+		if ok != leader {
+			leader = ok
+			log.Info("relay leadership changed", "leader", leader, "holder", holder)
+		}
+		if leader {
+			leaderGauge.With().Set(1)
+			o.relay(ctx, pub, holder, log)
+			if o.cfg.Retention > 0 && time.Since(lastCleanup) > time.Hour {
+				lastCleanup = time.Now()
+				if err := o.cleanup(ctx); err != nil {
+					log.Error("deleting published outbox events", "err", err)
+				}
+			}
+		} else {
+			leaderGauge.With().Set(0)
+		}
+		if err := o.observeLag(ctx); err != nil && ctx.Err() == nil {
+			log.Error("measuring outbox lag", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if leader {
+				o.release(context.Background(), holder)
+			}
+			leaderGauge.With().Set(0)
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// relay publishes pages of the outbox until it is drained, the lease is
+// lost or a page makes no progress. Blocks that have run out are lifted
+// once, before the first page: an aggregate that fails or is passed over
+// stays held for the rest of the pass, so its later events never go out
+// ahead of the ones left behind below the read position.
+func (o *Outbox) relay(ctx context.Context, pub Publisher, holder string, log *slog.Logger) {
+	o.unblock(time.Now())
+	held := map[string]bool{}
+	var after int64
+	for ctx.Err() == nil {
+		rows, next, err := o.fetch(ctx, after, held)
+		if err != nil {
+			log.Error("reading outbox", "err", err)
+			return
+		}
+		if len(rows) == 0 {
+			return
+		}
+		published, leased := o.publish(ctx, pub, rows, holder, held, log)
+		if !leased || published == 0 {
+			return
+		}
+		after = next
+	}
+}
+
+// publish sends rows one aggregate at a time, each aggregate's events in
+// order. An aggregate stops at its first event that fails and is blocked
+// for a while, and held for the rest of the pass, so the events after it
+// wait rather than overtake it; other aggregates are not held up. The
+// lease is renewed before every send, and a send may take at most half of
+// it, so a relay that lost its lease never publishes. It returns the
+// number of events published, and false if the lease was lost.
+func (o *Outbox) publish(ctx context.Context, pub Publisher, rows []row, holder string, held map[string]bool, log *slog.Logger) (int, bool) {
+	var order []string
+	byAggregate := map[string][]row{}
+	for _, r := range rows {
+		key := r.aggregate()
+		if _, ok := byAggregate[key]; !ok {
+			order = append(order, key)
+		}
+		byAggregate[key] = append(byAggregate[key], r)
+	}
+
+	n := 0
+	for _, key := range order {
+		if ok, err := o.acquire(ctx, holder); err != nil || !ok {
+			if err != nil && ctx.Err() == nil {
+				log.Error("renewing relay lease", "err", err)
+			}
+			return n, false
+		}
+		rows := byAggregate[key]
+		batch := make([]events.Event, len(rows))
+		for i, r := range rows {
+			batch[i] = r.event
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, o.cfg.LeaseTTL/2)
+		sent, err := pub.PublishOrdered(sendCtx, batch)
+		cancel()
+
+		ids := make([]int64, sent)
+		for i := range ids {
+			ids[i] = rows[i].id
+		}
+		if len(ids) > 0 {
+			if err := o.markPublished(ctx, ids); err != nil {
+				log.Error("marking outbox events published", "aggregate", key, "err", err)
+				o.block(key, 1, time.Now())
+				held[key] = true
+				continue
+			}
+			publishedTotal.With().Add(float64(len(ids)))
+			n += len(ids)
+		}
+		if err != nil && sent < len(rows) {
+			r := rows[sent]
+			errorsTotal.With().Inc()
+			log.Warn("publishing outbox event", "id", r.id, "event_id", r.event.ID, "aggregate", key, "err", err)
+			o.recordFailure(ctx, r.id, err)
+			o.block(key, r.attempts+1, time.Now())
+			held[key] = true
+		}
+	}
+	return n, true
+}
+
+// block holds back key's events after a failure, for longer the more often
+// its oldest event has failed.
+func (o *Outbox) block(key string, attempts int, now time.Time) {
+	d := o.cfg.PollInterval << min(attempts-1, 16)
+	if d <= 0 || d > maxBlock {
+		d = maxBlock
+	}
+	o.blocked[key] = now.Add(d)
+}
+
+// maxBlock caps how long a failing aggregate waits between attempts.
+const maxBlock = 5 * time.Minute
+
+// unblock lifts the blocks that have run out by now.
+func (o *Outbox) unblock(now time.Time) {
+	for key, until := range o.blocked {
+		if !now.Before(until) {
+			delete(o.blocked, key)
+		}
+	}
+}
+
+// fetch returns up to BatchSize unpublished rows with IDs above after,
+// oldest first, leaving out blocked and held aggregates. It reads past
+// their rows, so a window full of blocked aggregates cannot stall the
+// rest, and holds every aggregate it passes over. It returns the ID to
+// continue from.
+func (o *Outbox) fetch(ctx context.Context, after int64, held map[string]bool) ([]row, int64, error) {
+	var out []row
+	for len(out) < o.cfg.BatchSize {
+		page, err := o.page(ctx, after)
+		if err != nil {
+			return nil, after, err
+		}
+		for _, r := range page {
+			after = r.id
+			key := r.aggregate()
+			if _, blocked := o.blocked[key]; blocked || held[key] {
+				held[key] = true
+				continue
+			}
+			out = append(out, r)
+		}
+		if len(page) < o.cfg.BatchSize {
+			break
+		}
+	}
+	return out, after, nil
+}
+
+func (o *Outbox) page(ctx context.Context, after int64) ([]row, error) {
+	rs, err := o.db.QueryContext(ctx, o.cfg.Dialect.bind(
+		`SELECT id, aggregate_type, aggregate_id, attempts, payload FROM outbox
+		 WHERE published_at IS NULL AND id > ? ORDER BY id LIMIT ?`), after, o.cfg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	var out []row
+	for rs.Next() {
+		var r row
+		var payload string
+		if err := rs.Scan(&r.id, &r.aggregateType, &r.aggregateID, &r.attempts, &payload); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(payload), &r.event); err != nil {
+			return nil, fmt.Errorf("outbox: row %d: %w", r.id, err)
+		}
+		out = append(out, r)
+	}
+	return out, rs.Err()
+}
+
+func (o *Outbox) markPublished(ctx context.Context, ids []int64) error {
+	args := []any{time.Now().UTC()}
+	marks := make([]string, len(ids))
+	for i, id := range ids {
+		marks[i] = "?"
+		args = append(args, id)
+	}
+	_, err := o.db.ExecContext(ctx, o.cfg.Dialect.bind(
+		`UPDATE outbox SET published_at = ? WHERE id IN (`+strings.Join(marks, ",")+`)`), args...)
+	return err
+}
+
+func (o *Outbox) recordFailure(ctx context.Context, id int64, cause error) {
+	msg := cause.Error()
+	if len(msg) > 1000 {
+		msg = msg[:1000]
+	}
+	o.db.ExecContext(ctx, o.cfg.Dialect.bind(
+		`UPDATE outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?`), msg, id)
+}
+
+func (o *Outbox) observeLag(ctx context.Context) error {
+	var n int64
+	var oldest sql.NullTime
+	err := o.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), MIN(created_at) FROM outbox WHERE published_at IS NULL`).Scan(&n, &oldest)
+	if err != nil {
+		return err
+	}
+	pendingGauge.With().Set(float64(n))
+	lag := 0.0
+	if oldest.Valid {
+		lag = time.Since(oldest.Time).Seconds()
+	}
+	lagGauge.With().Set(lag)
+	return nil
+}
+
+func (o *Outbox) cleanup(ctx context.Context) error {
+	_, err := o.db.ExecContext(ctx, o.cfg.Dialect.bind(
+		`DELETE FROM outbox WHERE published_at IS NOT NULL AND published_at < ?`),
+		time.Now().Add(-o.cfg.Retention).UTC())
+	return err
+}
+
+// acquire takes or renews the relay lease. Instances' clocks are assumed to
+// agree to well within the lease TTL.
+func (o *Outbox) acquire(ctx context.Context, holder string) (bool, error) {
+	now := time.Now().UTC()
+	res, err := o.db.ExecContext(ctx, o.cfg.Dialect.bind(
+		`UPDATE outbox_lease SET holder = ?, expires_at = ?
+		 WHERE name = ? AND (holder = ? OR expires_at < ?)`),
+		holder, now.Add(o.cfg.LeaseTTL), leaseName, holder, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (o *Outbox) release(ctx context.Context, holder string) {
+	o.db.ExecContext(ctx, o.cfg.Dialect.bind(
+		`UPDATE outbox_lease SET expires_at = ? WHERE name = ? AND holder = ?`),
+		time.Unix(0, 0).UTC(), leaseName, holder)
+}
+
+func holderID() string {
+	host, _ := os.Hostname()
+	var b [4]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b[:]))
+}
@@ -0,0 +1,358 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goaws/internal/events"
+)
+
+// fakeDB is an in-memory stand-in for the database, understanding just the
+// statements the outbox issues in the SQLite dialect.
+type fakeDB struct {
+	mu          sync.Mutex
+	rows        []*fakeRow
+	leaseHolder string
+	leaseExpiry time.Time
+	leaseChecks int
+}
+
+type fakeRow struct {
+	id            int64
+	aggregateType string
+	aggregateID   string
+	payload       string
+	createdAt     time.Time
+	published     bool
+	attempts      int64
+	lastError     string
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func (c fakeConn) ExecContext(_ context.Context, q string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	arg := func(i int) driver.Value { return args[i].Value }
+	switch {
+	case strings.HasPrefix(q, "CREATE"), strings.HasPrefix(q, "DELETE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(q, "INSERT INTO outbox_lease"):
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(q, "INSERT INTO outbox"):
+		db.rows = append(db.rows, &fakeRow{
+			id:            int64(len(db.rows) + 1),
+			aggregateType: arg(1).(string),
+			aggregateID:   arg(2).(string),
+			payload:       arg(4).(string),
+			createdAt:     arg(5).(time.Time),
+		})
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(q, "UPDATE outbox SET published_at"):
+		for _, a := range args[1:] {
+			db.rows[a.Value.(int64)-1].published = true
+		}
+		return driver.RowsAffected(len(args) - 1), nil
+	case strings.HasPrefix(q, "UPDATE outbox SET attempts"):
+		r := db.rows[arg(1).(int64)-1]
+		r.attempts++
+		r.lastError = arg(0).(string)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(q, "UPDATE outbox_lease SET holder"):
+		db.leaseChecks++
+		holder, now := arg(0).(string), arg(4).(time.Time)
+		if db.leaseHolder != holder && !db.leaseExpiry.Before(now) {
+			return driver.RowsAffected(0), nil
+		}
+		db.leaseHolder, db.leaseExpiry = holder, arg(1).(time.Time)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(q, "UPDATE outbox_lease SET expires_at"):
+		if db.leaseHolder == arg(2).(string) {
+			db.leaseExpiry = arg(0).(time.Time)
+		}
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %q", q)
+}
+
+func (c fakeConn) QueryContext(_ context.Context, q string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch {
+	case strings.HasPrefix(q, "SELECT id,"):
+		after, limit := args[0].Value.(int64), int(args[1].Value.(int64))
+		rs := &fakeRows{cols: []string{"id", "aggregate_type", "aggregate_id", "attempts", "payload"}}
+		for _, r := range db.rows {
+			if !r.published && r.id > after && len(rs.vals) < limit {
+				rs.vals = append(rs.vals, []driver.Value{r.id, r.aggregateType, r.aggregateID, r.attempts, r.payload})
+			}
+		}
+		return rs, nil
+	case strings.HasPrefix(q, "SELECT COUNT(*)"):
+		var n int64
+		var oldest driver.Value
+		for _, r := range db.rows {
+			if !r.published {
+				if n == 0 {
+					oldest = r.createdAt
+				}
+				n++
+			}
+		}
+		return &fakeRows{cols: []string{"count", "min"}, vals: [][]driver.Value{{n, oldest}}}, nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", q)
+}
+
+type fakeRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
+
+// fakePublisher records the event IDs of each send. fail, if set, returns
+// how many leading events of a send to accept and the error to report.
+type fakePublisher struct {
+	sends [][]string
+	fail  func(ids []string) (int, error)
+}
+
+func (p *fakePublisher) PublishOrdered(_ context.Context, batch []events.Event) (int, error) {
+	ids := make([]string, len(batch))
+	for i, e := range batch {
+		ids[i] = e.ID
+	}
+	p.sends = append(p.sends, ids)
+	if p.fail != nil {
+		return p.fail(ids)
+	}
+	return len(batch), nil
+}
+
+func newTestOutbox(t *testing.T, batchSize int) (*Outbox, *fakeDB) {
+	t.Helper()
+	fdb := &fakeDB{}
+	db := sql.OpenDB(fdb)
+	t.Cleanup(func() { db.Close() })
+	o := New(Config{Dialect: SQLite, BatchSize: batchSize, PollInterval: time.Second}, db)
+	if err := o.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return o, fdb
+}
+
+// add stores events with the given IDs, each "aggregate:n", in order.
+func add(t *testing.T, o *Outbox, ids ...string) {
+	t.Helper()
+	ctx := context.Background()
+	for _, id := range ids {
+		agg, _, _ := strings.Cut(id, ":")
+		tx, err := o.db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := o.Add(ctx, tx, "order", agg, events.Event{ID: id, Type: "order.changed"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func relayOnce(t *testing.T, o *Outbox, pub Publisher) {
+	t.Helper()
+	if ok, err := o.acquire(context.Background(), "me"); !ok || err != nil {
+		t.Fatalf("acquire = %v, %v", ok, err)
+	}
+	o.relay(context.Background(), pub, "me", slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func unpublished(db *fakeDB) string {
+	var out []string
+	for _, r := range db.rows {
+		if !r.published {
+			out = append(out, fmt.Sprintf("%d/%d", r.id, r.attempts))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func sends(p *fakePublisher) string {
+	var out []string
+	for _, s := range p.sends {
+		out = append(out, strings.Join(s, ","))
+	}
+	return strings.Join(out, " ")
+}
+
+func TestRelayPublishesEachAggregateInOrder(t *testing.T) {
+	o, db := newTestOutbox(t, 10)
+	add(t, o, "a:1", "b:1", "a:2", "b:2", "a:3")
+	pub := &fakePublisher{fail: func(ids []string) (int, error) {
+		if ids[0] == "a:1" {
+			return 1, errors.New("throttled")
+		}
+		return len(ids), nil
+	}}
+	relayOnce(t, o, pub)
+
+	// a stops at a:2; the events after it wait instead of overtaking it.
+	if got, want := sends(pub), "a:1,a:2,a:3 b:1,b:2"; got != want {
+		t.Errorf("sends = %q, want %q", got, want)
+	}
+	if got, want := unpublished(db), "3/1 5/0"; got != want {
+		t.Errorf("unpublished id/attempts = %q, want %q", got, want)
+	}
+
+	// While blocked, a is left alone.
+	pub.sends, pub.fail = nil, nil
+	relayOnce(t, o, pub)
+	if len(pub.sends) != 0 {
+		t.Errorf("blocked aggregate was sent %q", sends(pub))
+	}
+
+	o.blocked["order/a"] = time.Now().Add(-time.Second)
+	relayOnce(t, o, pub)
+	if got, want := sends(pub), "a:2,a:3"; got != want {
+		t.Errorf("sends = %q, want %q", got, want)
+	}
+	if got := unpublished(db); got != "" {
+		t.Errorf("still unpublished: %q", got)
+	}
+}
+
+func TestFetchReadsPastBlockedAggregates(t *testing.T) {
+	o, _ := newTestOutbox(t, 2)
+	add(t, o, "a:1", "a:2", "a:3", "a:4", "b:1", "a:5", "c:1")
+	now := time.Now()
+	o.block("order/a", 1, now)
+
+	held := map[string]bool{}
+	rows, next, err := o.fetch(context.Background(), 0, held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, r.event.ID)
+	}
+	if strings.Join(got, " ") != "b:1 c:1" || next != 7 || !held["order/a"] || held["order/b"] {
+		t.Errorf("fetch = %v, next %d, held %v", got, next, held)
+	}
+
+	// The block lifts once it expires.
+	o.unblock(now.Add(maxBlock))
+	rows, _, err = o.fetch(context.Background(), 0, map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].event.ID != "a:1" {
+		t.Errorf("fetch after the block = %+v", rows)
+	}
+}
+
+func TestRelayHoldsFailedAggregateForThePass(t *testing.T) {
+	o, db := newTestOutbox(t, 2)
+	// The block runs out well before the second page is read.
+	o.cfg.PollInterval = time.Nanosecond
+	add(t, o, "a:1", "b:1", "a:2", "b:2", "a:3")
+	pub := &fakePublisher{fail: func(ids []string) (int, error) {
+		if ids[0] == "a:1" {
+			return 0, errors.New("throttled")
+		}
+		return len(ids), nil
+	}}
+	relayOnce(t, o, pub)
+
+	if got, want := sends(pub), "a:1 b:1 b:2"; got != want {
+		t.Errorf("sends = %q, want %q", got, want)
+	}
+	if got, want := unpublished(db), "1/1 3/0 5/0"; got != want {
+		t.Errorf("unpublished id/attempts = %q, want %q", got, want)
+	}
+
+	// The next pass starts from a:1 again.
+	pub.sends, pub.fail = nil, nil
+	relayOnce(t, o, pub)
+	if got, want := sends(pub), "a:1,a:2 a:3"; got != want {
+		t.Errorf("sends = %q, want %q", got, want)
+	}
+}
+
+func TestRelayRenewsLeaseBeforeEachSend(t *testing.T) {
+	o, db := newTestOutbox(t, 10)
+	add(t, o, "a:1", "b:1", "c:1")
+	pub := &fakePublisher{fail: func(ids []string) (int, error) {
+		if ids[0] == "b:1" {
+			// Another instance takes over while this send is in flight.
+			db.mu.Lock()
+			db.leaseHolder, db.leaseExpiry = "other", time.Now().Add(time.Minute)
+			db.mu.Unlock()
+		}
+		return len(ids), nil
+	}}
+	relayOnce(t, o, pub)
+
+	if got, want := sends(pub), "a:1 b:1"; got != want {
+		t.Errorf("sends = %q, want %q", got, want)
+	}
+	// One check to start the pass, then one before each of the three sends.
+	if db.leaseChecks != 4 {
+		t.Errorf("lease checked %d times", db.leaseChecks)
+	}
+}
+
+func TestBlockBackoff(t *testing.T) {
+	o, _ := newTestOutbox(t, 10)
+	now := time.Now()
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{20, maxBlock},
+		{1000, maxBlock},
+	} {
+		o.block("k", tc.attempts, now)
+		if got := o.blocked["k"].Sub(now); got != tc.want {
+			t.Errorf("attempts %d: blocked for %v, want %v", tc.attempts, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package profiler
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"goaws/internal/aws"
+)
+
+const usage = `usage: app profiles diff [flags] base new
+
+Compares two profiles and lists the functions whose cost changed most. base
+and new are capture directories, s3://bucket/prefix/<instance>/<time>/
+capture prefixes, or single .pb.gz profile files.
+
+flags:
+`
+
+// Command runs app profiles with args.
+func Command(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "diff" {
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	}
+	fset := flag.NewFlagSet("profiles diff", flag.ContinueOnError)
+	typ := fset.String("type", "cpu", "profile to compare in a capture: "+strings.Join(Types, ", "))
+	sampleType := fset.String("sample", "", "sample type, e.g. alloc_space; defaults to the profile's default")
+	top := fset.Int("top", 20, "number of functions to list")
+	cum := fset.Bool("cum", false, "compare cumulative rather than flat cost")
+	fset.Usage = func() {
+		fmt.Fprint(fset.Output(), usage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fset.NArg() != 2 {
+		fset.Usage()
+		return flag.ErrHelp
+	}
+	var profiles [2]*Profile
+	for i, src := range fset.Args() {
+		b, err := readProfile(ctx, src, *typ)
+		if err != nil {
+			return err
+		}
+		if profiles[i], err = ParseProfile(b); err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+	}
+	return writeDiff(os.Stdout, profiles[0], profiles[1], *sampleType, *top, *cum)
+}
+
+// readProfile loads the typ profile of a capture, or src itself if it is a
+// file.
+func readProfile(ctx context.Context, src, typ string) ([]byte, error) {
+	if rest, ok := strings.CutPrefix(src, "s3://"); ok {
+		bucket, key, _ := strings.Cut(rest, "/")
+		if !strings.HasSuffix(key, ".pb.gz") {
+			key = strings.TrimSuffix(key, "/") + "/" + typ + ".pb.gz"
+		}
+		body, err := aws.NewClient().GetObject(ctx, bucket, key)
+		if err != nil {
+			return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, err)
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	if st, err := os.Stat(src); err == nil && st.IsDir() {
+		src = filepath.Join(src, typ+".pb.gz")
+	}
+	return os.ReadFile(src)
+}
+
+func writeDiff(w io.Writer, base, cur *Profile, sampleType string, top int, cum bool) error {
+	bi, err := sampleIndex(base, sampleType)
+	if err != nil {
+		return fmt.Errorf("base: %w", err)
+	}
+	ci, err := sampleIndex(cur, base.SampleTypes[bi].Type)
+	if err != nil {
+		return fmt.Errorf("new: %w", err)
+	}
+	vt := base.SampleTypes[bi]
+	bf, btotal := costs(base, bi, cum)
+	cf, ctotal := costs(cur, ci, cum)
+
+	fmt.Fprintf(w, "base: %s\n", strings.Join(base.Comments, " "))
+	fmt.Fprintf(w, "new:  %s\n", strings.Join(cur.Comments, " "))
+	fmt.Fprintf(w, "%s/%s total: %s -> %s (%s)\n\n", vt.Type, vt.Unit,
+		formatValue(btotal, vt.Unit), formatValue(ctotal, vt.Unit), percent(btotal, ctotal))
+
+	type row struct {
+		fn            string
+		before, after int64
+	}
+	var rows []row
+	for fn, v := range bf {
+		rows = append(rows, row{fn, v, cf[fn]})
+	}
+	for fn, v := range cf {
+		if _, ok := bf[fn]; !ok {
+			rows = append(rows, row{fn, 0, v})
+		}
+	}
+	slices.SortFunc(rows, func(a, b row) int {
+		da, db := abs(a.after-a.before), abs(b.after-b.before)
+		if da != db {
+			if da > db {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.fn, b.fn)
+	})
+	kind := "flat"
+	if cum {
+		kind = "cum"
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "base %s\tnew %s\tdelta\t\t  function\n", kind, kind)
+	for i, r := range rows {
+		if i == top || r.after == r.before {
+			break
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", formatValue(r.before, vt.Unit), formatValue(r.after, vt.Unit),
+			signed(r.after-r.before, vt.Unit), percent(r.before, r.after), "  "+r.fn)
+	}
+	return tw.Flush()
+}
+
+// sampleIndex finds sample type name, or the profile's default: the
+// declared one, else the last, as pprof does.
+func sampleIndex(p *Profile, name string) (int, error) {
+	if len(p.SampleTypes) == 0 {
+		return 0, fmt.Errorf("profile has no sample types")
+	}
+	if name == "" {
+		name = p.DefaultSampleType
+	}
+	if name == "" {
+		return len(p.SampleTypes) - 1, nil
+	}
+	for i, st := range p.SampleTypes {
+		if st.Type == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no sample type %q", name)
+}
+
+// costs sums sample values by function: the leaf function for flat cost,
+// every function on the stack (once per sample) for cumulative.
+func costs(p *Profile, idx int, cum bool) (map[string]int64, int64) {
+	out := map[string]int64{}
+	var total int64
+	for _, s := range p.Samples {
+		if idx >= len(s.Values) {
+			continue
+		}
+		v := s.Values[idx]
+		total += v
+		stack := p.Stack(s)
+		if len(stack) == 0 {
+			continue
+		}
+		if !cum {
+			out[stack[0]] += v
+			continue
+		}
+		seen := map[string]bool{}
+		for _, fn := range stack {
+			if !seen[fn] {
+				seen[fn] = true
+				out[fn] += v
+			}
+		}
+	}
+	return out, total
+}
+
+func formatValue(v int64, unit string) string {
+	switch unit {
+	case "nanoseconds":
+		return time.Duration(v).Round(10 * time.Microsecond).String()
+	case "bytes":
+		f := float64(v)
+		for _, u := range []string{"B", "kB", "MB", "GB"} {
+			if f < 1024 && f > -1024 || u == "GB" {
+				return fmt.Sprintf("%.1f%s", f, u)
+			}
+			f /= 1024
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+func signed(v int64, unit string) string {
+	if v > 0 {
+		return "+" + formatValue(v, unit)
+	}
+	return formatValue(v, unit)
+}
+
+func percent(old, cur int64) string {
+	if old == 0 {
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", float64(cur-old)/float64(old)*100)
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,275 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// This file reads and annotates the pprof profile.proto format with just
+// enough protobuf to do so; see github.com/google/pprof/proto/profile.proto
+// for the field numbers.
+
+var errProto = errors.New("profiler: malformed profile")
+
+// Profile is the part of a pprof profile diff needs.
+type Profile struct {
+	SampleTypes []ValueType
+	Samples     []Sample
+	// Comments are free-form notes; captures carry version=, instance=
+	// and captured= comments.
+	Comments []string
+	// DefaultSampleType names the sample type pprof shows by default.
+	DefaultSampleType string
+
+	funcs map[uint64]string
+	locs  map[uint64][]uint64
+}
+
+// ValueType describes one of a sample's values.
+type ValueType struct{ Type, Unit string }
+
+// Sample is one stack, leaf first, and its values.
+type Sample struct {
+	Locations []uint64
+	Values    []int64
+}
+
+// Stack returns the function names of s, leaf first, with inlined frames
+// expanded.
+func (p *Profile) Stack(s Sample) []string {
+	var out []string
+	for _, id := range s.Locations {
+		for _, fn := range p.locs[id] {
+			out = append(out, p.funcs[fn])
+		}
+	}
+	return out
+}
+
+// gunzip returns b decompressed if it is gzipped, else b.
+func gunzip(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+		return b, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+// field is one decoded protobuf field. For varints v is the value; for
+// length-delimited fields b is the payload.
+type field struct {
+	num  int
+	wire int
+	v    uint64
+	b    []byte
+}
+
+func fields(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProto
+		}
+		b = b[n:]
+		f := field{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			f.v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errProto
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return errProto
+			}
+			f.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errProto
+			}
+			f.b, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return errProto
+			}
+			f.v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return errProto
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// varints returns a repeated integer field's values, packed or not.
+func varints(f field) ([]uint64, error) {
+	if f.wire == 0 {
+		return []uint64{f.v}, nil
+	}
+	var out []uint64
+	for b := f.b; len(b) > 0; {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errProto
+		}
+		out = append(out, v)
+		b = b[n:]
+	}
+	return out, nil
+}
+
+// ParseProfile decodes a profile, gzipped or not.
+func ParseProfile(data []byte) (*Profile, error) {
+	b, err := gunzip(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{funcs: map[uint64]string{}, locs: map[uint64][]uint64{}}
+	var (
+		strs          []string
+		sampleTypes   [][2]uint64
+		comments      []uint64
+		defaultType   uint64
+		funcNameIndex = map[uint64]uint64{}
+	)
+	err = fields(b, func(f field) error {
+		switch f.num {
+		case 1: // sample_type
+			var vt [2]uint64
+			err := fields(f.b, func(f field) error {
+				if f.num == 1 || f.num == 2 {
+					vt[f.num-1] = f.v
+				}
+				return nil
+			})
+			sampleTypes = append(sampleTypes, vt)
+			return err
+		case 2: // sample
+			var s Sample
+			err := fields(f.b, func(f field) error {
+				switch f.num {
+				case 1:
+					ids, err := varints(f)
+					s.Locations = append(s.Locations, ids...)
+					return err
+				case 2:
+					vs, err := varints(f)
+					for _, v := range vs {
+						s.Values = append(s.Values, int64(v))
+					}
+					return err
+				}
+				return nil
+			})
+			p.Samples = append(p.Samples, s)
+			return err
+		case 4: // location
+			var id uint64
+			var fns []uint64
+			err := fields(f.b, func(f field) error {
+				switch f.num {
+				case 1:
+					id = f.v
+				case 4: // line
+					return fields(f.b, func(f field) error {
+						if f.num == 1 {
+							fns = append(fns, f.v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			p.locs[id] = fns
+			return err
+		case 5: // function
+			var id, name uint64
+			err := fields(f.b, func(f field) error {
+				switch f.num {
+				case 1:
+					id = f.v
+				case 2:
+					name = f.v
+				}
+				return nil
+			})
+			funcNameIndex[id] = name
+			return err
+		case 6: // string_table
+			strs = append(strs, string(f.b))
+		case 13: // comment
+			vs, err := varints(f)
+			comments = append(comments, vs...)
+			return err
+		case 14: // default_sample_type
+			defaultType = f.v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	str := func(i uint64) string {
+		if i < uint64(len(strs)) {
+			return strs[i]
+		}
+		return ""
+	}
+	for _, vt := range sampleTypes {
+		p.SampleTypes = append(p.SampleTypes, ValueType{str(vt[0]), str(vt[1])})
+	}
+	for id, name := range funcNameIndex {
+		p.funcs[id] = str(name)
+	}
+	for _, c := range comments {
+		p.Comments = append(p.Comments, str(c))
+	}
+	p.DefaultSampleType = str(defaultType)
+	return p, nil
+}
+
+// addComments appends comments to a gzipped profile. Protobuf lets
+// repeated fields be appended to a message, so the new strings go at the
+// end of the string table and the comments refer to them.
+func addComments(data []byte, comments []string) ([]byte, error) {
+	b, err := gunzip(data)
+	if err != nil {
+		return nil, err
+	}
+	nstrs := 0
+	if err := fields(b, func(f field) error {
+		if f.num == 6 {
+			nstrs++
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, c := range comments {
+		b = binary.AppendUvarint(b, 6<<3|2)
+		b = binary.AppendUvarint(b, uint64(len(c)))
+		b = append(b, c...)
+	}
+	for i := range comments {
+		b = binary.AppendUvarint(b, 13<<3|0)
+		b = binary.AppendUvarint(b, uint64(nstrs+i))
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	return buf.Bytes(), nil
+}
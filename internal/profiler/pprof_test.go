@@ -0,0 +1,219 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func pbVarint(b []byte, num int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func pbBytes(b []byte, num int, p []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+func pbPacked(b []byte, num int, vs ...uint64) []byte {
+	var p []byte
+	for _, v := range vs {
+		p = binary.AppendUvarint(p, v)
+	}
+	return pbBytes(b, num, p)
+}
+
+// testProfile encodes a CPU profile with two samples: main.leaf, inlined
+// into main.inlined, called from main.root, costing leaf nanoseconds; and
+// main.root alone, costing root. The first sample uses packed repeated
+// fields, the second unpacked ones.
+func testProfile(leaf, root uint64) []byte {
+	var b []byte
+	b = pbBytes(b, 1, pbVarint(pbVarint(nil, 1, 3), 2, 4))
+	b = pbBytes(b, 1, pbVarint(pbVarint(nil, 1, 1), 2, 2))
+	b = pbBytes(b, 2, pbPacked(pbPacked(nil, 1, 1, 2), 2, 1, leaf))
+	b = pbBytes(b, 2, pbVarint(pbVarint(pbVarint(nil, 1, 2), 2, 1), 2, root))
+	line := func(fn uint64) []byte { return pbVarint(nil, 1, fn) }
+	b = pbBytes(b, 4, pbBytes(pbBytes(pbVarint(nil, 1, 1), 4, line(1)), 4, line(2)))
+	b = pbBytes(b, 4, pbBytes(pbVarint(nil, 1, 2), 4, line(3)))
+	for id, name := range []uint64{5, 6, 7} {
+		b = pbBytes(b, 5, pbVarint(pbVarint(nil, 1, uint64(id+1)), 2, name))
+	}
+	for _, s := range []string{"", "cpu", "nanoseconds", "samples", "count", "main.leaf", "main.inlined", "main.root"} {
+		b = pbBytes(b, 6, []byte(s))
+	}
+	return pbVarint(b, 14, 1)
+}
+
+func gzipped(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestParseProfile(t *testing.T) {
+	for name, data := range map[string][]byte{"plain": testProfile(10, 20), "gzipped": gzipped(testProfile(10, 20))} {
+		t.Run(name, func(t *testing.T) {
+			p, err := ParseProfile(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(p.SampleTypes, []ValueType{{"samples", "count"}, {"cpu", "nanoseconds"}}) || p.DefaultSampleType != "cpu" {
+				t.Errorf("sample types %v, default %q", p.SampleTypes, p.DefaultSampleType)
+			}
+			if len(p.Samples) != 2 {
+				t.Fatalf("samples %+v", p.Samples)
+			}
+			if got := p.Stack(p.Samples[0]); !slices.Equal(got, []string{"main.leaf", "main.inlined", "main.root"}) {
+				t.Errorf("stack 0 = %v", got)
+			}
+			if got := p.Stack(p.Samples[1]); !slices.Equal(got, []string{"main.root"}) {
+				t.Errorf("stack 1 = %v", got)
+			}
+			if !slices.Equal(p.Samples[0].Values, []int64{1, 10}) || !slices.Equal(p.Samples[1].Values, []int64{1, 20}) {
+				t.Errorf("values %v, %v", p.Samples[0].Values, p.Samples[1].Values)
+			}
+		})
+	}
+}
+
+func TestParseProfileRejectsTruncatedData(t *testing.T) {
+	b := testProfile(10, 20)
+	for _, n := range []int{1, len(b) - 3} {
+		if _, err := ParseProfile(b[:n]); !errors.Is(err, errProto) {
+			t.Errorf("%d bytes: err = %v", n, err)
+		}
+	}
+}
+
+func TestAddComments(t *testing.T) {
+	data, err := addComments(gzipped(testProfile(10, 20)), []string{"version=1.2.3", "instance=i-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0x1f || data[1] != 0x8b {
+		t.Error("result is not gzipped")
+	}
+	p, err := ParseProfile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(p.Comments, []string{"version=1.2.3", "instance=i-1"}) {
+		t.Errorf("comments %q", p.Comments)
+	}
+	// The existing string indexes still resolve.
+	if got := p.Stack(p.Samples[0]); got[0] != "main.leaf" || p.DefaultSampleType != "cpu" {
+		t.Errorf("stack %v, default %q", got, p.DefaultSampleType)
+	}
+}
+
+func TestAddCommentsToRuntimeProfile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	data, err := addComments(buf.Bytes(), []string{"captured=now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseProfile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(p.Comments, "captured=now") || len(p.Samples) == 0 {
+		t.Errorf("comments %q, %d samples", p.Comments, len(p.Samples))
+	}
+	var found bool
+	for _, s := range p.Samples {
+		found = found || slices.Contains(p.Stack(s), "runtime/pprof.writeRuntimeProfile")
+	}
+	if !found {
+		t.Error("this goroutine's stack is missing")
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	base, _ := ParseProfile(testProfile(10e6, 20e6))
+	cur, _ := ParseProfile(testProfile(30e6, 20e6))
+	base.Comments = []string{"version=1"}
+
+	var buf bytes.Buffer
+	if err := writeDiff(&buf, base, cur, "", 10, false); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"base: version=1", "cpu/nanoseconds total: 30ms -> 50ms (+66.7%)", "+20ms", "+200.0%", "main.leaf"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	// Unchanged functions are not listed.
+	if strings.Contains(out, "main.root") {
+		t.Errorf("flat diff lists main.root:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := writeDiff(&buf, base, cur, "samples", 10, true); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); strings.Contains(out, "main.") || !strings.Contains(out, "samples/count total: 2 -> 2") {
+		t.Errorf("samples diff:\n%s", out)
+	}
+
+	buf.Reset()
+	writeDiff(&buf, base, cur, "cpu", 2, true)
+	out = buf.String()
+	if !strings.Contains(out, "main.inlined") || !strings.Contains(out, "main.leaf") || strings.Contains(out, "main.root") {
+		t.Errorf("top 2 cumulative diff:\n%s", out)
+	}
+
+	if err := writeDiff(&buf, base, cur, "alloc_space", 10, false); err == nil {
+		t.Error("unknown sample type accepted")
+	}
+}
+
+func TestSampleIndex(t *testing.T) {
+	p := &Profile{SampleTypes: []ValueType{{"alloc_objects", "count"}, {"alloc_space", "bytes"}, {"inuse_space", "bytes"}}}
+	for name, want := range map[string]int{"": 2, "alloc_objects": 0} {
+		if got, err := sampleIndex(p, name); err != nil || got != want {
+			t.Errorf("sampleIndex(%q) = %d, %v", name, got, err)
+		}
+	}
+	p.DefaultSampleType = "alloc_space"
+	if got, _ := sampleIndex(p, ""); got != 1 {
+		t.Errorf("default = %d", got)
+	}
+	if _, err := sampleIndex(&Profile{}, ""); err == nil {
+		t.Error("profile without sample types accepted")
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	for _, tc := range []struct {
+		v    int64
+		unit string
+		want string
+	}{
+		{1536, "bytes", "1.5kB"},
+		{-3 << 20, "bytes", "-3.0MB"},
+		{5 << 40, "bytes", "5120.0GB"},
+		{1234567, "nanoseconds", "1.23ms"},
+		{42, "count", "42"},
+	} {
+		if got := formatValue(tc.v, tc.unit); got != tc.want {
+			t.Errorf("formatValue(%d, %s) = %q, want %q", tc.v, tc.unit, got, tc.want)
+		}
+	}
+	if percent(0, 5) != "new" || percent(200, 100) != "-50.0%" {
+		t.Error("percent")
+	}
+}
@@ -0,0 +1,247 @@
+// Package profiler periodically captures pprof profiles of the running
+// process, labelled with the build version and instance, and keeps them in
+// a local directory or an S3-compatible bucket for later comparison.
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"time"
+
+	"goaws/internal/aws"
+	"goaws/internal/buildinfo"
+	"goaws/internal/env"
+	"goaws/internal/metrics"
+)
+
+var (
+	captures      = metrics.NewCounter("profiler_captures_total", "Profile captures, by outcome.", "result")
+	profileErrors = metrics.NewCounter("profiler_errors_total", "Profiles that could not be captured or stored.", "type", "stage")
+)
+
+// Types are the profiles a capture can hold.
+var Types = []string{"cpu", "heap", "goroutine", "mutex", "block"}
+
+// Config configures profiling. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Enabled turns profiling on; it is off by default.
+	Enabled bool
+	// Interval is the time between captures; CPUDuration is how long
+	// each CPU profile runs.
+	Interval    time.Duration
+	CPUDuration time.Duration
+	Types       []string
+	// Dir keeps captures locally, the newest Keep of them. Bucket, if
+	// set, receives them under Prefix instead.
+	Dir    string
+	Keep   int
+	Bucket string
+	Prefix string
+	// MutexFraction and BlockRate are passed to
+	// runtime.SetMutexProfileFraction and runtime.SetBlockProfileRate
+	// when those profiles are captured. Larger values cost less.
+	MutexFraction int
+	BlockRate     int
+	// Instance labels captures; the caller sets it.
+	Instance string
+}
+
+// ConfigFromEnv reads the PROFILER_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Enabled:       env.Bool("PROFILER", false),
+		Interval:      env.Duration("PROFILER_INTERVAL", 10*time.Minute),
+		CPUDuration:   env.Duration("PROFILER_CPU_DURATION", 10*time.Second),
+		Types:         env.List("PROFILER_TYPES", Types),
+		Dir:           env.String("PROFILER_DIR", "/var/lib/srv/profiles"),
+		Keep:          env.Int("PROFILER_KEEP", 144),
+		Bucket:        env.String("PROFILER_S3_BUCKET", ""),
+		Prefix:        env.String("PROFILER_S3_PREFIX", "profiles/"),
+		MutexFraction: env.Int("PROFILER_MUTEX_FRACTION", 100),
+		BlockRate:     env.Int("PROFILER_BLOCK_RATE", 1000000),
+	}
+}
+
+// Meta describes a capture. It is stored next to the profiles as
+// meta.json.
+type Meta struct {
+	Version     string    `json:"version"`
+	Instance    string    `json:"instance"`
+	Time        time.Time `json:"time"`
+	GoVersion   string    `json:"go_version"`
+	CPUDuration string    `json:"cpu_duration,omitempty"`
+	Types       []string  `json:"types"`
+}
+
+// Profiler captures on a schedule.
+type Profiler struct {
+	cfg    Config
+	client *aws.Client
+}
+
+// New validates cfg. client is used when cfg.Bucket is set.
+func New(cfg Config, client *aws.Client) (*Profiler, error) {
+	for _, t := range cfg.Types {
+		if !slices.Contains(Types, t) {
+			return nil, fmt.Errorf("profiler: unknown profile type %q", t)
+		}
+	}
+	if cfg.Interval <= cfg.CPUDuration {
+		return nil, fmt.Errorf("profiler: interval %s must exceed the CPU profile duration %s", cfg.Interval, cfg.CPUDuration)
+	}
+	return &Profiler{cfg: cfg, client: client}, nil
+}
+
+// Run captures every Interval until ctx is done.
+func (p *Profiler) Run(ctx context.Context) {
+	if slices.Contains(p.cfg.Types, "mutex") {
+		runtime.SetMutexProfileFraction(p.cfg.MutexFraction)
+		defer runtime.SetMutexProfileFraction(0)
+	}
+	if slices.Contains(p.cfg.Types, "block") {
+		runtime.SetBlockProfileRate(p.cfg.BlockRate)
+		defer runtime.SetBlockProfileRate(0)
+	}
+	tick := time.NewTicker(p.cfg.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if err := p.Capture(ctx, time.Now()); err != nil {
+				captures.With("error").Inc()
+				slog.Error("profile capture failed", "err", err)
+				continue
+			}
+			captures.With("ok").Inc()
+		}
+	}
+}
+
+// Capture takes one set of profiles and stores it.
+func (p *Profiler) Capture(ctx context.Context, now time.Time) error {
+	meta := Meta{
+		Version:   buildinfo.Version(),
+		Instance:  p.cfg.Instance,
+		Time:      now.UTC(),
+		GoVersion: runtime.Version(),
+	}
+	comments := []string{
+		"version=" + meta.Version,
+		"instance=" + meta.Instance,
+		"captured=" + meta.Time.Format(time.RFC3339),
+	}
+	profiles := map[string][]byte{}
+	for _, t := range p.cfg.Types {
+		b, err := p.profile(ctx, t)
+		if err != nil {
+			profileErrors.With(t, "capture").Inc()
+			slog.Warn("profile not captured", "type", t, "err", err)
+			continue
+		}
+		if b, err = addComments(b, comments); err != nil {
+			profileErrors.With(t, "capture").Inc()
+			continue
+		}
+		profiles[t] = b
+		meta.Types = append(meta.Types, t)
+		if t == "cpu" {
+			meta.CPUDuration = p.cfg.CPUDuration.String()
+		}
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("profiler: no profiles captured")
+	}
+	mb, _ := json.MarshalIndent(meta, "", "  ")
+	name := captureName(meta.Time)
+	if p.cfg.Bucket != "" {
+		return p.upload(ctx, name, profiles, mb)
+	}
+	return p.store(name, profiles, mb)
+}
+
+func captureName(t time.Time) string { return t.Format("20060102T150405Z") }
+
+func (p *Profiler) profile(ctx context.Context, typ string) ([]byte, error) {
+	var buf bytes.Buffer
+	if typ == "cpu" {
+		// Fails if a CPU profile is already running, e.g. through
+		// /debug/pprof.
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.cfg.CPUDuration):
+		}
+		pprof.StopCPUProfile()
+		return buf.Bytes(), nil
+	}
+	prof := pprof.Lookup(typ)
+	if prof == nil {
+		return nil, fmt.Errorf("no %s profile", typ)
+	}
+	if err := prof.WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// store writes a capture to Dir/<time>/ and removes the oldest captures
+// beyond Keep.
+func (p *Profiler) store(name string, profiles map[string][]byte, meta []byte) error {
+	dir := filepath.Join(p.cfg.Dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for t, b := range profiles {
+		if err := os.WriteFile(filepath.Join(dir, t+".pb.gz"), b, 0o644); err != nil {
+			profileErrors.With(t, "store").Inc()
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta.json"), meta, 0o644); err != nil {
+		return err
+	}
+	if p.cfg.Keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(p.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	// Capture names sort by time.
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && strings.HasSuffix(e.Name(), "Z") {
+			names = append(names, e.Name())
+		}
+	}
+	for len(names) > p.cfg.Keep {
+		os.RemoveAll(filepath.Join(p.cfg.Dir, names[0]))
+		names = names[1:]
+	}
+	return nil
+}
+
+// upload puts a capture under Prefix<instance>/<time>/.
+func (p *Profiler) upload(ctx context.Context, name string, profiles map[string][]byte, meta []byte) error {
+	prefix := p.cfg.Prefix + p.cfg.Instance + "/" + name + "/"
+	for t, b := range profiles {
+		if err := p.client.PutObject(ctx, p.cfg.Bucket, prefix+t+".pb.gz", "application/octet-stream", b); err != nil {
+			profileErrors.With(t, "upload").Inc()
+			return err
+		}
+	}
+	return p.client.PutObject(ctx, p.cfg.Bucket, prefix+"meta.json", "application/json", meta)
+}
@@ -0,0 +1,120 @@
+package profiler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"goaws/internal/aws"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Types: []string{"threadcreate"}, Interval: time.Minute}, nil); err == nil {
+		t.Error("unknown type accepted")
+	}
+	if _, err := New(Config{Types: Types, Interval: time.Second, CPUDuration: 10 * time.Second}, nil); err == nil {
+		t.Error("interval shorter than the CPU profile accepted")
+	}
+}
+
+func TestCaptureStoresAndPrunes(t *testing.T) {
+	dir := t.TempDir()
+	p, err := New(Config{Types: []string{"heap", "goroutine"}, Interval: time.Minute, Dir: dir, Keep: 2, Instance: "i-1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		if err := p.Capture(context.Background(), start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !slices.Equal(names, []string{"20240501T120100Z", "20240501T120200Z"}) {
+		t.Fatalf("captures %v", names)
+	}
+
+	latest := filepath.Join(dir, "20240501T120200Z")
+	var meta Meta
+	b, _ := os.ReadFile(filepath.Join(latest, "meta.json"))
+	if err := json.Unmarshal(b, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Instance != "i-1" || !meta.Time.Equal(start.Add(2*time.Minute)) || !slices.Equal(meta.Types, []string{"heap", "goroutine"}) || meta.CPUDuration != "" {
+		t.Errorf("meta %+v", meta)
+	}
+	b, _ = readProfile(context.Background(), latest, "heap")
+	prof, err := ParseProfile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(prof.Comments, "instance=i-1") || !slices.Contains(prof.Comments, "captured=2024-05-01T12:02:00Z") {
+		t.Errorf("comments %q", prof.Comments)
+	}
+}
+
+func TestCaptureUploads(t *testing.T) {
+	var mu sync.Mutex
+	puts := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		io.ReadAll(r.Body)
+		puts[r.URL.Path] = r.Header.Get("Content-Type")
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	client := &aws.Client{Region: "us-east-1", Credentials: aws.StaticCredentials{AccessKeyID: "a", SecretAccessKey: "s"}, HTTP: srv.Client()}
+
+	p, err := New(Config{Types: []string{"goroutine"}, Interval: time.Minute, Bucket: "b", Prefix: "profiles/", Instance: "i-1"}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Capture(context.Background(), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/b/profiles/i-1/20240501T120000Z/goroutine.pb.gz": "application/octet-stream",
+		"/b/profiles/i-1/20240501T120000Z/meta.json":       "application/json",
+	}
+	if len(puts) != len(want) {
+		t.Errorf("puts %v", puts)
+	}
+	for k, v := range want {
+		if puts[k] != v {
+			t.Errorf("%s: content type %q", k, puts[k])
+		}
+	}
+}
+
+func TestCPUProfileStopsWithContext(t *testing.T) {
+	p, err := New(Config{Types: []string{"cpu"}, Interval: time.Hour, CPUDuration: time.Minute, Dir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	b, err := p.profile(ctx, "cpu")
+	if err != nil {
+		t.Skipf("CPU profile unavailable: %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("the CPU profile ran past the context")
+	}
+	if _, err := ParseProfile(b); err != nil {
+		t.Error(err)
+	}
+}
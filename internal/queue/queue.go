@@ -0,0 +1,316 @@
+// Package queue is a small durable job queue backed by one file per job in a
+// directory. It is meant for low volumes of background work that must survive
+// a restart of the process, such as webhook processing, on a single instance.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job is a unit of work as stored on disk.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	NotBefore time.Time       `json:"not_before"`
+	Created   time.Time       `json:"created"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+// Handler processes a job. Returning nil acknowledges it; any other error
+// schedules a retry, unless the error wraps Permanent.
+type Handler func(ctx context.Context, j *Job) error
+
+// Permanent marks an error as not worth retrying.
+var Permanent = errors.New("permanent failure")
+
+// DeferError postpones a job without counting an attempt, for when the
+// failure is known to be on the far side, e.g. an open circuit breaker.
+type DeferError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *DeferError) Error() string { return e.Err.Error() }
+func (e *DeferError) Unwrap() error { return e.Err }
+
+// Defer returns a DeferError for err.
+func Defer(err error, after time.Duration) error {
+	return &DeferError{Err: err, After: after}
+}
+
+// Options tune a Queue. Zero values get defaults.
+type Options struct {
+	Workers     int
+	MaxAttempts int
+	// Backoff returns the delay before retry number attempt (1-based).
+	Backoff func(attempt int) time.Duration
+	Poll    time.Duration
+}
+
+// ExponentialBackoff doubles base on every attempt up to max.
+func ExponentialBackoff(base, max time.Duration) func(int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := time.Duration(float64(base) * math.Pow(2, float64(attempt-1)))
+		if d > max || d <= 0 {
+			return max
+		}
+		return d
+	}
+}
+
+// Queue stores pending jobs in dir and moves jobs that exhaust their
+// attempts to dir/dead.
+type Queue struct {
+	dir  string
+	opts Options
+	log  *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]*Job
+	running map[string]bool
+	wake    chan struct{}
+}
+
+// Open loads any jobs left in dir by a previous run.
+func Open(dir string, opts Options) (*Queue, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(time.Second, 10*time.Minute)
+	}
+	if opts.Poll <= 0 {
+		opts.Poll = time.Second
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "dead"), 0o750); err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		dir:     dir,
+		opts:    opts,
+		log:     slog.Default().With("queue", filepath.Base(dir)),
+		pending: map[string]*Job{},
+		running: map[string]bool{},
+		wake:    make(chan struct{}, 1),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".job") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var j Job
+		if err := json.Unmarshal(b, &j); err != nil {
+			q.log.Warn("skipping corrupt job", "file", e.Name(), "err", err)
+			continue
+		}
+		q.pending[j.ID] = &j
+	}
+	return q, nil
+}
+
+// Enqueue durably stores a job and returns its ID.
+func (q *Queue) Enqueue(kind string, payload any) (string, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	j := &Job{ID: newID(now), Kind: kind, Payload: b, NotBefore: now, Created: now}
+	if err := q.write(j); err != nil {
+		return "", err
+	}
+	q.mu.Lock()
+	q.pending[j.ID] = j
+	q.mu.Unlock()
+	q.signal()
+	return j.ID, nil
+}
+
+// Len returns the number of jobs waiting or in progress.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Run processes jobs with h until ctx is done. Jobs in flight when ctx ends
+// stay on disk and are retried on the next Open.
+func (q *Queue) Run(ctx context.Context, h Handler) {
+	jobs := make(chan *Job)
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				q.process(ctx, h, j)
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	t := time.NewTicker(q.opts.Poll)
+	defer t.Stop()
+	for {
+		for _, j := range q.due() {
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				q.release(j)
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// due claims every job whose NotBefore has passed, oldest first.
+func (q *Queue) due() []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	var out []*Job
+	for id, j := range q.pending {
+		if !q.running[id] && !j.NotBefore.After(now) {
+			q.running[id] = true
+			out = append(out, j)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].ID < out[b].ID })
+	return out
+}
+
+func (q *Queue) release(j *Job) {
+	q.mu.Lock()
+	delete(q.running, j.ID)
+	q.mu.Unlock()
+}
+
+func (q *Queue) process(ctx context.Context, h Handler, j *Job) {
+	defer q.release(j)
+	err := h(ctx, j)
+	if err == nil {
+		q.remove(j)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	var d *DeferError
+	if errors.As(err, &d) {
+		j.NotBefore = time.Now().Add(d.After)
+		if err := q.write(j); err != nil {
+			q.log.Error("persisting deferred job", "id", j.ID, "err", err)
+		}
+		return
+	}
+	j.Attempts++
+	j.LastError = err.Error()
+	if errors.Is(err, Permanent) || j.Attempts >= q.opts.MaxAttempts {
+		q.log.Warn("job failed permanently", "id", j.ID, "kind", j.Kind, "attempts", j.Attempts, "err", err)
+		q.bury(j)
+		return
+	}
+	j.NotBefore = time.Now().Add(q.opts.Backoff(j.Attempts))
+	q.log.Info("job failed, will retry", "id", j.ID, "kind", j.Kind, "attempts", j.Attempts, "retry_at", j.NotBefore, "err", err)
+	if err := q.write(j); err != nil {
+		q.log.Error("persisting job retry", "id", j.ID, "err", err)
+	}
+}
+
+func (q *Queue) remove(j *Job) {
+	q.mu.Lock()
+	delete(q.pending, j.ID)
+	q.mu.Unlock()
+	if err := os.Remove(q.path(j.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		q.log.Error("removing finished job", "id", j.ID, "err", err)
+	}
+}
+
+func (q *Queue) bury(j *Job) {
+	q.mu.Lock()
+	delete(q.pending, j.ID)
+	q.mu.Unlock()
+	if err := q.write(j); err == nil {
+		err = os.Rename(q.path(j.ID), filepath.Join(q.dir, "dead", j.ID+".job"))
+		if err != nil {
+			q.log.Error("moving job to dead letters", "id", j.ID, "err", err)
+		}
+	}
+}
+
+// write atomically replaces the job's file.
+func (q *Queue) write(j *Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp := q.path(j.ID) + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(j.ID))
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.dir, id+".job")
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// newID returns a time-ordered unique ID.
+func newID(t time.Time) string {
+	var b [6]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%016x-%s", t.UnixNano(), hex.EncodeToString(b[:]))
+}
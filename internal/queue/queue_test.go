@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runUntil runs q with h until cond holds or a second passes.
+func runUntil(t *testing.T, q *Queue, h Handler, cond func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx, h)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !cond() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if !cond() {
+		t.Fatal("condition not reached")
+	}
+}
+
+func TestQueueSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if _, err := q.Enqueue("kind", map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err = Open(dir, Options{Workers: 1, Poll: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 3 {
+		t.Fatalf("reopened with %d jobs", q.Len())
+	}
+	var mu sync.Mutex
+	var got []string
+	runUntil(t, q, func(_ context.Context, j *Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, string(j.Payload))
+		return nil
+	}, func() bool { return q.Len() == 0 })
+
+	// Due jobs are handed out oldest first.
+	if fmt.Sprint(got) != `[{"n":0} {"n":1} {"n":2}]` {
+		t.Errorf("processed %v", got)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.job")); len(files) != 0 {
+		t.Errorf("left %v", files)
+	}
+}
+
+func TestQueueRetriesThenBuries(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, Options{MaxAttempts: 3, Poll: time.Millisecond, Backoff: func(int) time.Duration { return 0 }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := q.Enqueue("flaky", "x")
+	perm, _ := q.Enqueue("broken", "y")
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	runUntil(t, q, func(_ context.Context, j *Job) error {
+		mu.Lock()
+		attempts[j.ID]++
+		mu.Unlock()
+		if j.Kind == "broken" {
+			return fmt.Errorf("%w: bad payload", Permanent)
+		}
+		return errors.New("try again")
+	}, func() bool { return q.Len() == 0 })
+
+	if attempts[id] != 3 || attempts[perm] != 1 {
+		t.Errorf("attempts = %v", attempts)
+	}
+	for _, id := range []string{id, perm} {
+		if _, err := os.Stat(filepath.Join(dir, "dead", id+".job")); err != nil {
+			t.Errorf("%s not in dead letters: %v", id, err)
+		}
+	}
+}
+
+func TestQueueDeferDoesNotCountAttempt(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{MaxAttempts: 1, Poll: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Enqueue("k", nil)
+	var mu sync.Mutex
+	calls := 0
+	runUntil(t, q, func(_ context.Context, j *Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls < 3 {
+			return Defer(errors.New("circuit open"), 0)
+		}
+		if j.Attempts != 0 {
+			return fmt.Errorf("%d attempts counted", j.Attempts)
+		}
+		return nil
+	}, func() bool { return q.Len() == 0 })
+	if calls != 3 {
+		t.Errorf("calls = %d", calls)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff(time.Second, 10*time.Second)
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 200: 10 * time.Second} {
+		if got := b(attempt); got != want {
+			t.Errorf("attempt %d: %v, want %v", attempt, got, want)
+		}
+	}
+}
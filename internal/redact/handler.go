@@ -0,0 +1,84 @@
+package redact
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler returns a slog handler that redacts records before passing them
+// to h. Attributes are matched against the JSON paths with groups as
+// nesting, so $..password masks password in any group; messages and
+// string and error values are checked against the patterns. Other values
+// of kind Any are redacted as the JSON they encode to.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	if r == nil {
+		return h
+	}
+	return &handler{r: r, h: h}
+}
+
+type handler struct {
+	r    *Redactor
+	h    slog.Handler
+	path []elem
+}
+
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.h.Enabled(ctx, l)
+}
+
+func (h *handler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.String(SourceLog, rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.r.attr(h.path, a))
+		return true
+	})
+	return h.h.Handle(ctx, out)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	ra := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		ra[i] = h.r.attr(h.path, a)
+	}
+	return &handler{r: h.r, h: h.h.WithAttrs(ra), path: h.path}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &handler{r: h.r, h: h.h.WithGroup(name), path: append(h.path[:len(h.path):len(h.path)], elem{key: name})}
+}
+
+func (r *Redactor) attr(path []elem, a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	p := path
+	if a.Key != "" {
+		p = append(path[:len(path):len(path)], elem{key: a.Key})
+		if r.matchPath(p) {
+			redactions.With(SourceLog, "json").Inc()
+			return slog.String(a.Key, Mask)
+		}
+	}
+	switch v.Kind() {
+	case slog.KindGroup:
+		g := v.Group()
+		ra := make([]slog.Attr, len(g))
+		for i, ga := range g {
+			ra[i] = r.attr(p, ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(ra...)}
+	case slog.KindString:
+		return slog.String(a.Key, r.String(SourceLog, v.String()))
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			if s := r.String(SourceLog, err.Error()); s != err.Error() {
+				return slog.String(a.Key, s)
+			}
+			break
+		}
+		return slog.Attr{Key: a.Key, Value: slog.AnyValue(r.value(SourceLog, p, v.Any()))}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
@@ -0,0 +1,72 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func logJSON(t *testing.T, r *Redactor, log func(*slog.Logger)) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	log(slog.New(r.Handler(slog.NewJSONHandler(&buf, nil))))
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("%s: %v", buf.Bytes(), err)
+	}
+	return m
+}
+
+type credentials struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+type secret string
+
+func (secret) LogValue() slog.Value { return slog.StringValue("bob@example.com") }
+
+func TestHandler(t *testing.T) {
+	r := mustNew(t, Rules{JSONPaths: []string{"$..password", "$.req.token"}, Patterns: []string{"email"}})
+	m := logJSON(t, r, func(l *slog.Logger) {
+		l.With("password", "p1").WithGroup("req").Info("login by bob@example.com",
+			"token", "t",
+			slog.Group("form", "password", "p2", "email", "bob@example.com"),
+			"err", errors.New("no user bob@example.com"),
+			"plain_err", errors.New("denied"),
+			"creds", credentials{"alice", "p3"},
+			"addrs", []string{"bob@example.com", "x"},
+			"who", secret("s"),
+			"n", 3,
+		)
+	})
+	if m["msg"] != "login by [REDACTED]" || m["password"] != Mask {
+		t.Errorf("top level %v", m)
+	}
+	req := m["req"].(map[string]any)
+	want := map[string]string{
+		"token":     `"[REDACTED]"`,
+		"form":      `{"email":"[REDACTED]","password":"[REDACTED]"}`,
+		"err":       `"no user [REDACTED]"`,
+		"plain_err": `"denied"`,
+		"creds":     `{"password":"[REDACTED]","user":"alice"}`,
+		"addrs":     `["[REDACTED]","x"]`,
+		"who":       `"[REDACTED]"`,
+		"n":         `3`,
+	}
+	for k, w := range want {
+		if got, _ := json.Marshal(req[k]); string(got) != w {
+			t.Errorf("req.%s = %s, want %s", k, got, w)
+		}
+	}
+}
+
+func TestHandlerNil(t *testing.T) {
+	h := slog.NewJSONHandler(&bytes.Buffer{}, nil)
+	var r *Redactor
+	if r.Handler(h) != slog.Handler(h) {
+		t.Error("nil Redactor wrapped the handler")
+	}
+}
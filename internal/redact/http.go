@@ -0,0 +1,91 @@
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Header redacts one header value: listed headers are masked whole, others
+// are checked against the patterns.
+func (r *Redactor) Header(source, name, value string) string {
+	if r == nil {
+		return value
+	}
+	if r.headers[strings.ToLower(name)] {
+		redactions.With(source, "header").Inc()
+		return Mask
+	}
+	return r.String(source, value)
+}
+
+// Headers returns a redacted copy of h.
+func (r *Redactor) Headers(source string, h http.Header) http.Header {
+	if r == nil {
+		return h
+	}
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		rv := make([]string, len(vs))
+		for i, v := range vs {
+			rv[i] = r.Header(source, k, v)
+		}
+		out[k] = rv
+	}
+	return out
+}
+
+// Query redacts a raw query string, keeping its order and encoding: listed
+// parameters are masked whole, others are masked if their decoded value
+// matches a pattern.
+func (r *Redactor) Query(source, raw string) string {
+	if r == nil || raw == "" {
+		return raw
+	}
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			name = k
+		}
+		if r.params[strings.ToLower(name)] {
+			redactions.With(source, "query").Inc()
+			parts[i] = k + "=" + Mask
+			continue
+		}
+		if dv, err := url.QueryUnescape(v); err == nil {
+			if rv := r.String(source, dv); rv != dv {
+				parts[i] = k + "=" + Mask
+			}
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// URL redacts a URL's query and password.
+func (r *Redactor) URL(source string, u *url.URL) string {
+	if r == nil {
+		return u.String()
+	}
+	c := *u
+	if _, ok := c.User.Password(); ok {
+		redactions.With(source, "password").Inc()
+		c.User = url.UserPassword(c.User.Username(), Mask)
+	}
+	c.RawQuery = r.Query(source, c.RawQuery)
+	return c.String()
+}
+
+// RequestURI redacts the query of a request-target such as
+// r.URL.RequestURI().
+func (r *Redactor) RequestURI(source, uri string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok || r == nil {
+		return uri
+	}
+	return path + "?" + r.Query(source, query)
+}
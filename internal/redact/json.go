@@ -0,0 +1,194 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// step is one element of a JSON path.
+type step struct {
+	key     string
+	index   int
+	isIndex bool
+	wild    bool
+	// desc matches the step at any depth below the previous one (..key).
+	desc bool
+}
+
+type jsonPath []step
+
+// elem is one element of a value's location in a document.
+type elem struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func parseJSONPath(p string) (jsonPath, error) {
+	bad := func() (jsonPath, error) { return nil, fmt.Errorf("redact: bad JSON path %q", p) }
+	rest, ok := strings.CutPrefix(p, "$")
+	if !ok {
+		return bad()
+	}
+	var jp jsonPath
+	for rest != "" {
+		var s step
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			s.desc = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return bad()
+			}
+			in := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case in == "*":
+				s.wild = true
+			case len(in) >= 2 && in[0] == '\'' && in[len(in)-1] == '\'':
+				s.key = in[1 : len(in)-1]
+			default:
+				n, err := strconv.Atoi(in)
+				if err != nil {
+					return bad()
+				}
+				s.index, s.isIndex = n, true
+			}
+			jp = append(jp, s)
+			continue
+		default:
+			return bad()
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return bad()
+		}
+		s.key, rest = rest[:end], rest[end:]
+		s.wild = s.key == "*"
+		jp = append(jp, s)
+	}
+	if len(jp) == 0 {
+		return bad()
+	}
+	return jp, nil
+}
+
+func (s step) matches(e elem) bool {
+	switch {
+	case s.wild:
+		return true
+	case s.isIndex:
+		return e.isIndex && e.index == s.index
+	}
+	return !e.isIndex && strings.EqualFold(s.key, e.key)
+}
+
+func (jp jsonPath) match(path []elem) bool {
+	if len(jp) == 0 {
+		return len(path) == 0
+	}
+	s := jp[0]
+	if s.desc {
+		for i := range path {
+			if s.matches(path[i]) && jp[1:].match(path[i+1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(path) > 0 && s.matches(path[0]) && jp[1:].match(path[1:])
+}
+
+func (r *Redactor) matchPath(path []elem) bool {
+	for _, jp := range r.paths {
+		if jp.match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON redacts a JSON document: values at a JSON path are masked whole and
+// other strings are checked against the patterns. Input that is not JSON
+// is treated as a string.
+func (r *Redactor) JSON(source string, b []byte) []byte {
+	if r == nil {
+		return b
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return []byte(r.String(source, string(b)))
+	}
+	out, err := marshal(r.walk(source, nil, v))
+	if err != nil {
+		return []byte(r.String(source, string(b)))
+	}
+	return out
+}
+
+// Value redacts any value that encodes to JSON, returning the redacted
+// document decoded into maps, slices and scalars.
+func (r *Redactor) Value(source string, v any) any {
+	if r == nil {
+		return v
+	}
+	return r.value(source, nil, v)
+}
+
+// value redacts v as if it were found at path.
+func (r *Redactor) value(source string, path []elem, v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc any
+	if dec.Decode(&doc) != nil {
+		return v
+	}
+	return r.walk(source, path, doc)
+}
+
+func (r *Redactor) walk(source string, path []elem, v any) any {
+	if len(path) > 0 && r.matchPath(path) {
+		redactions.With(source, "json").Inc()
+		return Mask
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for k, vv := range v {
+			v[k] = r.walk(source, append(path[:len(path):len(path)], elem{key: k}), vv)
+		}
+	case []any:
+		for i, vv := range v {
+			v[i] = r.walk(source, append(path[:len(path):len(path)], elem{index: i, isIndex: true}), vv)
+		}
+	case string:
+		return r.String(source, v)
+	}
+	return v
+}
+
+// marshal encodes v without escaping <, > and &, which only matter in HTML.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,232 @@
+// Package redact removes secrets and personal data before they reach logs,
+// access logs, traces or request recordings. A Redactor is built from
+// Rules, a plain value, so a rule set can be checked in isolation:
+//
+//	r, _ := redact.New(redact.Rules{JSONPaths: []string{"$..password"}})
+//	r.JSON(redact.SourceLog, []byte(`{"user":{"password":"x"}}`))
+//	// {"user":{"password":"[REDACTED]"}}
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"goaws/internal/env"
+	"goaws/internal/metrics"
+)
+
+// Mask replaces redacted values.
+const Mask = "[REDACTED]"
+
+// Sources label where a redaction was applied.
+const (
+	SourceLog       = "log"
+	SourceAccessLog = "accesslog"
+	SourceTrace     = "trace"
+	SourceRecording = "recording"
+	SourceCrash     = "crash"
+)
+
+var redactions = metrics.NewCounter("redactions_total", "Values redacted, by where and by which kind of rule.", "source", "rule")
+
+// Rules say what to redact.
+type Rules struct {
+	// Headers are HTTP header names, matched case-insensitively.
+	Headers []string
+	// QueryParams are URL query parameter names, matched
+	// case-insensitively.
+	QueryParams []string
+	// JSONPaths select values in JSON documents and in structured log
+	// attributes, where groups nest. A path is $ followed by .key, .*,
+	// [*] or [n] steps; ..key matches key at any depth. Keys match
+	// case-insensitively.
+	JSONPaths []string
+	// Patterns name built-in patterns (email, bearer, jwt, aws_key,
+	// github_token, card, or token for the four token kinds) or are
+	// regular expressions. Matches in any string are masked.
+	Patterns []string
+}
+
+// DefaultRules cover credentials this service handles and common personal
+// data.
+var DefaultRules = Rules{
+	Headers: []string{
+		"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+		"X-Api-Key", "X-Amz-Security-Token", "X-Hub-Signature-256",
+	},
+	QueryParams: []string{
+		"access_token", "id_token", "token", "code", "client_secret", "password",
+		"api_key", "X-Amz-Signature", "X-Amz-Credential", "X-Amz-Security-Token",
+	},
+	JSONPaths: []string{
+		"$..password", "$..secret", "$..client_secret", "$..token", "$..access_token",
+		"$..refresh_token", "$..id_token", "$..api_key", "$..authorization", "$..cookie",
+	},
+	Patterns: []string{"email", "token", "card"},
+}
+
+// RulesFromEnv reads REDACT_HEADERS, REDACT_QUERY_PARAMS and
+// REDACT_JSON_PATHS (comma-separated lists that replace the defaults),
+// REDACT_PATTERNS (built-in pattern names) and REDACT_REGEX (one extra
+// regular expression).
+func RulesFromEnv() Rules {
+	r := Rules{
+		Headers:     env.List("REDACT_HEADERS", DefaultRules.Headers),
+		QueryParams: env.List("REDACT_QUERY_PARAMS", DefaultRules.QueryParams),
+		JSONPaths:   env.List("REDACT_JSON_PATHS", DefaultRules.JSONPaths),
+		Patterns:    env.List("REDACT_PATTERNS", DefaultRules.Patterns),
+	}
+	if re := env.String("REDACT_REGEX", ""); re != "" {
+		r.Patterns = append(r.Patterns, re)
+	}
+	return r
+}
+
+// FromEnv returns the Redactor configured by RulesFromEnv, or nil when
+// REDACT is false.
+func FromEnv() (*Redactor, error) {
+	if !env.Bool("REDACT", true) {
+		return nil, nil
+	}
+	return New(RulesFromEnv())
+}
+
+type pattern struct {
+	name string
+	re   *regexp.Regexp
+	// valid, if set, must accept a match for it to be masked.
+	valid func(string) bool
+}
+
+var builtins = map[string]pattern{
+	"email":        {re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+	"bearer":       {re: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`)},
+	"jwt":          {re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	"aws_key":      {re: regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`)},
+	"github_token": {re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,})\b`)},
+	"card":         {re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhn},
+}
+
+// Redactor applies Rules. A nil Redactor passes everything through, so
+// callers need not check whether redaction is on.
+type Redactor struct {
+	headers  map[string]bool
+	params   map[string]bool
+	paths    []jsonPath
+	patterns []pattern
+}
+
+// New compiles rules.
+func New(rules Rules) (*Redactor, error) {
+	r := &Redactor{headers: map[string]bool{}, params: map[string]bool{}}
+	for _, h := range rules.Headers {
+		r.headers[strings.ToLower(h)] = true
+	}
+	for _, p := range rules.QueryParams {
+		r.params[strings.ToLower(p)] = true
+	}
+	for _, p := range rules.JSONPaths {
+		jp, err := parseJSONPath(p)
+		if err != nil {
+			return nil, err
+		}
+		r.paths = append(r.paths, jp)
+	}
+	for _, p := range rules.Patterns {
+		if p == "token" {
+			for _, name := range []string{"bearer", "jwt", "aws_key", "github_token"} {
+				r.patterns = append(r.patterns, withName(builtins[name], name))
+			}
+			continue
+		}
+		if b, ok := builtins[p]; ok {
+			r.patterns = append(r.patterns, withName(b, p))
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redact: pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, pattern{name: "regex", re: re})
+	}
+	return r, nil
+}
+
+func withName(p pattern, name string) pattern {
+	p.name = name
+	return p
+}
+
+// String masks pattern matches in s.
+func (r *Redactor) String(source, s string) string {
+	if r == nil {
+		return s
+	}
+	for _, p := range r.patterns {
+		n := 0
+		s = p.re.ReplaceAllStringFunc(s, func(m string) string {
+			if p.valid != nil && !p.valid(m) {
+				return m
+			}
+			n++
+			return Mask
+		})
+		if n > 0 {
+			redactions.With(source, p.name).Add(float64(n))
+		}
+	}
+	return s
+}
+
+// luhn reports whether the digits in s pass the Luhn check, which card
+// numbers do and most other long numbers do not.
+func luhn(s string) bool {
+	sum, double, digits := 0, false, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// Attr redacts a dotted attribute such as a span attribute.
+// http.request.header.<name> and http.response.header.<name> follow the
+// header rules; other keys are matched against the JSON paths split on
+// dots, so $..token masks oauth.token. String values are checked against
+// the patterns.
+func (r *Redactor) Attr(source, key string, v any) any {
+	if r == nil {
+		return v
+	}
+	for _, prefix := range []string{"http.request.header.", "http.response.header."} {
+		if name, ok := strings.CutPrefix(key, prefix); ok && r.headers[strings.ToLower(name)] {
+			redactions.With(source, "header").Inc()
+			return Mask
+		}
+	}
+	parts := strings.Split(key, ".")
+	path := make([]elem, len(parts))
+	for i, p := range parts {
+		path[i] = elem{key: p}
+	}
+	if r.matchPath(path) {
+		redactions.With(source, "json").Inc()
+		return Mask
+	}
+	if s, ok := v.(string); ok {
+		return r.String(source, s)
+	}
+	return v
+}
@@ -0,0 +1,389 @@
+// Package slo tracks per-route service level objectives from request
+// outcomes and reports how fast each is burning its error budget.
+package slo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goaws/internal/env"
+	"goaws/internal/httpx"
+	"goaws/internal/metrics"
+)
+
+var (
+	burnRate        = metrics.NewGauge("slo_burn_rate", "Error budget burn rate over a window; 1 spends the budget exactly over the SLO window.", "route", "sli", "window")
+	budgetRemaining = metrics.NewGauge("slo_error_budget_remaining", "Fraction of the error budget left over the SLO window.", "route", "sli")
+	compliance      = metrics.NewGauge("slo_compliance", "Fraction of good requests over the SLO window.", "route", "sli")
+	objectiveGauge  = metrics.NewGauge("slo_objective", "Target fraction of good requests.", "route", "sli")
+	fastBurnGauge   = metrics.NewGauge("slo_fast_burn", "1 while the fast-burn alert is firing.", "route", "sli")
+)
+
+// Burn rate windows. The fast-burn alert needs both the long and the short
+// window over the threshold, so it fires quickly and clears quickly.
+var windows = []struct {
+	name string
+	d    time.Duration
+}{{"5m", 5 * time.Minute}, {"30m", 30 * time.Minute}, {"1h", time.Hour}, {"6h", 6 * time.Hour}}
+
+// Config configures SLO tracking. It is normally built by ConfigFromEnv.
+type Config struct {
+	// File holds the objectives; tracking is off when it is empty.
+	File string
+	// FastBurn and SlowBurn are burn rate thresholds: fast burn is the
+	// 1h and 5m rates over FastBurn (14.4 spends 2% of a 30 day budget
+	// in an hour), slow burn the 6h and 30m rates over SlowBurn.
+	FastBurn float64
+	SlowBurn float64
+	// MinRequests in the last hour are needed before an alert fires, so
+	// a single failure at night does not page.
+	MinRequests int
+	Interval    time.Duration
+}
+
+// ConfigFromEnv reads SLO_FILE, SLO_FAST_BURN, SLO_SLOW_BURN,
+// SLO_MIN_REQUESTS and SLO_EVAL_INTERVAL.
+func ConfigFromEnv() Config {
+	return Config{
+		File:        env.String("SLO_FILE", ""),
+		FastBurn:    env.Float("SLO_FAST_BURN", 14.4),
+		SlowBurn:    env.Float("SLO_SLOW_BURN", 6),
+		MinRequests: env.Int("SLO_MIN_REQUESTS", 20),
+		Interval:    env.Duration("SLO_EVAL_INTERVAL", 15*time.Second),
+	}
+}
+
+// Objective is one entry of the objectives file:
+//
+//	{"objectives": [
+//	  {"route": "GET /api/", "availability": 99.9,
+//	   "latency": {"threshold": "300ms", "target": 99}, "window": "30d"}]}
+//
+// Route is a mux pattern as registered, or * for every request.
+// Availability is the percentage of requests that must not fail with a
+// 5xx; latency the percentage that must complete within the threshold.
+// Either may be omitted. Window defaults to 30d.
+type Objective struct {
+	Route        string   `json:"route"`
+	Availability float64  `json:"availability"`
+	Latency      *Latency `json:"latency"`
+	Window       string   `json:"window"`
+}
+
+// Latency is a latency objective.
+type Latency struct {
+	Threshold string  `json:"threshold"`
+	Target    float64 `json:"target"`
+}
+
+// SLI kinds.
+const (
+	SLIAvailability = "availability"
+	SLILatency      = "latency"
+)
+
+// Tracker records request outcomes against the objectives.
+type Tracker struct {
+	cfg    Config
+	routes []*route
+	// firing is owned by Run.
+	firing map[string]bool
+}
+
+type route struct {
+	pattern    string
+	window     time.Duration
+	windowName string
+	threshold  time.Duration
+	slis       []sli
+
+	mu      sync.Mutex
+	minutes []bucket
+	hours   []bucket
+}
+
+type sli struct {
+	kind   string
+	target float64 // fraction
+}
+
+// bucket counts requests in one minute or hour. bad is indexed like
+// route.slis.
+type bucket struct {
+	start int64
+	total int64
+	bad   [2]int64
+}
+
+// Load reads the objectives in cfg.File.
+func Load(cfg Config) (*Tracker, error) {
+	b, err := os.ReadFile(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("slo: %w", err)
+	}
+	var doc struct {
+		Objectives []Objective `json:"objectives"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("slo: parsing %s: %w", cfg.File, err)
+	}
+	return New(cfg, doc.Objectives)
+}
+
+// New returns a tracker for objectives.
+func New(cfg Config, objectives []Objective) (*Tracker, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 15 * time.Second
+	}
+	t := &Tracker{cfg: cfg, firing: map[string]bool{}}
+	for _, o := range objectives {
+		r := &route{pattern: o.Route, window: 30 * 24 * time.Hour, windowName: "30d"}
+		if r.pattern == "" {
+			return nil, fmt.Errorf("slo: objective without a route")
+		}
+		if o.Window != "" {
+			w, err := parseWindow(o.Window)
+			if err != nil {
+				return nil, fmt.Errorf("slo: %s: window: %w", o.Route, err)
+			}
+			r.window, r.windowName = w, o.Window
+		}
+		if o.Availability > 0 {
+			if o.Availability >= 100 {
+				return nil, fmt.Errorf("slo: %s: availability must be below 100", o.Route)
+			}
+			r.slis = append(r.slis, sli{SLIAvailability, o.Availability / 100})
+		}
+		if o.Latency != nil {
+			d, err := time.ParseDuration(o.Latency.Threshold)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("slo: %s: bad latency threshold %q", o.Route, o.Latency.Threshold)
+			}
+			if o.Latency.Target <= 0 || o.Latency.Target >= 100 {
+				return nil, fmt.Errorf("slo: %s: latency target must be between 0 and 100", o.Route)
+			}
+			r.threshold = d
+			r.slis = append(r.slis, sli{SLILatency, o.Latency.Target / 100})
+		}
+		if len(r.slis) == 0 {
+			return nil, fmt.Errorf("slo: %s: no availability or latency objective", o.Route)
+		}
+		r.minutes = make([]bucket, int(windows[len(windows)-1].d/time.Minute))
+		r.hours = make([]bucket, max(int(r.window/time.Hour), 1))
+		t.routes = append(t.routes, r)
+		for _, s := range r.slis {
+			objectiveGauge.With(r.pattern, s.kind).Set(s.target)
+		}
+	}
+	return t, nil
+}
+
+// parseWindow accepts Go durations plus whole days, e.g. 30d.
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("bad window %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Hour {
+		return 0, fmt.Errorf("bad window %q (at least 1h)", s)
+	}
+	return d, nil
+}
+
+// Middleware records the outcome of every request. It must wrap the mux
+// directly so that the route is known when the handler returns.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := httpx.NewRecorder(w)
+		next.ServeHTTP(rec, r)
+		t.Record(httpx.Route(r), rec.Status(), time.Since(start), time.Now())
+	})
+}
+
+// Record adds one request outcome.
+func (t *Tracker) Record(pattern string, status int, d time.Duration, now time.Time) {
+	for _, r := range t.routes {
+		if r.pattern != "*" && r.pattern != pattern {
+			continue
+		}
+		var bad [2]int64
+		for i, s := range r.slis {
+			switch s.kind {
+			case SLIAvailability:
+				if status >= 500 {
+					bad[i] = 1
+				}
+			case SLILatency:
+				if d > r.threshold {
+					bad[i] = 1
+				}
+			}
+		}
+		r.mu.Lock()
+		r.minutes[slot(r.minutes, now.Unix()/60)].add(now.Unix()/60, bad)
+		r.hours[slot(r.hours, now.Unix()/3600)].add(now.Unix()/3600, bad)
+		r.mu.Unlock()
+	}
+}
+
+func slot(ring []bucket, idx int64) int { return int(idx % int64(len(ring))) }
+
+func (b *bucket) add(start int64, bad [2]int64) {
+	if b.start != start {
+		*b = bucket{start: start}
+	}
+	b.total++
+	b.bad[0] += bad[0]
+	b.bad[1] += bad[1]
+}
+
+// sum adds the buckets of ring that started within the last n units before
+// now (in the ring's units).
+func sum(ring []bucket, now, n int64) (total int64, bad [2]int64) {
+	for _, b := range ring {
+		if b.total > 0 && b.start > now-n && b.start <= now {
+			total += b.total
+			bad[0] += b.bad[0]
+			bad[1] += b.bad[1]
+		}
+	}
+	return total, bad
+}
+
+// Status is the state of one SLI of one route.
+type Status struct {
+	Route     string  `json:"route"`
+	SLI       string  `json:"sli"`
+	Objective float64 `json:"objective"`
+	Window    string  `json:"window"`
+	// Threshold is the latency objective's threshold.
+	Threshold string `json:"threshold,omitempty"`
+	// Requests, Compliance and BudgetRemaining cover the SLO window, or
+	// as much of it as this process has seen.
+	Requests        int64              `json:"requests"`
+	Compliance      float64            `json:"compliance"`
+	BudgetRemaining float64            `json:"error_budget_remaining"`
+	BurnRates       map[string]float64 `json:"burn_rates"`
+	FastBurn        bool               `json:"fast_burn"`
+	SlowBurn        bool               `json:"slow_burn"`
+}
+
+// Status evaluates every objective at now.
+func (t *Tracker) Status(now time.Time) []Status {
+	var out []Status
+	minute, hour := now.Unix()/60, now.Unix()/3600
+	for _, r := range t.routes {
+		r.mu.Lock()
+		total, bad := sum(r.hours, hour, int64(len(r.hours)))
+		type counts struct {
+			total int64
+			bad   [2]int64
+		}
+		byWindow := make([]counts, len(windows))
+		for i, w := range windows {
+			byWindow[i].total, byWindow[i].bad = sum(r.minutes, minute, int64(w.d/time.Minute))
+		}
+		r.mu.Unlock()
+
+		for i, s := range r.slis {
+			st := Status{
+				Route:           r.pattern,
+				SLI:             s.kind,
+				Objective:       s.target,
+				Window:          r.windowName,
+				Requests:        total,
+				Compliance:      1,
+				BudgetRemaining: 1,
+				BurnRates:       map[string]float64{},
+			}
+			if s.kind == SLILatency {
+				st.Threshold = r.threshold.String()
+			}
+			allowed := 1 - s.target
+			if total > 0 {
+				st.Compliance = 1 - float64(bad[i])/float64(total)
+				st.BudgetRemaining = 1 - (float64(bad[i])/float64(total))/allowed
+			}
+			for j, w := range windows {
+				if c := byWindow[j]; c.total > 0 {
+					st.BurnRates[w.name] = float64(c.bad[i]) / float64(c.total) / allowed
+				} else {
+					st.BurnRates[w.name] = 0
+				}
+			}
+			enough := byWindow[2].total >= int64(t.cfg.MinRequests)
+			st.FastBurn = enough && st.BurnRates["1h"] > t.cfg.FastBurn && st.BurnRates["5m"] > t.cfg.FastBurn
+			st.SlowBurn = enough && st.BurnRates["6h"] > t.cfg.SlowBurn && st.BurnRates["30m"] > t.cfg.SlowBurn
+			out = append(out, st)
+		}
+	}
+	return out
+}
+
+// Run evaluates the objectives every Interval, updating the slo_* metrics
+// and logging when a fast burn starts and ends.
+func (t *Tracker) Run(ctx context.Context) {
+	tick := time.NewTicker(t.cfg.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			t.evaluate(now)
+		}
+	}
+}
+
+func (t *Tracker) evaluate(now time.Time) {
+	for _, st := range t.Status(now) {
+		for name, v := range st.BurnRates {
+			burnRate.With(st.Route, st.SLI, name).Set(v)
+		}
+		budgetRemaining.With(st.Route, st.SLI).Set(st.BudgetRemaining)
+		compliance.With(st.Route, st.SLI).Set(st.Compliance)
+		firing := 0.0
+		if st.FastBurn {
+			firing = 1
+		}
+		fastBurnGauge.With(st.Route, st.SLI).Set(firing)
+
+		key := st.Route + "\xff" + st.SLI
+		was := t.firing[key]
+		t.firing[key] = st.FastBurn
+		attrs := []any{
+			"route", st.Route, "sli", st.SLI, "objective", st.Objective,
+			"burn_rate_1h", st.BurnRates["1h"], "burn_rate_5m", st.BurnRates["5m"],
+			"error_budget_remaining", st.BudgetRemaining,
+		}
+		switch {
+		case st.FastBurn && !was:
+			slog.Error("SLO fast burn", attrs...)
+		case !st.FastBurn && was:
+			slog.Info("SLO fast burn resolved", attrs...)
+		}
+	}
+}
+
+// Handler serves the current status as JSON, for /debug/slo.
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]any{"objectives": t.Status(time.Now())})
+	})
+}
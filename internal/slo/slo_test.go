@@ -0,0 +1,210 @@
+package slo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goaws/internal/httpx"
+	"goaws/internal/logging"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func newTracker(t *testing.T, objectives ...Objective) *Tracker {
+	t.Helper()
+	tr, err := New(Config{FastBurn: 14.4, SlowBurn: 6, MinRequests: 20}, objectives)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func find(sts []Status, route, kind string) Status {
+	for _, st := range sts {
+		if st.Route == route && st.SLI == kind {
+			return st
+		}
+	}
+	return Status{}
+}
+
+func TestNewRejectsBadObjectives(t *testing.T) {
+	for _, o := range []Objective{
+		{Availability: 99},
+		{Route: "*"},
+		{Route: "*", Availability: 100},
+		{Route: "*", Availability: 99, Window: "30m"},
+		{Route: "*", Availability: 99, Window: "0d"},
+		{Route: "*", Latency: &Latency{Threshold: "fast", Target: 99}},
+		{Route: "*", Latency: &Latency{Threshold: "100ms", Target: 100}},
+	} {
+		if _, err := New(Config{}, []Objective{o}); err == nil {
+			t.Errorf("%+v accepted", o)
+		}
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	for s, want := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "1d": 24 * time.Hour, "12h": 12 * time.Hour} {
+		if got, err := parseWindow(s); err != nil || got != want {
+			t.Errorf("parseWindow(%q) = %v, %v", s, got, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "slo.json")
+	os.WriteFile(file, []byte(`{"objectives":[{"route":"GET /api/","availability":99.9,"latency":{"threshold":"300ms","target":99},"window":"7d"}]}`), 0o644)
+	tr, err := Load(Config{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sts := tr.Status(time.Now())
+	if len(sts) != 2 || sts[0].Window != "7d" || !near(sts[0].Objective, 0.999) || sts[1].Threshold != "300ms" {
+		t.Errorf("status %+v", sts)
+	}
+	if len(tr.routes[0].hours) != 7*24 {
+		t.Errorf("%d hourly buckets for 7d", len(tr.routes[0].hours))
+	}
+	os.WriteFile(file, []byte(`{"objectives":`), 0o644)
+	if _, err := Load(Config{File: file}); err == nil {
+		t.Error("bad JSON accepted")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tr := newTracker(t,
+		Objective{Route: "GET /a", Availability: 99, Latency: &Latency{Threshold: "100ms", Target: 90}},
+		Objective{Route: "*", Availability: 99},
+	)
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	// Two hours ago: 10 failures, outside every burn window but 6h.
+	for range 10 {
+		tr.Record("GET /a", 503, time.Millisecond, now.Add(-2*time.Hour))
+	}
+	// Now: 90 good requests, 5 failures and 5 slow ones.
+	for i := range 100 {
+		status, d := 200, time.Millisecond
+		switch {
+		case i < 5:
+			status = 500
+		case i < 10:
+			d = time.Second
+		}
+		tr.Record("GET /a", status, d, now)
+	}
+	tr.Record("GET /b", 200, time.Millisecond, now)
+
+	sts := tr.Status(now)
+	avail := find(sts, "GET /a", SLIAvailability)
+	if avail.Requests != 110 || !near(avail.Compliance, 1-15.0/110) || !near(avail.BudgetRemaining, 1-(15.0/110)/0.01) {
+		t.Errorf("availability %+v", avail)
+	}
+	for w, want := range map[string]float64{"5m": 5, "30m": 5, "1h": 5, "6h": 15.0 / 110 / 0.01} {
+		if got := avail.BurnRates[w]; !near(got, want) {
+			t.Errorf("availability burn rate %s = %v, want %v", w, got, want)
+		}
+	}
+	// 5 is under both thresholds.
+	if avail.FastBurn || avail.SlowBurn {
+		t.Errorf("availability fast %v, slow %v", avail.FastBurn, avail.SlowBurn)
+	}
+
+	// Slow requests are bad for latency only; 5% against a 10% budget.
+	lat := find(sts, "GET /a", SLILatency)
+	if !near(lat.BurnRates["5m"], 0.5) || lat.Threshold != "100ms" || lat.SlowBurn {
+		t.Errorf("latency %+v", lat)
+	}
+
+	all := find(sts, "*", SLIAvailability)
+	if all.Requests != 111 {
+		t.Errorf("* counted %d requests", all.Requests)
+	}
+
+	// Once the minute buckets have aged out, the short windows are empty.
+	later := tr.Status(now.Add(7 * time.Hour))
+	if st := find(later, "GET /a", SLIAvailability); st.BurnRates["6h"] != 0 || st.Requests != 110 {
+		t.Errorf("seven hours later %+v", st)
+	}
+}
+
+func TestFastBurnNeedsMinRequests(t *testing.T) {
+	tr := newTracker(t, Objective{Route: "*", Availability: 99})
+	now := time.Now()
+	for range 19 {
+		tr.Record("GET /", 500, 0, now)
+	}
+	if st := tr.Status(now)[0]; st.FastBurn || st.SlowBurn {
+		t.Errorf("alert with 19 requests: %+v", st)
+	}
+	tr.Record("GET /", 500, 0, now)
+	if st := tr.Status(now)[0]; !st.FastBurn || !st.SlowBurn {
+		t.Errorf("no alert with 20 failures: %+v", st)
+	}
+}
+
+func TestEvaluateLogsTransitions(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	tr := newTracker(t, Objective{Route: "*", Availability: 99})
+	now := time.Now()
+	for range 20 {
+		tr.Record("GET /", 500, 0, now)
+	}
+	tr.evaluate(now)
+	tr.evaluate(now)
+	if n := strings.Count(buf.String(), `msg="SLO fast burn"`); n != 1 {
+		t.Errorf("fast burn logged %d times:\n%s", n, &buf)
+	}
+	tr.evaluate(now.Add(2 * time.Hour))
+	if !strings.Contains(buf.String(), "SLO fast burn resolved") {
+		t.Errorf("resolution not logged:\n%s", &buf)
+	}
+}
+
+func TestMiddlewareAndHandler(t *testing.T) {
+	tr := newTracker(t, Objective{Route: "GET /fail", Availability: 99})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(502) })
+	h := tr.Middleware(mux)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/other", nil))
+
+	rec := httptest.NewRecorder()
+	tr.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/slo", nil))
+	var doc struct{ Objectives []Status }
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Objectives) != 1 || doc.Objectives[0].Requests != 1 || doc.Objectives[0].Compliance != 0 {
+		t.Errorf("status %+v", doc.Objectives)
+	}
+}
+
+func TestMiddlewareLearnsRouteThroughCopies(t *testing.T) {
+	tr := newTracker(t, Objective{Route: "GET /x", Availability: 99})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /x", func(http.ResponseWriter, *http.Request) {})
+	inner := httpx.Routes(mux)
+	// The copy stands in for middleware between this one and the mux.
+	h := logging.Middleware(tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), struct{}{}, 1)))
+	})))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+	if st := tr.Status(time.Now())[0]; st.Requests != 1 {
+		t.Errorf("route not learned: %+v", st)
+	}
+}
@@ -0,0 +1,351 @@
+// Package statsd pushes metrics to a StatsD or DogStatsD agent over UDP.
+// Values are aggregated in process and sent once per flush interval:
+// counters are summed, gauges keep their last value, and timings and
+// histograms are batched into as few packets as the agent accepts.
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goaws/internal/env"
+	"goaws/internal/metrics"
+)
+
+var (
+	packetsSent    = metrics.NewCounter("statsd_packets_sent_total", "StatsD packets written.")
+	packetsDropped = metrics.NewCounter("statsd_packets_dropped_total", "StatsD packets that could not be written, e.g. because the agent is down.")
+	metricsDropped = metrics.NewCounter("statsd_metrics_dropped_total", "StatsD values dropped before sending.", "reason")
+)
+
+// Config configures the client. It is normally built by ConfigFromEnv.
+type Config struct {
+	// Addr is the agent's UDP address, e.g. 127.0.0.1:8125.
+	Addr string
+	// Flavor is "dogstatsd", which sends tags and multi-value packets,
+	// or "statsd", which folds tag values into the metric name.
+	Flavor        string
+	Prefix        string
+	Tags          []string
+	FlushInterval time.Duration
+	// MaxPacketSize bounds each datagram. 1432 fits an Ethernet MTU;
+	// raise it for loopback agents.
+	MaxPacketSize int
+	// MaxSeries bounds the distinct metric/tag combinations, and
+	// MaxValues the timing and histogram samples, held between flushes.
+	MaxSeries int
+	MaxValues int
+}
+
+// ConfigFromEnv reads the STATSD_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Addr:          env.String("STATSD_ADDR", "127.0.0.1:8125"),
+		Flavor:        env.String("STATSD_FLAVOR", "dogstatsd"),
+		Prefix:        env.String("STATSD_PREFIX", "srv."),
+		Tags:          env.List("STATSD_TAGS", nil),
+		FlushInterval: env.Duration("STATSD_FLUSH_INTERVAL", 10*time.Second),
+		MaxPacketSize: env.Int("STATSD_MAX_PACKET_SIZE", 1432),
+		MaxSeries:     env.Int("STATSD_MAX_SERIES", 10000),
+		MaxValues:     env.Int("STATSD_MAX_VALUES", 100000),
+	}
+}
+
+type series struct {
+	name   string
+	typ    string
+	tags   []string
+	value  float64
+	values []float64
+}
+
+// Client aggregates metrics and sends them to the agent. Its methods are
+// safe for concurrent use and never block on the network.
+type Client struct {
+	cfg  Config
+	conn net.Conn
+	log  *slog.Logger
+
+	mu      sync.Mutex
+	series  map[string]*series
+	nvalues int
+
+	mirror *metrics.Registry
+	prev   map[string]float64
+}
+
+// New returns a client for cfg.Addr. UDP has no handshake, so New succeeds
+// whether or not an agent is listening.
+func New(cfg Config) (*Client, error) {
+	switch cfg.Flavor {
+	case "dogstatsd", "statsd":
+	default:
+		return nil, fmt.Errorf("statsd: unknown flavor %q", cfg.Flavor)
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 10 * time.Second
+	}
+	if cfg.MaxPacketSize <= 0 {
+		cfg.MaxPacketSize = 1432
+	}
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	return &Client{
+		cfg:    cfg,
+		conn:   conn,
+		log:    slog.Default().With("component", "statsd"),
+		series: map[string]*series{},
+		prev:   map[string]float64{},
+	}, nil
+}
+
+// Mirror sends every metric in r on each flush: counters as counts of
+// their increase, gauges as gauges, and histograms as .count and .sum
+// counts. Labels become tags.
+func (c *Client) Mirror(r *metrics.Registry) {
+	c.mu.Lock()
+	c.mirror = r
+	c.mu.Unlock()
+}
+
+// Count adds v to a counter. Tags are "key:value" strings.
+func (c *Client) Count(name string, v float64, tags ...string) {
+	c.mu.Lock()
+	if s := c.get(name, "c", tags); s != nil {
+		s.value += v
+	}
+	c.mu.Unlock()
+}
+
+// Gauge sets a gauge; the last value before a flush is sent.
+func (c *Client) Gauge(name string, v float64, tags ...string) {
+	c.mu.Lock()
+	if s := c.get(name, "g", tags); s != nil {
+		s.value = v
+	}
+	c.mu.Unlock()
+}
+
+// Timing records a duration in milliseconds.
+func (c *Client) Timing(name string, d time.Duration, tags ...string) {
+	c.sample(name, "ms", float64(d)/float64(time.Millisecond), tags)
+}
+
+// Histogram records a value whose distribution the agent computes per host.
+func (c *Client) Histogram(name string, v float64, tags ...string) {
+	c.sample(name, "h", v, tags)
+}
+
+// Distribution records a value aggregated globally by Datadog. Plain StatsD
+// agents receive it as a timing.
+func (c *Client) Distribution(name string, v float64, tags ...string) {
+	typ := "d"
+	if c.cfg.Flavor == "statsd" {
+		typ = "ms"
+	}
+	c.sample(name, typ, v, tags)
+}
+
+func (c *Client) sample(name, typ string, v float64, tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg.MaxValues > 0 && c.nvalues >= c.cfg.MaxValues {
+		metricsDropped.With("buffer_full").Inc()
+		return
+	}
+	if s := c.get(name, typ, tags); s != nil {
+		s.values = append(s.values, v)
+		c.nvalues++
+	}
+}
+
+// get returns the series for name, typ and tags, creating it if there is
+// room. c.mu must be held.
+func (c *Client) get(name, typ string, tags []string) *series {
+	key := name + "|" + typ + "|" + strings.Join(tags, ",")
+	s, ok := c.series[key]
+	if !ok {
+		if c.cfg.MaxSeries > 0 && len(c.series) >= c.cfg.MaxSeries {
+			metricsDropped.With("buffer_full").Inc()
+			return nil
+		}
+		s = &series{name: name, typ: typ, tags: append([]string(nil), tags...)}
+		c.series[key] = s
+	}
+	return s
+}
+
+// Run flushes every interval until ctx is done, then once more and closes
+// the connection.
+func (c *Client) Run(ctx context.Context) {
+	t := time.NewTicker(c.cfg.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.Flush()
+		case <-ctx.Done():
+			c.Flush()
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// Flush sends everything aggregated since the previous flush.
+func (c *Client) Flush() {
+	c.mu.Lock()
+	if c.mirror != nil {
+		c.mirrorLocked()
+	}
+	batch := c.series
+	c.series = map[string]*series{}
+	c.nvalues = 0
+	c.mu.Unlock()
+
+	keys := make([]string, 0, len(batch))
+	for k := range batch {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var packet []byte
+	var inPacket int
+	send := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, err := c.conn.Write(packet); err != nil {
+			packetsDropped.With().Inc()
+			metricsDropped.With("send_failed").Add(float64(inPacket))
+			if !errors.Is(err, net.ErrClosed) {
+				c.log.Debug("sending statsd packet", "err", err)
+			}
+		} else {
+			packetsSent.With().Inc()
+		}
+		packet, inPacket = packet[:0], 0
+	}
+	for _, k := range keys {
+		for _, line := range c.lines(batch[k]) {
+			if len(line) > c.cfg.MaxPacketSize {
+				metricsDropped.With("too_large").Inc()
+				continue
+			}
+			if len(packet) > 0 && len(packet)+1+len(line) > c.cfg.MaxPacketSize {
+				send()
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+			inPacket++
+		}
+	}
+	send()
+}
+
+// mirrorLocked copies the registry into the pending series. c.mu must be
+// held.
+func (c *Client) mirrorLocked() {
+	for _, f := range c.mirror.Gather() {
+		for _, smp := range f.Samples {
+			tags := make([]string, len(smp.Labels))
+			for i, l := range smp.Labels {
+				tags[i] = l.Name + ":" + l.Value
+			}
+			var s *series
+			switch f.Kind {
+			case metrics.KindCounter:
+				if s = c.get(f.Name, "c", tags); s != nil {
+					s.value += c.delta(f.Name, tags, smp.Value)
+				}
+			case metrics.KindGauge:
+				if s = c.get(f.Name, "g", tags); s != nil {
+					s.value = smp.Value
+				}
+			case metrics.KindHistogram:
+				if s = c.get(f.Name+".count", "c", tags); s != nil {
+					s.value += c.delta(f.Name+".count", tags, float64(smp.Count))
+				}
+				if s = c.get(f.Name+".sum", "c", tags); s != nil {
+					s.value += c.delta(f.Name+".sum", tags, smp.Value)
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) delta(name string, tags []string, v float64) float64 {
+	key := name + "|" + strings.Join(tags, ",")
+	prev, seen := c.prev[key]
+	c.prev[key] = v
+	if !seen || v < prev {
+		return v
+	}
+	return v - prev
+}
+
+// lines renders one series. DogStatsD packs many samples into one line
+// (name:1:2:3|h); plain StatsD takes one sample per line.
+func (c *Client) lines(s *series) []string {
+	name, suffix := c.cfg.Prefix+s.name, ""
+	tags := append(append([]string(nil), c.cfg.Tags...), s.tags...)
+	if c.cfg.Flavor == "dogstatsd" {
+		if len(tags) > 0 {
+			suffix = "|#" + strings.Join(tags, ",")
+		}
+	} else {
+		for _, t := range tags {
+			_, v, _ := strings.Cut(t, ":")
+			name += "." + sanitize(v)
+		}
+	}
+
+	if s.values == nil {
+		return []string{name + ":" + formatFloat(s.value) + "|" + s.typ + suffix}
+	}
+	var out []string
+	if c.cfg.Flavor != "dogstatsd" {
+		for _, v := range s.values {
+			out = append(out, name+":"+formatFloat(v)+"|"+s.typ)
+		}
+		return out
+	}
+	tail := "|" + s.typ + suffix
+	line := name
+	for _, v := range s.values {
+		f := ":" + formatFloat(v)
+		if len(line)+len(f)+len(tail) > c.cfg.MaxPacketSize && line != name {
+			out = append(out, line+tail)
+			line = name
+		}
+		line += f
+	}
+	return append(out, line+tail)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// sanitize keeps tag values usable as StatsD name segments.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '.', '/', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,172 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"goaws/internal/metrics"
+)
+
+// agent listens like a StatsD agent and returns the client pointed at it.
+func agent(t *testing.T, cfg Config) (*Client, net.PacketConn) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	cfg.Addr = pc.LocalAddr().String()
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.conn.Close() })
+	return c, pc
+}
+
+// packets reads what the agent received.
+func packets(t *testing.T, pc net.PacketConn) []string {
+	t.Helper()
+	var out []string
+	buf := make([]byte, 65536)
+	for {
+		pc.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return out
+		}
+		out = append(out, string(buf[:n]))
+	}
+}
+
+func TestFlushDogStatsD(t *testing.T) {
+	c, pc := agent(t, Config{Flavor: "dogstatsd", Prefix: "srv.", Tags: []string{"env:prod"}, MaxPacketSize: 1432})
+	c.Count("jobs", 1, "queue:mail")
+	c.Count("jobs", 2, "queue:mail")
+	c.Gauge("depth", 3)
+	c.Gauge("depth", 4)
+	c.Timing("latency", 1500*time.Microsecond)
+	c.Histogram("size", 10, "kind:a")
+	c.Histogram("size", 20, "kind:a")
+	c.Distribution("dist", 0.5)
+	c.Flush()
+
+	got := packets(t, pc)
+	want := strings.Join([]string{
+		"srv.depth:4|g|#env:prod",
+		"srv.dist:0.5|d|#env:prod",
+		"srv.jobs:3|c|#env:prod,queue:mail",
+		"srv.latency:1.5|ms|#env:prod",
+		"srv.size:10:20|h|#env:prod,kind:a",
+	}, "\n")
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("packets = %q\nwant %q", got, want)
+	}
+
+	// Everything was sent; the next flush has nothing to say.
+	c.Flush()
+	if got := packets(t, pc); len(got) != 0 {
+		t.Errorf("second flush sent %q", got)
+	}
+}
+
+func TestFlushPlainStatsD(t *testing.T) {
+	c, pc := agent(t, Config{Flavor: "statsd", Prefix: "srv.", MaxPacketSize: 1432})
+	c.Count("requests", 1, "route:/items/{id}", "code:200")
+	c.Histogram("size", 1)
+	c.Histogram("size", 2)
+	c.Distribution("dist", 3)
+	c.Flush()
+
+	got := packets(t, pc)
+	want := strings.Join([]string{
+		"srv.dist:3|ms",
+		"srv.requests._items_{id}.200:1|c",
+		"srv.size:1|h",
+		"srv.size:2|h",
+	}, "\n")
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("packets = %q\nwant %q", got, want)
+	}
+}
+
+func TestFlushSplitsPackets(t *testing.T) {
+	c, pc := agent(t, Config{Flavor: "dogstatsd", MaxPacketSize: 40})
+	for i := range 10 {
+		c.Histogram("h", float64(i*1000))
+	}
+	c.Count("a_rather_long_counter_name", 1)
+	c.Count(strings.Repeat("x", 50), 1)
+	c.Flush()
+
+	got := packets(t, pc)
+	var values []string
+	for _, p := range got {
+		if len(p) > 40 {
+			t.Errorf("packet %q exceeds the limit", p)
+		}
+		for _, line := range strings.Split(p, "\n") {
+			if strings.HasPrefix(line, strings.Repeat("x", 10)) {
+				t.Errorf("oversized line %q was sent", line)
+			}
+			if name, rest, _ := strings.Cut(line, ":"); name == "h" {
+				values = append(values, strings.Split(strings.TrimSuffix(rest, "|h"), ":")...)
+			}
+		}
+	}
+	if len(values) != 10 {
+		t.Errorf("histogram values %v, want all 10", values)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	c, pc := agent(t, Config{Flavor: "dogstatsd", MaxSeries: 2, MaxValues: 3})
+	c.Count("a", 1)
+	c.Count("b", 1)
+	c.Count("c", 1) // a third series is dropped
+	c.Flush()
+	if got := packets(t, pc); len(got) != 1 || got[0] != "a:1|c\nb:1|c" {
+		t.Errorf("packets = %q", got)
+	}
+
+	for i := range 5 {
+		c.Timing("t", time.Duration(i)*time.Millisecond)
+	}
+	c.Flush()
+	if got := packets(t, pc); len(got) != 1 || got[0] != "t:0:1:2|ms" {
+		t.Errorf("packets = %q", got)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	r := metrics.NewRegistry()
+	hits := r.Counter("hits_total", "", "code")
+	r.Gauge("open", "").With().Set(2)
+	lat := r.Histogram("lat_seconds", "", []float64{1})
+	hits.With("200").Add(5)
+	lat.With().Observe(0.5)
+
+	c, pc := agent(t, Config{Flavor: "dogstatsd", MaxPacketSize: 1432})
+	c.Mirror(r)
+	c.Flush()
+	want := "hits_total:5|c|#code:200\nlat_seconds.count:1|c\nlat_seconds.sum:0.5|c\nopen:2|g"
+	if got := packets(t, pc); len(got) != 1 || got[0] != want {
+		t.Errorf("packets = %q\nwant %q", got, want)
+	}
+
+	// Counters are sent as their increase.
+	hits.With("200").Add(2)
+	c.Flush()
+	want = "hits_total:2|c|#code:200\nlat_seconds.count:0|c\nlat_seconds.sum:0|c\nopen:2|g"
+	if got := packets(t, pc); len(got) != 1 || got[0] != want {
+		t.Errorf("packets = %q\nwant %q", got, want)
+	}
+}
+
+func TestNewRejectsUnknownFlavor(t *testing.T) {
+	if _, err := New(Config{Flavor: "graphite", Addr: "127.0.0.1:8125"}); err == nil {
+		t.Error("unknown flavor accepted")
+	}
+}
@@ -0,0 +1,135 @@
+package status
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"time"
+
+	"goaws/internal/httpx"
+)
+
+//go:embed page.html
+var page string
+
+var pageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"windows": func() []string {
+		var names []string
+		for _, w := range Windows {
+			names = append(names, w.Name)
+		}
+		return names
+	},
+	"uptime": func(m map[string]float64, window string) string {
+		pct, ok := m[window]
+		if !ok {
+			return "–"
+		}
+		return fmt.Sprintf("%.2f%%", pct)
+	},
+	"time": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") },
+}).Parse(page))
+
+// IncidentsHandler serves the incident API for the admin listener:
+//
+//	GET  /status/incidents
+//	POST /status/incidents
+//	POST /status/incidents/{id}/updates
+//
+// An update with status resolved resolves the incident. Every change is on
+// the public page straight away.
+func (p *Page) IncidentsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status/incidents", p.listIncidents)
+	mux.HandleFunc("POST /status/incidents", p.createIncident)
+	mux.HandleFunc("POST /status/incidents/{id}/updates", p.addUpdate)
+	return mux
+}
+
+type incidentRequest struct {
+	Title   string `json:"title"`
+	Impact  string `json:"impact"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+var (
+	impacts  = []string{"minor", "major"}
+	statuses = []string{Investigating, Identified, Monitoring, Resolved}
+)
+
+func (p *Page) listIncidents(w http.ResponseWriter, r *http.Request) {
+	httpx.WriteJSON(w, http.StatusOK, p.store.all())
+}
+
+func (p *Page) createIncident(w http.ResponseWriter, r *http.Request) {
+	var req incidentRequest
+	if !httpx.ReadJSON(w, r, &req) {
+		return
+	}
+	if req.Title == "" || req.Message == "" {
+		httpx.WriteError(w, http.StatusBadRequest, "title and message are required")
+		return
+	}
+	if req.Impact == "" {
+		req.Impact = "minor"
+	}
+	if req.Status == "" {
+		req.Status = Investigating
+	}
+	if !slices.Contains(impacts, req.Impact) || !slices.Contains(statuses[:3], req.Status) {
+		httpx.WriteError(w, http.StatusBadRequest, "impact must be minor or major and status investigating, identified or monitoring")
+		return
+	}
+	now := time.Now().UTC()
+	inc := Incident{
+		ID:      newID("inc_"),
+		Title:   req.Title,
+		Impact:  req.Impact,
+		Status:  req.Status,
+		Started: now,
+		Updates: []Update{{At: now, Status: req.Status, Message: req.Message}},
+	}
+	if err := p.store.put(inc); err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p.render(now)
+	httpx.WriteJSON(w, http.StatusCreated, inc)
+}
+
+func (p *Page) addUpdate(w http.ResponseWriter, r *http.Request) {
+	var req incidentRequest
+	if !httpx.ReadJSON(w, r, &req) {
+		return
+	}
+	if req.Message == "" || !slices.Contains(statuses, req.Status) {
+		httpx.WriteError(w, http.StatusBadRequest, "message is required and status must be investigating, identified, monitoring or resolved")
+		return
+	}
+	now := time.Now()
+	inc, err := p.store.update(r.PathValue("id"), func(inc *Incident) {
+		if req.Impact != "" && slices.Contains(impacts, req.Impact) {
+			inc.Impact = req.Impact
+		}
+		if req.Status == Resolved {
+			resolve(inc, now, req.Message)
+			return
+		}
+		inc.Status, inc.Resolved = req.Status, nil
+		inc.Updates = append(inc.Updates, Update{At: now.UTC(), Status: req.Status, Message: req.Message})
+	})
+	if errors.Is(err, ErrNotFound) {
+		httpx.WriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p.render(now)
+	httpx.WriteJSON(w, http.StatusOK, inc)
+}
@@ -0,0 +1,332 @@
+// Package status answers "is it up?" for people rather than load
+// balancers. Dependencies are probed on a schedule; each round is recorded
+// in hourly buckets kept for 30 days, a dependency that keeps failing opens
+// an incident, and the result is rendered once per round as a public,
+// cacheable page and JSON document.
+package status
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"goaws/internal/buildinfo"
+	"goaws/internal/env"
+	"goaws/internal/metrics"
+)
+
+var (
+	dependencyUp  = metrics.NewGauge("status_dependency_up", "1 if the dependency's last probe succeeded.", "dependency")
+	probeDuration = metrics.NewHistogram("status_probe_duration_seconds", "Time taken by dependency probes.", metrics.DefBuckets, "dependency")
+)
+
+// Overall states.
+const (
+	Operational = "operational"
+	Degraded    = "degraded"
+	Outage      = "outage"
+)
+
+// Windows are the periods uptime is reported over.
+var Windows = []struct {
+	Name string
+	D    time.Duration
+}{{"24h", 24 * time.Hour}, {"7d", 7 * 24 * time.Hour}, {"30d", 30 * 24 * time.Hour}}
+
+// Config configures the status page. It is normally built by
+// ConfigFromEnv.
+type Config struct {
+	// Dir keeps uptime history and incidents across restarts. The page is
+	// served only when it is set.
+	Dir      string
+	Interval time.Duration
+	Timeout  time.Duration
+	// FailureThreshold consecutive failed probes open an incident.
+	FailureThreshold int
+	// Probes are HTTP dependencies as name=url; a GET answering 2xx or
+	// 3xx is up. Critical names those whose failure is an outage rather
+	// than degradation.
+	Probes   []string
+	Critical []string
+	// Title heads the page.
+	Title string
+}
+
+// ConfigFromEnv reads the STATUS_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Dir:              env.String("STATUS_DIR", ""),
+		Interval:         env.Duration("STATUS_INTERVAL", 30*time.Second),
+		Timeout:          env.Duration("STATUS_TIMEOUT", 5*time.Second),
+		FailureThreshold: env.Int("STATUS_FAILURE_THRESHOLD", 2),
+		Probes:           env.List("STATUS_PROBES", nil),
+		Critical:         env.List("STATUS_CRITICAL", nil),
+		Title:            env.String("STATUS_TITLE", "Service status"),
+	}
+}
+
+// Probe checks one dependency.
+type Probe struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// Dependency is a dependency's state on the page.
+type Dependency struct {
+	Name      string             `json:"name"`
+	Status    string             `json:"status"`
+	Critical  bool               `json:"critical"`
+	LatencyMS float64            `json:"latency_ms"`
+	CheckedAt time.Time          `json:"checked_at"`
+	Uptime    map[string]float64 `json:"uptime"`
+
+	failures int
+}
+
+// Report is the JSON document; the page renders the same data.
+type Report struct {
+	Title        string             `json:"title"`
+	Status       string             `json:"status"`
+	Version      string             `json:"version"`
+	BuildTime    string             `json:"build_time,omitempty"`
+	Updated      time.Time          `json:"updated"`
+	Uptime       map[string]float64 `json:"uptime"`
+	Dependencies []Dependency       `json:"dependencies"`
+	Incidents    []Incident         `json:"incidents"`
+}
+
+// Page probes dependencies and serves the status page.
+type Page struct {
+	cfg    Config
+	store  *store
+	probes []Probe
+
+	mu      sync.Mutex
+	deps    map[string]*Dependency
+	updated time.Time
+	// rendered is the last report as JSON and HTML, with an ETag for each.
+	json, html         []byte
+	jsonETag, htmlETag string
+}
+
+// New opens the store in cfg.Dir and adds the HTTP probes in cfg.Probes.
+func New(cfg Config) (*Page, error) {
+	st, err := openStore(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("status: %w", err)
+	}
+	p := &Page{cfg: cfg, store: st, deps: map[string]*Dependency{}}
+	for _, spec := range cfg.Probes {
+		name, url, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("status: probe %q is not name=url", spec)
+		}
+		p.Add(Probe{Name: name, Check: httpCheck(url)})
+	}
+	p.render(time.Now())
+	return p, nil
+}
+
+// Add registers a probe; it must be called before Run. A name listed in
+// Config.Critical makes it critical.
+func (p *Page) Add(pr Probe) {
+	pr.Critical = pr.Critical || slices.Contains(p.cfg.Critical, pr.Name)
+	p.probes = append(p.probes, pr)
+	p.mu.Lock()
+	p.deps[pr.Name] = &Dependency{Name: pr.Name, Status: "unknown", Critical: pr.Critical}
+	p.mu.Unlock()
+}
+
+func httpCheck(url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// Run probes every Interval until ctx is done.
+func (p *Page) Run(ctx context.Context) {
+	tick := time.NewTicker(p.cfg.Interval)
+	defer tick.Stop()
+	for {
+		p.round(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+type result struct {
+	err     error
+	latency time.Duration
+}
+
+// round runs every probe concurrently, records the outcomes and renders
+// the page.
+func (p *Page) round(ctx context.Context) {
+	now := time.Now()
+	results := make([]result, len(p.probes))
+	var wg sync.WaitGroup
+	for i, pr := range p.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+			defer cancel()
+			start := time.Now()
+			err := pr.Check(pctx)
+			results[i] = result{err, time.Since(start)}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	// The service is counted down when a critical dependency is, or any
+	// dependency if none is marked critical.
+	var anyDown, criticalDown, anyCritical bool
+	p.mu.Lock()
+	for i, pr := range p.probes {
+		r, d := results[i], p.deps[pr.Name]
+		up := r.err == nil
+		anyCritical = anyCritical || pr.Critical
+		d.CheckedAt, d.LatencyMS = now.UTC(), float64(r.latency.Microseconds())/1000
+		probeDuration.With(pr.Name).Observe(r.latency.Seconds())
+		p.store.sample(pr.Name, now, up)
+		if up {
+			dependencyUp.With(pr.Name).Set(1)
+			if d.Status == "down" {
+				slog.Info("dependency recovered", "dependency", pr.Name)
+			}
+			d.Status, d.failures = "up", 0
+			if err := p.store.resolveAuto(pr.Name, now); err != nil {
+				slog.Warn("saving status incidents failed", "err", err)
+			}
+			continue
+		}
+		anyDown, criticalDown = true, criticalDown || pr.Critical
+		dependencyUp.With(pr.Name).Set(0)
+		d.failures++
+		if d.Status != "down" {
+			slog.Warn("dependency down", "dependency", pr.Name, "err", r.err)
+		}
+		d.Status = "down"
+		if d.failures == p.cfg.FailureThreshold {
+			impact := "minor"
+			if pr.Critical {
+				impact = "major"
+			}
+			if err := p.store.openAuto(pr.Name, impact, now); err != nil {
+				slog.Warn("saving status incidents failed", "err", err)
+			}
+		}
+	}
+	p.mu.Unlock()
+	p.store.sample("", now, !criticalDown && (anyCritical || !anyDown))
+	if err := p.store.saveHistory(); err != nil {
+		slog.Warn("saving status history failed", "err", err)
+	}
+	p.render(now)
+}
+
+// report builds the current report. The caller holds p.mu.
+func (p *Page) report(now time.Time) Report {
+	info := buildinfo.Get()
+	r := Report{
+		Title:     p.cfg.Title,
+		Status:    Operational,
+		Version:   info.Version,
+		BuildTime: info.BuildTime,
+		Updated:   now.UTC(),
+		Uptime:    p.store.uptime("", now),
+		Incidents: p.store.recent(now, 7*24*time.Hour),
+	}
+	for _, pr := range p.probes {
+		d := *p.deps[pr.Name]
+		d.Uptime = p.store.uptime(pr.Name, now)
+		r.Dependencies = append(r.Dependencies, d)
+		if d.Status != "down" {
+			continue
+		}
+		if d.Critical {
+			r.Status = Outage
+		} else if r.Status == Operational {
+			r.Status = Degraded
+		}
+	}
+	// An open incident declared by hand is at least a degradation.
+	for _, inc := range r.Incidents {
+		if inc.Resolved != nil || inc.Dependency != "" {
+			continue
+		}
+		if inc.Impact == "major" {
+			r.Status = Outage
+		} else if r.Status == Operational {
+			r.Status = Degraded
+		}
+	}
+	return r
+}
+
+// render caches the report as JSON and HTML.
+func (p *Page) render(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.report(now)
+	js, _ := json.MarshalIndent(r, "", "  ")
+	var html bytes.Buffer
+	if err := pageTemplate.Execute(&html, r); err != nil {
+		slog.Error("rendering status page failed", "err", err)
+	}
+	p.json, p.jsonETag = js, etag(js)
+	p.html, p.htmlETag = html.Bytes(), etag(html.Bytes())
+	p.updated = now
+}
+
+func etag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// Handler serves the page at /status and the report at /status.json. Both
+// may be cached for half a probe interval; conditional requests are
+// answered from the ETag.
+func (p *Page) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		body, tag, typ := p.html, p.htmlETag, "text/html; charset=utf-8"
+		if strings.HasSuffix(r.URL.Path, ".json") {
+			body, tag, typ = p.json, p.jsonETag, "application/json"
+		}
+		updated := p.updated
+		p.mu.Unlock()
+		w.Header().Set("Content-Type", typ)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", max(int(p.cfg.Interval.Seconds())/2, 1)))
+		w.Header().Set("ETag", tag)
+		http.ServeContent(w, r, "", updated, bytes.NewReader(body))
+	})
+}
@@ -0,0 +1,217 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newPage(t *testing.T, cfg Config) *Page {
+	t.Helper()
+	cfg.Dir = t.TempDir()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// switchable is a probe whose outcome the test sets.
+type switchable struct{ down atomic.Bool }
+
+func (s *switchable) check(context.Context) error {
+	if s.down.Load() {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+func currentReport(p *Page) Report {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.report(time.Now())
+}
+
+func TestRoundsAndIncidents(t *testing.T) {
+	p := newPage(t, Config{FailureThreshold: 2, Critical: []string{"db"}})
+	db, cache := &switchable{}, &switchable{}
+	p.Add(Probe{Name: "db", Check: db.check})
+	p.Add(Probe{Name: "cache", Check: cache.check})
+	ctx := context.Background()
+
+	if r := currentReport(p); r.Status != Operational || r.Dependencies[0].Status != "unknown" || !r.Dependencies[0].Critical {
+		t.Errorf("before the first round %+v", r)
+	}
+	p.round(ctx)
+	if r := currentReport(p); r.Status != Operational || r.Uptime["24h"] != 100 {
+		t.Errorf("all up %+v", r)
+	}
+
+	// A non-critical dependency down degrades; an incident opens on the
+	// second failure, once.
+	cache.down.Store(true)
+	for range 3 {
+		p.round(ctx)
+	}
+	r := currentReport(p)
+	if r.Status != Degraded || len(r.Incidents) != 1 || r.Incidents[0].Dependency != "cache" || r.Incidents[0].Impact != "minor" {
+		t.Errorf("cache down %+v", r)
+	}
+	// The service is up while only non-critical dependencies are down.
+	if r.Uptime["24h"] != 100 || r.Dependencies[1].Uptime["24h"] != 25 {
+		t.Errorf("uptime %v, cache %v", r.Uptime, r.Dependencies[1].Uptime)
+	}
+
+	db.down.Store(true)
+	p.round(ctx)
+	p.round(ctx)
+	r = currentReport(p)
+	if r.Status != Outage || len(r.Incidents) != 2 || r.Incidents[0].Impact != "major" {
+		t.Errorf("db down %+v", r)
+	}
+
+	db.down.Store(false)
+	cache.down.Store(false)
+	p.round(ctx)
+	r = currentReport(p)
+	if r.Status != Operational {
+		t.Errorf("recovered %+v", r)
+	}
+	for _, inc := range r.Incidents {
+		if inc.Resolved == nil || inc.Status != Resolved || inc.Updates[len(inc.Updates)-1].Message != inc.Dependency+" has recovered." {
+			t.Errorf("incident not resolved: %+v", inc)
+		}
+	}
+
+	// History and incidents survive a restart.
+	st, err := openStore(p.cfg.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cache passed the first and last of seven rounds.
+	if len(st.all()) != 2 || st.uptime("cache", time.Now())["24h"] != 100*2.0/7 {
+		t.Errorf("reloaded %+v, %v", st.all(), st.uptime("cache", time.Now()))
+	}
+}
+
+func TestWithoutCriticalDependenciesAnyFailureIsDown(t *testing.T) {
+	p := newPage(t, Config{FailureThreshold: 5})
+	down := &switchable{}
+	down.down.Store(true)
+	p.Add(Probe{Name: "a", Check: (&switchable{}).check})
+	p.Add(Probe{Name: "b", Check: down.check})
+	p.round(context.Background())
+	if r := currentReport(p); r.Uptime["24h"] != 0 || r.Status != Degraded {
+		t.Errorf("report %+v", r)
+	}
+}
+
+func TestHTTPProbes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/ok", http.StatusFound) })
+	mux.HandleFunc("/ok", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("/bad", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(503) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	if _, err := New(Config{Dir: t.TempDir(), Probes: []string{"nourl"}}); err == nil {
+		t.Error("probe without a URL accepted")
+	}
+	p := newPage(t, Config{Probes: []string{"ok=" + srv.URL + "/old", "bad=" + srv.URL + "/bad"}})
+	p.round(context.Background())
+	r := currentReport(p)
+	if r.Dependencies[0].Status != "up" || r.Dependencies[1].Status != "down" {
+		t.Errorf("dependencies %+v", r.Dependencies)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	p := newPage(t, Config{Title: "Acme <status>", Interval: 30 * time.Second})
+	p.Add(Probe{Name: "db", Check: (&switchable{}).check})
+	p.round(context.Background())
+	h := p.Handler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/status.json", nil))
+	var r Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Title != "Acme <status>" || len(r.Dependencies) != 1 || rec.Header().Get("Cache-Control") != "public, max-age=15" {
+		t.Errorf("json %d %v %s", rec.Code, rec.Header(), rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+	if body := rec.Body.String(); !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(body, "Acme &lt;status&gt;") || !strings.Contains(body, "db") {
+		t.Errorf("page %v\n%s", rec.Header(), body)
+	}
+
+	req := httptest.NewRequest("GET", "/status", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("conditional request: %d", rec.Code)
+	}
+}
+
+func TestIncidentsAPI(t *testing.T) {
+	p := newPage(t, Config{})
+	h := p.IncidentsHandler()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, body := range []string{`{"title":"x"}`, `{"title":"x","message":"m","impact":"huge"}`, `{"title":"x","message":"m","status":"resolved"}`} {
+		if rec := do("POST", "/status/incidents", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d", body, rec.Code)
+		}
+	}
+
+	rec := do("POST", "/status/incidents", `{"title":"Payments failing","impact":"major","message":"Looking into it."}`)
+	var inc Incident
+	json.Unmarshal(rec.Body.Bytes(), &inc)
+	if rec.Code != http.StatusCreated || inc.Status != Investigating || inc.ID == "" {
+		t.Fatalf("create %d %s", rec.Code, rec.Body)
+	}
+	if r := currentReport(p); r.Status != Outage {
+		t.Errorf("open major incident: %s", r.Status)
+	}
+
+	if rec := do("POST", "/status/incidents/"+inc.ID+"/updates", `{"status":"monitoring","message":"Fixed.","impact":"minor"}`); rec.Code != http.StatusOK {
+		t.Errorf("update %d %s", rec.Code, rec.Body)
+	}
+	if r := currentReport(p); r.Status != Degraded {
+		t.Errorf("open minor incident: %s", r.Status)
+	}
+	do("POST", "/status/incidents/"+inc.ID+"/updates", `{"status":"resolved","message":"All good."}`)
+	if r := currentReport(p); r.Status != Operational || r.Incidents[0].Resolved == nil || len(r.Incidents[0].Updates) != 3 {
+		t.Errorf("resolved %+v", r)
+	}
+	if rec := do("POST", "/status/incidents/inc_nope/updates", `{"status":"monitoring","message":"m"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown incident: %d", rec.Code)
+	}
+
+	var list []Incident
+	json.Unmarshal(do("GET", "/status/incidents", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != inc.ID {
+		t.Errorf("list %+v", list)
+	}
+}
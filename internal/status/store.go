@@ -0,0 +1,270 @@
+package status
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("status: not found")
+
+// Incident states. An incident opened by a failing probe starts as
+// investigating and is resolved when the probe recovers.
+const (
+	Investigating = "investigating"
+	Identified    = "identified"
+	Monitoring    = "monitoring"
+	Resolved      = "resolved"
+)
+
+// Update is a note posted on an incident.
+type Update struct {
+	At      time.Time `json:"at"`
+	Status  string    `json:"status"`
+	Message string    `json:"message"`
+}
+
+// Incident is a period of degraded service, opened by a probe or by hand.
+type Incident struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Impact is minor or major; an open major incident is an outage.
+	Impact string `json:"impact"`
+	Status string `json:"status"`
+	// Dependency names the probe that opened the incident, if any.
+	Dependency string     `json:"dependency,omitempty"`
+	Started    time.Time  `json:"started"`
+	Resolved   *time.Time `json:"resolved,omitempty"`
+	Updates    []Update   `json:"updates"`
+}
+
+// maxIncidents bounds the incidents kept; the oldest resolved go first.
+const maxIncidents = 50
+
+// historyHours is how many hourly buckets are kept per dependency: 30 days.
+const historyHours = 30 * 24
+
+// bucket counts the probe rounds in one hour and how many of them passed.
+type bucket struct {
+	Hour  int64 `json:"hour"`
+	Up    int   `json:"up"`
+	Total int   `json:"total"`
+}
+
+// store keeps uptime history and incidents in memory and mirrors them to
+// JSON files in dir. History is saved once per probe round, incidents after
+// every change.
+type store struct {
+	dir string
+
+	mu        sync.Mutex
+	history   map[string][]bucket
+	incidents []*Incident
+}
+
+func openStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	s := &store{dir: dir, history: map[string][]bucket{}}
+	if err := loadFile(filepath.Join(dir, "history.json"), &s.history); err != nil {
+		return nil, err
+	}
+	if err := loadFile(filepath.Join(dir, "incidents.json"), &s.incidents); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// sample records one probe of name; "" is the service as a whole.
+func (s *store) sample(name string, now time.Time, up bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hour := now.Unix() / 3600
+	h := s.history[name]
+	if n := len(h); n == 0 || h[n-1].Hour != hour {
+		h = append(h, bucket{Hour: hour})
+	}
+	if len(h) > historyHours {
+		h = h[len(h)-historyHours:]
+	}
+	b := &h[len(h)-1]
+	b.Total++
+	if up {
+		b.Up++
+	}
+	s.history[name] = h
+}
+
+// uptime returns the percentage of passing probes of name over each of
+// Windows. A window with no samples is left out.
+func (s *store) uptime(name string, now time.Time) map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]float64{}
+	for _, w := range Windows {
+		since := now.Add(-w.D).Unix() / 3600
+		up, total := 0, 0
+		for _, b := range s.history[name] {
+			if b.Hour > since {
+				up += b.Up
+				total += b.Total
+			}
+		}
+		if total > 0 {
+			out[w.Name] = 100 * float64(up) / float64(total)
+		}
+	}
+	return out
+}
+
+func (s *store) saveHistory() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return saveFile(filepath.Join(s.dir, "history.json"), s.history)
+}
+
+// recent returns the open incidents and those resolved within d, newest
+// first.
+func (s *store) recent(now time.Time, d time.Duration) []Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Incident{}
+	for _, inc := range s.incidents {
+		if inc.Resolved == nil || now.Sub(*inc.Resolved) < d {
+			out = append(out, *inc)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.After(out[j].Started) })
+	return out
+}
+
+func (s *store) all() []Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Incident, 0, len(s.incidents))
+	for _, inc := range s.incidents {
+		out = append(out, *inc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.After(out[j].Started) })
+	return out
+}
+
+func (s *store) put(inc Incident) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.incidents = append(s.incidents, &inc)
+	s.prune()
+	return s.saveIncidents()
+}
+
+// update applies f to the stored incident and persists it.
+func (s *store) update(id string, f func(*Incident)) (Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, inc := range s.incidents {
+		if inc.ID == id {
+			f(inc)
+			return *inc, s.saveIncidents()
+		}
+	}
+	return Incident{}, ErrNotFound
+}
+
+// openAuto opens an incident for a failing dependency unless one is open.
+func (s *store) openAuto(dep, impact string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.openFor(dep) != nil {
+		return nil
+	}
+	s.incidents = append(s.incidents, &Incident{
+		ID:         newID("inc_"),
+		Title:      dep + " unavailable",
+		Impact:     impact,
+		Status:     Investigating,
+		Dependency: dep,
+		Started:    now.UTC(),
+		Updates:    []Update{{At: now.UTC(), Status: Investigating, Message: "Checks of " + dep + " are failing."}},
+	})
+	s.prune()
+	return s.saveIncidents()
+}
+
+// resolveAuto resolves the incident a dependency opened, if it is open.
+func (s *store) resolveAuto(dep string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inc := s.openFor(dep)
+	if inc == nil {
+		return nil
+	}
+	resolve(inc, now, dep+" has recovered.")
+	return s.saveIncidents()
+}
+
+func (s *store) openFor(dep string) *Incident {
+	for _, inc := range s.incidents {
+		if inc.Dependency == dep && inc.Resolved == nil {
+			return inc
+		}
+	}
+	return nil
+}
+
+func resolve(inc *Incident, now time.Time, msg string) {
+	t := now.UTC()
+	inc.Status, inc.Resolved = Resolved, &t
+	inc.Updates = append(inc.Updates, Update{At: t, Status: Resolved, Message: msg})
+}
+
+// prune drops the oldest resolved incidents beyond maxIncidents.
+func (s *store) prune() {
+	sort.Slice(s.incidents, func(i, j int) bool { return s.incidents[i].Started.Before(s.incidents[j].Started) })
+	for i := 0; len(s.incidents) > maxIncidents && i < len(s.incidents); {
+		if s.incidents[i].Resolved != nil {
+			s.incidents = append(s.incidents[:i], s.incidents[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+func (s *store) saveIncidents() error {
+	return saveFile(filepath.Join(s.dir, "incidents.json"), s.incidents)
+}
+
+func loadFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func saveFile(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newID(prefix string) string {
+	var b [12]byte
+	rand.Read(b[:])
+	return prefix + hex.EncodeToString(b[:])
+}
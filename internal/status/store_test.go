@@ -0,0 +1,75 @@
+package status
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUptimeWindows(t *testing.T) {
+	s, err := openStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	s.sample("db", now, true)
+	s.sample("db", now, false)
+	s.sample("db", now.Add(-3*24*time.Hour), false)
+	s.sample("db", now.Add(-3*24*time.Hour), false)
+	s.sample("db", now.Add(-20*24*time.Hour), true)
+
+	got := s.uptime("db", now)
+	for w, want := range map[string]float64{"24h": 50, "7d": 25, "30d": 40} {
+		if got[w] != want {
+			t.Errorf("%s = %v, want %v", w, got[w], want)
+		}
+	}
+	if _, ok := s.uptime("other", now)["24h"]; ok {
+		t.Error("window without samples reported")
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	s, _ := openStore(t.TempDir())
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for h := range historyHours + 10 {
+		s.sample("db", start.Add(time.Duration(h)*time.Hour), true)
+	}
+	if n := len(s.history["db"]); n != historyHours {
+		t.Errorf("%d buckets", n)
+	}
+}
+
+func TestPruneDropsOldestResolved(t *testing.T) {
+	s, _ := openStore(t.TempDir())
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The oldest incident stays open.
+	s.openAuto("db", "major", start)
+	for i := range maxIncidents + 5 {
+		at := start.Add(time.Duration(i+1) * time.Hour)
+		s.put(Incident{ID: fmt.Sprint(i), Started: at, Resolved: &at})
+	}
+	all := s.all()
+	if len(all) != maxIncidents {
+		t.Fatalf("%d incidents", len(all))
+	}
+	if last := all[len(all)-1]; last.Dependency != "db" || all[len(all)-2].ID != "6" {
+		t.Errorf("oldest kept: %+v, then %s", last, all[len(all)-2].ID)
+	}
+	if s.openAuto("db", "major", start) != nil || len(s.all()) != maxIncidents {
+		t.Error("a second incident opened for db")
+	}
+}
+
+func TestRecent(t *testing.T) {
+	s, _ := openStore(t.TempDir())
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	old, fresh := now.Add(-8*24*time.Hour), now.Add(-time.Hour)
+	s.put(Incident{ID: "old", Started: old, Resolved: &old})
+	s.put(Incident{ID: "fresh", Started: fresh, Resolved: &fresh})
+	s.put(Incident{ID: "open", Started: old})
+	got := s.recent(now, 7*24*time.Hour)
+	if len(got) != 2 || got[0].ID != "fresh" || got[1].ID != "open" {
+		t.Errorf("recent %+v", got)
+	}
+}
@@ -0,0 +1,209 @@
+package synthetic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"goaws/internal/metrics"
+)
+
+const usage = `usage: app probe [flags] checks.json
+
+Runs the scripted HTTP checks in checks.json once, prints a report and
+exits non-zero if any failed. With -daemon it runs each check on its
+interval until stopped, serving /metrics and the latest results as JSON
+at /report on -addr.
+
+flags:
+`
+
+// Report is the latest result of every check.
+type Report struct {
+	Time   time.Time `json:"time"`
+	OK     bool      `json:"ok"`
+	Checks []Result  `json:"checks"`
+}
+
+// Command runs app probe with args.
+func Command(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("probe", flag.ContinueOnError)
+	daemon := fset.Bool("daemon", false, "run the checks on their intervals until stopped")
+	addr := fset.String("addr", "127.0.0.1:9091", "listen address for /metrics and /report in daemon mode")
+	base := fset.String("base", "", "base URL for relative check URLs, overriding base_url")
+	only := fset.String("check", "", "comma-separated checks to run; all by default")
+	asJSON := fset.Bool("json", false, "print the report as JSON")
+	fset.Usage = func() {
+		fmt.Fprint(fset.Output(), usage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 {
+		fset.Usage()
+		return flag.ErrHelp
+	}
+	script, err := Load(fset.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *base != "" {
+		script.BaseURL = *base
+	}
+	if *only != "" {
+		names := strings.Split(*only, ",")
+		script.Checks = slices.DeleteFunc(script.Checks, func(c *Check) bool { return !slices.Contains(names, c.Name) })
+		if len(script.Checks) == 0 {
+			return fmt.Errorf("no checks named %s", *only)
+		}
+	}
+
+	if *daemon {
+		return runDaemon(ctx, script, *addr)
+	}
+	rep := Report{Time: time.Now().UTC(), OK: true}
+	failed := 0
+	for _, c := range script.Checks {
+		res := script.Run(ctx, c)
+		if !res.OK {
+			rep.OK = false
+			failed++
+		}
+		rep.Checks = append(rep.Checks, res)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	} else if err := rep.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(rep.Checks))
+	}
+	return nil
+}
+
+// runDaemon runs every check on its interval and serves the results until
+// ctx is done.
+func runDaemon(ctx context.Context, script *Script, addr string) error {
+	// The checks also stop when the server fails.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	var mu sync.Mutex
+	latest := map[string]Result{}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler(metrics.Default))
+	mux.HandleFunc("GET /report", func(w http.ResponseWriter, r *http.Request) {
+		rep := Report{Time: time.Now().UTC(), OK: true, Checks: []Result{}}
+		mu.Lock()
+		for _, c := range script.Checks {
+			if res, ok := latest[c.Name]; ok {
+				rep.OK = rep.OK && res.OK
+				rep.Checks = append(rep.Checks, res)
+			}
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	})
+	srv := &http.Server{Addr: addr, Handler: mux}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	slog.Info("probe daemon running", "addr", addr, "checks", len(script.Checks))
+
+	var wg sync.WaitGroup
+	for _, c := range script.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tick := time.NewTicker(c.interval)
+			defer tick.Stop()
+			for {
+				res := script.Run(ctx, c)
+				if ctx.Err() != nil {
+					return
+				}
+				mu.Lock()
+				prev, seen := latest[c.Name]
+				latest[c.Name] = res
+				mu.Unlock()
+				switch {
+				case !res.OK && (!seen || prev.OK):
+					slog.Warn("check failing", "check", c.Name, "failure", res.Failure)
+				case res.OK && seen && !prev.OK:
+					slog.Info("check recovered", "check", c.Name)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick.C:
+				}
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	stop()
+	wg.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// WriteText prints the report as a table of checks and their steps.
+func (rep *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, res := range rep.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t\t%.0f ms\n", result(res.OK), res.Check, res.DurationMS)
+		for _, sr := range res.Steps {
+			status := "-"
+			if sr.Status != 0 {
+				status = fmt.Sprint(sr.Status)
+			}
+			fmt.Fprintf(tw, "  %s\t%s %s\t%s\t%.0f ms\n", result(sr.OK), sr.Method, sr.URL, status, sr.LatencyMS)
+			for _, f := range sr.Failures {
+				fmt.Fprintf(tw, "  \t  %s\n", f)
+			}
+		}
+		for _, cert := range res.Certs {
+			fmt.Fprintf(tw, "  tls\t%s, expires %s\t\t%.0f days\n", cert.Host, cert.NotAfter.Format(time.DateOnly), cert.DaysLeft)
+		}
+		if !res.OK && len(res.Steps) > 0 && res.Steps[len(res.Steps)-1].OK {
+			fmt.Fprintf(tw, "  \t  %s\n", res.Failure)
+		}
+	}
+	return tw.Flush()
+}
+
+func result(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "FAIL"
+}
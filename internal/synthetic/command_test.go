@@ -0,0 +1,69 @@
+package synthetic
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDaemonStopsWhenServerFails(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	srv := testServer(t)
+	s := mustLoad(t, `{"checks": [{"interval": "1h", "steps": [{"url": "/me"}]}]}`)
+	s.BaseURL = srv.URL
+
+	done := make(chan error, 1)
+	go func() { done <- runDaemon(context.Background(), s, ln.Addr().String()) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("no error for an address in use")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon still running after its server failed")
+	}
+}
+
+func TestDaemonReports(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	srv := testServer(t)
+	s := mustLoad(t, `{"checks": [{"name": "me", "interval": "1h", "steps": [{"url": "/me"}]}]}`)
+	s.BaseURL = srv.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runDaemon(ctx, s, addr) }()
+
+	var rep Report
+	for deadline := time.Now().Add(5 * time.Second); len(rep.Checks) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no report")
+		}
+		time.Sleep(10 * time.Millisecond)
+		resp, err := http.Get("http://" + addr + "/report")
+		if err != nil {
+			continue
+		}
+		json.NewDecoder(resp.Body).Decode(&rep)
+		resp.Body.Close()
+	}
+	if rep.OK || rep.Checks[0].Check != "me" || rep.Checks[0].Steps[0].Status != http.StatusUnauthorized {
+		t.Errorf("report %+v", rep)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
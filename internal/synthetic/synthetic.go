@@ -0,0 +1,539 @@
+// Package synthetic runs scripted HTTP checks against the service from the
+// outside, the way a user reaches it: through DNS, the ALB and TLS. A check
+// is a sequence of requests whose responses are asserted on, with values
+// captured from one step available to the next.
+package synthetic
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"goaws/internal/metrics"
+)
+
+var (
+	checkSuccess  = metrics.NewGauge("synthetic_check_success", "1 if the check's last run passed.", "check")
+	checkDuration = metrics.NewGauge("synthetic_check_duration_seconds", "Time taken by the check's last run.", "check")
+	checkLastRun  = metrics.NewGauge("synthetic_check_last_run_timestamp_seconds", "When the check last ran.", "check")
+	checkRuns     = metrics.NewCounter("synthetic_check_runs_total", "Check runs by result.", "check", "result")
+	stepDuration  = metrics.NewHistogram("synthetic_step_duration_seconds", "Time taken by each step, to the end of the body.", metrics.DefBuckets, "check", "step")
+	tlsExpiry     = metrics.NewGauge("synthetic_tls_expiry_seconds", "Time until the certificate a check was served expires.", "check", "host")
+)
+
+// maxBody bounds how much of a response is read for assertions.
+const maxBody = 1 << 20
+
+// Script is a checks file:
+//
+//	{"base_url": "https://app.example.com",
+//	 "vars": {"user": "probe"},
+//	 "checks": [
+//	   {"name": "login", "interval": "1m", "tls_min_validity": "14d",
+//	    "steps": [
+//	      {"method": "POST", "url": "/auth/login",
+//	       "headers": {"Content-Type": "application/json"},
+//	       "body": "{\"user\": \"${user}\", \"password\": \"${PROBE_PASSWORD}\"}",
+//	       "expect": {"status": [200], "json": {"ok": true}, "max_latency": "500ms"},
+//	       "capture": {"token": "json:token"}},
+//	      {"url": "/api/me", "headers": {"Authorization": "Bearer ${token}"},
+//	       "expect": {"headers": {"Content-Type": "^application/json"}, "body": "probe"}}]}]}
+//
+// URLs not starting with a scheme are relative to BaseURL. ${name} in a
+// URL, header or body is replaced by a value captured by an earlier step,
+// then by Vars, then by the environment. Results show captured and
+// environment values as ${name}, since they may be credentials.
+type Script struct {
+	BaseURL string            `json:"base_url"`
+	Vars    map[string]string `json:"vars"`
+	Checks  []*Check          `json:"checks"`
+}
+
+// Check is a sequence of steps run in order; it fails at the first step
+// that does. Steps share a cookie jar and connections.
+type Check struct {
+	Name string `json:"name"`
+	// Interval is how often the daemon runs the check; 1m by default.
+	Interval string `json:"interval"`
+	// Timeout bounds each step; 10s by default.
+	Timeout string `json:"timeout"`
+	// TLSMinValidity fails the check when a certificate it is served
+	// expires sooner, e.g. 14d.
+	TLSMinValidity string  `json:"tls_min_validity"`
+	Steps          []*Step `json:"steps"`
+
+	interval, timeout, minValidity time.Duration
+}
+
+// Step is one request and what its response must look like.
+type Step struct {
+	Name            string            `json:"name"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	FollowRedirects bool              `json:"follow_redirects"`
+	Expect          Expect            `json:"expect"`
+	// Capture names values for later steps. A source is header:<name>,
+	// json:<dotted.path> or regexp:<pattern>, whose first group (or
+	// whole match) is taken from the body.
+	Capture map[string]string `json:"capture"`
+
+	captureREs map[string]*regexp.Regexp
+}
+
+// Expect holds a step's assertions. Without Status, any status below 400
+// passes.
+type Expect struct {
+	Status []int `json:"status"`
+	// Headers are regular expressions the response header must match;
+	// an empty one only requires the header to be present.
+	Headers map[string]string `json:"headers"`
+	// Body must be a substring of the response body.
+	Body       string `json:"body"`
+	BodyRegexp string `json:"body_regexp"`
+	// JSON maps dotted paths into the response body to expected values.
+	JSON       map[string]any `json:"json"`
+	MaxLatency string         `json:"max_latency"`
+
+	maxLatency time.Duration
+	headerREs  map[string]*regexp.Regexp
+	bodyRE     *regexp.Regexp
+}
+
+// Load reads and validates a checks file.
+func Load(path string) (*Script, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Script
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(s.Checks) == 0 {
+		return nil, fmt.Errorf("%s: no checks", path)
+	}
+	seen := map[string]bool{}
+	for i, c := range s.Checks {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("check%d", i+1)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("check %s: duplicate name", c.Name)
+		}
+		seen[c.Name] = true
+		if err := c.compile(); err != nil {
+			return nil, fmt.Errorf("check %s: %w", c.Name, err)
+		}
+	}
+	return &s, nil
+}
+
+func (c *Check) compile() error {
+	var err error
+	if c.interval, err = parseDuration(c.Interval, time.Minute); err != nil || c.interval <= 0 {
+		return fmt.Errorf("interval: bad duration %q", c.Interval)
+	}
+	if c.timeout, err = parseDuration(c.Timeout, 10*time.Second); err != nil {
+		return fmt.Errorf("timeout: %w", err)
+	}
+	if c.minValidity, err = parseDuration(c.TLSMinValidity, 0); err != nil {
+		return fmt.Errorf("tls_min_validity: %w", err)
+	}
+	if len(c.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+	for i, st := range c.Steps {
+		if st.Name == "" {
+			st.Name = fmt.Sprintf("step%d", i+1)
+		}
+		if st.Method == "" {
+			st.Method = http.MethodGet
+		}
+		if st.URL == "" {
+			return fmt.Errorf("step %s: no url", st.Name)
+		}
+		e := &st.Expect
+		if e.maxLatency, err = parseDuration(e.MaxLatency, 0); err != nil {
+			return fmt.Errorf("step %s: max_latency: %w", st.Name, err)
+		}
+		e.headerREs = map[string]*regexp.Regexp{}
+		for name, pat := range e.Headers {
+			if e.headerREs[name], err = regexp.Compile(pat); err != nil {
+				return fmt.Errorf("step %s: header %s: %w", st.Name, name, err)
+			}
+		}
+		if e.BodyRegexp != "" {
+			if e.bodyRE, err = regexp.Compile(e.BodyRegexp); err != nil {
+				return fmt.Errorf("step %s: body_regexp: %w", st.Name, err)
+			}
+		}
+		st.captureREs = map[string]*regexp.Regexp{}
+		for name, src := range st.Capture {
+			kind, arg, _ := strings.Cut(src, ":")
+			switch kind {
+			case "header", "json":
+			case "regexp":
+				if st.captureREs[name], err = regexp.Compile(arg); err != nil {
+					return fmt.Errorf("step %s: capture %s: %w", st.Name, name, err)
+				}
+			default:
+				return fmt.Errorf("step %s: capture %s: source must be header:, json: or regexp:", st.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// parseDuration accepts Go durations plus whole days, e.g. 14d.
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("bad duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("bad duration %q", s)
+	}
+	return d, nil
+}
+
+// Result is the outcome of one run of a check.
+type Result struct {
+	Check      string       `json:"check"`
+	Time       time.Time    `json:"time"`
+	OK         bool         `json:"ok"`
+	DurationMS float64      `json:"duration_ms"`
+	Steps      []StepResult `json:"steps"`
+	Certs      []Cert       `json:"certs,omitempty"`
+	// Failure says why the check failed.
+	Failure string `json:"failure,omitempty"`
+}
+
+// StepResult is the outcome of one step.
+type StepResult struct {
+	Step      string  `json:"step"`
+	Method    string  `json:"method"`
+	URL       string  `json:"url"`
+	Status    int     `json:"status,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	OK        bool    `json:"ok"`
+	// Failures lists every assertion that did not hold.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// Cert is a certificate a check was served.
+type Cert struct {
+	Host     string    `json:"host"`
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft float64   `json:"days_left"`
+}
+
+// Run runs c once and records the outcome in the synthetic_* metrics.
+func (s *Script) Run(ctx context.Context, c *Check) Result {
+	start := time.Now()
+	res := Result{Check: c.Name, Time: start.UTC(), OK: true}
+
+	jar, _ := cookiejar.New(nil)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Jar: jar}
+	vars := map[string]string{}
+	certs := map[string]bool{}
+
+	for _, st := range c.Steps {
+		sr, resp, body := s.step(ctx, client, c, st, vars)
+		if resp != nil && resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+			host := resp.Request.URL.Hostname()
+			if !certs[host] {
+				certs[host] = true
+				res.Certs = append(res.Certs, certFor(host, resp.TLS))
+			}
+		}
+		if sr.OK {
+			capture(st, resp, body, vars, &sr)
+		}
+		res.Steps = append(res.Steps, sr)
+		stepDuration.With(c.Name, st.Name).Observe(sr.LatencyMS / 1000)
+		if !sr.OK {
+			res.OK = false
+			res.Failure = st.Name + ": " + strings.Join(sr.Failures, "; ")
+			break
+		}
+	}
+
+	for _, cert := range res.Certs {
+		left := time.Until(cert.NotAfter)
+		tlsExpiry.With(c.Name, cert.Host).Set(left.Seconds())
+		if c.minValidity > 0 && left < c.minValidity && res.OK {
+			res.OK = false
+			res.Failure = fmt.Sprintf("certificate for %s expires in %.1f days", cert.Host, cert.DaysLeft)
+		}
+	}
+
+	elapsed := time.Since(start)
+	res.DurationMS = float64(elapsed.Microseconds()) / 1000
+	checkDuration.With(c.Name).Set(elapsed.Seconds())
+	checkLastRun.With(c.Name).Set(float64(start.Unix()))
+	if res.OK {
+		checkSuccess.With(c.Name).Set(1)
+		checkRuns.With(c.Name, "pass").Inc()
+	} else {
+		checkSuccess.With(c.Name).Set(0)
+		checkRuns.With(c.Name, "fail").Inc()
+	}
+	return res
+}
+
+// step makes one request and checks the response. It returns the response
+// and its body, already read, when one was received. The result shows the
+// URL with captured and environment values left as ${name}, and such values
+// are hidden in failure messages too, since the result is printed and
+// served.
+func (s *Script) step(ctx context.Context, client *http.Client, c *Check, st *Step, vars map[string]string) (sr StepResult, resp *http.Response, b []byte) {
+	sr = StepResult{Step: st.Name, Method: st.Method}
+	fail := func(format string, args ...any) {
+		sr.Failures = append(sr.Failures, fmt.Sprintf(format, args...))
+	}
+	var undefined []string
+	secrets := map[string]string{}
+	expand := func(v string) string {
+		return s.expand(v, vars, &undefined, secrets)
+	}
+	url := s.absolute(expand(st.URL))
+	sr.URL = s.absolute(s.expand(st.URL, vars, new([]string), nil))
+	defer func() {
+		hide := []string{url, sr.URL}
+		for val, name := range secrets {
+			if len(val) >= minSecret {
+				hide = append(hide, val, name)
+			}
+		}
+		r := strings.NewReplacer(hide...)
+		for i, f := range sr.Failures {
+			sr.Failures[i] = r.Replace(f)
+		}
+	}()
+	body := expand(st.Body)
+	headers := map[string]string{}
+	for k, v := range st.Headers {
+		headers[k] = expand(v)
+	}
+	if len(undefined) > 0 {
+		fail("undefined variables: %s", strings.Join(undefined, ", "))
+		return sr, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, st.Method, url, strings.NewReader(body))
+	if err != nil {
+		fail("%v", err)
+		return sr, nil, nil
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "goaws-probe")
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		if st.FollowRedirects {
+			return nil
+		}
+		return http.ErrUseLastResponse
+	}
+
+	start := time.Now()
+	resp, err = client.Do(req)
+	if err != nil {
+		sr.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+		fail("%v", err)
+		return sr, nil, nil
+	}
+	b, err = io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	resp.Body.Close()
+	latency := time.Since(start)
+	sr.LatencyMS = float64(latency.Microseconds()) / 1000
+	sr.Status = resp.StatusCode
+	if err != nil {
+		fail("reading body: %v", err)
+	}
+
+	e := &st.Expect
+	if len(e.Status) > 0 && !slices.Contains(e.Status, resp.StatusCode) {
+		fail("status %d, want %v", resp.StatusCode, e.Status)
+	} else if len(e.Status) == 0 && resp.StatusCode >= 400 {
+		fail("status %d", resp.StatusCode)
+	}
+	for name, re := range e.headerREs {
+		v, ok := resp.Header[http.CanonicalHeaderKey(name)]
+		switch {
+		case !ok:
+			fail("no %s header", name)
+		case !re.MatchString(strings.Join(v, ", ")):
+			fail("%s header %q does not match %q", name, strings.Join(v, ", "), re)
+		}
+	}
+	if e.Body != "" && !strings.Contains(string(b), e.Body) {
+		fail("body does not contain %q", e.Body)
+	}
+	if e.bodyRE != nil && !e.bodyRE.Match(b) {
+		fail("body does not match %q", e.bodyRE)
+	}
+	if len(e.JSON) > 0 {
+		var doc any
+		if err := json.Unmarshal(b, &doc); err != nil {
+			fail("body is not JSON: %v", err)
+		} else {
+			for path, want := range e.JSON {
+				got, ok := lookup(doc, path)
+				if !ok {
+					fail("JSON %s missing", path)
+				} else if !jsonEqual(got, want) {
+					fail("JSON %s is %v, want %v", path, got, want)
+				}
+			}
+		}
+	}
+	if e.maxLatency > 0 && latency > e.maxLatency {
+		fail("took %s, over %s", latency.Round(time.Millisecond), e.maxLatency)
+	}
+	sr.OK = len(sr.Failures) == 0
+	return sr, resp, b
+}
+
+var varPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// minSecret is the shortest value hidden in failure messages; shorter ones
+// would garble them and are unlikely to be credentials.
+const minSecret = 8
+
+// expand replaces ${name} in v, noting names that are not defined. Values
+// captured by earlier steps or taken from the environment may be secrets:
+// they are recorded in secrets, mapped to their ${name}, or with secrets
+// nil left unexpanded.
+func (s *Script) expand(v string, vars map[string]string, undefined *[]string, secrets map[string]string) string {
+	return varPattern.ReplaceAllStringFunc(v, func(m string) string {
+		name := m[2 : len(m)-1]
+		secret := func(val string) string {
+			if secrets == nil {
+				return m
+			}
+			secrets[val] = m
+			return val
+		}
+		if val, ok := vars[name]; ok {
+			return secret(val)
+		}
+		if val, ok := s.Vars[name]; ok {
+			return val
+		}
+		if val, ok := os.LookupEnv(name); ok {
+			return secret(val)
+		}
+		*undefined = append(*undefined, name)
+		return m
+	})
+}
+
+// absolute resolves a URL not starting with a scheme against BaseURL.
+func (s *Script) absolute(u string) string {
+	if strings.Contains(u, "://") {
+		return u
+	}
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + strings.TrimPrefix(u, "/")
+}
+
+// capture stores the values st captures from a response; one that cannot
+// be found fails the step.
+func capture(st *Step, resp *http.Response, body []byte, vars map[string]string, sr *StepResult) {
+	for name, src := range st.Capture {
+		kind, arg, _ := strings.Cut(src, ":")
+		var val string
+		found := false
+		switch kind {
+		case "header":
+			val = resp.Header.Get(arg)
+			found = val != ""
+		case "json":
+			var doc any
+			if json.Unmarshal(body, &doc) == nil {
+				var v any
+				if v, found = lookup(doc, arg); found {
+					val = fmt.Sprint(v)
+				}
+			}
+		case "regexp":
+			if m := st.captureREs[name].FindSubmatch(body); m != nil {
+				val, found = string(m[len(m)-1]), true
+			}
+		}
+		if !found {
+			sr.OK = false
+			sr.Failures = append(sr.Failures, fmt.Sprintf("capture %s: %s not found", name, src))
+			continue
+		}
+		vars[name] = val
+	}
+}
+
+// lookup follows a dotted path through decoded JSON; numeric elements
+// index arrays.
+func lookup(doc any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]any:
+			var ok bool
+			if doc, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// jsonEqual compares decoded JSON values.
+func jsonEqual(a, b any) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+func certFor(host string, cs *tls.ConnectionState) Cert {
+	leaf := cs.PeerCertificates[0]
+	return Cert{
+		Host:     host,
+		Subject:  leaf.Subject.CommonName,
+		Issuer:   leaf.Issuer.CommonName,
+		NotAfter: leaf.NotAfter.UTC(),
+		DaysLeft: time.Until(leaf.NotAfter).Hours() / 24,
+	}
+}
@@ -0,0 +1,208 @@
+package synthetic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadScript(t *testing.T, doc string) (*Script, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "checks.json")
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func mustLoad(t *testing.T, doc string) *Script {
+	t.Helper()
+	s, err := loadScript(t, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLoadRejectsBadScripts(t *testing.T) {
+	for _, doc := range []string{
+		`{"checks": []}`,
+		`{"checks": [{"name": "a", "steps": [{"url": "/"}]}, {"name": "a", "steps": [{"url": "/"}]}]}`,
+		`{"checks": [{"steps": []}]}`,
+		`{"checks": [{"steps": [{"method": "GET"}]}]}`,
+		`{"checks": [{"interval": "0s", "steps": [{"url": "/"}]}]}`,
+		`{"checks": [{"tls_min_validity": "two weeks", "steps": [{"url": "/"}]}]}`,
+		`{"checks": [{"steps": [{"url": "/", "expect": {"body_regexp": "("}}]}]}`,
+		`{"checks": [{"steps": [{"url": "/", "capture": {"x": "cookie:sid"}}]}]}`,
+		`{"checks": [`,
+	} {
+		if _, err := loadScript(t, doc); err == nil {
+			t.Errorf("%s loaded", doc)
+		}
+	}
+	s := mustLoad(t, `{"checks": [{"steps": [{"url": "/"}]}]}`)
+	c := s.Checks[0]
+	if c.Name != "check1" || c.Steps[0].Name != "step1" || c.Steps[0].Method != "GET" || c.interval != time.Minute || c.timeout != 10*time.Second {
+		t.Errorf("defaults %+v %+v", c, c.Steps[0])
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{"": time.Second, "14d": 14 * 24 * time.Hour, "90s": 90 * time.Second} {
+		if got, err := parseDuration(s, time.Second); err != nil || got != want {
+			t.Errorf("parseDuration(%q) = %v, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"0d", "-1s", "xd"} {
+		if _, err := parseDuration(s, 0); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
+
+func testServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok": true, "token": "tok-12345678", "items": [{"id": 7}]}`))
+	})
+	mux.HandleFunc("GET /me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok-12345678" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Echo", r.URL.Query().Get("key"))
+		w.Write([]byte("hello probe, order 7"))
+	})
+	mux.HandleFunc("GET /moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/me", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunCapturesAndAsserts(t *testing.T) {
+	srv := testServer(t)
+	s := mustLoad(t, `{"vars": {"who": "probe"}, "checks": [{"name": "login", "steps": [
+		{"method": "POST", "url": "/login", "expect": {"status": [200], "json": {"ok": true, "items.0.id": 7}, "headers": {"Content-Type": "^application/json"}},
+		 "capture": {"token": "json:token", "type": "header:Content-Type", "n": "regexp:\"id\": (\\d+)"}},
+		{"url": "/me?who=${who}", "headers": {"Authorization": "Bearer ${token}"}, "expect": {"body": "hello probe", "body_regexp": "order \\d+"}}]}]}`)
+	s.BaseURL = srv.URL
+	res := s.Run(context.Background(), s.Checks[0])
+	if !res.OK || len(res.Steps) != 2 {
+		t.Fatalf("result %+v", res)
+	}
+	if res.Steps[1].Status != 200 || res.Steps[1].URL != srv.URL+"/me?who=probe" {
+		t.Errorf("step 2 %+v", res.Steps[1])
+	}
+}
+
+func TestRunReportsEveryFailedAssertion(t *testing.T) {
+	srv := testServer(t)
+	s := mustLoad(t, `{"checks": [{"steps": [
+		{"method": "POST", "url": "/login", "expect": {"status": [201], "json": {"ok": false, "missing": 1}, "headers": {"X-Nope": ""},
+		 "body": "absent", "body_regexp": "^x", "max_latency": "1ns"}},
+		{"url": "/me"}]}]}`)
+	s.BaseURL = srv.URL
+	res := s.Run(context.Background(), s.Checks[0])
+	if res.OK || len(res.Steps) != 1 {
+		t.Fatalf("result %+v", res)
+	}
+	got := strings.Join(res.Steps[0].Failures, "\n")
+	for _, want := range []string{"status 200, want [201]", "JSON ok is true, want false", "JSON missing missing", "no X-Nope header",
+		`body does not contain "absent"`, `body does not match "^x"`, "over 1ns"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+	if !strings.HasPrefix(res.Failure, "step1: ") {
+		t.Errorf("failure %q", res.Failure)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	srv := testServer(t)
+	for _, tc := range []struct {
+		name, steps, want string
+	}{
+		{"undefined", `[{"url": "/me?x=${NOT_SET_ANYWHERE}"}]`, "undefined variables: NOT_SET_ANYWHERE"},
+		{"capture", `[{"method": "POST", "url": "/login", "capture": {"t": "json:nope"}}]`, "capture t: json:nope not found"},
+		{"redirect not followed", `[{"url": "/moved", "expect": {"status": [200]}}]`, "status 302, want [200]"},
+		{"error status", `[{"url": "/me"}]`, "status 401"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := mustLoad(t, `{"checks": [{"steps": `+tc.steps+`}]}`)
+			s.BaseURL = srv.URL
+			if res := s.Run(context.Background(), s.Checks[0]); res.OK || !strings.Contains(res.Failure, tc.want) {
+				t.Errorf("failure %q, want %q", res.Failure, tc.want)
+			}
+		})
+	}
+	s := mustLoad(t, `{"checks": [{"steps": [{"url": "/moved", "follow_redirects": true, "expect": {"status": [401]}}]}]}`)
+	s.BaseURL = srv.URL
+	if res := s.Run(context.Background(), s.Checks[0]); !res.OK {
+		t.Errorf("followed redirect: %+v", res)
+	}
+}
+
+func TestSecretsAreNotReported(t *testing.T) {
+	srv := testServer(t)
+	t.Setenv("PROBE_KEY", "s3cr3t-api-key")
+	s := mustLoad(t, `{"checks": [{"steps": [
+		{"method": "POST", "url": "/login", "capture": {"token": "json:token"}},
+		{"url": "/me?key=${PROBE_KEY}&t=${token}", "headers": {"Authorization": "Bearer ${token}"}, "expect": {"headers": {"X-Echo": "^nothing$"}}}]}]}`)
+	s.BaseURL = srv.URL
+	res := s.Run(context.Background(), s.Checks[0])
+	if res.OK || res.Steps[1].Status != 200 {
+		t.Fatalf("result %+v", res)
+	}
+	if got, want := res.Steps[1].URL, srv.URL+"/me?key=${PROBE_KEY}&t=${token}"; got != want {
+		t.Errorf("URL %q, want %q", got, want)
+	}
+	// The server echoes the key back, and the failure quotes it.
+	if !strings.Contains(res.Failure, `X-Echo header "${PROBE_KEY}"`) || strings.Contains(res.Failure, "s3cr3t") {
+		t.Errorf("failure %q", res.Failure)
+	}
+
+	// Errors quoting the request URL show the unexpanded one.
+	srv.Close()
+	s.Checks[0].Steps[0].URL = "/me?key=${PROBE_KEY}"
+	res = s.Run(context.Background(), s.Checks[0])
+	if res.OK || strings.Contains(res.Failure, "s3cr3t") || !strings.Contains(res.Failure, "${PROBE_KEY}") {
+		t.Errorf("failure %q", res.Failure)
+	}
+}
+
+func TestCertificateValidity(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	prev := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	t.Cleanup(func() { http.DefaultTransport = prev })
+
+	s := mustLoad(t, `{"checks": [{"tls_min_validity": "36500d", "steps": [{"url": "/"}]}]}`)
+	s.BaseURL = srv.URL
+	res := s.Run(context.Background(), s.Checks[0])
+	if res.OK || len(res.Certs) != 1 || res.Certs[0].Host != "127.0.0.1" || !strings.HasPrefix(res.Failure, "certificate for 127.0.0.1 expires in ") {
+		t.Errorf("result %+v", res)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	doc := map[string]any{"a": []any{map[string]any{"b": "x"}}}
+	for path, want := range map[string]any{"a.0.b": "x", "a.1.b": nil, "a.x": nil, "a.0.b.c": nil} {
+		got, ok := lookup(doc, path)
+		if ok != (want != nil) || (ok && got != want) {
+			t.Errorf("lookup(%s) = %v, %v", path, got, ok)
+		}
+	}
+	if !jsonEqual(float64(7), 7) || jsonEqual("7", 7) {
+		t.Error("jsonEqual")
+	}
+}
@@ -0,0 +1,168 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"goaws/internal/env"
+	"goaws/internal/redact"
+)
+
+// Config configures tracing. It is normally built by ConfigFromEnv from the
+// standard OTEL_* variables, so the usual collector setup works unchanged.
+type Config struct {
+	// Endpoint is the OTLP/HTTP traces URL, e.g.
+	// http://127.0.0.1:4318/v1/traces.
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+	Sampler     string
+	SamplerArg  float64
+	// SampleErrors exports traces the sampler dropped if any span fails.
+	SampleErrors bool
+
+	// Exporters lists the backends: otlp, xray. Tracing is off when empty.
+	Exporters []string
+	// XRayDaemon is the UDP address of the X-Ray daemon.
+	XRayDaemon string
+	// XRaySamplingRules is a local sampling rules file for the xray
+	// sampler; empty means the X-Ray defaults.
+	XRaySamplingRules string
+
+	// Redactor, if set, is applied to span attributes, annotations,
+	// metadata and status messages before export.
+	Redactor *redact.Redactor
+}
+
+// ConfigFromEnv reads OTEL_EXPORTER_OTLP_TRACES_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_ENDPOINT, to which /v1/traces is appended),
+// OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME, OTEL_TRACES_EXPORTER,
+// OTEL_TRACES_SAMPLER, OTEL_TRACES_SAMPLER_ARG and OTEL_SDK_DISABLED, plus
+// TRACES_SAMPLE_ERRORS, AWS_XRAY_DAEMON_ADDRESS and XRAY_SAMPLING_RULES.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Endpoint:     env.String("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", ""),
+		Headers:      parseHeaders(env.String("OTEL_EXPORTER_OTLP_HEADERS", "")),
+		ServiceName:  env.String("OTEL_SERVICE_NAME", "srv"),
+		Sampler:      env.String("OTEL_TRACES_SAMPLER", "parentbased_always_on"),
+		SamplerArg:   env.Float("OTEL_TRACES_SAMPLER_ARG", 1),
+		SampleErrors: env.Bool("TRACES_SAMPLE_ERRORS", true),
+		Exporters:    env.List("OTEL_TRACES_EXPORTER", nil),
+
+		XRayDaemon:        env.String("AWS_XRAY_DAEMON_ADDRESS", "127.0.0.1:2000"),
+		XRaySamplingRules: env.String("XRAY_SAMPLING_RULES", ""),
+	}
+	if cfg.Endpoint == "" {
+		if base := env.String("OTEL_EXPORTER_OTLP_ENDPOINT", ""); base != "" {
+			cfg.Endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	// Setting only the endpoint keeps working as it did before exporters
+	// were selectable.
+	if cfg.Exporters == nil && cfg.Endpoint != "" {
+		cfg.Exporters = []string{"otlp"}
+	}
+	if env.Bool("OTEL_SDK_DISABLED", false) || slices.Contains(cfg.Exporters, "none") {
+		cfg.Exporters = nil
+	}
+	return cfg
+}
+
+// Enabled reports whether any exporter is configured.
+func (c Config) Enabled() bool { return len(c.Exporters) > 0 }
+
+// New builds the tracer and its exporters. run must be called, normally
+// as a background worker, for spans to leave the process.
+func New(cfg Config) (t *Tracer, run func(context.Context), err error) {
+	sampler, err := newSampler(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var exporters multiExporter
+	var runs []func(context.Context)
+	for _, name := range cfg.Exporters {
+		switch strings.ToLower(name) {
+		case "otlp":
+			if cfg.Endpoint == "" {
+				return nil, nil, errors.New("trace: otlp exporter needs OTEL_EXPORTER_OTLP_ENDPOINT")
+			}
+			// Copy the transport before anything wraps
+			// http.DefaultTransport, so exports are never traced
+			// themselves.
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			exp := NewOTLPExporter(cfg.Endpoint, cfg.Headers, []Attr{
+				{"service.name", cfg.ServiceName},
+				{"telemetry.sdk.language", "go"},
+			}, transport)
+			exporters = append(exporters, exp)
+			runs = append(runs, exp.Run)
+		case "xray":
+			exp := NewXRayExporter(cfg.XRayDaemon, cfg.ServiceName)
+			exporters = append(exporters, exp)
+			runs = append(runs, exp.Run)
+		default:
+			return nil, nil, fmt.Errorf("trace: unknown exporter %q", name)
+		}
+	}
+	run = func(ctx context.Context) {
+		var wg sync.WaitGroup
+		for _, r := range runs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r(ctx)
+			}()
+		}
+		wg.Wait()
+	}
+	t = NewTracer(sampler, cfg.SampleErrors, exporters)
+	t.redactor = cfg.Redactor
+	return t, run, nil
+}
+
+// newSampler adds the xray and parentbased_xray samplers, which apply X-Ray
+// sampling rules, to those ParseSampler knows.
+func newSampler(cfg Config) (Sampler, error) {
+	base, parent := strings.CutPrefix(strings.ToLower(cfg.Sampler), "parentbased_")
+	if base != "xray" {
+		return ParseSampler(cfg.Sampler, cfg.SamplerArg)
+	}
+	rules, err := LoadXRayRules(cfg.XRaySamplingRules)
+	if err != nil {
+		return nil, err
+	}
+	if parent {
+		return ParentBased{Root: rules}, nil
+	}
+	return rules, nil
+}
+
+type multiExporter []Exporter
+
+func (m multiExporter) Export(spans []*Span) {
+	for _, e := range m {
+		e.Export(spans)
+	}
+}
+
+// parseHeaders reads the OTEL "k1=v1,k2=v2" form with URL-encoded values.
+func parseHeaders(s string) map[string]string {
+	h := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if dv, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = dv
+		}
+		h[strings.TrimSpace(k)] = v
+	}
+	return h
+}
@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"goaws/internal/httpx"
+)
+
+// Middleware starts a server span for every request, continuing the trace
+// from traceparent or X-Amzn-Trace-Id. The span is named after the matched
+// ServeMux pattern and marked failed on 5xx responses.
+func Middleware(t *Tracer, next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := Extract(r.Header); ok {
+			ctx = ContextWithRemote(ctx, sc)
+		}
+		ctx, span := t.Start(ctx, r.Method, KindServer,
+			Attr{"http.request.method", r.Method},
+			Attr{"url.path", r.URL.Path},
+			Attr{"server.address", r.Host},
+			Attr{"user_agent.original", r.UserAgent()},
+			Attr{"client.address", httpx.ClientIP(r)},
+		)
+		defer span.End()
+
+		rec := httpx.NewRecorder(w)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(rec, r)
+
+		route := httpx.Route(r)
+		if strings.HasPrefix(route, r.Method+" ") {
+			span.SetName(route)
+		} else {
+			span.SetName(r.Method + " " + route)
+		}
+		span.SetAttr("http.route", route)
+		span.SetAttr("http.response.status_code", rec.Status())
+		if rec.Status() >= 500 {
+			span.SetStatus(StatusError, strconv.Itoa(rec.Status()))
+		}
+	})
+}
+
+// Transport is an http.RoundTripper that records a client span for every
+// outbound request and propagates the trace to the server.
+type Transport struct {
+	Tracer *Tracer
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Tracer == nil {
+		return base.RoundTrip(req)
+	}
+	ctx, span := t.Tracer.Start(req.Context(), req.Method, KindClient,
+		Attr{"http.request.method", req.Method},
+		Attr{"server.address", req.URL.Hostname()},
+		Attr{"url.full", redactURL(req)},
+	)
+	defer span.End()
+
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttr("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.SetStatus(StatusError, resp.Status)
+	}
+	return resp, nil
+}
+
+// redactURL drops the query string and credentials, which often carry
+// presigned signatures or tokens.
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,83 @@
+package trace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"goaws/internal/httpx"
+	"goaws/internal/logging"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *recordingExporter) Export(spans []*Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mu.Unlock()
+}
+
+func (e *recordingExporter) only(t *testing.T) *Span {
+	t.Helper()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.spans) != 1 {
+		t.Fatalf("exported %d spans, want 1", len(e.spans))
+	}
+	return e.spans[0]
+}
+
+func attr(s *Span, key string) any {
+	for _, a := range s.Attrs() {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// The server span is named after the route even though logging and the
+// middleware in between hand copies of the request to the mux.
+func TestMiddlewareSpanNamedAfterRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	// Stands in for middleware that adds to the request context.
+	copying := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(r.Context()))
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler func(*Tracer) http.Handler
+	}{
+		{"mux", func(t *Tracer) http.Handler { return Middleware(t, mux) }},
+		{"server", func(t *Tracer) http.Handler {
+			return logging.Middleware(Middleware(t, copying(httpx.Routes(mux))))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exp := &recordingExporter{}
+			h := tc.handler(NewTracer(AlwaysOn, false, exp))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/7", nil))
+
+			s := exp.only(t)
+			if s.name != "GET /items/{id}" {
+				t.Errorf("span name = %q", s.name)
+			}
+			if got := attr(s, "http.route"); got != "GET /items/{id}" {
+				t.Errorf("http.route = %v", got)
+			}
+			if got := attr(s, "http.response.status_code"); got != int64(http.StatusTeapot) {
+				t.Errorf("status = %v", got)
+			}
+		})
+	}
+}
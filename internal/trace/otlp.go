@@ -0,0 +1,230 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"goaws/internal/metrics"
+)
+
+var (
+	exportedSpans = metrics.NewCounter("trace_spans_exported_total", "Spans handed to a trace backend.", "exporter")
+	droppedSpans  = metrics.NewCounter("trace_spans_dropped_total", "Spans dropped before export.", "exporter", "reason")
+	exportErrors  = metrics.NewCounter("trace_export_errors_total", "Failed export requests.", "exporter")
+)
+
+// OTLPExporter batches spans and posts them as OTLP/HTTP JSON to
+// <endpoint>/v1/traces, e.g. a local collector on :4318.
+type OTLPExporter struct {
+	url      string
+	headers  map[string]string
+	resource []Attr
+	client   *http.Client
+
+	ch        chan *Span
+	batchSize int
+	interval  time.Duration
+	log       *slog.Logger
+}
+
+// NewOTLPExporter returns an exporter posting to url. transport should not
+// itself be traced, or every export would produce more spans.
+func NewOTLPExporter(url string, headers map[string]string, resource []Attr, transport http.RoundTripper) *OTLPExporter {
+	return &OTLPExporter{
+		url:       url,
+		headers:   headers,
+		resource:  resource,
+		client:    &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		ch:        make(chan *Span, 4096),
+		batchSize: 512,
+		interval:  5 * time.Second,
+		log:       slog.Default().With("component", "trace"),
+	}
+}
+
+// Export queues spans without blocking; spans are dropped when the queue is
+// full.
+func (e *OTLPExporter) Export(spans []*Span) {
+	for _, s := range spans {
+		select {
+		case e.ch <- s:
+		default:
+			droppedSpans.With("otlp", "queue_full").Inc()
+		}
+	}
+}
+
+// Run sends batches until ctx is done, then flushes what is queued.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	var batch []*Span
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 {
+			e.send(ctx, batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-e.ch:
+			batch = append(batch, s)
+			if len(batch) >= e.batchSize {
+				flush(ctx)
+			}
+		case <-t.C:
+			flush(ctx)
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for {
+				select {
+				case s := <-e.ch:
+					batch = append(batch, s)
+				default:
+					flush(drainCtx)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *OTLPExporter) send(ctx context.Context, batch []*Span) {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		droppedSpans.With("otlp", "encode").Add(float64(len(batch)))
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		droppedSpans.With("otlp", "encode").Add(float64(len(batch)))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("collector returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		exportErrors.With("otlp").Inc()
+		droppedSpans.With("otlp", "export_failed").Add(float64(len(batch)))
+		e.log.Warn("exporting spans", "count", len(batch), "err", err)
+		return
+	}
+	exportedSpans.With("otlp").Add(float64(len(batch)))
+}
+
+// The OTLP/JSON shapes, from opentelemetry-proto's trace/v1 and common/v1.
+// IDs are hex and timestamps decimal strings, as the JSON mapping requires.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		TraceState        string         `json:"traceState,omitempty"`
+		Name              string         `json:"name"`
+		Kind              Kind           `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    StatusCode `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+func (e *OTLPExporter) encode(batch []*Span) otlpRequest {
+	var ss otlpScopeSpans
+	ss.Scope.Name = "goaws/internal/trace"
+	for _, s := range batch {
+		s.mu.Lock()
+		os := otlpSpan{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			TraceState:        s.sc.TraceState,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        keyValues(append(annotationAttrs(s.annotations), s.attrs...)),
+			Status:            otlpStatus{Code: s.status, Message: s.statusMsg},
+		}
+		s.mu.Unlock()
+		if s.parent.IsValid() {
+			os.ParentSpanID = s.parent.String()
+		}
+		ss.Spans = append(ss.Spans, os)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: keyValues(e.resource)},
+		ScopeSpans: []otlpScopeSpans{ss},
+	}}}
+}
+
+// annotationAttrs returns the annotations as attributes in a new slice, so
+// the span's own attrs are never appended to by concurrent exporters.
+func annotationAttrs(m map[string]any) []Attr {
+	attrs := make([]Attr, 0, len(m))
+	for k, v := range m {
+		attrs = append(attrs, Attr{k, v})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+func keyValues(attrs []Attr) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]any
+		switch x := a.Value.(type) {
+		case bool:
+			v = map[string]any{"boolValue": x}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			v = map[string]any{"doubleValue": x}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Extract reads the remote parent from W3C traceparent, falling back to the
+// AWS X-Amzn-Trace-Id header set by the ALB.
+func Extract(h http.Header) (SpanContext, bool) {
+	if sc, ok := parseTraceparent(h.Get("traceparent")); ok {
+		sc.TraceState = h.Get("tracestate")
+		return sc, true
+	}
+	return ParseXRay(h.Get("X-Amzn-Trace-Id"))
+}
+
+// Inject writes sc as both traceparent and X-Amzn-Trace-Id.
+func Inject(ctx context.Context, h http.Header) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	sc := s.SpanContext()
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	h.Set("traceparent", "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+	if sc.TraceState != "" {
+		h.Set("tracestate", sc.TraceState)
+	}
+	h.Set("X-Amzn-Trace-Id", FormatXRay(sc))
+}
+
+func parseTraceparent(v string) (SpanContext, bool) {
+	// version-traceid-parentid-flags, e.g.
+	// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) {
+		return SpanContext{}, false
+	}
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) || !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	sc.SampledSet = true
+	sc.Remote = true
+	return sc, true
+}
+
+// ParseXRay reads an X-Amzn-Trace-Id header such as
+// "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1".
+// The ALB only sets Root, leaving the sampling decision to us.
+func ParseXRay(v string) (SpanContext, bool) {
+	var sc SpanContext
+	for _, field := range strings.Split(v, ";") {
+		k, val, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch k {
+		case "Root":
+			parts := strings.Split(val, "-")
+			if len(parts) != 3 || parts[0] != "1" || !decodeHex(sc.TraceID[:], parts[1]+parts[2]) {
+				return SpanContext{}, false
+			}
+		case "Parent":
+			if !decodeHex(sc.SpanID[:], val) {
+				return SpanContext{}, false
+			}
+		case "Sampled":
+			switch val {
+			case "1":
+				sc.Sampled, sc.SampledSet = true, true
+			case "0":
+				sc.Sampled, sc.SampledSet = false, true
+			}
+		}
+	}
+	if !sc.TraceID.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Remote = true
+	return sc, true
+}
+
+// FormatXRay renders sc as an X-Amzn-Trace-Id value.
+func FormatXRay(sc SpanContext) string {
+	v := "Root=" + sc.TraceID.XRay()
+	if sc.SpanID.IsValid() {
+		v += ";Parent=" + sc.SpanID.String()
+	}
+	if sc.SampledSet {
+		if sc.Sampled {
+			v += ";Sampled=1"
+		} else {
+			v += ";Sampled=0"
+		}
+	}
+	return v
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
@@ -0,0 +1,101 @@
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Sampler decides up front whether a new span is recorded and exported.
+type Sampler interface {
+	ShouldSample(p SamplingParams) bool
+}
+
+// SamplingParams describes the span being started.
+type SamplingParams struct {
+	Parent  SpanContext
+	TraceID TraceID
+	Name    string
+	Kind    Kind
+	// Attrs are the attributes passed to Start; server spans carry the
+	// method, path and host there.
+	Attrs []Attr
+}
+
+// Attr returns the string value of the named attribute, or "".
+func (p SamplingParams) Attr(key string) string {
+	for _, a := range p.Attrs {
+		if a.Key == key {
+			s, _ := a.Value.(string)
+			return s
+		}
+	}
+	return ""
+}
+
+type constSampler bool
+
+func (c constSampler) ShouldSample(SamplingParams) bool { return bool(c) }
+
+// AlwaysOn samples everything; AlwaysOff nothing.
+var (
+	AlwaysOn  Sampler = constSampler(true)
+	AlwaysOff Sampler = constSampler(false)
+)
+
+// RatioSampler samples a fixed fraction of traces. The decision depends only
+// on the trace ID, so every service using the same ratio agrees on it.
+type RatioSampler struct {
+	bound uint64
+}
+
+// NewRatioSampler samples ratio (0..1) of traces.
+func NewRatioSampler(ratio float64) RatioSampler {
+	switch {
+	case ratio >= 1:
+		return RatioSampler{bound: 1 << 63}
+	case ratio <= 0:
+		return RatioSampler{}
+	}
+	return RatioSampler{bound: uint64(ratio * (1 << 63))}
+}
+
+func (r RatioSampler) ShouldSample(p SamplingParams) bool {
+	// The low 8 bytes are random; the high ones start with a timestamp.
+	return binary.BigEndian.Uint64(p.TraceID[8:])>>1 < r.bound
+}
+
+// ParentBased follows the parent's decision when there is one, and defers
+// to Root otherwise, including for ALB requests whose X-Amzn-Trace-Id
+// carries no Sampled flag.
+type ParentBased struct {
+	Root Sampler
+}
+
+func (pb ParentBased) ShouldSample(p SamplingParams) bool {
+	if p.Parent.TraceID.IsValid() && p.Parent.SampledSet {
+		return p.Parent.Sampled
+	}
+	return pb.Root.ShouldSample(p)
+}
+
+// ParseSampler understands the OTEL_TRACES_SAMPLER values always_on,
+// always_off, traceidratio and their parentbased_ variants.
+func ParseSampler(name string, ratio float64) (Sampler, error) {
+	base, parent := strings.CutPrefix(strings.ToLower(name), "parentbased_")
+	var s Sampler
+	switch base {
+	case "always_on":
+		s = AlwaysOn
+	case "always_off":
+		s = AlwaysOff
+	case "traceidratio":
+		s = NewRatioSampler(ratio)
+	default:
+		return nil, fmt.Errorf("trace: unknown sampler %q", name)
+	}
+	if parent {
+		s = ParentBased{Root: s}
+	}
+	return s, nil
+}
@@ -0,0 +1,212 @@
+package trace
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+)
+
+// OpenDB is sql.Open with a client span around every statement. driverName
+// must already be registered, as for sql.Open.
+func OpenDB(t *Tracer, driverName, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil || t == nil {
+		return db, err
+	}
+	drv := db.Driver()
+	db.Close()
+	c := &connector{tracer: t, system: driverName, driver: drv, dsn: dsn}
+	if dc, ok := drv.(driver.DriverContext); ok {
+		if c.base, err = dc.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+	return sql.OpenDB(c), nil
+}
+
+type connector struct {
+	tracer *Tracer
+	system string
+	driver driver.Driver
+	base   driver.Connector
+	dsn    string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	var err error
+	if c.base != nil {
+		conn, err = c.base.Connect(ctx)
+	} else {
+		conn, err = c.driver.Open(c.dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, c: c}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return c.driver }
+
+func (c *connector) start(ctx context.Context, query string) (context.Context, *Span) {
+	return c.tracer.Start(ctx, spanName(query), KindClient,
+		Attr{"db.system", c.system},
+		Attr{"db.statement", query},
+	)
+}
+
+// spanName uses the statement's leading keyword, e.g. "SELECT", which keeps
+// span names low-cardinality.
+func spanName(query string) string {
+	op, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	return strings.ToUpper(op)
+}
+
+func endSpan(s *Span, err error) {
+	if err != nil && !errors.Is(err, driver.ErrSkip) && !errors.Is(err, sql.ErrNoRows) {
+		s.SetError(err)
+	}
+	s.End()
+}
+
+// tracedConn forwards to the driver's connection. Statements executed
+// directly are traced here; prepared ones through tracedStmt.
+type tracedConn struct {
+	driver.Conn
+	c *connector
+}
+
+func (tc *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ex, ok := tc.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := tc.c.start(ctx, query)
+	res, err := ex.ExecContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		// database/sql will prepare the statement instead, which is
+		// traced there; don't report this attempt.
+		span.discard()
+		return nil, err
+	}
+	endSpan(span, err)
+	return res, err
+}
+
+func (tc *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := tc.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := tc.c.start(ctx, query)
+	rows, err := q.QueryContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		span.discard()
+		return nil, err
+	}
+	endSpan(span, err)
+	return rows, err
+}
+
+func (tc *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var st driver.Stmt
+	var err error
+	if p, ok := tc.Conn.(driver.ConnPrepareContext); ok {
+		st, err = p.PrepareContext(ctx, query)
+	} else {
+		st, err = tc.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: st, c: tc.c, query: query}, nil
+}
+
+func (tc *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := tc.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("trace: driver does not support transaction options")
+	}
+	return tc.Conn.Begin()
+}
+
+func (tc *tracedConn) ResetSession(ctx context.Context) error {
+	if r, ok := tc.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (tc *tracedConn) IsValid() bool {
+	if v, ok := tc.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (tc *tracedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if c, ok := tc.Conn.(driver.NamedValueChecker); ok {
+		return c.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type tracedStmt struct {
+	driver.Stmt
+	c     *connector
+	query string
+}
+
+func (ts *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := ts.c.start(ctx, ts.query)
+	var res driver.Result
+	var err error
+	if ex, ok := ts.Stmt.(driver.StmtExecContext); ok {
+		res, err = ex.ExecContext(ctx, args)
+	} else {
+		var vals []driver.Value
+		if vals, err = values(args); err == nil {
+			res, err = ts.Stmt.Exec(vals)
+		}
+	}
+	endSpan(span, err)
+	return res, err
+}
+
+func (ts *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := ts.c.start(ctx, ts.query)
+	var rows driver.Rows
+	var err error
+	if q, ok := ts.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		var vals []driver.Value
+		if vals, err = values(args); err == nil {
+			rows, err = ts.Stmt.Query(vals)
+		}
+	}
+	endSpan(span, err)
+	return rows, err
+}
+
+func (ts *tracedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if c, ok := ts.Stmt.(driver.NamedValueChecker); ok {
+		return c.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func values(args []driver.NamedValue) ([]driver.Value, error) {
+	out := make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, errors.New("trace: driver does not support named parameters")
+		}
+		out[i] = a.Value
+	}
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package trace
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+)
+
+// skipDriver declines to execute statements directly, so database/sql
+// prepares them instead, as some drivers do for statements with arguments.
+type skipDriver struct{ err error }
+
+func (d skipDriver) Open(string) (driver.Conn, error) { return skipConn{d.err}, nil }
+
+type skipConn struct{ err error }
+
+func (c skipConn) Prepare(string) (driver.Stmt, error) { return skipStmt{c.err}, nil }
+func (c skipConn) Close() error                        { return nil }
+func (c skipConn) Begin() (driver.Tx, error)           { return nil, errors.New("no transactions") }
+
+func (c skipConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c skipConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+type skipStmt struct{ err error }
+
+func (s skipStmt) Close() error                               { return nil }
+func (s skipStmt) NumInput() int                              { return -1 }
+func (s skipStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), s.err }
+func (s skipStmt) Query([]driver.Value) (driver.Rows, error)  { return emptyRows{}, s.err }
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string         { return []string{"x"} }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+func init() {
+	sql.Register("trace-skip", skipDriver{})
+	sql.Register("trace-skip-failing", skipDriver{err: errors.New("disk full")})
+}
+
+func TestSkippedStatementsAreTracedOnce(t *testing.T) {
+	exp := &recordingExporter{}
+	db, err := OpenDB(NewTracer(AlwaysOn, false, exp), "trace-skip", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO t VALUES (?)", 1); err != nil {
+		t.Fatal(err)
+	}
+	s := exp.only(t)
+	if s.name != "INSERT" || attr(s, "db.statement") != "INSERT INTO t VALUES (?)" {
+		t.Errorf("span %q %v", s.name, s.Attrs())
+	}
+
+	exp.spans = nil
+	rows, err := db.Query("SELECT x FROM t WHERE x = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if s := exp.only(t); s.name != "SELECT" {
+		t.Errorf("span %q", s.name)
+	}
+}
+
+func TestSkippedAttemptIsNotExportedWithFailedTrace(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer := NewTracer(AlwaysOff, true, exp)
+	db, err := OpenDB(tracer, "trace-skip-failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, parent := tracer.Start(context.Background(), "job", KindInternal)
+	if _, err := db.ExecContext(ctx, "DELETE FROM t WHERE x = ?", 1); err == nil {
+		t.Fatal("exec succeeded")
+	}
+	parent.End()
+
+	// The failure brings the unsampled trace out: the job and the one
+	// statement that ran, not the attempt the driver skipped.
+	var names []string
+	for _, s := range exp.spans {
+		names = append(names, s.name)
+	}
+	if len(names) != 2 || names[0] != "DELETE" || names[1] != "job" {
+		t.Errorf("exported %v", names)
+	}
+}
@@ -0,0 +1,383 @@
+// Package trace is a small OpenTelemetry-compatible tracer. It creates
+// server, client and internal spans, propagates context through W3C
+// traceparent and AWS X-Ray headers, samples by parent, ratio or error, and
+// exports over OTLP/HTTP.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"goaws/internal/redact"
+)
+
+// TraceID is 16 bytes. The first four are the creation time in Unix
+// seconds, which makes every ID a valid X-Ray trace ID as well.
+type TraceID [16]byte
+
+// SpanID is 8 bytes.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (t TraceID) IsValid() bool  { return t != TraceID{} }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+func (s SpanID) IsValid() bool   { return s != SpanID{} }
+
+// XRay formats t as an X-Ray root, e.g. 1-5759e988-bd862e3fe1be46a994272793.
+func (t TraceID) XRay() string {
+	h := t.String()
+	return "1-" + h[:8] + "-" + h[8:]
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	binary.BigEndian.PutUint32(t[:4], uint32(time.Now().Unix()))
+	rand.Read(t[4:])
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		rand.Read(s[:])
+	}
+	return s
+}
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	// SampledSet is false when the upstream left the decision to us, as
+	// the ALB does with X-Amzn-Trace-Id.
+	SampledSet bool
+	Remote     bool
+	TraceState string
+}
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// StatusCode is the OTLP span status code.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attr is a span attribute. Value is a string, bool, int64 or float64.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Span is one timed operation.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	kind   Kind
+	root   *localRoot
+	// ownsRoot is set on the first span of the trace in this process,
+	// which ends after all of its children.
+	ownsRoot bool
+	// local is that first span, which X-Ray reports as the segment.
+	local *Span
+
+	mu        sync.Mutex
+	name      string
+	start     time.Time
+	end       time.Time
+	attrs     []Attr
+	status    StatusCode
+	statusMsg string
+	ended     bool
+
+	annotations map[string]any
+	metadata    map[string]any
+}
+
+// localRoot collects the spans of one trace within this process while the
+// sampling decision is still open, so a trace that was not sampled up front
+// can still be exported when one of its spans fails.
+type localRoot struct {
+	mu      sync.Mutex
+	spans   []*Span
+	errored bool
+}
+
+// SpanContext returns the span's propagated identity.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName renames the span, e.g. once the route is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttr records an attribute. Values other than string, bool, integers
+// and floats are formatted with %v.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case string, bool, int64, float64:
+	case int:
+		value = int64(v)
+	case float32:
+		value = float64(v)
+	default:
+		value = fmt.Sprint(v)
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, Attr{key, value})
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status = StatusError
+	s.statusMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// SetStatus sets the status explicitly.
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status, s.statusMsg = code, msg
+	s.mu.Unlock()
+}
+
+// SetAnnotation records an indexed key/value that X-Ray can filter traces
+// by. Values are strings, bools or numbers; OTLP exports them as attributes.
+func (s *Span) SetAnnotation(key string, value any) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case string, bool, int64, float64:
+	case int:
+		value = int64(v)
+	case float32:
+		value = float64(v)
+	default:
+		value = fmt.Sprint(v)
+	}
+	s.mu.Lock()
+	if s.annotations == nil {
+		s.annotations = map[string]any{}
+	}
+	s.annotations[key] = value
+	s.mu.Unlock()
+}
+
+// SetMetadata records an unindexed value, which may be any JSON-encodable
+// object. Only the X-Ray exporter sends metadata.
+func (s *Span) SetMetadata(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.metadata == nil {
+		s.metadata = map[string]any{}
+	}
+	s.metadata[key] = value
+	s.mu.Unlock()
+}
+
+// Attrs returns a copy of the attributes recorded so far.
+func (s *Span) Attrs() []Attr {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Attr(nil), s.attrs...)
+}
+
+// End finishes the span and hands it to the exporter if it is sampled.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	failed := s.status == StatusError
+	s.redact(s.tracer.redactor)
+	s.mu.Unlock()
+	s.tracer.finish(s, failed)
+}
+
+// discard ends s without exporting it, for an operation that turned out
+// not to happen.
+func (s *Span) discard() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+}
+
+// redact applies r to everything exported, once the span can no longer
+// change. Samplers have already seen the raw attributes. s.mu is held.
+func (s *Span) redact(r *redact.Redactor) {
+	if r == nil {
+		return
+	}
+	// The attrs may still share an array with the slice passed to Start.
+	attrs := make([]Attr, len(s.attrs))
+	for i, a := range s.attrs {
+		attrs[i] = Attr{a.Key, r.Attr(redact.SourceTrace, a.Key, a.Value)}
+	}
+	s.attrs = attrs
+	for k, v := range s.annotations {
+		s.annotations[k] = r.Attr(redact.SourceTrace, k, v)
+	}
+	for k, v := range s.metadata {
+		s.metadata[k] = r.Value(redact.SourceTrace, map[string]any{k: v}).(map[string]any)[k]
+	}
+	s.statusMsg = r.String(redact.SourceTrace, s.statusMsg)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns ctx carrying s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the current span, or nil. Every Span method is
+// safe to call on nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+type remoteKey struct{}
+
+// ContextWithRemote returns ctx carrying an extracted remote parent.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Exporter receives finished, sampled spans.
+type Exporter interface {
+	Export(spans []*Span)
+}
+
+// Tracer starts spans.
+type Tracer struct {
+	sampler     Sampler
+	sampleError bool
+	exporter    Exporter
+	redactor    *redact.Redactor
+}
+
+// NewTracer returns a tracer. When sampleErrors is set, traces the sampler
+// dropped are still exported if any of their spans ends with an error.
+func NewTracer(sampler Sampler, sampleErrors bool, exporter Exporter) *Tracer {
+	return &Tracer{sampler: sampler, sampleError: sampleErrors, exporter: exporter}
+}
+
+// Start begins a span as a child of the span or remote parent in ctx. A nil
+// Tracer returns a nil span, so callers need not check whether tracing is
+// enabled.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.sc
+		s.root = p.root
+		s.local = p.local
+	} else if rp, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = rp
+	}
+
+	if parent.TraceID.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.TraceState = parent.TraceState
+		s.parent = parent.SpanID
+	} else {
+		s.sc.TraceID = newTraceID()
+	}
+	s.sc.SpanID = newSpanID()
+	if s.local == nil {
+		s.local = s
+	}
+	s.sc.Sampled = t.sampler.ShouldSample(SamplingParams{
+		Parent: parent, TraceID: s.sc.TraceID, Name: name, Kind: kind, Attrs: attrs,
+	})
+	s.sc.SampledSet = true
+
+	if !s.sc.Sampled && s.root == nil && t.sampleError {
+		s.root = &localRoot{}
+		s.ownsRoot = true
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+func (t *Tracer) finish(s *Span, failed bool) {
+	if s.sc.Sampled {
+		t.exporter.Export([]*Span{s})
+		return
+	}
+	r := s.root
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.errored = r.errored || failed
+	var out []*Span
+	if s.ownsRoot {
+		if r.errored {
+			out = r.spans
+		}
+		r.spans = nil
+	}
+	r.mu.Unlock()
+	if len(out) > 0 {
+		t.exporter.Export(out)
+	}
+}
@@ -0,0 +1,336 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// xrayHeader precedes every document sent to the X-Ray daemon.
+const xrayHeader = `{"format": "json", "version": 1}` + "\n"
+
+// maxXRayPacket keeps a document within one UDP datagram.
+const maxXRayPacket = 64000
+
+// XRayExporter sends spans to the X-Ray daemon over UDP. The first span of a
+// trace in this process becomes the segment; its descendants are nested in
+// it as subsegments and sent with it once it ends. Spans that outlive their
+// segment, or segments too large for one datagram, are sent as standalone
+// subsegment documents instead.
+type XRayExporter struct {
+	addr    string
+	service string
+	ch      chan *Span
+	// wait is how long children are held for a segment that has not
+	// ended before they are sent on their own.
+	wait     time.Duration
+	interval time.Duration
+	log      *slog.Logger
+}
+
+// NewXRayExporter returns an exporter for the daemon at addr, e.g.
+// 127.0.0.1:2000. service names the segments.
+func NewXRayExporter(addr, service string) *XRayExporter {
+	return &XRayExporter{
+		addr:     addr,
+		service:  service,
+		ch:       make(chan *Span, 4096),
+		wait:     30 * time.Second,
+		interval: time.Second,
+		log:      slog.Default().With("component", "trace"),
+	}
+}
+
+// Export queues spans without blocking.
+func (e *XRayExporter) Export(spans []*Span) {
+	for _, s := range spans {
+		select {
+		case e.ch <- s:
+		default:
+			droppedSpans.With("xray", "queue_full").Inc()
+		}
+	}
+}
+
+type pendingSegment struct {
+	since time.Time
+	spans []*Span
+}
+
+// Run sends documents until ctx is done, then sends what is left.
+func (e *XRayExporter) Run(ctx context.Context) {
+	conn, err := net.Dial("udp", e.addr)
+	if err != nil {
+		e.log.Error("dialing xray daemon", "addr", e.addr, "err", err)
+		return
+	}
+	defer conn.Close()
+
+	pending := map[*Span]*pendingSegment{}
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	handle := func(s *Span) {
+		if s.local != s {
+			p := pending[s.local]
+			if p == nil {
+				p = &pendingSegment{since: time.Now()}
+				pending[s.local] = p
+			}
+			p.spans = append(p.spans, s)
+			return
+		}
+		var children []*Span
+		if p := pending[s]; p != nil {
+			children = p.spans
+			delete(pending, s)
+		}
+		e.sendSegment(conn, s, children)
+	}
+	for {
+		select {
+		case s := <-e.ch:
+			handle(s)
+		case now := <-t.C:
+			for seg, p := range pending {
+				if now.Sub(p.since) >= e.wait {
+					e.sendOrphans(conn, p.spans)
+					delete(pending, seg)
+				}
+			}
+		case <-ctx.Done():
+			for {
+				select {
+				case s := <-e.ch:
+					handle(s)
+				default:
+					for _, p := range pending {
+						e.sendOrphans(conn, p.spans)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// xrayDoc is a segment or subsegment document, see
+// https://docs.aws.amazon.com/xray/latest/devguide/xray-api-segmentdocuments.html
+type xrayDoc struct {
+	Name        string         `json:"name"`
+	ID          string         `json:"id"`
+	TraceID     string         `json:"trace_id,omitempty"`
+	ParentID    string         `json:"parent_id,omitempty"`
+	Type        string         `json:"type,omitempty"`
+	StartTime   float64        `json:"start_time"`
+	EndTime     float64        `json:"end_time"`
+	Origin      string         `json:"origin,omitempty"`
+	Namespace   string         `json:"namespace,omitempty"`
+	Error       bool           `json:"error,omitempty"`
+	Throttle    bool           `json:"throttle,omitempty"`
+	Fault       bool           `json:"fault,omitempty"`
+	Cause       *xrayCause     `json:"cause,omitempty"`
+	HTTP        *xrayHTTP      `json:"http,omitempty"`
+	SQL         map[string]any `json:"sql,omitempty"`
+	Annotations map[string]any `json:"annotations,omitempty"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+	Subsegments []*xrayDoc     `json:"subsegments,omitempty"`
+}
+
+type xrayCause struct {
+	Exceptions []xrayException `json:"exceptions"`
+}
+
+type xrayException struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+type xrayHTTP struct {
+	Request  map[string]any `json:"request,omitempty"`
+	Response map[string]any `json:"response,omitempty"`
+}
+
+func (e *XRayExporter) sendSegment(conn net.Conn, seg *Span, children []*Span) {
+	doc := e.doc(seg)
+	doc.TraceID = seg.sc.TraceID.XRay()
+	if seg.parent.IsValid() {
+		doc.ParentID = seg.parent.String()
+	}
+	doc.Name = e.service
+	doc.Origin = "AWS::EC2::Instance"
+	if seg.kind != KindServer {
+		// Background work has no request to describe; keep the operation
+		// searchable.
+		if doc.Annotations == nil {
+			doc.Annotations = map[string]any{}
+		}
+		doc.Annotations["operation"] = seg.name
+	}
+	doc.Subsegments = e.tree(seg.sc.SpanID, children)
+	if e.write(conn, doc, len(children)+1) {
+		return
+	}
+	// Too big for one datagram: send the segment bare and each child
+	// subtree on its own.
+	doc.Subsegments = nil
+	e.write(conn, doc, 1)
+	e.sendOrphans(conn, children)
+}
+
+// sendOrphans sends spans whose segment is gone as standalone subsegments,
+// keeping nesting among the spans themselves.
+func (e *XRayExporter) sendOrphans(conn net.Conn, spans []*Span) {
+	ids := make(map[SpanID]bool, len(spans))
+	for _, s := range spans {
+		ids[s.sc.SpanID] = true
+	}
+	for _, s := range spans {
+		if ids[s.parent] {
+			continue
+		}
+		doc := e.doc(s)
+		doc.Type = "subsegment"
+		doc.TraceID = s.sc.TraceID.XRay()
+		doc.ParentID = s.parent.String()
+		doc.Subsegments = e.tree(s.sc.SpanID, spans)
+		n := 1 + countDocs(doc.Subsegments)
+		if !e.write(conn, doc, n) {
+			droppedSpans.With("xray", "too_large").Add(float64(n))
+		}
+	}
+}
+
+func countDocs(docs []*xrayDoc) int {
+	n := len(docs)
+	for _, d := range docs {
+		n += countDocs(d.Subsegments)
+	}
+	return n
+}
+
+// tree nests the spans under parent.
+func (e *XRayExporter) tree(parent SpanID, spans []*Span) []*xrayDoc {
+	var out []*xrayDoc
+	for _, s := range spans {
+		if s.parent != parent {
+			continue
+		}
+		d := e.doc(s)
+		d.Subsegments = e.tree(s.sc.SpanID, spans)
+		out = append(out, d)
+	}
+	return out
+}
+
+// write sends doc, reporting false when it does not fit in a datagram.
+func (e *XRayExporter) write(conn net.Conn, doc *xrayDoc, spans int) bool {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		droppedSpans.With("xray", "encode").Add(float64(spans))
+		return true
+	}
+	if len(xrayHeader)+len(body) > maxXRayPacket {
+		return false
+	}
+	if _, err := conn.Write(append([]byte(xrayHeader), body...)); err != nil {
+		exportErrors.With("xray").Inc()
+		droppedSpans.With("xray", "export_failed").Add(float64(spans))
+		return true
+	}
+	exportedSpans.With("xray").Add(float64(spans))
+	return true
+}
+
+func (e *XRayExporter) doc(s *Span) *xrayDoc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := &xrayDoc{
+		Name:      s.name,
+		ID:        s.sc.SpanID.String(),
+		StartTime: float64(s.start.UnixMicro()) / 1e6,
+		EndTime:   float64(s.end.UnixMicro()) / 1e6,
+	}
+	a := attrMap(s.attrs)
+	status, _ := a["http.response.status_code"].(int64)
+	switch {
+	case status == 429:
+		d.Error, d.Throttle = true, true
+	case status >= 500:
+		d.Fault = true
+	case status >= 400:
+		d.Error = true
+	case s.status == StatusError:
+		d.Fault = true
+	}
+	if s.status == StatusError && s.statusMsg != "" {
+		d.Cause = &xrayCause{Exceptions: []xrayException{{ID: newSpanID().String(), Message: s.statusMsg}}}
+	}
+
+	switch {
+	case a["db.system"] != nil:
+		d.Namespace = "remote"
+		d.Name = str(a["db.system"])
+		d.SQL = map[string]any{"database_type": a["db.system"], "sanitized_query": a["db.statement"]}
+	case a["http.request.method"] != nil:
+		d.HTTP = &xrayHTTP{Request: map[string]any{"method": a["http.request.method"]}}
+		if status != 0 {
+			d.HTTP.Response = map[string]any{"status": status}
+		}
+		if s.kind == KindClient {
+			host := str(a["server.address"])
+			d.Name = host
+			d.Namespace = "remote"
+			if strings.HasSuffix(host, ".amazonaws.com") {
+				d.Namespace = "aws"
+			}
+			d.HTTP.Request["url"] = a["url.full"]
+		} else {
+			d.HTTP.Request["url"] = a["url.path"]
+			d.HTTP.Request["client_ip"] = a["client.address"]
+			d.HTTP.Request["user_agent"] = a["user_agent.original"]
+		}
+		for k, v := range d.HTTP.Request {
+			if v == nil || v == "" {
+				delete(d.HTTP.Request, k)
+			}
+		}
+	}
+
+	if len(s.annotations) > 0 {
+		d.Annotations = make(map[string]any, len(s.annotations))
+		for k, v := range s.annotations {
+			d.Annotations[xrayKey(k)] = v
+		}
+	}
+	if len(s.metadata) > 0 {
+		d.Metadata = map[string]any{"default": s.metadata}
+	}
+	return d
+}
+
+func attrMap(attrs []Attr) map[string]any {
+	m := make(map[string]any, len(attrs))
+	for _, a := range attrs {
+		m[a.Key] = a.Value
+	}
+	return m
+}
+
+func str(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// xrayKey maps an annotation key onto the characters X-Ray indexes:
+// letters, digits and underscores.
+func xrayKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, k)
+}
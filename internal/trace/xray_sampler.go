@@ -0,0 +1,138 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// XRayRule is one rule of an X-Ray local sampling rules document. Each
+// second the first FixedTarget matching requests are sampled, and Rate of
+// the rest.
+type XRayRule struct {
+	Description string  `json:"description"`
+	Host        string  `json:"host"`
+	HTTPMethod  string  `json:"http_method"`
+	URLPath     string  `json:"url_path"`
+	FixedTarget int     `json:"fixed_target"`
+	Rate        float64 `json:"rate"`
+
+	mu     sync.Mutex
+	second int64
+	used   int
+	// ratio samples Rate of the requests past FixedTarget. It is built on
+	// first use, as rules are usually decoded from JSON.
+	ratio *RatioSampler
+}
+
+// XRayRules is the version 2 local sampling rules format used by the X-Ray
+// SDKs:
+//
+//	{"version": 2,
+//	 "rules": [{"host": "*", "http_method": "GET", "url_path": "/api/*",
+//	            "fixed_target": 1, "rate": 0.1}],
+//	 "default": {"fixed_target": 1, "rate": 0.05}}
+//
+// Patterns accept * and ?. The first matching rule applies.
+type XRayRules struct {
+	Version int         `json:"version"`
+	Rules   []*XRayRule `json:"rules"`
+	Default *XRayRule   `json:"default"`
+}
+
+// DefaultXRayRules is the X-Ray default: one request a second, then 5%.
+func DefaultXRayRules() *XRayRules {
+	return &XRayRules{Version: 2, Default: &XRayRule{FixedTarget: 1, Rate: 0.05}}
+}
+
+// LoadXRayRules reads a rules file; an empty path gives the defaults.
+func LoadXRayRules(file string) (*XRayRules, error) {
+	if file == "" {
+		return DefaultXRayRules(), nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("trace: %w", err)
+	}
+	var r XRayRules
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("trace: parsing %s: %w", file, err)
+	}
+	if r.Version != 2 {
+		return nil, fmt.Errorf("trace: %s: unsupported sampling rules version %d", file, r.Version)
+	}
+	if r.Default == nil {
+		return nil, fmt.Errorf("trace: %s: missing default rule", file)
+	}
+	return &r, nil
+}
+
+// ShouldSample implements Sampler. Spans other than server spans, which
+// carry no request to match, fall under the default rule.
+func (x *XRayRules) ShouldSample(p SamplingParams) bool {
+	rule := x.Default
+	if p.Kind == KindServer {
+		host, method, urlPath := p.Attr("server.address"), p.Attr("http.request.method"), p.Attr("url.path")
+		for _, r := range x.Rules {
+			if globMatch(r.Host, host) && globMatch(r.HTTPMethod, method) && globMatch(r.URLPath, urlPath) {
+				rule = r
+				break
+			}
+		}
+	}
+	return rule.take(p.TraceID)
+}
+
+func (r *XRayRule) take(id TraceID) bool {
+	now := time.Now().Unix()
+	r.mu.Lock()
+	if now != r.second {
+		r.second, r.used = now, 0
+	}
+	ok := r.used < r.FixedTarget
+	if ok {
+		r.used++
+	}
+	if r.ratio == nil {
+		rs := NewRatioSampler(r.Rate)
+		r.ratio = &rs
+	}
+	ratio := r.ratio
+	r.mu.Unlock()
+	return ok || ratio.ShouldSample(SamplingParams{TraceID: id})
+}
+
+// globMatch matches X-Ray rule patterns case-insensitively: * matches any
+// run of characters, slashes included, and ? any single one. An empty
+// pattern matches anything.
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	p, t := strings.ToLower(pattern), strings.ToLower(s)
+	// Backtrack to the most recent star on a mismatch.
+	star, mark := -1, 0
+	i, j := 0, 0
+	for j < len(t) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == t[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, mark = i, j
+			i++
+		case star >= 0:
+			mark++
+			i, j = star+1, mark
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
@@ -0,0 +1,123 @@
+package trace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "anything", true},
+		{"*", "", true},
+		{"/api/*", "/api/items/7", true},
+		{"/api/*", "/apix", false},
+		{"/api/?", "/api/7", true},
+		{"/api/?", "/api/77", false},
+		{"*.example.com", "WWW.Example.com", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"GET", "get", true},
+		{"GET", "POST", false},
+	} {
+		if got := globMatch(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("globMatch(%q, %q) = %v", tc.pattern, tc.s, got)
+		}
+	}
+}
+
+func serverParams(method, path string, id byte) SamplingParams {
+	var tid TraceID
+	tid[15] = id
+	return SamplingParams{TraceID: tid, Kind: KindServer, Attrs: []Attr{
+		{"server.address", "api.example.com"},
+		{"http.request.method", method},
+		{"url.path", path},
+	}}
+}
+
+func TestXRayRulesShouldSample(t *testing.T) {
+	x := &XRayRules{
+		Version: 2,
+		Rules: []*XRayRule{
+			{HTTPMethod: "GET", URLPath: "/healthz", FixedTarget: 0, Rate: 0},
+			{URLPath: "/api/*", FixedTarget: 2, Rate: 0},
+		},
+		Default: &XRayRule{FixedTarget: 0, Rate: 1},
+	}
+	if x.ShouldSample(serverParams("GET", "/healthz", 1)) {
+		t.Error("health check sampled")
+	}
+	// The reservoir takes two a second, then a rate of 0 takes none.
+	second := time.Now().Unix()
+	var n int
+	for i := range 5 {
+		if x.ShouldSample(serverParams("POST", "/api/items", byte(i))) {
+			n++
+		}
+	}
+	if time.Now().Unix() != second {
+		t.Skip("the reservoir refilled mid-test")
+	}
+	if n != 2 {
+		t.Errorf("sampled %d of 5 API requests, want 2", n)
+	}
+	// Other requests, and spans that aren't server spans, get the default.
+	if !x.ShouldSample(serverParams("GET", "/", 1)) {
+		t.Error("default rule not applied")
+	}
+	if !x.ShouldSample(SamplingParams{Kind: KindClient, Attrs: serverParams("GET", "/healthz", 1).Attrs}) {
+		t.Error("client span matched a request rule")
+	}
+}
+
+func TestXRayRuleRate(t *testing.T) {
+	r := &XRayRule{Rate: 0.5}
+	var low, high TraceID
+	high[8] = 0xff
+	if !r.take(low) || r.take(high) {
+		t.Error("rate does not follow the trace ID")
+	}
+	// The sampler is built once and reused.
+	first := r.ratio
+	r.take(low)
+	if r.ratio != first {
+		t.Error("ratio sampler rebuilt")
+	}
+}
+
+func TestLoadXRayRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	r, err := LoadXRayRules(write("ok.json", `{"version": 2,
+		"rules": [{"http_method": "GET", "url_path": "/api/*", "fixed_target": 1, "rate": 0.1}],
+		"default": {"fixed_target": 1, "rate": 0.05}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Rules) != 1 || r.Rules[0].URLPath != "/api/*" || r.Default.Rate != 0.05 {
+		t.Errorf("loaded %+v", r)
+	}
+	if r, err := LoadXRayRules(""); err != nil || r.Default.FixedTarget != 1 {
+		t.Errorf("defaults = %+v, %v", r, err)
+	}
+	for name, body := range map[string]string{
+		"v1.json":      `{"version": 1, "default": {"rate": 0.1}}`,
+		"nodef.json":   `{"version": 2, "rules": []}`,
+		"invalid.json": `{`,
+	} {
+		if _, err := LoadXRayRules(write(name, body)); err == nil {
+			t.Errorf("%s loaded", name)
+		}
+	}
+}
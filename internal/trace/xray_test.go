@@ -0,0 +1,177 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportXRay runs spans through an XRayExporter to a local UDP socket and
+// returns the documents it sent, header checked and stripped.
+func exportXRay(t *testing.T, build func(*Tracer)) []map[string]any {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	exp := NewXRayExporter(pc.LocalAddr().String(), "orders")
+	build(NewTracer(AlwaysOn, false, exp))
+
+	// With ctx already done, Run sends everything queued and returns.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exp.Run(ctx)
+
+	var docs []map[string]any
+	buf := make([]byte, maxXRayPacket)
+	for {
+		pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		header, body, ok := strings.Cut(string(buf[:n]), "\n")
+		if !ok || header+"\n" != xrayHeader {
+			t.Fatalf("datagram %q lacks the daemon header", buf[:n])
+		}
+		var doc map[string]any
+		if err := json.Unmarshal([]byte(body), &doc); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func TestXRaySegment(t *testing.T) {
+	var traceID, rootID, dbID string
+	docs := exportXRay(t, func(tr *Tracer) {
+		ctx, root := tr.Start(context.Background(), "GET /orders/{id}", KindServer,
+			Attr{"http.request.method", "GET"},
+			Attr{"url.path", "/orders/7"},
+			Attr{"client.address", "203.0.113.9"},
+		)
+		root.SetAnnotation("order.id", 7)
+		root.SetMetadata("cart", map[string]int{"items": 2})
+		_, db := tr.Start(ctx, "SELECT", KindClient,
+			Attr{"db.system", "pgx"},
+			Attr{"db.statement", "SELECT * FROM orders WHERE id = $1"},
+		)
+		db.SetError(errors.New("connection reset"))
+		db.End()
+		root.SetAttr("http.response.status_code", 503)
+		root.End()
+		traceID, rootID, dbID = root.sc.TraceID.XRay(), root.sc.SpanID.String(), db.sc.SpanID.String()
+	})
+	if len(docs) != 1 {
+		t.Fatalf("sent %d documents, want 1", len(docs))
+	}
+	seg := docs[0]
+	for key, want := range map[string]any{
+		"name":     "orders",
+		"id":       rootID,
+		"trace_id": traceID,
+		"origin":   "AWS::EC2::Instance",
+		"fault":    true,
+	} {
+		if seg[key] != want {
+			t.Errorf("segment %s = %v, want %v", key, seg[key], want)
+		}
+	}
+	if seg["parent_id"] != nil || seg["type"] != nil {
+		t.Errorf("segment has parent_id %v, type %v", seg["parent_id"], seg["type"])
+	}
+	if seg["end_time"].(float64) < seg["start_time"].(float64) {
+		t.Error("segment ends before it starts")
+	}
+	req := seg["http"].(map[string]any)["request"].(map[string]any)
+	if req["method"] != "GET" || req["url"] != "/orders/7" || req["client_ip"] != "203.0.113.9" {
+		t.Errorf("http.request = %v", req)
+	}
+	if got := seg["http"].(map[string]any)["response"].(map[string]any)["status"]; got != float64(503) {
+		t.Errorf("http.response.status = %v", got)
+	}
+	if got := seg["annotations"].(map[string]any)["order_id"]; got != float64(7) {
+		t.Errorf("annotations = %v", seg["annotations"])
+	}
+	if got := seg["metadata"].(map[string]any)["default"].(map[string]any)["cart"]; got == nil {
+		t.Errorf("metadata = %v", seg["metadata"])
+	}
+
+	subs := seg["subsegments"].([]any)
+	if len(subs) != 1 {
+		t.Fatalf("%d subsegments", len(subs))
+	}
+	sub := subs[0].(map[string]any)
+	if sub["id"] != dbID || sub["name"] != "pgx" || sub["namespace"] != "remote" || sub["fault"] != true {
+		t.Errorf("subsegment = %v", sub)
+	}
+	if got := sub["sql"].(map[string]any)["sanitized_query"]; got != "SELECT * FROM orders WHERE id = $1" {
+		t.Errorf("sql = %v", sub["sql"])
+	}
+	cause := sub["cause"].(map[string]any)["exceptions"].([]any)[0].(map[string]any)
+	if cause["message"] != "connection reset" {
+		t.Errorf("cause = %v", cause)
+	}
+}
+
+func TestXRayOrphanedSubsegments(t *testing.T) {
+	var traceID, rootID string
+	docs := exportXRay(t, func(tr *Tracer) {
+		ctx, root := tr.Start(context.Background(), "job", KindInternal)
+		ctx, call := tr.Start(ctx, "POST", KindClient,
+			Attr{"http.request.method", "POST"},
+			Attr{"server.address", "sqs.us-east-1.amazonaws.com"},
+			Attr{"url.full", "https://sqs.us-east-1.amazonaws.com/"},
+		)
+		_, inner := tr.Start(ctx, "retry", KindInternal)
+		inner.End()
+		call.SetAttr("http.response.status_code", 429)
+		call.End()
+		// The segment never ends before shutdown.
+		traceID, rootID = root.sc.TraceID.XRay(), root.sc.SpanID.String()
+	})
+	if len(docs) != 1 {
+		t.Fatalf("sent %d documents, want 1", len(docs))
+	}
+	d := docs[0]
+	if d["type"] != "subsegment" || d["trace_id"] != traceID || d["parent_id"] != rootID {
+		t.Errorf("orphan = %v", d)
+	}
+	if d["name"] != "sqs.us-east-1.amazonaws.com" || d["namespace"] != "aws" || d["throttle"] != true || d["error"] != true {
+		t.Errorf("orphan = %v", d)
+	}
+	if subs, _ := d["subsegments"].([]any); len(subs) != 1 || subs[0].(map[string]any)["name"] != "retry" {
+		t.Errorf("orphan's subsegments = %v", d["subsegments"])
+	}
+}
+
+func TestXRayOversizedSegmentIsSplit(t *testing.T) {
+	docs := exportXRay(t, func(tr *Tracer) {
+		ctx, root := tr.Start(context.Background(), "batch", KindInternal)
+		big := strings.Repeat("x", maxXRayPacket/3)
+		for range 4 {
+			_, s := tr.Start(ctx, "step", KindInternal)
+			s.SetMetadata("blob", big)
+			s.End()
+		}
+		root.End()
+	})
+	// The bare segment, then each child on its own.
+	if len(docs) != 5 {
+		t.Fatalf("sent %d documents, want 5", len(docs))
+	}
+	if docs[0]["subsegments"] != nil || docs[0]["annotations"].(map[string]any)["operation"] != "batch" {
+		t.Errorf("segment = %v", docs[0])
+	}
+	for _, d := range docs[1:] {
+		if d["type"] != "subsegment" || d["parent_id"] != docs[0]["id"] {
+			t.Errorf("child = %v", d["id"])
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"goaws/internal/httpx"
+)
+
+// Handler serves the management API:
+//
+//	GET    /webhooks/subscriptions
+//	POST   /webhooks/subscriptions
+//	GET    /webhooks/subscriptions/{id}
+//	PATCH  /webhooks/subscriptions/{id}
+//	DELETE /webhooks/subscriptions/{id}
+//	POST   /webhooks/subscriptions/{id}/ping
+//	GET    /webhooks/subscriptions/{id}/deliveries
+//	GET    /webhooks/deliveries
+//	GET    /webhooks/deliveries/{id}
+//	POST   /webhooks/deliveries/{id}/redeliver
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /webhooks/subscriptions", s.listSubscriptions)
+	mux.HandleFunc("POST /webhooks/subscriptions", s.createSubscription)
+	mux.HandleFunc("GET /webhooks/subscriptions/{id}", s.getSubscription)
+	mux.HandleFunc("PATCH /webhooks/subscriptions/{id}", s.patchSubscription)
+	mux.HandleFunc("DELETE /webhooks/subscriptions/{id}", s.deleteSubscription)
+	mux.HandleFunc("POST /webhooks/subscriptions/{id}/ping", s.ping)
+	mux.HandleFunc("GET /webhooks/subscriptions/{id}/deliveries", s.listDeliveries)
+	mux.HandleFunc("GET /webhooks/deliveries", s.listDeliveries)
+	mux.HandleFunc("GET /webhooks/deliveries/{id}", s.getDelivery)
+	mux.HandleFunc("POST /webhooks/deliveries/{id}/redeliver", s.redeliver)
+	return mux
+}
+
+type subscriptionRequest struct {
+	URL         *string  `json:"url"`
+	Secret      *string  `json:"secret"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description"`
+	Active      *bool    `json:"active"`
+}
+
+func (s *Service) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs := s.store.subscriptions()
+	for i := range subs {
+		subs[i] = subs[i].Redacted()
+	}
+	httpx.WriteJSON(w, http.StatusOK, subs)
+}
+
+func (s *Service) createSubscription(w http.ResponseWriter, r *http.Request) {
+	var req subscriptionRequest
+	if !httpx.ReadJSON(w, r, &req) {
+		return
+	}
+	if req.URL == nil || !validURL(*req.URL) {
+		httpx.WriteError(w, http.StatusBadRequest, "url must be an absolute http(s) URL")
+		return
+	}
+	if len(req.Events) == 0 {
+		httpx.WriteError(w, http.StatusBadRequest, "events must not be empty")
+		return
+	}
+	now := time.Now().UTC()
+	sub := Subscription{
+		ID:        newID("sub_"),
+		URL:       *req.URL,
+		Secret:    newID("whsec_"),
+		Events:    req.Events,
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if req.Secret != nil && *req.Secret != "" {
+		sub.Secret = *req.Secret
+	}
+	if req.Description != nil {
+		sub.Description = *req.Description
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+	if err := s.store.putSubscription(sub); err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The secret is only ever shown in the creation response.
+	httpx.WriteJSON(w, http.StatusCreated, sub)
+}
+
+func (s *Service) getSubscription(w http.ResponseWriter, r *http.Request) {
+	sub, err := s.store.subscription(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, sub.Redacted())
+}
+
+func (s *Service) patchSubscription(w http.ResponseWriter, r *http.Request) {
+	var req subscriptionRequest
+	if !httpx.ReadJSON(w, r, &req) {
+		return
+	}
+	if req.URL != nil && !validURL(*req.URL) {
+		httpx.WriteError(w, http.StatusBadRequest, "url must be an absolute http(s) URL")
+		return
+	}
+	id := r.PathValue("id")
+	sub, err := s.store.updateSubscription(id, func(sub *Subscription) {
+		if req.URL != nil {
+			sub.URL = *req.URL
+		}
+		if req.Secret != nil && *req.Secret != "" {
+			sub.Secret = *req.Secret
+		}
+		if req.Events != nil {
+			sub.Events = req.Events
+		}
+		if req.Description != nil {
+			sub.Description = *req.Description
+		}
+		if req.Active != nil {
+			sub.Active = *req.Active
+			if sub.Active {
+				sub.DisabledReason = ""
+				sub.DisabledAt = nil
+				sub.ConsecutiveFailures = 0
+				sub.FailingSince = nil
+			}
+		}
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if req.Active != nil && *req.Active {
+		s.breaker.reset(id)
+	}
+	httpx.WriteJSON(w, http.StatusOK, sub.Redacted())
+}
+
+func (s *Service) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.deleteSubscription(r.PathValue("id")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) ping(w http.ResponseWriter, r *http.Request) {
+	d, err := s.Ping(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, d)
+}
+
+func (s *Service) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	httpx.WriteJSON(w, http.StatusOK, s.store.deliveriesFor(r.PathValue("id"), limit))
+}
+
+func (s *Service) getDelivery(w http.ResponseWriter, r *http.Request) {
+	d, err := s.store.delivery(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, d)
+}
+
+func (s *Service) redeliver(w http.ResponseWriter, r *http.Request) {
+	d, err := s.Redeliver(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusAccepted, d)
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		httpx.WriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+	httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+}
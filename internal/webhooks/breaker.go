@@ -0,0 +1,84 @@
+package webhooks
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a per-subscription circuit breaker. After threshold consecutive
+// failures it opens for cooldown, then lets a single probe through; the
+// probe's outcome closes it again or restarts the cooldown.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	state map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, state: map[string]*circuit{}}
+}
+
+// allow reports whether a request to id may go ahead, and if not how long
+// until it is worth asking again. probe is true when the request is the
+// half-open circuit's one probe, which the caller must settle.
+func (b *breaker) allow(id string, now time.Time) (ok, probe bool, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.state[id]
+	if c == nil || c.failures < b.threshold {
+		return true, false, 0
+	}
+	if now.Before(c.openUntil) {
+		return false, false, c.openUntil.Sub(now)
+	}
+	if c.probing {
+		return false, false, b.cooldown / 4
+	}
+	c.probing = true
+	return true, true, 0
+}
+
+func (b *breaker) success(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.state, id)
+}
+
+func (b *breaker) failure(id string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.state[id]
+	if c == nil {
+		c = &circuit{}
+		b.state[id] = c
+	}
+	c.failures++
+	c.probing = false
+	if c.failures >= b.threshold {
+		c.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// settle ends a probe that neither succeeded nor failed, e.g. because the
+// service is shutting down, so that the next delivery can probe again. It
+// is a no-op when success or failure already settled it. Only the caller
+// that allow told it was probing may call it.
+func (b *breaker) settle(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.state[id]; c != nil {
+		c.probing = false
+	}
+}
+
+func (b *breaker) reset(id string) {
+	b.success(id)
+}
@@ -0,0 +1,35 @@
+package webhooks
+
+import (
+	"context"
+
+	"goaws/internal/events"
+)
+
+// The Service is an events.Sink, so that everything the service publishes,
+// including what the outbox relays, reaches subscribers too. Each event is
+// delivered under its CloudEvents type and keeps its ID, so subscribers can
+// drop the duplicates a retried batch may bring.
+
+func (s *Service) Name() string  { return "webhooks" }
+func (s *Service) MaxBatch() int { return 100 }
+
+// Send queues every event of batch for its subscribers. Events that could
+// not be queued are reported in an events.PartialError, so only they are
+// retried.
+func (s *Service) Send(ctx context.Context, batch []events.Event) error {
+	var failed map[string]string
+	for _, e := range batch {
+		err := s.publish(Envelope{ID: e.ID, Event: e.Type, CreatedAt: e.Time.UTC(), Data: e.Data})
+		if err != nil {
+			if failed == nil {
+				failed = map[string]string{}
+			}
+			failed[e.ID] = err.Error()
+		}
+	}
+	if failed != nil {
+		return &events.PartialError{Failed: failed, Retryable: true}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("webhooks: not found")
+
+// Subscription is an endpoint another team registered to be notified of
+// events.
+type Subscription struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	FailingSince        *time.Time `json:"failing_since,omitempty"`
+}
+
+// Wants reports whether s is subscribed to event. "*" matches everything.
+func (s *Subscription) Wants(event string) bool {
+	for _, e := range s.Events {
+		if e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy safe to show after creation.
+func (s Subscription) Redacted() Subscription {
+	s.Secret = ""
+	return s
+}
+
+// Delivery states.
+const (
+	StatePending   = "pending"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// Attempt is one HTTP request made for a delivery.
+type Attempt struct {
+	At         time.Time `json:"at"`
+	DurationMS int64     `json:"duration_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Response   string    `json:"response,omitempty"`
+}
+
+// Delivery is one event sent to one subscription, with its attempt history.
+type Delivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	State          string          `json:"state"`
+	Attempts       []Attempt       `json:"attempts"`
+	RedeliveryOf   string          `json:"redelivery_of,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// store keeps subscriptions and the most recent deliveries in memory and
+// mirrors them to JSON files in dir after every change.
+type store struct {
+	dir        string
+	maxHistory int
+
+	mu         sync.Mutex
+	subs       map[string]*Subscription
+	deliveries map[string]*Delivery
+}
+
+func openStore(dir string, maxHistory int) (*store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	s := &store{dir: dir, maxHistory: maxHistory, subs: map[string]*Subscription{}, deliveries: map[string]*Delivery{}}
+	if err := loadFile(filepath.Join(dir, "subscriptions.json"), &s.subs); err != nil {
+		return nil, err
+	}
+	if err := loadFile(filepath.Join(dir, "deliveries.json"), &s.deliveries); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *store) subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		out = append(out, *sub)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (s *store) subscription(id string) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	return *sub, nil
+}
+
+// updateSubscription applies f to the stored subscription and persists it.
+func (s *store) updateSubscription(id string, f func(*Subscription)) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	f(sub)
+	sub.UpdatedAt = time.Now().UTC()
+	return *sub, s.saveSubs()
+}
+
+func (s *store) putSubscription(sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[sub.ID] = &sub
+	return s.saveSubs()
+}
+
+func (s *store) deleteSubscription(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.subs, id)
+	return s.saveSubs()
+}
+
+func (s *store) delivery(id string) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return Delivery{}, ErrNotFound
+	}
+	return *d, nil
+}
+
+// deliveriesFor returns the logged deliveries of a subscription, newest
+// first. An empty subID returns all of them.
+func (s *store) deliveriesFor(subID string, limit int) []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Delivery
+	for _, d := range s.deliveries {
+		if subID == "" || d.SubscriptionID == subID {
+			out = append(out, *d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (s *store) putDelivery(d Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[d.ID] = &d
+	s.prune()
+	return s.saveDeliveries()
+}
+
+func (s *store) updateDelivery(id string, f func(*Delivery)) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return Delivery{}, ErrNotFound
+	}
+	f(d)
+	d.UpdatedAt = time.Now().UTC()
+	return *d, s.saveDeliveries()
+}
+
+// prune drops the oldest finished deliveries beyond maxHistory. Pending ones
+// are kept because the queue still refers to them.
+func (s *store) prune() {
+	if len(s.deliveries) <= s.maxHistory {
+		return
+	}
+	var done []*Delivery
+	for _, d := range s.deliveries {
+		if d.State != StatePending {
+			done = append(done, d)
+		}
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].CreatedAt.Before(done[j].CreatedAt) })
+	for _, d := range done {
+		if len(s.deliveries) <= s.maxHistory {
+			break
+		}
+		delete(s.deliveries, d.ID)
+	}
+}
+
+func (s *store) saveSubs() error {
+	return saveFile(filepath.Join(s.dir, "subscriptions.json"), s.subs)
+}
+
+func (s *store) saveDeliveries() error {
+	return saveFile(filepath.Join(s.dir, "deliveries.json"), s.deliveries)
+}
+
+func loadFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func saveFile(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newID(prefix string) string {
+	var b [12]byte
+	rand.Read(b[:])
+	return prefix + hex.EncodeToString(b[:])
+}
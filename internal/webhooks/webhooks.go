@@ -0,0 +1,336 @@
+// Package webhooks delivers events from this service to endpoints other
+// teams subscribe. Payloads are signed with a per-subscription secret and
+// delivered through a durable queue with exponential backoff; endpoints that
+// keep failing are circuit broken and eventually disabled.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"goaws/internal/env"
+	"goaws/internal/queue"
+)
+
+// Config configures the delivery subsystem. It is normally built by
+// ConfigFromEnv.
+type Config struct {
+	// Dir holds subscriptions, the delivery log and the delivery queue.
+	Dir         string
+	Workers     int
+	MaxAttempts int
+	Timeout     time.Duration
+	MaxHistory  int
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// DisableAfter disables a subscription whose deliveries have failed
+	// without a single success for this long.
+	DisableAfter time.Duration
+}
+
+// ConfigFromEnv reads the WEBHOOKS_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Dir:              env.String("WEBHOOKS_DIR", ""),
+		Workers:          env.Int("WEBHOOKS_WORKERS", 4),
+		MaxAttempts:      env.Int("WEBHOOKS_MAX_ATTEMPTS", 12),
+		Timeout:          env.Duration("WEBHOOKS_TIMEOUT", 10*time.Second),
+		MaxHistory:       env.Int("WEBHOOKS_HISTORY", 1000),
+		BreakerThreshold: env.Int("WEBHOOKS_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:  env.Duration("WEBHOOKS_BREAKER_COOLDOWN", time.Minute),
+		DisableAfter:     env.Duration("WEBHOOKS_DISABLE_AFTER", 24*time.Hour),
+	}
+}
+
+// Envelope is the JSON body every subscriber receives.
+type Envelope struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Service owns subscriptions and delivers events to them.
+type Service struct {
+	cfg     Config
+	store   *store
+	queue   *queue.Queue
+	breaker *breaker
+	client  *http.Client
+	log     *slog.Logger
+}
+
+type deliveryJob struct {
+	DeliveryID string `json:"delivery_id"`
+}
+
+// New opens the store and queue under cfg.Dir.
+func New(cfg Config) (*Service, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("webhooks: a state directory is required")
+	}
+	st, err := openStore(cfg.Dir, cfg.MaxHistory)
+	if err != nil {
+		return nil, fmt.Errorf("webhooks: opening store: %w", err)
+	}
+	q, err := queue.Open(filepath.Join(cfg.Dir, "queue"), queue.Options{
+		Workers:     cfg.Workers,
+		MaxAttempts: cfg.MaxAttempts,
+		Backoff:     jittered(queue.ExponentialBackoff(5*time.Second, time.Hour)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("webhooks: opening queue: %w", err)
+	}
+	return &Service{
+		cfg:     cfg,
+		store:   st,
+		queue:   q,
+		breaker: newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		client:  &http.Client{Timeout: cfg.Timeout},
+		log:     slog.Default().With("component", "webhooks"),
+	}, nil
+}
+
+// jittered spreads retries over [d/2, d) so endpoints coming back up are not
+// hit by every queued delivery at once.
+func jittered(backoff func(int) time.Duration) func(int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := backoff(attempt)
+		return d/2 + rand.N(d/2+1)
+	}
+}
+
+// Publish queues event for every active subscription that wants it.
+func (s *Service) Publish(ctx context.Context, event string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.publish(Envelope{ID: newID("evt_"), Event: event, CreatedAt: time.Now().UTC(), Data: raw})
+}
+
+func (s *Service) publish(e Envelope) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, sub := range s.store.subscriptions() {
+		if !sub.Active || !sub.Wants(e.Event) {
+			continue
+		}
+		if _, err := s.enqueue(sub.ID, e.Event, payload, ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) enqueue(subID, event string, payload []byte, redeliveryOf string) (Delivery, error) {
+	now := time.Now().UTC()
+	d := Delivery{
+		ID:             newID("dlv_"),
+		SubscriptionID: subID,
+		Event:          event,
+		Payload:        payload,
+		State:          StatePending,
+		RedeliveryOf:   redeliveryOf,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := s.store.putDelivery(d); err != nil {
+		return d, err
+	}
+	if _, err := s.queue.Enqueue(event, deliveryJob{DeliveryID: d.ID}); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// Run delivers queued events until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	s.queue.Run(ctx, s.process)
+}
+
+func (s *Service) process(ctx context.Context, j *queue.Job) error {
+	var job deliveryJob
+	if err := json.Unmarshal(j.Payload, &job); err != nil {
+		return fmt.Errorf("%w: %v", queue.Permanent, err)
+	}
+	d, err := s.store.delivery(job.DeliveryID)
+	if err != nil {
+		return fmt.Errorf("%w: delivery %s: %v", queue.Permanent, job.DeliveryID, err)
+	}
+	sub, err := s.store.subscription(d.SubscriptionID)
+	if err != nil || !sub.Active {
+		s.finish(d.ID, StateFailed)
+		return fmt.Errorf("%w: subscription %s is gone or disabled", queue.Permanent, d.SubscriptionID)
+	}
+
+	now := time.Now()
+	ok, probe, wait := s.breaker.allow(sub.ID, now)
+	if !ok {
+		return queue.Defer(fmt.Errorf("circuit open for %s", sub.ID), wait)
+	}
+	if probe {
+		// Whatever happens to this delivery, the half-open circuit's probe
+		// must not stay claimed. Deliveries let through while it was
+		// closed leave the probe to whoever holds it.
+		defer s.breaker.settle(sub.ID)
+	}
+
+	a := s.send(ctx, sub, d)
+	s.store.updateDelivery(d.ID, func(d *Delivery) { d.Attempts = append(d.Attempts, a) })
+	if a.Error == "" {
+		s.recordSuccess(sub.ID)
+		s.finish(d.ID, StateSucceeded)
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.recordFailure(sub.ID, now)
+	if a.StatusCode == http.StatusGone {
+		s.disable(sub.ID, "endpoint returned 410 Gone")
+		s.finish(d.ID, StateFailed)
+		return fmt.Errorf("%w: %s", queue.Permanent, a.Error)
+	}
+	if j.Attempts+1 >= s.cfg.MaxAttempts {
+		s.finish(d.ID, StateFailed)
+	}
+	return errors.New(a.Error)
+}
+
+// send makes one signed POST and describes its outcome.
+func (s *Service) send(ctx context.Context, sub Subscription, d Delivery) (a Attempt) {
+	start := time.Now()
+	a.At = start.UTC()
+	defer func() { a.DurationMS = time.Since(start).Milliseconds() }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	ts := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "goaws-webhooks/1")
+	req.Header.Set("X-Webhook-Id", d.ID)
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature-256", Sign([]byte(sub.Secret), ts, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	a.StatusCode = resp.StatusCode
+	a.Response = string(body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.Error = "endpoint returned " + resp.Status
+	}
+	return a
+}
+
+// Sign returns the X-Webhook-Signature-256 value for body sent at ts.
+// Including the timestamp lets receivers reject replays.
+func Sign(secret []byte, ts string, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(ts))
+	m.Write([]byte("."))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+func (s *Service) finish(id, state string) {
+	if _, err := s.store.updateDelivery(id, func(d *Delivery) { d.State = state }); err != nil {
+		s.log.Error("updating delivery", "delivery", id, "err", err)
+	}
+}
+
+func (s *Service) recordSuccess(subID string) {
+	s.breaker.success(subID)
+	s.store.updateSubscription(subID, func(sub *Subscription) {
+		sub.ConsecutiveFailures = 0
+		sub.FailingSince = nil
+	})
+}
+
+func (s *Service) recordFailure(subID string, now time.Time) {
+	s.breaker.failure(subID, now)
+	sub, err := s.store.updateSubscription(subID, func(sub *Subscription) {
+		sub.ConsecutiveFailures++
+		if sub.FailingSince == nil {
+			t := now.UTC()
+			sub.FailingSince = &t
+		}
+	})
+	if err != nil {
+		return
+	}
+	if sub.ConsecutiveFailures >= s.cfg.BreakerThreshold && now.Sub(*sub.FailingSince) >= s.cfg.DisableAfter {
+		s.disable(subID, fmt.Sprintf("failing continuously since %s", sub.FailingSince.Format(time.RFC3339)))
+	}
+}
+
+func (s *Service) disable(subID, reason string) {
+	s.store.updateSubscription(subID, func(sub *Subscription) {
+		now := time.Now().UTC()
+		sub.Active = false
+		sub.DisabledReason = reason
+		sub.DisabledAt = &now
+	})
+	s.log.Warn("subscription disabled", "subscription", subID, "reason", reason)
+}
+
+// Ping sends a ping event to a subscription right away, bypassing the queue
+// and the circuit breaker, and returns the logged delivery.
+func (s *Service) Ping(ctx context.Context, subID string) (Delivery, error) {
+	sub, err := s.store.subscription(subID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	data, _ := json.Marshal(map[string]string{"subscription_id": sub.ID})
+	payload, _ := json.Marshal(Envelope{ID: newID("evt_"), Event: "ping", CreatedAt: time.Now().UTC(), Data: data})
+	now := time.Now().UTC()
+	d := Delivery{ID: newID("dlv_"), SubscriptionID: sub.ID, Event: "ping", Payload: payload, CreatedAt: now, UpdatedAt: now}
+	a := s.send(ctx, sub, d)
+	d.Attempts = []Attempt{a}
+	d.State = StateSucceeded
+	if a.Error != "" {
+		d.State = StateFailed
+	}
+	return d, s.store.putDelivery(d)
+}
+
+// Redeliver queues a fresh copy of a logged delivery.
+func (s *Service) Redeliver(id string) (Delivery, error) {
+	d, err := s.store.delivery(id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return s.enqueue(d.SubscriptionID, d.Event, d.Payload, d.ID)
+}
+
+// Pending returns the number of deliveries waiting in the queue.
+func (s *Service) Pending() int {
+	return s.queue.Len()
+}
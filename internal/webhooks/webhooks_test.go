@@ -0,0 +1,234 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goaws/internal/events"
+	"goaws/internal/queue"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBreaker(2, time.Minute)
+	b.failure("s", now)
+	if ok, probe, _ := b.allow("s", now); !ok || probe {
+		t.Fatalf("allow below the threshold = %t, probe %t", ok, probe)
+	}
+	b.failure("s", now)
+	if ok, _, wait := b.allow("s", now.Add(time.Second)); ok || wait != 59*time.Second {
+		t.Fatalf("allow while open = %t, %v", ok, wait)
+	}
+
+	// Half-open: one probe at a time.
+	later := now.Add(time.Minute)
+	if ok, probe, _ := b.allow("s", later); !ok || !probe {
+		t.Fatalf("allow after the cooldown = %t, probe %t", ok, probe)
+	}
+	if ok, _, _ := b.allow("s", later); ok {
+		t.Fatal("second concurrent probe")
+	}
+	// A probe that ends without an outcome frees the slot.
+	b.settle("s")
+	if ok, _, _ := b.allow("s", later); !ok {
+		t.Fatal("probe not released by settle")
+	}
+	// A failed probe restarts the cooldown.
+	b.failure("s", later)
+	b.settle("s")
+	if ok, _, _ := b.allow("s", later.Add(time.Second)); ok {
+		t.Fatal("allowed right after a failed probe")
+	}
+	// A successful one closes the circuit.
+	if ok, _, _ := b.allow("s", later.Add(time.Minute)); !ok {
+		t.Fatal("no second probe")
+	}
+	b.success("s")
+	b.settle("s")
+	if ok, _, _ := b.allow("s", later.Add(time.Minute)); !ok {
+		t.Fatal("closed circuit refused")
+	}
+}
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 of "1700000000.{}" keyed with "secret".
+	const want = "sha256=b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163"
+	if got := Sign([]byte("secret"), "1700000000", []byte("{}")); got != want {
+		t.Errorf("Sign = %s", got)
+	}
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// endpoint records the deliveries it receives and answers with status.
+func endpoint(t *testing.T, status int) (*httptest.Server, func() []received) {
+	var mu sync.Mutex
+	var got []received
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, received{r.Header.Clone(), b})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), got...)
+	}
+}
+
+func newService(t *testing.T) *Service {
+	t.Helper()
+	s, err := New(Config{Dir: t.TempDir(), Workers: 1, MaxAttempts: 3, Timeout: time.Second,
+		MaxHistory: 100, BreakerThreshold: 5, BreakerCooldown: time.Minute, DisableAfter: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func subscribe(t *testing.T, s *Service, url string, evts ...string) Subscription {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"url": url, "events": evts, "secret": "whsec"})
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/subscriptions", strings.NewReader(string(body))))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("subscribe: %d %s", w.Code, w.Body)
+	}
+	var sub Subscription
+	json.Unmarshal(w.Body.Bytes(), &sub)
+	return sub
+}
+
+func TestPublishedEventsReachSubscribers(t *testing.T) {
+	s := newService(t)
+	srv, got := endpoint(t, http.StatusNoContent)
+	subscribe(t, s, srv.URL, "order.created")
+	subscribe(t, s, srv.URL+"/all", "*")
+
+	// The service is a sink of the events publisher.
+	var sink events.Sink = s
+	created, _ := events.New("order.created", "order/1", map[string]int{"total": 3})
+	shipped, _ := events.New("order.shipped", "order/1", nil)
+	if err := sink.Send(context.Background(), []events.Event{created, shipped}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(got()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reqs := got()
+	if len(reqs) != 3 {
+		t.Fatalf("received %d deliveries, want 3", len(reqs))
+	}
+	ids := map[string]int{}
+	for _, r := range reqs {
+		var env Envelope
+		if err := json.Unmarshal(r.body, &env); err != nil {
+			t.Fatal(err)
+		}
+		ids[env.ID]++
+		if env.ID != created.ID && env.ID != shipped.ID {
+			t.Errorf("envelope id %s is not the event's", env.ID)
+		}
+		if r.header.Get("X-Webhook-Event") != env.Event {
+			t.Errorf("X-Webhook-Event = %s for %s", r.header.Get("X-Webhook-Event"), env.Event)
+		}
+		ts := r.header.Get("X-Webhook-Timestamp")
+		if sig := r.header.Get("X-Webhook-Signature-256"); sig != Sign([]byte("whsec"), ts, r.body) {
+			t.Errorf("bad signature %s", sig)
+		}
+	}
+	if ids[created.ID] != 2 || ids[shipped.ID] != 1 {
+		t.Errorf("deliveries per event = %v", ids)
+	}
+}
+
+// A probe interrupted by shutdown must not leave the circuit half-open with
+// its probe slot taken.
+func TestInterruptedProbeReleasesCircuit(t *testing.T) {
+	s := newService(t)
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+	sub := subscribe(t, s, srv.URL, "*")
+
+	past := time.Now().Add(-time.Hour)
+	for range s.cfg.BreakerThreshold {
+		s.breaker.failure(sub.ID, past)
+	}
+	d, err := s.enqueue(sub.ID, "e", []byte(`{}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(deliveryJob{DeliveryID: d.ID})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.process(ctx, &queue.Job{Payload: payload})
+	if ok, _, _ := s.breaker.allow(sub.ID, time.Now()); !ok {
+		t.Fatal("circuit left half-open with its probe claimed")
+	}
+}
+
+// A delivery let through while the circuit was closed, and interrupted after
+// it went half-open, must not release the probe another delivery holds.
+func TestInterruptedDeliveryKeepsOthersProbe(t *testing.T) {
+	s := newService(t)
+	arrived := make(chan struct{}, 1)
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+	sub := subscribe(t, s, srv.URL, "*")
+
+	d, err := s.enqueue(sub.ID, "e", []byte(`{}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(deliveryJob{DeliveryID: d.ID})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.process(ctx, &queue.Job{Payload: payload})
+	}()
+	<-arrived
+
+	past := time.Now().Add(-time.Hour)
+	for range s.cfg.BreakerThreshold {
+		s.breaker.failure(sub.ID, past)
+	}
+	if ok, probe, _ := s.breaker.allow(sub.ID, time.Now()); !ok || !probe {
+		t.Fatalf("allow after the cooldown = %t, probe %t", ok, probe)
+	}
+	cancel()
+	<-done
+	if ok, _, _ := s.breaker.allow(sub.ID, time.Now()); ok {
+		t.Fatal("second probe let through after an unrelated delivery ended")
+	}
+}
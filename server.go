@@ -1,17 +1,444 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"goaws/internal/accesslog"
+	"goaws/internal/alblogs"
+	"goaws/internal/auth"
+	"goaws/internal/aws"
+	"goaws/internal/bench"
+	"goaws/internal/crash"
+	"goaws/internal/cwlogs"
+	"goaws/internal/env"
+	"goaws/internal/events"
+	"goaws/internal/flags"
+	"goaws/internal/ghwebhook"
+	"goaws/internal/httpx"
+	"goaws/internal/inspect"
+	"goaws/internal/journald"
+	"goaws/internal/limits"
+	"goaws/internal/loadtest"
+	"goaws/internal/logging"
+	"goaws/internal/metrics"
+	"goaws/internal/outbox"
+	"goaws/internal/profiler"
+	"goaws/internal/redact"
+	"goaws/internal/slo"
+	"goaws/internal/statsd"
+	"goaws/internal/status"
+	"goaws/internal/synthetic"
+	"goaws/internal/trace"
+	"goaws/internal/webhooks"
 )
 
+// commands are the subcommands of the binary; without one it runs the
+// server.
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"alb-logs": alblogs.Command,
+	"bench":    bench.Command,
+	"loadtest": loadtest.Command,
+	"probe":    synthetic.Command,
+	"profiles": profiler.Command,
+}
+
 func main() {
-	fmt.Println("server up and running...")
+	// Size the runtime to the cgroup before anything allocates much; the
+	// result is logged once logging is set up.
+	runtimeLimits := limits.Apply(limits.ConfigFromEnv())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd(ctx, os.Args[2:])
+			stop()
+			switch {
+			case errors.Is(err, flag.ErrHelp):
+				os.Exit(2)
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "app %s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	// Crash reporting starts before anything else runs so that it sees
+	// every worker and log record. Without a writable CRASH_DIR the server
+	// runs without it.
+	redactor, err := redact.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	crashCfg := crash.ConfigFromEnv()
+	crashCfg.Redactor = redactor
+	if crashCfg.Bucket != "" {
+		crashCfg.Instance = instanceID(ctx)
+	}
+	reporter, err := crash.New(crashCfg, aws.NewClient())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	defer reporter.Recover()
+
+	// Background workers are started with goWork and drained before exit.
+	var workers sync.WaitGroup
+	goWork := func(f func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			defer reporter.Recover()
+			f(ctx)
+		}()
+	}
+
+	// Logging is set up before anything logs. Records go to the journal
+	// when running under systemd, else to stdout, and optionally to
+	// CloudWatch Logs. Remote sinks run outside goWork so that they are
+	// stopped last and ship what the other workers log while shutting down.
+	level := logging.LevelFromEnv()
+	var console slog.Handler
+	if jcfg := journald.ConfigFromEnv(); jcfg.Use() {
+		jcfg.Level = level
+		if h, err := journald.New(jcfg); err == nil {
+			console = h
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if console == nil {
+		console = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	}
+	logHandlers := []slog.Handler{console}
+	var logSinks sync.WaitGroup
+	logCtx, stopLogs := context.WithCancel(context.Background())
+	cwCfg := cwlogs.ConfigFromEnv()
+	if cwCfg.Group != "" {
+		if cwCfg.Stream == "" {
+			cwCfg.Stream = instanceID(ctx)
+		}
+		shipper := cwlogs.New(cwCfg, aws.NewClient())
+		logHandlers = append(logHandlers, slog.NewJSONHandler(shipper, &slog.HandlerOptions{Level: level}))
+		logSinks.Add(1)
+		go func() {
+			defer logSinks.Done()
+			defer reporter.Recover()
+			shipper.Run(logCtx)
+		}()
+	}
+	// The async pipeline sits in front of every sink so that a backed-up
+	// journal or stdout pipe never stalls a handler. It is drained after
+	// the workers stop and before the remote sinks do. Redaction runs on
+	// its worker, after sampling, so that it costs handlers nothing.
+	logHandler := redactor.Handler(logging.Tee(logHandlers...))
+	asyncDone := make(chan struct{})
+	asyncCtx, stopAsync := context.WithCancel(context.Background())
+	if acfg := logging.AsyncConfigFromEnv(); acfg.Enabled {
+		var pipeline *logging.Async
+		logHandler, pipeline = logging.NewAsync(logHandler, acfg)
+		go func() {
+			defer close(asyncDone)
+			defer reporter.Recover()
+			pipeline.Run(asyncCtx)
+		}()
+	} else {
+		close(asyncDone)
+	}
+	// The request inspector keeps the records logged while serving each
+	// request it holds.
+	var inspector *inspect.Inspector
+	if inspectCfg := inspect.ConfigFromEnv(); inspectCfg.Enabled {
+		inspectCfg.Redactor = redactor
+		inspector = inspect.New(inspectCfg)
+	}
+	slog.SetDefault(slog.New(logging.ContextHandler(reporter.Handler(inspector.Handler(logHandler)))))
+	slog.Info("runtime limits", "limits", runtimeLimits)
+
+	// Tracing comes first so that every outbound client created below goes
+	// through the traced transport.
+	var tracer *trace.Tracer
+	if traceCfg := trace.ConfigFromEnv(); traceCfg.Enabled() {
+		traceCfg.Redactor = redactor
+		t, export, err := trace.New(traceCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tracer = t
+		http.DefaultTransport = &trace.Transport{Tracer: tracer, Base: http.DefaultTransport}
+		goWork(export)
+	}
+
 	http.HandleFunc("/", HelloServer)
-	http.ListenAndServe(":8080", nil)
+	http.Handle("GET /readyz", reporter.ReadyHandler())
+
+	// The admin mux is served on a separate, loopback-only listener by
+	// default; it is never routed through the ALB.
+	admin := http.NewServeMux()
+	metricsCfg := metrics.ConfigFromEnv()
+	if metricsCfg.Enabled("prometheus") {
+		admin.Handle("GET /metrics", metrics.Handler(metrics.Default))
+	}
+	if metricsCfg.Enabled("emf") {
+		emfCfg := metricsCfg.EMF
+		if _, ok := emfCfg.Dimensions["Environment"]; !ok {
+			emfCfg.Dimensions["Environment"] = env.String("ENV", "development")
+		}
+		if _, ok := emfCfg.Dimensions["InstanceId"]; !ok {
+			emfCfg.Dimensions["InstanceId"] = instanceID(ctx)
+		}
+		// Lines on stdout only become metrics if the CloudWatch agent
+		// forwards them. With CloudWatch Logs configured they are shipped
+		// directly instead, to a stream of their own so that they stay out
+		// of the application logs and the journal.
+		var emfOut io.Writer = os.Stdout
+		if cwCfg.Group != "" {
+			emfLogs := cwCfg
+			emfLogs.Stream += "/emf"
+			shipper := cwlogs.New(emfLogs, aws.NewClient())
+			emfOut = shipper
+			logSinks.Add(1)
+			go func() {
+				defer logSinks.Done()
+				defer reporter.Recover()
+				shipper.Run(logCtx)
+			}()
+		}
+		goWork(metrics.NewEMF(metrics.Default, emfOut, emfCfg).Run)
+	}
+	if metricsCfg.Enabled("statsd") {
+		client, err := statsd.New(statsd.ConfigFromEnv())
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.Mirror(metrics.Default)
+		goWork(client.Run)
+	}
+
+	authCfg, err := auth.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// With sign-in configured, the management endpoints below also need a
+	// session with a role; otherwise the admin listener's loopback address
+	// is their only guard. Metrics stay open to scrapers.
+	var authn *auth.Auth
+	if authCfg.Enabled() {
+		authn = auth.NewGitHub(authCfg)
+		http.Handle("/auth/", authn.Handler())
+	}
+
+	if hookCfg := ghwebhook.ConfigFromEnv(); hookCfg.Secret != "" {
+		hooks, err := ghwebhook.New(hookCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hooks.OnPush(func(ctx context.Context, d ghwebhook.Delivery, e *ghwebhook.PushEvent) error {
+			slog.Info("github push", "delivery", d.ID, "repo", e.Repository.FullName, "ref", e.Ref, "after", e.After)
+			return nil
+		})
+		http.Handle(hookCfg.Path, hooks)
+		goWork(hooks.Run)
+	}
+
+	var outbound *webhooks.Service
+	if hooksCfg := webhooks.ConfigFromEnv(); hooksCfg.Dir != "" {
+		outbound, err = webhooks.New(hooksCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		admin.Handle("/webhooks/", authn.Require(auth.RoleAdmin, outbound.Handler()))
+		goWork(outbound.Run)
+	}
+
+	if flagsCfg := flags.ConfigFromEnv(); flagsCfg.Enabled() {
+		flagSet, err := flags.New(flagsCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		flags.SetDefault(flagSet)
+		admin.Handle("/flags", authn.Require(auth.RoleOperator, flagSet.Handler()))
+		admin.Handle("/flags/", authn.Require(auth.RoleOperator, flagSet.Handler()))
+		goWork(flagSet.Run)
+	}
+
+	// The status page is created before the dependencies below so that
+	// they can add probes to it.
+	var statusPage *status.Page
+	if statusCfg := status.ConfigFromEnv(); statusCfg.Dir != "" {
+		statusPage, err = status.New(statusCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle("GET /status", statusPage.Handler())
+		http.Handle("GET /status.json", statusPage.Handler())
+		admin.Handle("/status/", authn.Require(auth.RoleOperator, statusPage.IncidentsHandler()))
+	}
+
+	// Outbound webhook subscribers receive published events like any
+	// other sink.
+	var publisher *events.Publisher
+	if eventsCfg := events.ConfigFromEnv(); len(eventsCfg.Sinks) > 0 || outbound != nil {
+		sinks, err := events.NewSinks(eventsCfg, aws.NewClient())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if outbound != nil {
+			sinks = append(sinks, outbound)
+		}
+		publisher = events.NewPublisher(eventsCfg, sinks...)
+		goWork(publisher.Run)
+	}
+
+	outboxCfg, err := outbox.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if outboxCfg.DatabaseURL != "" {
+		if publisher == nil {
+			log.Fatal("outbox: OUTBOX_DATABASE_URL is set but neither EVENTS_SINKS nor WEBHOOKS_DIR is")
+		}
+		db, err := trace.OpenDB(tracer, outboxCfg.Driver, outboxCfg.DatabaseURL)
+		if err != nil {
+			log.Fatalf("outbox: %v (is the %q driver linked into this binary?)", err, outboxCfg.Driver)
+		}
+		defer db.Close()
+		if statusPage != nil {
+			statusPage.Add(status.Probe{Name: "database", Critical: true, Check: db.PingContext})
+		}
+		box := outbox.New(outboxCfg, db)
+		if err := box.Migrate(ctx); err != nil {
+			log.Fatal(err)
+		}
+		goWork(func(ctx context.Context) { box.Run(ctx, publisher) })
+	}
+
+	if profCfg := profiler.ConfigFromEnv(); profCfg.Enabled {
+		profCfg.Instance = instanceID(ctx)
+		prof, err := profiler.New(profCfg, aws.NewClient())
+		if err != nil {
+			log.Fatal(err)
+		}
+		goWork(prof.Run)
+	}
+
+	if inspector != nil {
+		admin.Handle("/debug/requests", authn.Require(auth.RoleOperator, inspector))
+		admin.Handle("/debug/requests/", authn.Require(auth.RoleOperator, inspector))
+	}
+	if reporter != nil {
+		admin.Handle("GET /debug/crashes", authn.Require(auth.RoleOperator, reporter.ListHandler()))
+		goWork(reporter.Run)
+	}
+
+	if statusPage != nil {
+		statusPage.Add(status.Probe{Name: "stability", Check: func(context.Context) error {
+			if reporter.CrashLoop() {
+				return errors.New("crash loop")
+			}
+			return nil
+		}})
+		goWork(statusPage.Run)
+	}
+
+	// Routes lets the middleware around the mux pass copies of the request
+	// on and still learn the route.
+	var public http.Handler = httpx.Routes(http.DefaultServeMux)
+	// Handlers find the signed-in user, if any, through auth.FromContext.
+	public = authn.Middleware(public)
+	// SLO tracking goes next, so that its latencies are the handlers'.
+	if sloCfg := slo.ConfigFromEnv(); sloCfg.File != "" {
+		tracker, err := slo.Load(sloCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		public = tracker.Middleware(public)
+		admin.Handle("GET /debug/slo", authn.Require(auth.RoleViewer, tracker.Handler()))
+		goWork(tracker.Run)
+	}
+
+	adminSrv := &http.Server{Addr: env.String("ADMIN_ADDR", "127.0.0.1:9090"), Handler: admin}
+	go serve(ctx, adminSrv)
+
+	// Access logs are a sink like the others: the writer outlives the
+	// server's shutdown grace period so that the last requests are logged.
+	if alCfg := accesslog.ConfigFromEnv(); alCfg.File != "" {
+		alCfg.Redactor = redactor
+		al, err := accesslog.New(alCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		public = al.Middleware(public)
+		logSinks.Add(1)
+		go func() {
+			defer logSinks.Done()
+			defer reporter.Recover()
+			al.Run(logCtx)
+		}()
+	}
+
+	public = inspector.Middleware(reporter.Middleware(public))
+
+	// Logging comes first: its request context carries the route back out
+	// to the server span once the mux has run.
+	serve(ctx, &http.Server{Addr: ":8080", Handler: logging.Middleware(trace.Middleware(tracer, public))})
+	workers.Wait()
+	stopAsync()
+	<-asyncDone
+	stopLogs()
+	logSinks.Wait()
+}
+
+// instanceID returns the EC2 instance ID, or the hostname off EC2.
+func instanceID(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if id, err := aws.InstanceMetadata(ctx, "instance-id"); err == nil {
+		return id
+	}
+	host, _ := os.Hostname()
+	return host
 }
 
+// serve runs srv until ctx is done, then gives in-flight requests a grace
+// period to finish.
+func serve(ctx context.Context, srv *http.Server) {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("server up and running", "addr", srv.Addr)
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// hello is the root response, built once with its Content-Length.
+var hello = httpx.Static("text/plain; charset=utf-8", []byte("Hello, Go AWS Auto Deployed"))
+
 func HelloServer(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("got request...")
-	fmt.Fprintf(w, "Hello, Go AWS Auto Deployed")
+	// Every request is in the access log; this is for following one
+	// request's records at debug level.
+	slog.DebugContext(r.Context(), "got request")
+	hello.ServeHTTP(w, r)
 }